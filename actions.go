@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ditek/jsonfsm/gofsm"
+	"github.com/gorilla/mux"
+)
+
+// actionAPI lists the asynchronous actions the machines wait for and
+// receives their results
+type actionAPI struct {
+	manager *gofsm.Manager
+}
+
+// routes registers the action end points, wrapped with the given middleware
+func (api *actionAPI) routes(r *mux.Router, wrap func(http.Handler) http.Handler) {
+	r.Handle("/actions", wrap(http.HandlerFunc(api.listHandler))).Methods("GET")
+	r.Handle("/actions/{token}/complete", wrap(http.HandlerFunc(api.completeHandler))).Methods("POST")
+}
+
+// listHandler lists the pending actions, optionally only the ones of a session
+func (api *actionAPI) listHandler(w http.ResponseWriter, r *http.Request) {
+	session := r.URL.Query().Get("session")
+	pending := []gofsm.PendingAction{}
+	for _, p := range api.manager.PendingActions() {
+		if session != "" && p.Session != session {
+			continue
+		}
+		pending = append(pending, p)
+	}
+	gofsm.RespondWithJSON(w, http.StatusOK, pending)
+}
+
+// completeHandler resumes the machine waiting for an action with its result
+func (api *actionAPI) completeHandler(w http.ResponseWriter, r *http.Request) {
+	var result gofsm.ActionResult
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+		gofsm.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	res, err := api.manager.CompleteAction(mux.Vars(r)["token"], result)
+	switch err {
+	case nil:
+		gofsm.RespondWithJSON(w, http.StatusOK, res)
+	case gofsm.ErrActionNotPending:
+		gofsm.RespondWithError(w, http.StatusNotFound, err.Error())
+	case gofsm.ErrInstancePaused:
+		gofsm.RespondWithError(w, http.StatusConflict, err.Error())
+	case gofsm.ErrInstanceTerminated:
+		gofsm.RespondWithError(w, http.StatusGone, err.Error())
+	default:
+		gofsm.RespondWithError(w, http.StatusBadRequest, err.Error())
+	}
+}
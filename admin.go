@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/ditek/jsonfsm/gofsm"
+	"github.com/gorilla/mux"
+)
+
+// Log levels of the admin end points
+const (
+	levelInfo  = "info"
+	levelError = "error"
+)
+
+// logFilter drops the log lines below the log level
+// The errors of the server are the lines with "Error"
+type logFilter struct {
+	out        io.Writer
+	errorsOnly atomic.Bool
+}
+
+// Write writes a log line if its level is enabled
+func (f *logFilter) Write(p []byte) (int, error) {
+	if f.errorsOnly.Load() && !bytes.Contains(p, []byte("Error")) {
+		return len(p), nil
+	}
+	return f.out.Write(p)
+}
+
+// filterLog filters the standard log by level
+// It must be called before the loggers writing to it are created, e.g. the trace
+func filterLog() *logFilter {
+	logs := &logFilter{out: log.Writer()}
+	log.SetOutput(logs)
+	return logs
+}
+
+// level returns the log level
+func (f *logFilter) level() string {
+	if f.errorsOnly.Load() {
+		return levelError
+	}
+	return levelInfo
+}
+
+// admin serves the end points adjusting a running server
+type admin struct {
+	cfg     AdminConfig
+	tenants *tenants
+	traces  *gofsm.TraceSwitch
+	logs    *logFilter
+}
+
+// newAdmin creates the admin end points
+func newAdmin(cfg AdminConfig, tenants *tenants, traces *gofsm.TraceSwitch, logs *logFilter) (*admin, error) {
+	if cfg.Token == "" {
+		return nil, fmt.Errorf("Error: The admin end points need a token")
+	}
+	return &admin{cfg: cfg, tenants: tenants, traces: traces, logs: logs}, nil
+}
+
+// routes returns the router of the admin end points
+func (a *admin) routes() http.Handler {
+	r := mux.NewRouter()
+	r.HandleFunc("/admin/log-level", a.logLevelHandler).Methods("GET", "PUT")
+	r.HandleFunc("/admin/trace", a.traceHandler).Methods("GET", "PUT")
+	r.HandleFunc("/admin/queues", a.queuesHandler).Methods("GET")
+	r.HandleFunc("/admin/flush", a.flushHandler).Methods("POST")
+	return a.authenticate(r)
+}
+
+// authenticate rejects the requests without the admin token
+func (a *admin) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(a.cfg.Token)) != 1 {
+			gofsm.RespondWithError(w, http.StatusUnauthorized, "invalid admin token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// logLevelHandler reports or changes the log level, "info" or "error"
+func (a *admin) logLevelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "PUT" {
+		var req struct {
+			Level string `json:"level"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			gofsm.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if req.Level != levelInfo && req.Level != levelError {
+			gofsm.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("Error: Unknown log level '%s'", req.Level))
+			return
+		}
+		a.logs.errorsOnly.Store(req.Level == levelError)
+	}
+	gofsm.RespondWithJSON(w, http.StatusOK, map[string]string{"level": a.logs.level()})
+}
+
+// traceHandler reports or toggles the trace of the machines
+func (a *admin) traceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "PUT" {
+		var req struct {
+			Trace *bool `json:"trace"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Trace == nil {
+			gofsm.RespondWithError(w, http.StatusBadRequest, "Error: Expected {\"trace\": true|false}")
+			return
+		}
+		a.traces.Set(*req.Trace)
+	}
+	gofsm.RespondWithJSON(w, http.StatusOK, map[string]bool{"trace": a.traces.On()})
+}
+
+// tenantQueues are the queues of a tenant
+type tenantQueues struct {
+	Tenant string           `json:"tenant,omitempty"`
+	Queue  gofsm.QueueStats `json:"queue"`
+	// Depths are the events waiting per session
+	Depths  map[string]int         `json:"depths"`
+	Workers *gofsm.WorkerPoolStats `json:"workers,omitempty"`
+}
+
+// queuesHandler reports the event queues of each tenant, and the actions
+// waiting for the workers
+func (a *admin) queuesHandler(w http.ResponseWriter, r *http.Request) {
+	queues := []tenantQueues{}
+	a.tenants.each(func(tenant string, s *server) {
+		q := tenantQueues{Tenant: tenant, Queue: s.manager.QueueStats(), Depths: s.manager.QueueDepths()}
+		if s.workers != nil {
+			stats := s.workers.Stats()
+			q.Workers = &stats
+		}
+		queues = append(queues, q)
+	})
+	gofsm.RespondWithJSON(w, http.StatusOK, queues)
+}
+
+// flushHandler unloads the idle machines of every tenant to the snapshot
+// store and empties the cache of the parsed expressions
+func (a *admin) flushHandler(w http.ResponseWriter, r *http.Request) {
+	unloaded := 0
+	var errs []string
+	a.tenants.each(func(tenant string, s *server) {
+		n, err := s.manager.Flush()
+		unloaded += n
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	})
+	gofsm.FlushGuards()
+	if len(errs) > 0 {
+		gofsm.RespondWithJSON(w, http.StatusInternalServerError, map[string]interface{}{
+			"error":    strings.Join(errs, "; "),
+			"unloaded": unloaded,
+		})
+		return
+	}
+	log.Printf("Flushed %d machines\n", unloaded)
+	gofsm.RespondWithJSON(w, http.StatusOK, map[string]int{"unloaded": unloaded})
+}
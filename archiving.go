@@ -0,0 +1,53 @@
+package main
+
+import (
+	"time"
+
+	"github.com/ditek/jsonfsm/gofsm"
+	"github.com/ditek/jsonfsm/gofsm/archive"
+)
+
+// archiving moves the completed instances of every tenant to the bucket of
+// the configuration
+type archiving struct {
+	bucket    archive.Bucket
+	prefix    string
+	retention time.Duration
+	interval  time.Duration
+}
+
+// newArchiving returns the archiving of the configuration, nil if it is disabled
+// With an encrypter, the archives are encrypted
+func newArchiving(cfg archive.Config, enc gofsm.Encrypter) (*archiving, error) {
+	if cfg.Type == "" {
+		return nil, nil
+	}
+	bucket, err := archive.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if enc != nil {
+		bucket = archive.EncryptBucket(bucket, enc)
+	}
+	a := &archiving{bucket: bucket, prefix: cfg.Prefix, retention: 24 * time.Hour, interval: time.Hour}
+	if cfg.Retention != "" {
+		if a.retention, err = time.ParseDuration(cfg.Retention); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Interval != "" {
+		if a.interval, err = time.ParseDuration(cfg.Interval); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// start archives the instances of the manager of a tenant, with their events
+// if the history is kept in a database
+func (a *archiving) start(manager *gofsm.Manager, history archive.History) {
+	if a == nil {
+		return
+	}
+	archive.NewArchiver(manager, a.bucket, history, a.prefix, a.retention).Start(a.interval)
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ditek/jsonfsm/gofsm"
+)
+
+// Authentication types
+const (
+	authAPIKey = "apiKey"
+	authHMAC   = "hmac"
+	authJWT    = "jwt"
+)
+
+// principalKey is the request context key of the authenticated caller
+type principalKey struct{}
+
+// principalFromRequest returns the authenticated caller of a request
+func principalFromRequest(r *http.Request) string {
+	p, _ := r.Context().Value(principalKey{}).(string)
+	return p
+}
+
+// tenantKey is the request context key of the tenant of the caller
+type tenantKey struct{}
+
+// tenantFromRequest returns the tenant of the caller of a request, empty
+// when tenancy is disabled
+func tenantFromRequest(r *http.Request) string {
+	t, _ := r.Context().Value(tenantKey{}).(string)
+	return t
+}
+
+// rolesKey is the request context key of the roles of the caller
+type rolesKey struct{}
+
+// rolesFromRequest returns the roles of the caller of a request
+func rolesFromRequest(r *http.Request) []string {
+	roles, _ := r.Context().Value(rolesKey{}).([]string)
+	return roles
+}
+
+// Authorizer decides whether a caller may send an event
+type Authorizer func(principal string, event gofsm.Event) error
+
+// authenticator authenticates requests and authorizes their events
+type authenticator struct {
+	cfg         AuthConfig
+	tenants     TenantsConfig
+	authorizers []Authorizer
+}
+
+// newAuthenticator creates an authenticator from the config
+// With tenants, every caller must belong to a tenant
+func newAuthenticator(cfg AuthConfig, tenants TenantsConfig) (*authenticator, error) {
+	switch cfg.Type {
+	case "":
+	case authAPIKey:
+		if cfg.Header == "" {
+			cfg.Header = "X-API-Key"
+		}
+		if len(cfg.APIKeys) == 0 {
+			return nil, fmt.Errorf("Error: API key authentication needs at least one key")
+		}
+	case authHMAC:
+		if cfg.Header == "" {
+			cfg.Header = "X-Signature"
+		}
+		if cfg.Secret == "" {
+			return nil, fmt.Errorf("Error: HMAC authentication needs a secret")
+		}
+	case authJWT:
+		if cfg.Secret == "" {
+			return nil, fmt.Errorf("Error: JWT authentication needs a secret")
+		}
+		if cfg.RolesClaim == "" {
+			cfg.RolesClaim = "roles"
+		}
+	default:
+		return nil, fmt.Errorf("Error: Unknown authentication type '%s'", cfg.Type)
+	}
+	if tenants.enabled() && cfg.Type == "" {
+		return nil, fmt.Errorf("Error: Tenants need authentication")
+	}
+	if tenants.Claim != "" && cfg.Type != authJWT {
+		return nil, fmt.Errorf("Error: The tenant claim needs JWT authentication")
+	}
+	for principal, tenant := range tenants.Principals {
+		if err := gofsm.CheckName(tenant); err != nil {
+			return nil, fmt.Errorf("Error: Invalid tenant of '%s': %v", principal, err)
+		}
+	}
+	a := &authenticator{cfg: cfg, tenants: tenants}
+	if len(cfg.Permissions) > 0 {
+		a.authorizers = append(a.authorizers, permissionAuthorizer(cfg.Permissions))
+	}
+	return a, nil
+}
+
+// addAuthorizer adds a hook that is consulted for every event
+func (a *authenticator) addAuthorizer(authorizer Authorizer) {
+	a.authorizers = append(a.authorizers, authorizer)
+}
+
+// middleware rejects requests that cannot be authenticated
+func (a *authenticator) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := a.authenticate(r)
+		if err != nil {
+			gofsm.RespondWithError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		ctx := context.WithValue(r.Context(), principalKey{}, principal)
+		ctx = context.WithValue(ctx, rolesKey{}, a.roles(principal, r))
+		if a.tenants.enabled() {
+			tenant, err := a.tenant(principal, r)
+			if err != nil {
+				gofsm.RespondWithError(w, http.StatusForbidden, err.Error())
+				return
+			}
+			ctx = context.WithValue(ctx, tenantKey{}, tenant)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// enabled tells if the requests are authenticated
+func (a *authenticator) enabled() bool {
+	return a.cfg.Type != ""
+}
+
+// hasRole tells if a caller has a role
+func hasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate returns the caller of a request
+func (a *authenticator) authenticate(r *http.Request) (string, error) {
+	switch a.cfg.Type {
+	case authAPIKey:
+		key := r.Header.Get(a.cfg.Header)
+		for name, k := range a.cfg.APIKeys {
+			if subtle.ConstantTimeCompare([]byte(key), []byte(k)) == 1 {
+				return name, nil
+			}
+		}
+		return "", fmt.Errorf("invalid API key")
+	case authHMAC:
+		return authHMAC, a.checkSignature(r)
+	case authJWT:
+		return a.checkJWT(r)
+	}
+	return "", nil
+}
+
+// tenant returns the tenant of an authenticated caller, from its token claim
+// or else from the principals of the config
+func (a *authenticator) tenant(principal string, r *http.Request) (string, error) {
+	tenant := a.tenants.Principals[principal]
+	if a.tenants.Claim != "" {
+		// The token was verified by the authentication
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		var claims map[string]interface{}
+		if parts := strings.Split(token, "."); len(parts) == 3 && decodeSegment(parts[1], &claims) == nil {
+			if claim, ok := claims[a.tenants.Claim].(string); ok {
+				tenant = claim
+			}
+		}
+	}
+	if tenant == "" {
+		return "", fmt.Errorf("Error: '%s' belongs to no tenant", principal)
+	}
+	if err := gofsm.CheckName(tenant); err != nil {
+		return "", fmt.Errorf("Error: Invalid tenant '%s'", tenant)
+	}
+	return tenant, nil
+}
+
+// roles returns the roles of an authenticated caller, from the config and
+// from its token claim
+func (a *authenticator) roles(principal string, r *http.Request) []string {
+	roles := append([]string{}, a.cfg.Roles[principal]...)
+	if a.cfg.Type != authJWT {
+		return roles
+	}
+	// The token was verified by the authentication
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	var claims map[string]interface{}
+	if parts := strings.Split(token, "."); len(parts) == 3 && decodeSegment(parts[1], &claims) == nil {
+		switch claim := claims[a.cfg.RolesClaim].(type) {
+		case string:
+			roles = append(roles, strings.Fields(claim)...)
+		case []interface{}:
+			for _, role := range claim {
+				if role, ok := role.(string); ok {
+					roles = append(roles, role)
+				}
+			}
+		}
+	}
+	return roles
+}
+
+// checkSignature verifies the hex HMAC-SHA256 signature of the request body
+// The signature may be prefixed with "sha256="
+func (a *authenticator) checkSignature(r *http.Request) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	signature := strings.TrimPrefix(r.Header.Get(a.cfg.Header), "sha256=")
+	sent, err := hex.DecodeString(signature)
+	if err != nil || len(sent) == 0 {
+		return fmt.Errorf("missing or malformed signature")
+	}
+	mac := hmac.New(sha256.New, []byte(a.cfg.Secret))
+	mac.Write(body)
+	if !hmac.Equal(sent, mac.Sum(nil)) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// checkJWT verifies an HS256 bearer token and returns its subject
+func (a *authenticator) checkJWT(r *http.Request) (string, error) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("missing or malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return "", fmt.Errorf("unsupported token algorithm")
+	}
+	sent, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("malformed token signature")
+	}
+	mac := hmac.New(sha256.New, []byte(a.cfg.Secret))
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sent, mac.Sum(nil)) {
+		return "", fmt.Errorf("invalid token signature")
+	}
+
+	var claims struct {
+		Sub string   `json:"sub"`
+		Exp *float64 `json:"exp"`
+		Nbf *float64 `json:"nbf"`
+	}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", fmt.Errorf("malformed token claims")
+	}
+	now := float64(time.Now().Unix())
+	if claims.Exp != nil && now >= *claims.Exp {
+		return "", fmt.Errorf("token expired")
+	}
+	if claims.Nbf != nil && now < *claims.Nbf {
+		return "", fmt.Errorf("token not valid yet")
+	}
+	return claims.Sub, nil
+}
+
+// decodeSegment decodes a base64url encoded JSON segment of a token
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// authorize runs the authorization hooks for an event
+func (a *authenticator) authorize(principal string, event gofsm.Event) error {
+	for _, authorizer := range a.authorizers {
+		if err := authorizer(principal, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// permissionAuthorizer allows the callers listed for each event in the config
+func permissionAuthorizer(permissions map[string][]string) Authorizer {
+	return func(principal string, event gofsm.Event) error {
+		allowed, ok := permissions[event.Action]
+		if !ok {
+			if allowed, ok = permissions["*"]; !ok {
+				return nil
+			}
+		}
+		for _, p := range allowed {
+			if p == principal || p == "*" {
+				return nil
+			}
+		}
+		return fmt.Errorf("Error: '%s' is not allowed to send the event '%s'", principal, event.Action)
+	}
+}
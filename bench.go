@@ -0,0 +1,139 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ditek/jsonfsm/gofsm"
+)
+
+// benchResult holds the measurements of a benchmark run
+type benchResult struct {
+	events    int
+	rejected  int
+	elapsed   time.Duration
+	latencies []time.Duration
+}
+
+// percentile returns the latency below which the given fraction of events completed
+func (r *benchResult) percentile(p float64) time.Duration {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+	i := int(p * float64(len(r.latencies)-1))
+	return r.latencies[i]
+}
+
+// benchCommand fires synthetic events at in-memory machines and reports
+// throughput and latency percentiles
+// Returns the process exit code
+func benchCommand(args []string) int {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	n := flags.Int("n", 100000, "number of events")
+	workers := flags.Int("c", 1, "number of machines driven concurrently")
+	seed := flags.Int64("seed", 1, "seed of the event generator")
+	flags.Parse(args)
+	if flags.NArg() < 1 || *workers < 1 {
+		fmt.Println(fmt.Errorf("Usage: ./jsonfsm bench [-n <events>] [-c <machines>] [-seed <seed>] <file_name>"))
+		return 1
+	}
+	data, err := gofsm.ReadDefinitionFile(flags.Arg(0))
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	// Each transition logs the new state, which would dominate the measurements
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	result, err := runBench(data, *n, *workers, *seed)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	fmt.Printf("events:     %d (%d rejected)\n", result.events, result.rejected)
+	fmt.Printf("elapsed:    %v\n", result.elapsed)
+	fmt.Printf("throughput: %.0f events/s\n", float64(result.events)/result.elapsed.Seconds())
+	fmt.Printf("latency:    p50 %v  p90 %v  p99 %v  max %v\n",
+		result.percentile(0.5), result.percentile(0.9), result.percentile(0.99), result.percentile(1))
+	return 0
+}
+
+// runBench drives 'workers' machines with n events in total
+func runBench(data []byte, n, workers int, seed int64) (*benchResult, error) {
+	machines := make([]*gofsm.Machine, workers)
+	for i := range machines {
+		fsm, err := gofsm.Load(data)
+		if err != nil {
+			return nil, err
+		}
+		fsm.Init()
+		defer fsm.Stop()
+		machines[i] = fsm
+	}
+
+	result := &benchResult{latencies: make([]time.Duration, 0, n)}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i, fsm := range machines {
+		count := n / workers
+		if i < n%workers {
+			count++
+		}
+		wg.Add(1)
+		go func(fsm *gofsm.Machine, count int, rng *rand.Rand) {
+			defer wg.Done()
+			latencies, rejected := driveMachine(fsm, count, rng)
+			mu.Lock()
+			defer mu.Unlock()
+			result.latencies = append(result.latencies, latencies...)
+			result.rejected += rejected
+		}(fsm, count, rand.New(rand.NewSource(seed+int64(i))))
+	}
+	wg.Wait()
+	result.elapsed = time.Since(start)
+	result.events = len(result.latencies)
+	sort.Slice(result.latencies, func(i, j int) bool {
+		return result.latencies[i] < result.latencies[j]
+	})
+	return result, nil
+}
+
+// driveMachine sends events accepted by the current state of the machine,
+// with the expected code as parameter half of the time
+// Machines that reach a state without events are put back in their initial state
+func driveMachine(fsm *gofsm.Machine, count int, rng *rand.Rand) ([]time.Duration, int) {
+	latencies := make([]time.Duration, 0, count)
+	rejected := 0
+	for len(latencies) < count {
+		events := fsm.AcceptedEvents()
+		if len(events) == 0 {
+			snap := fsm.Snapshot()
+			snap.CurrentState = fsm.InitialState
+			snap.Child = nil
+			if err := fsm.Restore(snap); err != nil || len(fsm.AcceptedEvents()) == 0 {
+				return latencies, rejected
+			}
+			continue
+		}
+		event := gofsm.Event{Action: events[rng.Intn(len(events))]}
+		if rng.Intn(2) == 0 {
+			event.Param = fsm.ExpectedCode
+		}
+		start := time.Now()
+		if _, err := fsm.SendEvent(event); err != nil {
+			rejected++
+		}
+		latencies = append(latencies, time.Since(start))
+	}
+	return latencies, rejected
+}
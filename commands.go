@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ditek/jsonfsm/gofsm"
+	"github.com/ditek/jsonfsm/gofsm/codegen"
+	"github.com/ditek/jsonfsm/gofsm/xstate"
+)
+
+// validateCommand checks the given definition files and reports every problem found
+// Returns the process exit code
+func validateCommand(args []string) int {
+	if len(args) < 1 {
+		fmt.Println(fmt.Errorf("Usage: ./jsonfsm validate <file_name>..."))
+		return 1
+	}
+	code := 0
+	for _, fileName := range args {
+		data, err := gofsm.ReadDefinitionFile(fileName)
+		if err != nil {
+			fmt.Println(err)
+			code = 1
+			continue
+		}
+		if err := gofsm.ValidateSchema(data); err != nil {
+			if errs, ok := err.(gofsm.ValidationErrors); ok {
+				for _, e := range errs {
+					fmt.Printf("%s: %s\n", fileName, e)
+				}
+			} else {
+				fmt.Printf("%s: %s\n", fileName, err)
+			}
+			code = 1
+			continue
+		}
+		fmt.Printf("%s: OK\n", fileName)
+	}
+	return code
+}
+
+// lintFinding is a finding of the lint command, with the file it was found in
+type lintFinding struct {
+	File string `json:"file"`
+	gofsm.LintFinding
+}
+
+// lintCommand reports the smells of the given definition files
+// Invalid definitions are reported as 'invalid' findings
+// Returns the process exit code, 1 for invalid definitions or, with -strict, for any finding
+func lintCommand(args []string) int {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the findings as a JSON array")
+	strict := flags.Bool("strict", false, "fail on any finding")
+	flags.Parse(args)
+	if flags.NArg() < 1 {
+		fmt.Println(fmt.Errorf("Usage: ./jsonfsm lint [-json] [-strict] <file_name>..."))
+		return 1
+	}
+	code := 0
+	findings := []lintFinding{}
+	for _, fileName := range flags.Args() {
+		def, err := gofsm.LoadDefinitionFile(fileName)
+		if err != nil {
+			code = 1
+			errs, ok := err.(gofsm.ValidationErrors)
+			if !ok {
+				errs = gofsm.ValidationErrors{{Message: err.Error()}}
+			}
+			for _, e := range errs {
+				findings = append(findings, lintFinding{fileName, gofsm.LintFinding{Rule: "invalid", Path: e.Path, Message: e.Message}})
+			}
+			continue
+		}
+		for _, f := range gofsm.Lint(def) {
+			findings = append(findings, lintFinding{fileName, f})
+			if *strict {
+				code = 1
+			}
+		}
+	}
+	if *asJSON {
+		out, _ := json.MarshalIndent(findings, "", "    ")
+		fmt.Println(string(out))
+		return code
+	}
+	for _, f := range findings {
+		fmt.Printf("%s: %s\n", f.File, f.LintFinding)
+	}
+	return code
+}
+
+// generateCommand writes the typed Go package of a definition
+// Flags may follow the file name, as in "generate order.json -pkg orderfsm"
+// Returns the process exit code
+func generateCommand(args []string) int {
+	flags := flag.NewFlagSet("generate", flag.ExitOnError)
+	pkg := flags.String("pkg", "", "name of the generated package")
+	out := flags.String("o", "", "output file, stdout if empty")
+	var files []string
+	for flags.Parse(args); flags.NArg() > 0; flags.Parse(args) {
+		files = append(files, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+	if len(files) != 1 || *pkg == "" {
+		fmt.Println(fmt.Errorf("Usage: ./jsonfsm generate <file_name> -pkg <package> [-o <output_file>]"))
+		return 1
+	}
+	data, err := gofsm.ReadDefinitionFile(files[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	src, err := codegen.Generate(data, *pkg, filepath.Base(files[0]))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", files[0], err)
+		return 1
+	}
+	if *out == "" {
+		fmt.Print(string(src))
+		return 0
+	}
+	if err := ioutil.WriteFile(*out, src, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// matrixCommand prints the state × event matrix of a definition as a Markdown
+// table or as CSV, for the readers of the definition who don't read JSON
+// Returns the process exit code
+func matrixCommand(args []string) int {
+	flags := flag.NewFlagSet("matrix", flag.ExitOnError)
+	format := flags.String("format", "markdown", "output format, markdown or csv")
+	out := flags.String("o", "", "output file, stdout if empty")
+	flags.Parse(args)
+	if flags.NArg() != 1 || (*format != "markdown" && *format != "csv") {
+		fmt.Println(fmt.Errorf("Usage: ./jsonfsm matrix [-format markdown|csv] [-o <output_file>] <file_name>"))
+		return 1
+	}
+	def, err := gofsm.LoadDefinitionFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", flags.Arg(0), err)
+		return 1
+	}
+	matrix := def.Matrix()
+	var buf bytes.Buffer
+	if *format == "csv" {
+		if err := matrix.WriteCSV(&buf); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	} else {
+		buf.WriteString(matrix.Markdown())
+	}
+	if *out == "" {
+		fmt.Print(buf.String())
+		return 0
+	}
+	if err := ioutil.WriteFile(*out, buf.Bytes(), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// openAPICommand prints the OpenAPI spec of a server running the given definitions
+// The first file is the definition given on the command line, the other ones
+// are uploaded definitions named after their file
+// Returns the process exit code
+func openAPICommand(args []string) int {
+	if len(args) < 1 {
+		fmt.Println(fmt.Errorf("Usage: ./jsonfsm openapi <file_name> [<uploaded_file>...]"))
+		return 1
+	}
+	defs := map[string]*gofsm.Definition{}
+	for i, fileName := range args {
+		def, err := gofsm.LoadDefinitionFile(fileName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", fileName, err)
+			return 1
+		}
+		name := defaultMachine
+		if i > 0 {
+			name = strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName))
+		}
+		defs[name] = def
+	}
+	out, err := json.MarshalIndent(openAPISpec(defs), "", "    ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(string(out))
+	return 0
+}
+
+// importCommand converts an XState machine config and prints the definition
+// The conversion warnings are printed on stderr
+// Returns the process exit code
+func importCommand(args []string) int {
+	if len(args) != 1 {
+		fmt.Println(fmt.Errorf("Usage: ./jsonfsm import <xstate_file>"))
+		return 1
+	}
+	data, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	def, warnings, err := xstate.Convert(data)
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "%s: warning: %s\n", args[0], warning)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+		return 1
+	}
+	out, err := json.MarshalIndent(def, "", "    ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(string(out))
+	return 0
+}
+
+// migrateCommand converts the given definition files to the current schema
+// version, printing them or, with -w, writing them back
+// Returns the process exit code
+func migrateCommand(args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	write := flags.Bool("w", false, "write the converted definitions back to their files")
+	flags.Parse(args)
+	if flags.NArg() < 1 {
+		fmt.Println(fmt.Errorf("Usage: ./jsonfsm migrate [-w] <file_name>..."))
+		return 1
+	}
+	code := 0
+	for _, fileName := range flags.Args() {
+		data, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 1
+			continue
+		}
+		out, err := gofsm.MigrateDefinition(data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", fileName, err)
+			code = 1
+			continue
+		}
+		if !*write {
+			fmt.Print(string(out))
+			continue
+		}
+		if err := ioutil.WriteFile(fileName, out, 0644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 1
+		}
+	}
+	return code
+}
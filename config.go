@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/ditek/jsonfsm/gofsm"
+	"github.com/ditek/jsonfsm/gofsm/archive"
+	"github.com/ditek/jsonfsm/gofsm/audit"
+	"github.com/ditek/jsonfsm/gofsm/lock"
+	"github.com/ditek/jsonfsm/gofsm/webhook"
+)
+
+// Config holds the server settings
+type Config struct {
+	Addr string     `json:"addr"`
+	Auth AuthConfig `json:"auth"`
+	// TLS serves the end points over TLS, optionally verifying the client certificates
+	TLS TLSConfig `json:"tls"`
+	// MaxBodyBytes limits the size of event requests
+	MaxBodyBytes int64            `json:"maxBodyBytes"`
+	RateLimit    RateLimitConfig  `json:"rateLimit"`
+	Kafka        KafkaConfig      `json:"kafka"`
+	NATS         NATSConfig       `json:"nats"`
+	MQTT         MQTTConfig       `json:"mqtt"`
+	AMQP         AMQPConfig       `json:"amqp"`
+	Webhooks     []webhook.Config `json:"webhooks"`
+	// Audit lists the sinks receiving the audit trail of the machines
+	Audit []audit.Config `json:"audit"`
+	// Store persists the definitions uploaded to /definitions
+	Store StoreConfig `json:"store"`
+	// Sessions tunes how the machines of the sessions are held in memory
+	Sessions SessionsConfig `json:"sessions"`
+	// Lock lets replicas sharing the snapshot directory serve the same sessions
+	Lock lock.Config `json:"lock"`
+	// Cluster partitions the sessions between replicas sharing the snapshot directory
+	Cluster ClusterConfig `json:"cluster"`
+	// Tenants lets one deployment serve several customers, each with its own
+	// sessions, definitions and instances
+	Tenants TenantsConfig `json:"tenants"`
+	// Archive moves the completed instances to object storage
+	Archive archive.Config `json:"archive"`
+	// Database tunes the database given with -db
+	Database DatabaseConfig `json:"database"`
+	// Encryption encrypts the contexts and the event parameters persisted
+	Encryption EncryptionConfig `json:"encryption"`
+	// Workers runs the actions on a bounded pool of goroutines
+	Workers WorkersConfig `json:"workers"`
+	// Breakers maps action names to the circuit breakers protecting the services they call
+	Breakers map[string]BreakerConfig `json:"breakers,omitempty"`
+	// Record is the directory where the steps of the machines are recorded as
+	// test fixtures, see fsmtest.Replay
+	Record string `json:"record,omitempty"`
+	// Trace logs the transitions considered for each event and why they were
+	// skipped, for debugging
+	Trace bool `json:"trace,omitempty"`
+	// Coverage counts the states and transitions taken by the machines of
+	// each tenant, reported on /coverage
+	Coverage bool `json:"coverage,omitempty"`
+	// Admin serves the admin end points on a separate port
+	Admin AdminConfig `json:"admin"`
+	// Debug serves the debugger web page on /debug, for development only
+	Debug bool `json:"debug"`
+}
+
+// AdminConfig serves the end points adjusting a running server
+type AdminConfig struct {
+	// Addr is the address of the admin end points, disabled if empty
+	Addr string `json:"addr,omitempty"`
+	// Token authenticates the operators, as a bearer token
+	Token string `json:"token,omitempty"`
+}
+
+// TenantsConfig maps the authenticated callers to their tenant
+// Tenancy is enabled when either field is set
+type TenantsConfig struct {
+	// Principals maps caller names, e.g. the names of the API keys, to their tenant
+	Principals map[string]string `json:"principals,omitempty"`
+	// Claim is the JWT claim naming the tenant of the caller, e.g. "tenant"
+	Claim string `json:"claim,omitempty"`
+}
+
+// enabled tells if the callers are mapped to tenants
+func (cfg TenantsConfig) enabled() bool {
+	return len(cfg.Principals) > 0 || cfg.Claim != ""
+}
+
+// DatabaseConfig limits the history kept by the database
+type DatabaseConfig struct {
+	// EventRetention is how long the events are kept, e.g. "720h", forever if empty
+	EventRetention string `json:"eventRetention,omitempty"`
+	// MaxEvents is the number of events kept for each instance, all of them if 0
+	MaxEvents int `json:"maxEvents,omitempty"`
+	// CompactInterval is the time between the compactions, "1h" by default
+	CompactInterval string `json:"compactInterval,omitempty"`
+}
+
+// EncryptionConfig holds the AES key encrypting the persisted data
+// Encryption is disabled without key
+type EncryptionConfig struct {
+	// Key is the base64 of a key of 16, 24 or 32 bytes, for AES-128, AES-192 or AES-256
+	Key string `json:"key,omitempty"`
+	// KeyFile is a file holding the base64 key instead, e.g. a mounted secret
+	KeyFile string `json:"keyFile,omitempty"`
+}
+
+// encrypter returns the AES-GCM encrypter of the key, nil without key
+func (cfg EncryptionConfig) encrypter() (gofsm.Encrypter, error) {
+	key := cfg.Key
+	if cfg.KeyFile != "" {
+		if key != "" {
+			return nil, fmt.Errorf("Error: The encryption key and key file are alternatives, only one can be configured")
+		}
+		data, err := ioutil.ReadFile(cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		key = strings.TrimSpace(string(data))
+	}
+	if key == "" {
+		return nil, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("Error: The encryption key is not base64: %v", err)
+	}
+	return gofsm.NewAESGCM(raw)
+}
+
+// WorkersConfig sizes the worker pool running the actions
+type WorkersConfig struct {
+	// Size is the number of workers, actions run on the goroutine of their event if zero
+	Size int `json:"size,omitempty"`
+	// Limits maps action names to the number of their invocations running at once
+	Limits map[string]int `json:"limits,omitempty"`
+}
+
+// BreakerConfig opens the circuit of an action after repeated failures
+type BreakerConfig struct {
+	// Failures is the number of failures in a row that opens the circuit
+	Failures int `json:"failures"`
+	// Cooldown is the time the circuit stays open before a trial call, e.g. "30s"
+	Cooldown string `json:"cooldown"`
+}
+
+// StoreConfig selects where definitions are persisted
+type StoreConfig struct {
+	// Type is "memory" or "file", memory by default
+	Type string `json:"type"`
+	// Dir is the directory of the file store
+	Dir string `json:"dir,omitempty"`
+}
+
+// SessionsConfig shards the sessions and limits the machines kept in memory
+type SessionsConfig struct {
+	// Shards is the number of independently locked groups of sessions, 1 by default
+	Shards int `json:"shards,omitempty"`
+	// MaxLoaded is the number of machines kept in memory, unlimited if zero
+	// Idle machines beyond it are evicted to the snapshot directory
+	MaxLoaded int `json:"maxLoaded,omitempty"`
+	// SnapshotDir keeps the evicted machines on disk, they are kept in memory if empty
+	// With a lock, it must be shared by the replicas
+	SnapshotDir string `json:"snapshotDir,omitempty"`
+	// Queue bounds the queues of the events sent with ?async=true
+	Queue QueueConfig `json:"queue,omitempty"`
+	// StuckInterval is the time between the scans for the instances stuck
+	// past the SLA of their state, e.g. "1m", they are not scanned if empty
+	StuckInterval string `json:"stuckInterval,omitempty"`
+}
+
+// QueueConfig bounds the event queue of each session
+type QueueConfig struct {
+	// Size is the number of events a queue holds, unbounded if zero
+	Size int `json:"size,omitempty"`
+	// Overflow is "block", "reject" or "dropOldest", reject by default
+	Overflow string `json:"overflow,omitempty"`
+	// Timeout is how long "block" waits for room, "1s" by default
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// ClusterConfig lists the replicas the sessions are partitioned between
+type ClusterConfig struct {
+	// Self is the base URL other nodes reach this node at, partitioning is disabled if empty
+	Self string `json:"self,omitempty"`
+	// Nodes are the base URLs of all the nodes, including this one
+	Nodes []string `json:"nodes,omitempty"`
+	// ProbeInterval is the time between the health checks of the nodes, "2s" by default
+	ProbeInterval string `json:"probeInterval,omitempty"`
+	// Secret is shared by the nodes to sign the events they forward, it is
+	// required unless the nodes use mutual TLS
+	Secret string `json:"secret,omitempty"`
+}
+
+// KafkaConfig selects the Kafka topic events are consumed from
+type KafkaConfig struct {
+	Brokers []string `json:"brokers"`
+	Topic   string   `json:"topic"`
+	GroupID string   `json:"groupId"`
+	// DeadLetterTopic receives the events that can't be processed
+	DeadLetterTopic string `json:"deadLetterTopic,omitempty"`
+}
+
+// NATSConfig selects the NATS subject events are received from
+type NATSConfig struct {
+	URL     string `json:"url"`
+	Subject string `json:"subject"`
+	Queue   string `json:"queue"`
+}
+
+// MQTTConfig selects the MQTT topic events are received from
+type MQTTConfig struct {
+	Broker   string `json:"broker"`
+	Topic    string `json:"topic"`
+	ClientID string `json:"clientId"`
+	QoS      byte   `json:"qos"`
+}
+
+// AMQPConfig selects the AMQP queue events are consumed from
+type AMQPConfig struct {
+	URL   string `json:"url"`
+	Queue string `json:"queue"`
+	// DeadLetterQueue receives the rejected events, they are dropped if empty
+	DeadLetterQueue string `json:"deadLetterQueue,omitempty"`
+	// Prefetch is the number of unacked messages delivered ahead, unlimited if zero
+	Prefetch int `json:"prefetch,omitempty"`
+}
+
+// RateLimitConfig limits the events sent by each client
+type RateLimitConfig struct {
+	// EventsPerSecond is the sustained rate, rate limiting is disabled if zero
+	EventsPerSecond float64 `json:"eventsPerSecond"`
+	// Burst is the number of events a client can send at once
+	Burst int `json:"burst"`
+}
+
+// AuthConfig selects how callers of the event endpoint are authenticated
+type AuthConfig struct {
+	// Type is one of "apiKey", "hmac" or "jwt", authentication is disabled if empty
+	Type string `json:"type"`
+	// Header carries the API key or the HMAC signature
+	Header string `json:"header,omitempty"`
+	// APIKeys maps caller names to their API key
+	APIKeys map[string]string `json:"apiKeys,omitempty"`
+	// Secret is the shared secret of HMAC signatures and HS256 JWTs
+	Secret string `json:"secret,omitempty"`
+	// Permissions maps event names to the callers allowed to send them
+	// The "*" entry applies to events that are not listed
+	Permissions map[string][]string `json:"permissions,omitempty"`
+	// Roles maps caller names to their roles, checked against the roles of
+	// the transitions
+	Roles map[string][]string `json:"roles,omitempty"`
+	// RolesClaim is the JWT claim listing the roles of the caller, as an
+	// array or a space separated string such as "scope", "roles" by default
+	RolesClaim string `json:"rolesClaim,omitempty"`
+}
+
+// defaultConfig returns the settings used without a config file
+func defaultConfig() Config {
+	return Config{Addr: ":3000", MaxBodyBytes: 1 << 20}
+}
+
+// loadConfig reads the server settings from a JSON file
+func loadConfig(fileName string) (Config, error) {
+	cfg := defaultConfig()
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return cfg, err
+	}
+	err = json.Unmarshal(data, &cfg)
+	return cfg, err
+}
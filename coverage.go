@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ditek/jsonfsm/gofsm"
+)
+
+// coverageHandler reports the states and transitions exercised by the
+// machines of the tenant, within ?window=24h if set, for the definition
+// given on the command line and the uploaded ones, or only the one named
+// by ?definition=
+func (s *server) coverageHandler(w http.ResponseWriter, r *http.Request) {
+	if s.coverage == nil {
+		gofsm.RespondWithError(w, http.StatusNotFound, "coverage is disabled")
+		return
+	}
+	var window time.Duration
+	if value := r.URL.Query().Get("window"); value != "" {
+		var err error
+		if window, err = time.ParseDuration(value); err != nil || window < 0 {
+			gofsm.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("invalid window '%s'", value))
+			return
+		}
+	}
+	now := time.Now()
+	reports := []gofsm.CoverageReport{}
+	name := r.URL.Query().Get("definition")
+	if name == "" {
+		reports = append(reports, s.coverage.Report(s.def, window, now))
+	}
+	defs := s.definitions.all()
+	names := make([]string, 0, len(defs))
+	for defName := range defs {
+		names = append(names, defName)
+	}
+	sort.Strings(names)
+	for _, defName := range names {
+		if name != "" && defName != name {
+			continue
+		}
+		report := s.coverage.Report(defs[defName], window, now)
+		report.Definition = defName
+		reports = append(reports, report)
+	}
+	if name != "" && len(reports) == 0 {
+		gofsm.RespondWithError(w, http.StatusNotFound, "definition not found")
+		return
+	}
+	gofsm.RespondWithJSON(w, http.StatusOK, reports)
+}
+
+// headerFlags are the repeated -H flags of a command
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlags) Set(value string) error {
+	if !strings.Contains(value, ":") {
+		return fmt.Errorf("Error: Invalid header '%s', expected 'Name: value'", value)
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+// coverageCommand prints the coverage reports of a file written by
+// fsmtest.WriteCoverage, or of a server given by its URL
+// Returns the process exit code, 1 if a report is below -min percent of
+// the transitions
+func coverageCommand(args []string) int {
+	flags := flag.NewFlagSet("coverage", flag.ExitOnError)
+	window := flags.String("window", "", "only count the hits within the window before now, e.g. 24h, for servers")
+	asJSON := flags.Bool("json", false, "print the reports as JSON")
+	min := flags.Float64("min", 0, "fail below the given percent of transitions covered")
+	var headers headerFlags
+	flags.Var(&headers, "H", "header of the requests to the server, e.g. 'X-API-Key: secret', repeatable")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Println(fmt.Errorf("Usage: ./jsonfsm coverage [-window <duration>] [-json] [-min <percent>] [-H <header>] <report_file_or_server_url>"))
+		return 1
+	}
+	source := flags.Arg(0)
+	var data []byte
+	var err error
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		data, err = fetchCoverage(source, *window, headers)
+	} else {
+		data, err = ioutil.ReadFile(source)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	var reports []gofsm.CoverageReport
+	if err := json.Unmarshal(data, &reports); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", source, err)
+		return 1
+	}
+	if *asJSON {
+		out, _ := json.MarshalIndent(reports, "", "    ")
+		fmt.Println(string(out))
+	} else {
+		for i, report := range reports {
+			if i > 0 {
+				fmt.Println()
+			}
+			printCoverage(report)
+		}
+	}
+	for _, report := range reports {
+		if report.Transitions.Percent < *min {
+			return 1
+		}
+	}
+	return 0
+}
+
+// fetchCoverage gets the coverage reports of a server
+func fetchCoverage(server, window string, headers headerFlags) ([]byte, error) {
+	u := strings.TrimSuffix(server, "/") + "/coverage"
+	if window != "" {
+		u += "?window=" + url.QueryEscape(window)
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, header := range headers {
+		parts := strings.SplitN(header, ":", 2)
+		req.Header.Set(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error: %s: %s %s", u, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// printCoverage prints a report with the states and transitions never exercised
+func printCoverage(report gofsm.CoverageReport) {
+	name := report.Definition
+	if name == "" {
+		name = "(unnamed definition)"
+	}
+	fmt.Println(name)
+	if report.Since != nil {
+		fmt.Printf("  since:       %s\n", report.Since.Format(time.RFC3339))
+	}
+	fmt.Printf("  states:      %.1f%% (%d/%d)\n", report.States.Percent, report.States.Covered, report.States.Total)
+	fmt.Printf("  transitions: %.1f%% (%d/%d)\n", report.Transitions.Percent, report.Transitions.Covered, report.Transitions.Total)
+	for _, state := range report.UnvisitedStates {
+		fmt.Printf("  unvisited:   %s\n", state)
+	}
+	for _, edge := range report.NeverFired {
+		event := edge.Event
+		if event == "" {
+			event = "(eventless)"
+		}
+		fmt.Printf("  never fired: %s --%s--> %s\n", edge.From, event, edge.To)
+	}
+}
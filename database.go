@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ditek/jsonfsm/gofsm"
+	"github.com/ditek/jsonfsm/gofsm/archive"
+	"github.com/ditek/jsonfsm/gofsm/boltdb"
+	"github.com/ditek/jsonfsm/gofsm/postgres"
+	"github.com/ditek/jsonfsm/gofsm/sqlite"
+)
+
+// tenantStore keeps the definitions, sessions, instances and events of a tenant
+type tenantStore interface {
+	gofsm.Store
+	gofsm.SnapshotStore
+	gofsm.Projection
+	gofsm.AuditSink
+	gofsm.HistoryStore
+	archive.History
+}
+
+// database is the SQLite or BoltDB file or the Postgres server given with -db
+type database struct {
+	tenant func(tenant string) tenantStore
+	// locker locks the sessions of a tenant for the replicas sharing the
+	// database, it is nil when the database serves a single server
+	locker func(tenant string) gofsm.Locker
+	// retention limits the events kept for each instance, compacted every
+	// compactInterval, which is zero when the database keeps its history
+	retention       gofsm.Retention
+	compactInterval time.Duration
+	close           func() error
+}
+
+// openDatabase opens a Postgres database for a "postgres://" URL, a BoltDB
+// file for "bolt:<file>" and a SQLite file otherwise
+func openDatabase(dsn string) (*database, error) {
+	if path, ok := strings.CutPrefix(dsn, "bolt:"); ok {
+		db, err := boltdb.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		return &database{
+			tenant: func(tenant string) tenantStore { return db.Tenant(tenant) },
+			close:  db.Close,
+		}, nil
+	}
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		db, err := postgres.Open(dsn)
+		if err != nil {
+			return nil, err
+		}
+		return &database{
+			tenant: func(tenant string) tenantStore { return db.Tenant(tenant) },
+			locker: func(tenant string) gofsm.Locker { return db.Tenant(tenant) },
+			close:  db.Close,
+		}, nil
+	}
+	db, err := sqlite.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &database{
+		tenant: func(tenant string) tenantStore { return db.Tenant(tenant) },
+		close:  db.Close,
+	}, nil
+}
+
+// setRetention sets the retention of the events of the configuration, the
+// managers of the tenants compact the history of their instances
+func (db *database) setRetention(cfg DatabaseConfig) error {
+	if cfg.EventRetention == "" && cfg.MaxEvents == 0 {
+		return nil
+	}
+	if db == nil {
+		return fmt.Errorf("Error: The event retention needs a database, see -db")
+	}
+	if cfg.MaxEvents < 0 {
+		return fmt.Errorf("Error: Invalid maximum number of events %d", cfg.MaxEvents)
+	}
+	db.retention.MaxEvents = cfg.MaxEvents
+	if cfg.EventRetention != "" {
+		retention, err := time.ParseDuration(cfg.EventRetention)
+		if err != nil {
+			return err
+		}
+		db.retention.MaxAge = retention
+	}
+	db.compactInterval = time.Hour
+	if cfg.CompactInterval != "" {
+		interval, err := time.ParseDuration(cfg.CompactInterval)
+		if err != nil {
+			return err
+		}
+		db.compactInterval = interval
+	}
+	return nil
+}
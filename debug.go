@@ -0,0 +1,178 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/ditek/jsonfsm/gofsm"
+	"github.com/gorilla/mux"
+)
+
+// debugHistory is the number of transitions kept for the debugger page
+const debugHistory = 200
+
+// debugAdminRole is the role needed to step a session back when the
+// requests are authenticated
+const debugAdminRole = "admin"
+
+//go:embed debug.html
+var debugPage []byte
+
+// debugger serves a web page that draws the machine and follows its transitions live
+type debugger struct {
+	def  *gofsm.Definition
+	auth *authenticator
+	// tenants serve the sessions of the callers, set once created
+	tenants *tenants
+
+	mu          sync.Mutex
+	history     []gofsm.TransitionRecord
+	subscribers map[chan gofsm.TransitionRecord]struct{}
+}
+
+// newDebugger creates a debugger for the machines of the tenants
+// Its record method must listen to the manager of each tenant before the
+// sessions are created, so it sees all their transitions
+func newDebugger(def *gofsm.Definition, auth *authenticator) *debugger {
+	return &debugger{
+		def:         def,
+		auth:        auth,
+		subscribers: map[chan gofsm.TransitionRecord]struct{}{},
+	}
+}
+
+// record keeps a transition and passes it on to the open pages
+// Pages that don't keep up miss transitions rather than block the machine
+func (d *debugger) record(record gofsm.TransitionRecord) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.history = append(d.history, record)
+	if len(d.history) > debugHistory {
+		d.history = d.history[len(d.history)-debugHistory:]
+	}
+	for ch := range d.subscribers {
+		select {
+		case ch <- record:
+		default:
+		}
+	}
+}
+
+// routes registers the debugger end points, wrapped e.g. to authenticate them
+func (d *debugger) routes(r *mux.Router, wrap func(http.Handler) http.Handler) {
+	r.Handle("/debug", wrap(http.HandlerFunc(d.pageHandler))).Methods("GET")
+	r.Handle("/debug/machine", wrap(http.HandlerFunc(d.machineHandler))).Methods("GET")
+	r.Handle("/debug/stream", wrap(http.HandlerFunc(d.streamHandler))).Methods("GET")
+	r.Handle("/debug/stepback", wrap(http.HandlerFunc(d.stepBackHandler))).Methods("POST")
+}
+
+func (d *debugger) pageHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(debugPage)
+}
+
+// machine returns the machine of a session of the tenant of the caller,
+// responding with an error if there is none
+func (d *debugger) machine(w http.ResponseWriter, r *http.Request) (*gofsm.Machine, bool) {
+	s, err := d.tenants.get(tenantFromRequest(r))
+	if err != nil {
+		gofsm.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	fsm, ok := s.manager.Get(r.URL.Query().Get("session"))
+	if !ok {
+		gofsm.RespondWithError(w, http.StatusNotFound, "session not found")
+	}
+	return fsm, ok
+}
+
+// machineHandler returns the definition, the state of a session and its recent transitions
+func (d *debugger) machineHandler(w http.ResponseWriter, r *http.Request) {
+	fsm, ok := d.machine(w, r)
+	if !ok {
+		return
+	}
+	session, tenant := r.URL.Query().Get("session"), tenantFromRequest(r)
+	history := []gofsm.TransitionRecord{}
+	d.mu.Lock()
+	for _, record := range d.history {
+		if record.Tenant == tenant && record.Machine == session {
+			history = append(history, record)
+		}
+	}
+	d.mu.Unlock()
+	gofsm.RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"definition": d.def,
+		"snapshot":   fsm.Snapshot(),
+		"history":    history,
+		"steps":      len(fsm.History()),
+	})
+}
+
+// stepBackHandler rewinds a session of the tenant of the caller by the
+// number of steps given as 'n', 1 by default
+// Authenticated callers need the admin role
+func (d *debugger) stepBackHandler(w http.ResponseWriter, r *http.Request) {
+	if d.auth.enabled() && !hasRole(rolesFromRequest(r), debugAdminRole) {
+		gofsm.RespondWithError(w, http.StatusForbidden, fmt.Sprintf("Error: Stepping back needs the '%s' role", debugAdminRole))
+		return
+	}
+	fsm, ok := d.machine(w, r)
+	if !ok {
+		return
+	}
+	n := 1
+	if arg := r.URL.Query().Get("n"); arg != "" {
+		var err error
+		if n, err = strconv.Atoi(arg); err != nil {
+			gofsm.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if err := fsm.StepBack(n); err != nil {
+		gofsm.RespondWithError(w, http.StatusConflict, err.Error())
+		return
+	}
+	gofsm.RespondWithJSON(w, http.StatusOK, fsm.Snapshot())
+}
+
+// streamHandler sends the transitions of the sessions of the tenant of the
+// caller as server-sent events
+func (d *debugger) streamHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		gofsm.RespondWithError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	ch := make(chan gofsm.TransitionRecord, 16)
+	d.mu.Lock()
+	d.subscribers[ch] = struct{}{}
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		delete(d.subscribers, ch)
+		d.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case record := <-ch:
+			if record.Tenant != tenantFromRequest(r) {
+				continue
+			}
+			data, _ := json.Marshal(record)
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		}
+	}
+}
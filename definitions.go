@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/ditek/jsonfsm/gofsm"
+	"github.com/gorilla/mux"
+)
+
+// definitionRegistry holds the definitions uploaded at runtime
+// The definitions are persisted in the store and loaded again on startup
+type definitionRegistry struct {
+	store       gofsm.Store
+	mu          sync.RWMutex
+	definitions map[string]*gofsm.Definition
+}
+
+// definitionInfo is the summary of a definition returned by the API
+type definitionInfo struct {
+	Name         string          `json:"name"`
+	Version      string          `json:"version,omitempty"`
+	Metadata     *gofsm.Metadata `json:"metadata,omitempty"`
+	InitialState string          `json:"initialState"`
+	States       int             `json:"states"`
+	Transitions  int             `json:"transitions"`
+}
+
+// newStore creates the store of a tenant selected in the config
+func newStore(tenant string, cfg StoreConfig) (gofsm.Store, error) {
+	switch cfg.Type {
+	case "", "memory":
+		return gofsm.NewMemoryStore(), nil
+	case "file":
+		if cfg.Dir == "" {
+			return nil, fmt.Errorf("Error: The file store needs a directory")
+		}
+		return gofsm.NewFileStore(tenantDir(cfg.Dir, tenant))
+	}
+	return nil, fmt.Errorf("Error: Unknown store type '%s'", cfg.Type)
+}
+
+// newDefinitionRegistry loads the definitions of the store
+// Stored definitions that are no longer valid are skipped
+func newDefinitionRegistry(store gofsm.Store) (*definitionRegistry, error) {
+	reg := &definitionRegistry{store: store, definitions: map[string]*gofsm.Definition{}}
+	names, err := store.Definitions()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		data, err := store.Definition(name)
+		if err != nil {
+			return nil, err
+		}
+		def, err := reg.load(name, data)
+		if err != nil {
+			log.Printf("Error: Skipping stored definition '%s': %v\n", name, err)
+			continue
+		}
+		reg.definitions[name] = def
+	}
+	return reg, nil
+}
+
+// load parses a definition, merged with the stored definition it extends
+// The definitions extended are resolved when loading, so machines created
+// afterwards don't see later changes of a base
+func (reg *definitionRegistry) load(name string, data []byte) (*gofsm.Definition, error) {
+	data, err := gofsm.Extend(data, name, func(base, from string) (string, []byte, error) {
+		data, err := reg.store.Definition(base)
+		return base, data, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return gofsm.LoadDefinition(data)
+}
+
+// get returns a definition by name
+func (reg *definitionRegistry) get(name string) (*gofsm.Definition, bool) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	def, ok := reg.definitions[name]
+	return def, ok
+}
+
+// all returns a copy of the loaded definitions by name
+func (reg *definitionRegistry) all() map[string]*gofsm.Definition {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	defs := make(map[string]*gofsm.Definition, len(reg.definitions))
+	for name, def := range reg.definitions {
+		defs[name] = def
+	}
+	return defs
+}
+
+// routes registers the definition end points, wrapped with the given middleware
+func (reg *definitionRegistry) routes(r *mux.Router, wrap func(http.Handler) http.Handler) {
+	r.Handle("/definitions", wrap(http.HandlerFunc(reg.listHandler))).Methods("GET")
+	r.Handle("/definitions/{name}", wrap(http.HandlerFunc(reg.getHandler))).Methods("GET")
+	r.Handle("/definitions/{name}", wrap(http.HandlerFunc(reg.putHandler))).Methods("PUT")
+	r.Handle("/definitions/{name}", wrap(http.HandlerFunc(reg.deleteHandler))).Methods("DELETE")
+}
+
+func info(name string, def *gofsm.Definition) definitionInfo {
+	return definitionInfo{
+		Name:         name,
+		Version:      def.Version,
+		Metadata:     def.Metadata,
+		InitialState: def.InitialState,
+		States:       len(def.States),
+		Transitions:  len(def.Transitions),
+	}
+}
+
+func (reg *definitionRegistry) listHandler(w http.ResponseWriter, r *http.Request) {
+	names, err := reg.store.Definitions()
+	if err != nil {
+		gofsm.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	infos := make([]definitionInfo, 0, len(names))
+	for _, name := range names {
+		if def, ok := reg.get(name); ok {
+			infos = append(infos, info(name, def))
+		}
+	}
+	gofsm.RespondWithJSON(w, http.StatusOK, infos)
+}
+
+// getHandler returns the definition as it was uploaded
+func (reg *definitionRegistry) getHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := reg.store.Definition(mux.Vars(r)["name"])
+	if err == gofsm.ErrNotFound {
+		gofsm.RespondWithError(w, http.StatusNotFound, "definition not found")
+		return
+	}
+	if err != nil {
+		gofsm.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// putHandler validates and stores a definition, replacing any previous one
+// Machines already running keep the definition they were created from
+func (reg *definitionRegistry) putHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	name := mux.Vars(r)["name"]
+	if err := gofsm.CheckName(name); err != nil {
+		gofsm.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		gofsm.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	def, err := reg.load(name, data)
+	if errs, ok := err.(gofsm.ValidationErrors); ok {
+		gofsm.RespondWithJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"error":   "Error: Invalid definition",
+			"details": errs,
+		})
+		return
+	}
+	if err != nil {
+		gofsm.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if err := reg.store.SaveDefinition(name, data); err != nil {
+		gofsm.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	code := http.StatusCreated
+	if _, ok := reg.definitions[name]; ok {
+		code = http.StatusOK
+	}
+	reg.definitions[name] = def
+	gofsm.RespondWithJSON(w, code, info(name, def))
+}
+
+func (reg *definitionRegistry) deleteHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	err := reg.store.DeleteDefinition(name)
+	if err == gofsm.ErrNotFound {
+		gofsm.RespondWithError(w, http.StatusNotFound, "definition not found")
+		return
+	}
+	if err != nil {
+		gofsm.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	delete(reg.definitions, name)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,66 @@
+package gofsm
+
+import (
+	"errors"
+)
+
+// ErrInstanceTerminated is returned for the events of an aborted machine
+var ErrInstanceTerminated = errors.New("Error: The instance is terminated")
+
+// AuditAborted is the kind of the audit records of aborted machines
+const AuditAborted = "instance.aborted"
+
+// Abort terminates the machine: its timers and schedules are stopped, its
+// running sub-machine is aborted, the compensations of its completed states
+// are run in reverse order, its pending human task is withdrawn and further
+// events are rejected with ErrInstanceTerminated
+// The machine keeps its current state and context, and the reason is audited
+func (fsm *Machine) Abort(reason string) error {
+	fsm = fsm.root()
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	if fsm.terminated {
+		return ErrInstanceTerminated
+	}
+	fsm.halt()
+	if fsm.child != nil {
+		// The child is terminated with its parent, it compensates its own steps
+		fsm.child.Abort(reason)
+	}
+	fsm.compensate(Event{Action: "abort", Param: reason})
+	// The pending human task and asynchronous action are withdrawn
+	delete(fsm.Context, ContextTask)
+	delete(fsm.Context, ContextPendingAction)
+	fsm.terminated = true
+	fsm.abortReason = reason
+	fsm.abortedAt = fsm.clock().Now()
+	fsm.updateCorrelation(Event{})
+	fsm.audit(AuditRecord{
+		Kind:  AuditAborted,
+		Param: reason,
+		From:  fsm.CurrentState.Name,
+		To:    fsm.CurrentState.Name,
+	})
+	return nil
+}
+
+// Terminated tells if the machine was aborted and why
+func (fsm *Machine) Terminated() (reason string, terminated bool) {
+	fsm = fsm.root()
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	return fsm.abortReason, fsm.terminated
+}
+
+// Abort terminates the machine of a session, see Machine.Abort
+// With a shared store, the terminated machine is saved so other replicas
+// reject its events too
+func (m *Manager) Abort(id, reason string) error {
+	if _, ok := m.Snapshot(id); !ok {
+		return ErrSessionNotFound
+	}
+	_, err := m.run(id, func(error) {}, func(fsm *Machine) (TransitionResult, error) {
+		return TransitionResult{}, fsm.Abort(reason)
+	})
+	return err
+}
@@ -0,0 +1,182 @@
+package gofsm
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ActionFunc is the signature of an action that can be triggered by a state
+// It receives the action argument and the response writer of the event and
+// returns whether the action succeeded
+type ActionFunc func(fsm *Machine, arg string) bool
+
+// ActionRegistry maps action names to their implementation
+type ActionRegistry struct {
+	mu      sync.RWMutex
+	actions map[string]ActionFunc
+}
+
+// NewActionRegistry creates an empty action registry
+func NewActionRegistry() *ActionRegistry {
+	return &ActionRegistry{actions: map[string]ActionFunc{}}
+}
+
+// Actions is the package-level registry used by the machines that
+// weren't given their own registry
+// It comes with the built-in actions already registered
+var Actions = NewDefaultActionRegistry()
+
+// NewDefaultActionRegistry creates a registry with the built-in actions
+func NewDefaultActionRegistry() *ActionRegistry {
+	r := NewActionRegistry()
+	r.Register("Log", (*Machine).Log)
+	r.Register("ValidateCode", (*Machine).ValidateCode)
+	r.Register("SendResponse", (*Machine).SendResponse)
+	r.Register("Sleep", (*Machine).Sleep)
+	r.Register("HTTPRequest", (*Machine).HTTPRequest)
+	r.Register("SetVariable", (*Machine).SetVariable)
+	r.Register("SetVar", (*Machine).SetVar)
+	r.Register("Compare", (*Machine).Compare)
+	return r
+}
+
+// Register adds an action to the registry
+// Returns an error if an action with the same name already exists
+func (r *ActionRegistry) Register(name string, action ActionFunc) error {
+	if name == "" || action == nil {
+		return fmt.Errorf("Error: An action needs a name and a function")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.actions[name]; ok {
+		return fmt.Errorf("Error: Action '%s' is already registered", name)
+	}
+	r.actions[name] = action
+	return nil
+}
+
+// Unregister removes an action from the registry
+func (r *ActionRegistry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.actions, name)
+}
+
+// Get returns the action with the given name or nil if not found
+func (r *ActionRegistry) Get(name string) ActionFunc {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.actions[name]
+}
+
+// Clone returns an independent copy of the registry
+// Useful to override a few actions of an existing registry
+func (r *ActionRegistry) Clone() *ActionRegistry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	clone := NewActionRegistry()
+	for name, action := range r.actions {
+		clone.actions[name] = action
+	}
+	return clone
+}
+
+// Names returns the names of all registered actions
+func (r *ActionRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.actions))
+	for name := range r.actions {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Namespace returns a view of the registry where all names
+// are prefixed with the given namespace, e.g. "payments.Charge"
+func (r *ActionRegistry) Namespace(ns string) *ActionNamespace {
+	return &ActionNamespace{registry: r, prefix: ns + "."}
+}
+
+// ActionNamespace registers actions under a common prefix
+type ActionNamespace struct {
+	registry *ActionRegistry
+	prefix   string
+}
+
+// Register adds an action under the namespace
+func (n *ActionNamespace) Register(name string, action ActionFunc) error {
+	return n.registry.Register(n.prefix+name, action)
+}
+
+// Unregister removes an action from the namespace
+func (n *ActionNamespace) Unregister(name string) {
+	n.registry.Unregister(n.prefix + name)
+}
+
+/******* Built-in Actions ********/
+
+// Sleep blocks for the duration given as argument, e.g. "500ms"
+// The duration is measured on the clock of the machine, and the sleep fails
+// when the action timeout of the state expires first
+func (fsm *Machine) Sleep(arg string) bool {
+	d, err := time.ParseDuration(arg)
+	if err != nil {
+		log.Println("Error: Invalid sleep duration:", err)
+		return false
+	}
+	select {
+	case <-fsm.clock().After(d):
+		return true
+	case <-fsm.ActionContext().Done():
+		return false
+	}
+}
+
+// SetVariable stores a variable in the FSM context
+// The argument has the form "name=value", the value of a declared variable
+// is converted to its type
+func (fsm *Machine) SetVariable(arg string) bool {
+	name, text, ok := splitVariable(arg)
+	if !ok {
+		fsm.logf("Error: Invalid variable assignment '%s'", arg)
+		return false
+	}
+	var value interface{} = text
+	if decl := fsm.variable(name); decl != nil {
+		var err error
+		if value, err = decl.parse(text); err != nil {
+			fsm.logf("Error: Variable '%s' can't be set: %v", name, err)
+			return false
+		}
+	}
+	if fsm.Context == nil {
+		fsm.Context = map[string]interface{}{}
+	}
+	fsm.Context[name] = value
+	return true
+}
+
+// Compare checks a variable in the FSM context against a value
+// The argument has the form "name=value"
+func (fsm *Machine) Compare(arg string) bool {
+	name, value, ok := splitVariable(arg)
+	if !ok {
+		fsm.logf("Error: Invalid comparison '%s'", arg)
+		return false
+	}
+	current, found := fsm.Context[name]
+	return found && fmt.Sprint(current) == value
+}
+
+// splitVariable splits a "name=value" argument
+func splitVariable(arg string) (string, string, bool) {
+	parts := strings.SplitN(arg, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return "", "", false
+	}
+	return strings.TrimSpace(parts[0]), parts[1], true
+}
@@ -0,0 +1,112 @@
+package gofsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrActionTimeout is matched by errors.Is for the actions that exceeded the
+// actionTimeout of their state
+var ErrActionTimeout = errors.New("Error: Action timed out")
+
+// actionTimeoutError names the action that timed out
+type actionTimeoutError struct {
+	action  string
+	state   string
+	timeout string
+}
+
+func (e actionTimeoutError) Error() string {
+	return fmt.Sprintf("Error: Action '%s' timed out after %s in state '%s'", e.action, e.timeout, e.state)
+}
+
+func (e actionTimeoutError) Is(target error) bool {
+	return target == ErrActionTimeout
+}
+
+// ActionContext returns the context of the running actions of the state,
+// cancelled with ErrActionTimeout as the cause once the actionTimeout of the
+// state expires. Long actions should read it when they start and stop once
+// it is done, the actions that don't are abandoned and their result is lost
+// It is never cancelled for the states without actionTimeout
+func (fsm *Machine) ActionContext() context.Context {
+	if fsm.call != nil {
+		// The context of an abandoned action stays cancelled
+		return fsm.call.ctx
+	}
+	fsm.actionMu.Lock()
+	defer fsm.actionMu.Unlock()
+	if fsm.actionCtx == nil {
+		return context.Background()
+	}
+	return fsm.actionCtx
+}
+
+// startActionTimeout gives the actions of the current state a context that
+// is cancelled once its actionTimeout expires, on the clock of the machine
+// The returned function ends the context once the actions are done
+func (fsm *Machine) startActionTimeout() (func(), error) {
+	state := fsm.CurrentState
+	timeout, err := time.ParseDuration(state.ActionTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("Error: Invalid action timeout '%s' in state '%s': %v", state.ActionTimeout, state.Name, err)
+	}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	timer := fsm.clock().AfterFunc(timeout, func() {
+		cancel(ErrActionTimeout)
+	})
+	fsm.actionMu.Lock()
+	fsm.actionCtx = ctx
+	fsm.actionMu.Unlock()
+	return func() {
+		timer.Stop()
+		cancel(nil)
+		fsm.actionMu.Lock()
+		fsm.actionCtx = nil
+		fsm.actionMu.Unlock()
+	}, nil
+}
+
+// callBounded calls an action with its handle on the worker pool of the
+// machine, or on another goroutine, and waits for it until the action context
+// is done, the action is then abandoned and fails with ErrActionTimeout
+// The handle of an abandoned action works on its own copy of the context and
+// its responses and events are dropped, see actionCall
+// Without a pool and an action timeout, the action is called directly
+func (fsm *Machine) callBounded(name string, call func(h *Machine) (bool, error)) (bool, error) {
+	ctx := fsm.ActionContext()
+	h := fsm.handle(ctx, ctx.Done() != nil)
+	if ctx.Done() == nil && fsm.pool == nil {
+		success, err := call(h)
+		fsm.release(h, false)
+		return success, err
+	}
+	timedOut := actionTimeoutError{action: name, state: fsm.CurrentState.Name, timeout: fsm.CurrentState.ActionTimeout}
+	if ctx.Err() != nil {
+		// The previous actions of the state used up the time
+		fsm.release(h, true)
+		return false, timedOut
+	}
+	done := make(chan actionResult, 1)
+	run := func() {
+		success, err := call(h)
+		done <- actionResult{success: success, err: err}
+	}
+	if fsm.pool == nil {
+		go run()
+	} else if err := fsm.pool.submit(ctx, name, run); err != nil {
+		// The time ran out waiting for a worker
+		fsm.release(h, true)
+		return false, timedOut
+	}
+	select {
+	case r := <-done:
+		fsm.release(h, false)
+		return r.success, r.err
+	case <-ctx.Done():
+		fsm.release(h, true)
+		return false, timedOut
+	}
+}
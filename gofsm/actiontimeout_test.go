@@ -0,0 +1,80 @@
+package gofsm
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestAbandonedActionDropped checks that an action still running after its
+// timeout can't change the machine, run with -race
+func TestAbandonedActionDropped(t *testing.T) {
+	def, err := LoadDefinition([]byte(`{
+		"initialState": "IDLE",
+		"context": {"count": 0},
+		"states": [
+			{"name": "IDLE", "action": "Log", "waitForEvent": true},
+			{"name": "SLOW", "action": "Ignore", "actionTimeout": "10ms", "waitForEvent": true},
+			{"name": "DONE", "action": "Log", "waitForEvent": true}
+		],
+		"transitions": [
+			{"from": "IDLE", "toSuccess": "SLOW", "event": "start"},
+			{"from": "SLOW", "toSuccess": "DONE", "toFailure": "IDLE", "branch": true, "event": "go"},
+			{"from": "IDLE", "toSuccess": "IDLE", "event": "late"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	returned := make(chan error, 1)
+	actions := NewDefaultActionRegistry()
+	actions.Register("Ignore", func(fsm *Machine, arg string) bool {
+		// Ignores the action context and keeps using the machine afterwards
+		time.Sleep(50 * time.Millisecond)
+		fsm.Context["abandoned"] = true
+		fsm.Respond(200, "late")
+		fsm.SetOutcome("late")
+		fsm.Emit("late", "")
+		fsm.Log("still running")
+		if token := fsm.Pending(); token != "" {
+			returned <- errors.New("Pending returned a token")
+			return true
+		}
+		_, err := fsm.SendEvent(Event{Action: "late"})
+		returned <- err
+		return true
+	})
+	fsm := NewMachine(def, WithActions(actions))
+	fsm.Init()
+	if _, err := fsm.SendEvent(Event{Action: "start"}); err != nil {
+		t.Fatal(err)
+	}
+	result, err := fsm.SendEvent(Event{Action: "go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ToState != "IDLE" {
+		t.Fatalf("Got state %s after the timeout, want IDLE", result.ToState)
+	}
+	// The machine keeps processing events meanwhile
+	for i := 0; i < 10; i++ {
+		fsm.SendEvent(Event{Action: "late"})
+		fsm.Snapshot()
+		time.Sleep(5 * time.Millisecond)
+	}
+	select {
+	case err := <-returned:
+		if !errors.Is(err, ErrActionAbandoned) {
+			t.Errorf("Got error %v from the abandoned action, want ErrActionAbandoned", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The abandoned action didn't return")
+	}
+	snapshot := fsm.Snapshot()
+	if _, ok := snapshot.Context["abandoned"]; ok {
+		t.Error("The abandoned action changed the context")
+	}
+	if snapshot.CurrentState != "IDLE" {
+		t.Errorf("Got state %s, want IDLE", snapshot.CurrentState)
+	}
+}
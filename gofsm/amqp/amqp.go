@@ -0,0 +1,109 @@
+// Package amqp provides an event source consuming events from an AMQP queue, e.g. on RabbitMQ
+package amqp
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/ditek/jsonfsm/gofsm"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Source consumes JSON events from an AMQP queue
+// A message is acked once its transition, and the snapshot of the machine
+// when the manager persists them, succeeded. Events that are rejected are
+// dead-lettered, and other failures are requeued once
+type Source struct {
+	conn       *amqp.Connection
+	queue      string
+	deadLetter string
+	prefetch   int
+}
+
+var _ gofsm.EventSource = (*Source)(nil)
+
+// NewSource creates a source for the given queue
+// With a dead letter queue, both queues are declared and the rejected
+// messages are routed to the dead letter queue by the default exchange
+func NewSource(conn *amqp.Connection, queue, deadLetterQueue string, prefetch int) *Source {
+	return &Source{conn: conn, queue: queue, deadLetter: deadLetterQueue, prefetch: prefetch}
+}
+
+// Run consumes messages until the context is cancelled or the channel is closed
+// Messages are processed one at a time, in the order of the queue
+func (s *Source) Run(ctx context.Context, sink gofsm.EventSink) error {
+	ch, err := s.conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	if err := s.declare(ch); err != nil {
+		return err
+	}
+	if s.prefetch > 0 {
+		if err := ch.Qos(s.prefetch, 0, false); err != nil {
+			return err
+		}
+	}
+	deliveries, err := ch.Consume(s.queue, "", false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case d, ok := <-deliveries:
+			if !ok {
+				return fmt.Errorf("Error: AMQP channel of queue '%s' closed", s.queue)
+			}
+			if err := s.handle(d, sink); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// declare declares the queue and its dead letter queue
+func (s *Source) declare(ch *amqp.Channel) error {
+	if s.deadLetter == "" {
+		return nil
+	}
+	if _, err := ch.QueueDeclare(s.deadLetter, true, false, false, false, nil); err != nil {
+		return err
+	}
+	args := amqp.Table{
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": s.deadLetter,
+	}
+	_, err := ch.QueueDeclare(s.queue, true, false, false, false, args)
+	return err
+}
+
+// handle sends the event of a message to the sink and settles the message
+func (s *Source) handle(d amqp.Delivery, sink gofsm.EventSink) error {
+	event, err := gofsm.DecodeEvent(d.Body)
+	if err != nil {
+		log.Printf("Error: Malformed event in message %d of queue '%s': %v\n", d.DeliveryTag, s.queue, err)
+		return d.Nack(false, false)
+	}
+	_, err = sink.SendEvent(event)
+	switch {
+	case err == nil || err == gofsm.ErrDuplicateEvent:
+		return d.Ack(false)
+	case gofsm.Rejected(err):
+		log.Println(err)
+		return d.Nack(false, false)
+	default:
+		// Failures that may be transient, e.g. of the snapshot store, get
+		// another try before the message is dead-lettered
+		log.Println(err)
+		return d.Nack(false, !d.Redelivered)
+	}
+}
+
+// Close closes the connection
+func (s *Source) Close() error {
+	return s.conn.Close()
+}
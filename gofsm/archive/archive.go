@@ -0,0 +1,192 @@
+// Package archive moves the completed instances of a session manager to
+// object storage once they are old enough, with their final snapshot and
+// their event history, keeping the hot store small while preserving the
+// audit trail
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/ditek/jsonfsm/gofsm"
+)
+
+// Config describes the object storage and when instances are archived
+type Config struct {
+	// Type is "s3" or "dir", archiving is disabled if empty
+	Type string `json:"type"`
+	// Endpoint is the host of the S3 service, e.g. "s3.amazonaws.com" or "minio:9000"
+	Endpoint string `json:"endpoint,omitempty"`
+	// Region is the region of the bucket, optional
+	Region    string `json:"region,omitempty"`
+	Bucket    string `json:"bucket,omitempty"`
+	AccessKey string `json:"accessKey,omitempty"`
+	SecretKey string `json:"secretKey,omitempty"`
+	// Insecure connects to the S3 service without TLS, for local services
+	Insecure bool `json:"insecure,omitempty"`
+	// Dir is the directory of the "dir" storage, e.g. a mounted volume
+	Dir string `json:"dir,omitempty"`
+	// Prefix starts the keys of the archives, e.g. "jsonfsm/"
+	Prefix string `json:"prefix,omitempty"`
+	// Retention is how long completed instances stay in the hot store, "24h" by default
+	Retention string `json:"retention,omitempty"`
+	// Interval is the time between the archiving passes, "1h" by default
+	Interval string `json:"interval,omitempty"`
+}
+
+// Bucket is the object storage the archives are written to
+type Bucket interface {
+	Put(ctx context.Context, key string, data []byte) error
+}
+
+// New creates the bucket described by the config
+func New(cfg Config) (Bucket, error) {
+	switch cfg.Type {
+	case "s3":
+		return NewS3Bucket(cfg)
+	case "dir":
+		return NewDirBucket(cfg.Dir)
+	}
+	return nil, fmt.Errorf("Error: Unknown archive type '%s'", cfg.Type)
+}
+
+// History is the hot store of the events and instances of the machines,
+// such as a database store
+type History interface {
+	Events(machine string) ([]gofsm.AuditRecord, error)
+	// DeleteInstance removes the instance and the events of a machine
+	DeleteInstance(id string) error
+}
+
+// Archive is the object written for a completed instance
+type Archive struct {
+	ID          string              `json:"id"`
+	Tenant      string              `json:"tenant,omitempty"`
+	Metadata    *gofsm.Metadata     `json:"metadata,omitempty"`
+	CompletedAt time.Time           `json:"completedAt"`
+	ArchivedAt  time.Time           `json:"archivedAt"`
+	Snapshot    gofsm.Snapshot      `json:"snapshot"`
+	Events      []gofsm.AuditRecord `json:"events,omitempty"`
+}
+
+// Archiver moves the instances of a manager that completed longer than the
+// retention ago to a bucket
+// An instance is only removed from the manager and the history once its
+// archive is written, a failed pass is retried on the next one
+type Archiver struct {
+	manager   *gofsm.Manager
+	bucket    Bucket
+	history   History
+	prefix    string
+	retention time.Duration
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewArchiver creates the archiver of the instances of a manager
+// The history is optional, without it the archives only hold the snapshots
+func NewArchiver(manager *gofsm.Manager, bucket Bucket, history History, prefix string, retention time.Duration) *Archiver {
+	return &Archiver{manager: manager, bucket: bucket, history: history, prefix: prefix, retention: retention}
+}
+
+// Archive runs a pass and returns the number of archived instances
+// Evicted machines are loaded back to be checked
+func (a *Archiver) Archive(ctx context.Context) (int, error) {
+	archived := 0
+	before := time.Now().Add(-a.retention)
+	for _, id := range a.manager.Sessions() {
+		if err := ctx.Err(); err != nil {
+			return archived, err
+		}
+		fsm, ok := a.manager.Get(id)
+		if !ok {
+			continue
+		}
+		completedAt, completed := fsm.Completed()
+		if !completed || completedAt.After(before) {
+			continue
+		}
+		if err := a.archive(ctx, id, fsm, completedAt); err != nil {
+			return archived, fmt.Errorf("Error: Cannot archive instance '%s': %v", id, err)
+		}
+		archived++
+	}
+	return archived, nil
+}
+
+// archive writes the archive of an instance and removes the instance
+func (a *Archiver) archive(ctx context.Context, id string, fsm *gofsm.Machine, completedAt time.Time) error {
+	archive := Archive{
+		ID:          id,
+		Tenant:      fsm.Tenant,
+		Metadata:    fsm.Metadata,
+		CompletedAt: completedAt,
+		ArchivedAt:  time.Now(),
+		Snapshot:    fsm.Snapshot(),
+	}
+	if a.history != nil {
+		events, err := a.history.Events(id)
+		if err != nil {
+			return err
+		}
+		archive.Events = events
+	}
+	data, err := json.Marshal(archive)
+	if err != nil {
+		return err
+	}
+	if err := a.bucket.Put(ctx, a.key(archive), data); err != nil {
+		return err
+	}
+	a.manager.Remove(id)
+	if a.history != nil {
+		if err := a.history.DeleteInstance(id); err != nil && err != gofsm.ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// key returns the key of the archive of an instance, under its tenant and
+// the day it completed, e.g. "acme/2024-05-01/order-17.json"
+func (a *Archiver) key(archive Archive) string {
+	return a.prefix + path.Join(archive.Tenant, archive.CompletedAt.UTC().Format("2006-01-02"), archive.ID+".json")
+}
+
+// Start runs a pass at every interval until Stop is called
+func (a *Archiver) Start(interval time.Duration) {
+	a.stop = make(chan struct{})
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			n, err := a.Archive(ctx)
+			cancel()
+			if err != nil {
+				log.Println(err)
+			}
+			if n > 0 {
+				log.Printf("Archived %d completed instances\n", n)
+			}
+			select {
+			case <-a.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops the passes, waiting for the current one
+func (a *Archiver) Stop() {
+	close(a.stop)
+	a.wg.Wait()
+}
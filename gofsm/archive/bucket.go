@@ -0,0 +1,102 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ditek/jsonfsm/gofsm"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Bucket writes the archives to a bucket of S3 or of a compatible service
+type S3Bucket struct {
+	client *minio.Client
+	bucket string
+}
+
+var _ Bucket = (*S3Bucket)(nil)
+
+// NewS3Bucket connects to the bucket of the config
+// Without keys, the credentials come from the AWS environment variables
+func NewS3Bucket(cfg Config) (*S3Bucket, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, fmt.Errorf("Error: The s3 archive needs an endpoint and a bucket")
+	}
+	creds := credentials.NewEnvAWS()
+	if cfg.AccessKey != "" {
+		creds = credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, "")
+	}
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: !cfg.Insecure,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &S3Bucket{client: client, bucket: cfg.Bucket}, nil
+}
+
+// Put uploads an archive
+func (b *S3Bucket) Put(ctx context.Context, key string, data []byte) error {
+	_, err := b.client.PutObject(ctx, b.bucket, key, bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: "application/json"})
+	return err
+}
+
+// DirBucket writes the archives to the files of a directory
+type DirBucket struct {
+	dir string
+}
+
+var _ Bucket = (*DirBucket)(nil)
+
+// NewDirBucket creates the directory if needed
+func NewDirBucket(dir string) (*DirBucket, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("Error: The dir archive needs a directory")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &DirBucket{dir: dir}, nil
+}
+
+// Put writes an archive to the file named after its key, replacing it
+// atomically so a file is never left half written
+func (b *DirBucket) Put(ctx context.Context, key string, data []byte) error {
+	path := filepath.Join(b.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// encryptedBucket encrypts the archives written to a bucket, see EncryptBucket
+type encryptedBucket struct {
+	bucket Bucket
+	enc    gofsm.Encrypter
+}
+
+// EncryptBucket wraps a bucket so the archives are encrypted before they are
+// written, their keys staying readable
+func EncryptBucket(bucket Bucket, enc gofsm.Encrypter) Bucket {
+	return &encryptedBucket{bucket: bucket, enc: enc}
+}
+
+// Put encrypts an archive and writes it
+func (b *encryptedBucket) Put(ctx context.Context, key string, data []byte) error {
+	sealed, err := b.enc.Encrypt(data)
+	if err != nil {
+		return err
+	}
+	return b.bucket.Put(ctx, key, sealed)
+}
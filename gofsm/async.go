@@ -0,0 +1,254 @@
+package gofsm
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ContextPendingAction is the context key of the asynchronous action the
+// machine waits for. Keeping it in the context lets snapshots keep it
+const ContextPendingAction = "pendingAction"
+
+// AuditActionCompleted is the kind of the audit records of the asynchronous actions completed
+const AuditActionCompleted = "action.completed"
+
+// OutcomePending means that the action completes later, see Machine.Pending
+const OutcomePending Outcome = "pending"
+
+// Errors of the asynchronous actions
+var (
+	// ErrActionPending is returned for the events sent while the machine waits for an action
+	ErrActionPending = errors.New("Error: The machine is waiting for the result of an action")
+	// ErrActionNotPending is returned for the results of actions the machine doesn't wait for
+	ErrActionNotPending = errors.New("Error: No action is pending with this token")
+)
+
+// ActionResult is the result of an asynchronous action
+type ActionResult struct {
+	Success bool `json:"success"`
+	// Outcome selects the destination of a transition declaring 'outcomes'
+	Outcome string `json:"outcome,omitempty"`
+	// Data is stored in the context before the transition goes on
+	Data map[string]interface{} `json:"data,omitempty"`
+}
+
+// PendingAction is an asynchronous action the machine waits for
+type PendingAction struct {
+	Token   string    `json:"token"`
+	Session string    `json:"session"`
+	State   string    `json:"state"`
+	Action  string    `json:"action,omitempty"`
+	Started time.Time `json:"started"`
+}
+
+// Pending makes the running action asynchronous and returns the token of
+// its result. If the action succeeds, the transition stops there and the
+// machine stays in its state, rejecting the events with ErrActionPending,
+// until CompleteAction is called with the token and the actual result. If
+// the action fails, the failure applies at once
+// The token is passed to the external job doing the work, e.g. in a callback
+// URL. The actions of a state share the same token
+// Timeouts of the state still fire, leaving the state drops the pending action
+// Abandoned actions get no token
+func (fsm *Machine) Pending() string {
+	fsm.actionMu.Lock()
+	defer fsm.actionMu.Unlock()
+	if fsm.abandoned() {
+		return ""
+	}
+	if fsm.pendingToken == "" {
+		b := make([]byte, 16)
+		rand.Read(b)
+		// The session in the token finds the machine of the result
+		fsm.pendingToken = hex.EncodeToString(b) + "." + fsm.ID
+	}
+	return fsm.pendingToken
+}
+
+// takePending returns and clears the token of the running actions, if any
+func (fsm *Machine) takePending() string {
+	fsm.actionMu.Lock()
+	defer fsm.actionMu.Unlock()
+	token := fsm.pendingToken
+	fsm.pendingToken = ""
+	return token
+}
+
+// suspend keeps the transition at the given index waiting for the result of
+// the pending action
+func (fsm *Machine) suspend(token string, index int, event Event) error {
+	if fsm.Context == nil {
+		fsm.Context = map[string]interface{}{}
+	}
+	fsm.Context[ContextPendingAction] = map[string]interface{}{
+		"token":      token,
+		"state":      fsm.CurrentState.Name,
+		"action":     fsm.CurrentState.Action,
+		"transition": index,
+		"event":      event.Action,
+		"param":      event.Param,
+		"data":       event.Data,
+		"started":    fsm.clock().Now().Format(time.RFC3339Nano),
+	}
+	if fsm.result != nil && fsm.result.ActionOutcome == "" {
+		fsm.result.ActionOutcome = OutcomePending
+	}
+	fsm.recordPending()
+	return nil
+}
+
+// pendingRecord returns the record of the pending action of the locked machine
+func (fsm *Machine) pendingRecord() (map[string]interface{}, bool) {
+	record, ok := fsm.Context[ContextPendingAction].(map[string]interface{})
+	if !ok || record["state"] != fsm.CurrentState.Name {
+		return nil, false
+	}
+	return record, true
+}
+
+// pendingOf returns the pending action recorded in the snapshot of a session
+func pendingOf(id string, snap Snapshot) (PendingAction, bool) {
+	record, ok := snap.Context[ContextPendingAction].(map[string]interface{})
+	if !ok || record["state"] != snap.CurrentState {
+		return PendingAction{}, false
+	}
+	field := func(name string) string {
+		s, _ := record[name].(string)
+		return s
+	}
+	pending := PendingAction{
+		Token:   field("token"),
+		Session: id,
+		State:   field("state"),
+		Action:  field("action"),
+	}
+	pending.Started, _ = time.Parse(time.RFC3339Nano, field("started"))
+	return pending, true
+}
+
+// PendingAction returns the asynchronous action the machine waits for
+func (fsm *Machine) PendingAction() (PendingAction, bool) {
+	fsm = fsm.root()
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	return pendingOf(fsm.ID, Snapshot{CurrentState: fsm.CurrentState.Name, Context: fsm.Context})
+}
+
+// CompleteAction resumes the transition that waits for an asynchronous
+// action with its result: the data is stored in the context, and the
+// transition goes on as if the action had just returned, e.g. taking the
+// 'toFailure' branch if it failed
+// Returns ErrActionNotPending if the token isn't the one of the pending action,
+// and an error if the data doesn't have the type of a declared variable
+func (fsm *Machine) CompleteAction(token string, result ActionResult) (TransitionResult, error) {
+	fsm = fsm.root()
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	res := TransitionResult{FromState: fsm.CurrentState.Name, Path: []string{}}
+	record, ok := fsm.pendingRecord()
+	if !ok || record["token"] != token {
+		return res, ErrActionNotPending
+	}
+	if fsm.terminated {
+		return res, ErrInstanceTerminated
+	}
+	if fsm.paused {
+		return res, ErrInstancePaused
+	}
+	// Snapshots turn the index into a float64
+	var index int
+	switch i := record["transition"].(type) {
+	case int:
+		index = i
+	case float64:
+		index = int(i)
+	}
+	if index < 0 || index >= len(fsm.Transitions) {
+		return res, ErrActionNotPending
+	}
+	t := fsm.Transitions[index]
+	// The result can't change the type of the declared variables
+	data := make(map[string]interface{}, len(result.Data))
+	for k, v := range result.Data {
+		if decl := fsm.variable(k); decl != nil {
+			var err error
+			if v, err = decl.check(v); err != nil {
+				return res, fmt.Errorf("Error: Variable '%s' can't be set: %v", k, err)
+			}
+		}
+		data[k] = v
+	}
+	// The action of the machine resumes, like its timers
+	event := Event{Session: fsm.ID, internal: true}
+	event.Action, _ = record["event"].(string)
+	event.Param, _ = record["param"].(string)
+	event.Data, _ = record["data"].(map[string]interface{})
+
+	fsm.result = &res
+	err := fsm.runToCompletion(StepCompletion, event, func() error {
+		delete(fsm.Context, ContextPendingAction)
+		fsm.recordResult(result)
+		for k, v := range data {
+			fsm.Context[k] = v
+		}
+		fsm.outcome = result.Outcome
+		// The resumed transition counts in this macrostep
+		fsm.microsteps++
+		return fsm.endTransition(t, event, result.Success, nil)
+	})
+	res.Microsteps = fsm.microsteps
+	fsm.result = nil
+	res.ToState = fsm.CurrentState.Name
+	if res.ActionOutcome == "" {
+		res.ActionOutcome = OutcomeNone
+	}
+	audit := AuditRecord{
+		Kind:    AuditActionCompleted,
+		EventID: token,
+		Event:   event.Action,
+		From:    res.FromState,
+		To:      res.ToState,
+	}
+	if err != nil {
+		audit.Error = err.Error()
+	}
+	fsm.audit(audit)
+	return res, err
+}
+
+// CompleteAction resumes the machine waiting for an asynchronous action,
+// see Machine.CompleteAction
+// The token names the session of the machine
+func (m *Manager) CompleteAction(token string, result ActionResult) (TransitionResult, error) {
+	i := strings.Index(token, ".")
+	if i < 0 {
+		return TransitionResult{}, ErrActionNotPending
+	}
+	session := token[i+1:]
+	if _, ok := m.Snapshot(session); !ok {
+		return TransitionResult{}, ErrActionNotPending
+	}
+	return m.run(session, func(error) {}, func(fsm *Machine) (TransitionResult, error) {
+		return fsm.CompleteAction(token, result)
+	})
+}
+
+// PendingActions returns the asynchronous actions the machines of all
+// sessions wait for, evicted ones included
+func (m *Manager) PendingActions() []PendingAction {
+	pending := []PendingAction{}
+	for _, id := range m.Sessions() {
+		snap, ok := m.Snapshot(id)
+		if !ok {
+			continue
+		}
+		if p, ok := pendingOf(id, snap); ok {
+			pending = append(pending, p)
+		}
+	}
+	return pending
+}
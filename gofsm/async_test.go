@@ -0,0 +1,86 @@
+package gofsm
+
+import "testing"
+
+func TestCompleteActionChecksVariables(t *testing.T) {
+	tokens := make(chan string, 1)
+	actions := NewDefaultActionRegistry()
+	actions.Register("Start", func(fsm *Machine, arg string) bool {
+		tokens <- fsm.Pending()
+		return true
+	})
+	def, err := LoadDefinition([]byte(`{
+		"initialState": "IDLE",
+		"variables": [{"name": "retries", "type": "integer"}],
+		"states": [
+			{"name": "IDLE", "action": "Start", "waitForEvent": true},
+			{"name": "DONE", "action": "Log", "waitForEvent": true}
+		],
+		"transitions": [
+			{"from": "IDLE", "toSuccess": "DONE", "event": "start"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsm := NewMachine(def, WithActions(actions))
+	fsm.Init()
+	if _, err := fsm.SendEvent(Event{Action: "start"}); err != nil {
+		t.Fatal(err)
+	}
+	token := <-tokens
+	// The data can't change the type of a declared variable
+	if _, err := fsm.CompleteAction(token, ActionResult{Success: true, Data: map[string]interface{}{"retries": "many"}}); err == nil {
+		t.Fatal("Completed with a string for an integer variable")
+	}
+	if _, ok := fsm.PendingAction(); !ok {
+		t.Fatal("The action isn't pending after the invalid result")
+	}
+	result, err := fsm.CompleteAction(token, ActionResult{Success: true, Data: map[string]interface{}{"retries": 2, "note": "ok"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ToState != "DONE" {
+		t.Errorf("Got state %s, want DONE", result.ToState)
+	}
+	snapshot := fsm.Snapshot()
+	if snapshot.Context["retries"] != 2.0 || snapshot.Context["note"] != "ok" {
+		t.Errorf("Got context %v", snapshot.Context)
+	}
+}
+
+func TestCompleteActionResumesMatchedTransition(t *testing.T) {
+	tokens := make(chan string, 1)
+	actions := NewDefaultActionRegistry()
+	actions.Register("Start", func(fsm *Machine, arg string) bool {
+		tokens <- fsm.Pending()
+		return true
+	})
+	def, err := LoadDefinition([]byte(`{
+		"initialState": "IDLE",
+		"states": [
+			{"name": "IDLE", "action": "Start", "waitForEvent": true},
+			{"name": "FAST", "action": "Log", "waitForEvent": true},
+			{"name": "SLOW", "action": "Log", "waitForEvent": true}
+		],
+		"transitions": [
+			{"from": "IDLE", "toSuccess": "FAST", "event": "start", "guard": "event.param == 'fast'"},
+			{"from": "IDLE", "toSuccess": "SLOW", "event": "start"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsm := NewMachine(def, WithActions(actions))
+	fsm.Init()
+	if _, err := fsm.SendEvent(Event{Action: "start", Param: "slow"}); err != nil {
+		t.Fatal(err)
+	}
+	result, err := fsm.CompleteAction(<-tokens, ActionResult{Success: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ToState != "SLOW" {
+		t.Errorf("Got state %s, want SLOW", result.ToState)
+	}
+}
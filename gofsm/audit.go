@@ -0,0 +1,98 @@
+package gofsm
+
+import "time"
+
+// Kinds of audit records
+const (
+	AuditEventAccepted = "event.accepted"
+	AuditEventRejected = "event.rejected"
+	AuditTransition    = "transition"
+)
+
+// AuditRecord is an entry of the audit trail of a machine
+type AuditRecord struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Machine string    `json:"machine"`
+	Tenant  string    `json:"tenant,omitempty"`
+	// Definition and Labels come from the metadata of the definition
+	Definition string            `json:"definition,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	EventID    string            `json:"eventId,omitempty"`
+	Event      string            `json:"event,omitempty"`
+	Param      string            `json:"param,omitempty"`
+	// From and To are the states of transitions and the states before and after accepted events
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	// Error is the reason why an event was rejected
+	Error string `json:"error,omitempty"`
+	// Since is when a stuck machine entered its state, Param is the SLA of the state
+	Since *time.Time `json:"since,omitempty"`
+}
+
+// AuditSink receives the audit records of machines
+// Sinks are called while the machine is locked, so they must not send events
+// to it and should hand slow work off to another goroutine
+type AuditSink interface {
+	Audit(record AuditRecord)
+}
+
+// OnAudit registers a sink for the audit records of the machine
+func (fsm *Machine) OnAudit(sink AuditSink) {
+	fsm = fsm.root()
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	fsm.auditSinks = append(fsm.auditSinks, sink)
+}
+
+// audit sends a record to the sinks of the machine
+func (fsm *Machine) audit(record AuditRecord) {
+	record.Time = fsm.clock().Now()
+	record.Machine = fsm.ID
+	record.Param = fsm.redact(record.Param)
+	record.Error = fsm.redact(record.Error)
+	record.Tenant = fsm.Tenant
+	if fsm.Metadata != nil {
+		record.Definition = fsm.Metadata.Name
+		record.Labels = fsm.Metadata.Labels
+	}
+	for _, sink := range fsm.auditSinks {
+		sink.Audit(record)
+	}
+}
+
+// auditEvent records whether an external event was accepted
+func (fsm *Machine) auditEvent(event Event, result TransitionResult, err error) {
+	if len(fsm.auditSinks) == 0 {
+		return
+	}
+	record := AuditRecord{
+		Kind:    AuditEventAccepted,
+		EventID: event.ID,
+		Event:   event.Action,
+		Param:   fsm.redactEvent(event, event.Param),
+		From:    result.FromState,
+		To:      result.ToState,
+	}
+	if err != nil {
+		record.Kind = AuditEventRejected
+		record.Error = fsm.redactEvent(event, err.Error())
+	}
+	// The event may have been rejected without a step, its fields are
+	// redacted before the ones of the last event
+	fsm.audit(record)
+}
+
+// auditTransition records a transition from one state to the current one
+func (fsm *Machine) auditTransition(from string, event Event) {
+	if len(fsm.auditSinks) == 0 {
+		return
+	}
+	fsm.audit(AuditRecord{
+		Kind:    AuditTransition,
+		EventID: event.ID,
+		Event:   event.Action,
+		From:    from,
+		To:      fsm.CurrentState.Name,
+	})
+}
@@ -0,0 +1,37 @@
+// Package audit ships audit sinks writing the records of state machines
+// to a JSON-lines file, to syslog or to an HTTP endpoint
+package audit
+
+import (
+	"fmt"
+
+	"github.com/ditek/jsonfsm/gofsm"
+)
+
+// Config describes an audit sink
+type Config struct {
+	// Type is one of "file", "syslog" or "http"
+	Type string `json:"type"`
+	// Path is the file records are appended to
+	Path string `json:"path,omitempty"`
+	// URL is the endpoint records are posted to
+	URL string `json:"url,omitempty"`
+	// Network and Address select a remote syslog server, the local one if empty
+	Network string `json:"network,omitempty"`
+	Address string `json:"address,omitempty"`
+	// Tag is the syslog tag, "jsonfsm" by default
+	Tag string `json:"tag,omitempty"`
+}
+
+// New creates the sink described by the config
+func New(cfg Config) (gofsm.AuditSink, error) {
+	switch cfg.Type {
+	case "file":
+		return NewFileSink(cfg.Path)
+	case "syslog":
+		return NewSyslogSink(cfg.Network, cfg.Address, cfg.Tag)
+	case "http":
+		return NewHTTPSink(cfg.URL)
+	}
+	return nil, fmt.Errorf("Error: Unknown audit sink type '%s'", cfg.Type)
+}
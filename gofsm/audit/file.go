@@ -0,0 +1,48 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/ditek/jsonfsm/gofsm"
+)
+
+// FileSink appends the records to a file, one JSON object per line
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+var _ gofsm.AuditSink = (*FileSink)(nil)
+
+// NewFileSink opens the file in append mode, creating it if needed
+func NewFileSink(path string) (*FileSink, error) {
+	if path == "" {
+		return nil, fmt.Errorf("Error: The file audit sink needs a path")
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+// Audit writes a record as soon as it is received
+func (s *FileSink) Audit(record gofsm.AuditRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.enc.Encode(record); err != nil {
+		log.Println("Error: Cannot write audit record:", err)
+	}
+}
+
+// Close closes the file
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
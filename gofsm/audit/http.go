@@ -0,0 +1,94 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ditek/jsonfsm/gofsm"
+)
+
+// Defaults of the HTTP sink
+const (
+	defaultRetries   = 3
+	defaultBackoff   = time.Second
+	defaultTimeout   = 10 * time.Second
+	defaultQueueSize = 1000
+)
+
+// HTTPSink posts the records to an endpoint in the background, in order
+// Records are dropped when the queue is full so that machines never block
+type HTTPSink struct {
+	url    string
+	client *http.Client
+	queue  chan gofsm.AuditRecord
+}
+
+var _ gofsm.AuditSink = (*HTTPSink)(nil)
+
+// NewHTTPSink creates a sink and starts its delivery goroutine
+func NewHTTPSink(url string) (*HTTPSink, error) {
+	if url == "" {
+		return nil, fmt.Errorf("Error: The HTTP audit sink needs a URL")
+	}
+	s := &HTTPSink{
+		url:    url,
+		client: &http.Client{Timeout: defaultTimeout},
+		queue:  make(chan gofsm.AuditRecord, defaultQueueSize),
+	}
+	go s.run()
+	return s, nil
+}
+
+// Audit queues a record for delivery
+func (s *HTTPSink) Audit(record gofsm.AuditRecord) {
+	select {
+	case s.queue <- record:
+	default:
+		log.Println("Error: Audit queue is full, dropping record of machine", record.Machine)
+	}
+}
+
+// run delivers the queued records in order
+func (s *HTTPSink) run() {
+	for record := range s.queue {
+		s.deliver(record)
+	}
+}
+
+// deliver posts a record, retrying with exponential backoff
+func (s *HTTPSink) deliver(record gofsm.AuditRecord) {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	backoff := defaultBackoff
+	for attempt := 0; ; attempt++ {
+		err = s.post(payload)
+		if err == nil {
+			return
+		}
+		if attempt >= defaultRetries {
+			log.Printf("Error: Audit delivery to %s failed: %v\n", s.url, err)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (s *HTTPSink) post(payload []byte) error {
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+//go:build !windows && !plan9
+
+package audit
+
+import (
+	"encoding/json"
+	"log"
+	"log/syslog"
+
+	"github.com/ditek/jsonfsm/gofsm"
+)
+
+// defaultTag is the syslog tag used when the config leaves it out
+const defaultTag = "jsonfsm"
+
+// SyslogSink sends the records to syslog as JSON messages
+// Rejected events are logged as warnings, the other records as information
+type SyslogSink struct {
+	writer *syslog.Writer
+}
+
+var _ gofsm.AuditSink = (*SyslogSink)(nil)
+
+// NewSyslogSink connects to a syslog server, the local one if network and address are empty
+func NewSyslogSink(network, address, tag string) (*SyslogSink, error) {
+	if tag == "" {
+		tag = defaultTag
+	}
+	writer, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_AUTH, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogSink{writer: writer}, nil
+}
+
+// Audit sends a record
+func (s *SyslogSink) Audit(record gofsm.AuditRecord) {
+	msg, err := json.Marshal(record)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if record.Kind == gofsm.AuditEventRejected {
+		err = s.writer.Warning(string(msg))
+	} else {
+		err = s.writer.Info(string(msg))
+	}
+	if err != nil {
+		log.Println("Error: Cannot send audit record to syslog:", err)
+	}
+}
+
+// Close closes the connection to syslog
+func (s *SyslogSink) Close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,14 @@
+//go:build windows || plan9
+
+package audit
+
+import (
+	"fmt"
+
+	"github.com/ditek/jsonfsm/gofsm"
+)
+
+// NewSyslogSink fails since syslog is not available on this platform
+func NewSyslogSink(network, address, tag string) (gofsm.AuditSink, error) {
+	return nil, fmt.Errorf("Error: Syslog is not available on this platform")
+}
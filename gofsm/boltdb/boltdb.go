@@ -0,0 +1,456 @@
+// Package boltdb persists definitions, snapshots, instances and events in an
+// embedded BoltDB key-value file, for edge and IoT devices without SQL
+package boltdb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ditek/jsonfsm/gofsm"
+	bolt "go.etcd.io/bbolt"
+)
+
+// The buckets of a tenant, nested in its own bucket
+// The events bucket holds a bucket per machine, keyed by sequence number
+var (
+	definitionsBucket = []byte("definitions")
+	snapshotsBucket   = []byte("snapshots")
+	instancesBucket   = []byte("instances")
+	eventsBucket      = []byte("events")
+)
+
+// tenantPrefix starts the names of the buckets of the tenants, bucket names
+// can't be empty
+const tenantPrefix = "tenant:"
+
+// Store keeps the definitions, the snapshots of the sessions, the current
+// state of the instances and the audit trail of the events of a tenant
+// It is a definition store, a snapshot store, a projection maintaining the
+// instances and an audit sink appending to the events
+type Store struct {
+	db     *bolt.DB
+	tenant string
+}
+
+var (
+	_ gofsm.Store         = (*Store)(nil)
+	_ gofsm.SnapshotStore = (*Store)(nil)
+	_ gofsm.Projection    = (*Store)(nil)
+	_ gofsm.AuditSink     = (*Store)(nil)
+	_ gofsm.HistoryStore  = (*Store)(nil)
+)
+
+// Instance is the current state of a session
+type Instance struct {
+	ID        string    `json:"id"`
+	State     string    `json:"state"`
+	StartedAt time.Time `json:"startedAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Open opens the database in the given file, creating it if needed
+// The file is locked, a single process can open it
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("Error: Cannot open database '%s': %v", path, err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Tenant returns the store of a tenant, sharing the database
+func (s *Store) Tenant(tenant string) *Store {
+	return &Store{db: s.db, tenant: tenant}
+}
+
+// Close closes the database, for the stores of all tenants
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Tenants returns the tenants that have data in the database
+func (s *Store) Tenants() ([]string, error) {
+	var tenants []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			if tenant, ok := strings.CutPrefix(string(name), tenantPrefix); ok {
+				tenants = append(tenants, tenant)
+			}
+			return nil
+		})
+	})
+	return tenants, err
+}
+
+// bucket returns a bucket of the tenant, nil if it doesn't exist yet
+// The bucket is created when the transaction is writable
+func (s *Store) bucket(tx *bolt.Tx, name []byte) (*bolt.Bucket, error) {
+	key := []byte(tenantPrefix + s.tenant)
+	if !tx.Writable() {
+		tenant := tx.Bucket(key)
+		if tenant == nil {
+			return nil, nil
+		}
+		return tenant.Bucket(name), nil
+	}
+	tenant, err := tx.CreateBucketIfNotExists(key)
+	if err != nil {
+		return nil, err
+	}
+	return tenant.CreateBucketIfNotExists(name)
+}
+
+// put stores a value in a bucket of the tenant
+func (s *Store) put(name []byte, key string, value []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := s.bucket(tx, name)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), value)
+	})
+}
+
+// get returns a copy of a value of a bucket of the tenant or ErrNotFound
+func (s *Store) get(name []byte, key string) ([]byte, error) {
+	var value []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b, err := s.bucket(tx, name)
+		if err != nil || b == nil {
+			return err
+		}
+		// Values are only valid during the transaction
+		if v := b.Get([]byte(key)); v != nil {
+			value = append([]byte{}, v...)
+		}
+		return nil
+	})
+	if err == nil && value == nil {
+		return nil, gofsm.ErrNotFound
+	}
+	return value, err
+}
+
+// remove deletes a value of a bucket of the tenant or returns ErrNotFound
+func (s *Store) remove(name []byte, key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := s.bucket(tx, name)
+		if err != nil {
+			return err
+		}
+		if b.Get([]byte(key)) == nil {
+			return gofsm.ErrNotFound
+		}
+		return b.Delete([]byte(key))
+	})
+}
+
+// SaveDefinition creates or replaces the JSON definition stored under a name
+func (s *Store) SaveDefinition(name string, data []byte) error {
+	if err := gofsm.CheckName(name); err != nil {
+		return err
+	}
+	return s.put(definitionsBucket, name, data)
+}
+
+// Definition returns the JSON definition stored under a name or ErrNotFound
+func (s *Store) Definition(name string) ([]byte, error) {
+	return s.get(definitionsBucket, name)
+}
+
+// DeleteDefinition removes a definition or returns ErrNotFound
+func (s *Store) DeleteDefinition(name string) error {
+	return s.remove(definitionsBucket, name)
+}
+
+// Definitions returns the sorted names of the stored definitions
+func (s *Store) Definitions() ([]string, error) {
+	names := []string{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b, err := s.bucket(tx, definitionsBucket)
+		if err != nil || b == nil {
+			return err
+		}
+		// Keys are iterated in byte order
+		return b.ForEach(func(k, _ []byte) error {
+			names = append(names, string(k))
+			return nil
+		})
+	})
+	return names, err
+}
+
+// SaveSnapshot creates or replaces the snapshot of a session
+func (s *Store) SaveSnapshot(id string, snap gofsm.Snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	return s.put(snapshotsBucket, id, data)
+}
+
+// LoadSnapshot returns the snapshot of a session or ErrNotFound
+func (s *Store) LoadSnapshot(id string) (gofsm.Snapshot, error) {
+	data, err := s.get(snapshotsBucket, id)
+	if err != nil {
+		return gofsm.Snapshot{}, err
+	}
+	var snap gofsm.Snapshot
+	err = json.Unmarshal(data, &snap)
+	return snap, err
+}
+
+// DeleteSnapshot removes the snapshot of a session or returns ErrNotFound
+func (s *Store) DeleteSnapshot(id string) error {
+	return s.remove(snapshotsBucket, id)
+}
+
+// Project keeps the current state of the instance of a transition
+func (s *Store) Project(record gofsm.ProjectionRecord) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := s.bucket(tx, instancesBucket)
+		if err != nil {
+			return err
+		}
+		instance := Instance{ID: record.Machine, StartedAt: record.Timestamp}
+		if data := b.Get([]byte(record.Machine)); data != nil {
+			if err := json.Unmarshal(data, &instance); err != nil {
+				return err
+			}
+		}
+		instance.State = record.To
+		instance.UpdatedAt = record.Timestamp
+		data, err := json.Marshal(instance)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(record.Machine), data)
+	})
+}
+
+// Instances returns the instances of the tenant, sorted by ID
+func (s *Store) Instances() ([]Instance, error) {
+	instances := []Instance{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b, err := s.bucket(tx, instancesBucket)
+		if err != nil || b == nil {
+			return err
+		}
+		return b.ForEach(func(_, v []byte) error {
+			var i Instance
+			if err := json.Unmarshal(v, &i); err != nil {
+				return err
+			}
+			instances = append(instances, i)
+			return nil
+		})
+	})
+	return instances, err
+}
+
+// DeleteInstance removes the instance and the events of a machine, e.g.
+// once they are archived
+func (s *Store) DeleteInstance(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		instances, err := s.bucket(tx, instancesBucket)
+		if err != nil {
+			return err
+		}
+		if err := instances.Delete([]byte(id)); err != nil {
+			return err
+		}
+		events, err := s.bucket(tx, eventsBucket)
+		if err != nil {
+			return err
+		}
+		if events.Bucket([]byte(id)) == nil {
+			return nil
+		}
+		return events.DeleteBucket([]byte(id))
+	})
+}
+
+// Audit appends a record to the events of its machine
+// Sinks can't fail the event, so errors are logged
+func (s *Store) Audit(record gofsm.AuditRecord) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		events, err := s.bucket(tx, eventsBucket)
+		if err != nil {
+			return err
+		}
+		b, err := events.CreateBucketIfNotExists([]byte(record.Machine))
+		if err != nil {
+			return err
+		}
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		return b.Put(sequenceKey(seq), data)
+	})
+	if err != nil {
+		log.Printf("Error: Cannot record event of machine '%s': %v\n", record.Machine, err)
+	}
+}
+
+// Events returns the audit records of a machine, oldest first
+func (s *Store) Events(machine string) ([]gofsm.AuditRecord, error) {
+	records := []gofsm.AuditRecord{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		events, err := s.bucket(tx, eventsBucket)
+		if err != nil || events == nil {
+			return err
+		}
+		b := events.Bucket([]byte(machine))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(_, v []byte) error {
+			var r gofsm.AuditRecord
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			records = append(records, r)
+			return nil
+		})
+	})
+	return records, err
+}
+
+// Compact removes the audit records of the tenant older than a time and
+// returns how many were removed
+// The records of a machine are appended in time order, so the oldest are
+// removed until a newer one is reached. Machines left without records lose
+// their bucket
+func (s *Store) Compact(before time.Time) (int, error) {
+	removed := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		events, err := s.bucket(tx, eventsBucket)
+		if err != nil {
+			return err
+		}
+		var empty [][]byte
+		err = events.ForEachBucket(func(machine []byte) error {
+			c := events.Bucket(machine).Cursor()
+			for k, v := c.First(); k != nil; k, v = c.First() {
+				var r gofsm.AuditRecord
+				if err := json.Unmarshal(v, &r); err != nil {
+					return err
+				}
+				if !r.Time.Before(before) {
+					return nil
+				}
+				if err := c.Delete(); err != nil {
+					return err
+				}
+				removed++
+			}
+			empty = append(empty, append([]byte{}, machine...))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// Buckets can't be deleted while they are iterated
+		for _, machine := range empty {
+			if err := events.DeleteBucket(machine); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// Exceeding returns the machines with more events than the retention keeps,
+// or with events older than it
+func (s *Store) Exceeding(r gofsm.Retention, now time.Time) ([]string, error) {
+	var machines []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		events, err := s.bucket(tx, eventsBucket)
+		if err != nil || events == nil {
+			return err
+		}
+		return events.ForEachBucket(func(machine []byte) error {
+			b := events.Bucket(machine)
+			if r.MaxEvents > 0 && b.Stats().KeyN > r.MaxEvents {
+				machines = append(machines, string(machine))
+				return nil
+			}
+			if r.MaxAge <= 0 {
+				return nil
+			}
+			_, v := b.Cursor().First()
+			if v == nil {
+				return nil
+			}
+			var first gofsm.AuditRecord
+			if err := json.Unmarshal(v, &first); err != nil {
+				return err
+			}
+			if first.Time.Before(now.Add(-r.MaxAge)) {
+				machines = append(machines, string(machine))
+			}
+			return nil
+		})
+	})
+	return machines, err
+}
+
+// Truncate removes the events of a machine older than the retention, then
+// the oldest ones beyond its maximum number of events
+// The events are appended in time order, so the oldest are removed until one
+// is kept
+func (s *Store) Truncate(machine string, r gofsm.Retention, now time.Time) (int, error) {
+	removed := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		events, err := s.bucket(tx, eventsBucket)
+		if err != nil {
+			return err
+		}
+		b := events.Bucket([]byte(machine))
+		if b == nil {
+			return nil
+		}
+		excess := 0
+		if r.MaxEvents > 0 {
+			excess = b.Stats().KeyN - r.MaxEvents
+		}
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.First() {
+			if removed >= excess {
+				if r.MaxAge <= 0 {
+					return nil
+				}
+				var record gofsm.AuditRecord
+				if err := json.Unmarshal(v, &record); err != nil {
+					return err
+				}
+				if !record.Time.Before(now.Add(-r.MaxAge)) {
+					return nil
+				}
+			}
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// sequenceKey encodes a sequence number so the keys sort in its order
+func sequenceKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
@@ -0,0 +1,175 @@
+package boltdb
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ditek/jsonfsm/gofsm"
+)
+
+// openStore opens a database in a temporary directory, closed with the test
+func openStore(t *testing.T) (*Store, string) {
+	path := filepath.Join(t.TempDir(), "fsm.db")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, path
+}
+
+func TestDefinitions(t *testing.T) {
+	s, _ := openStore(t)
+	for _, name := range []string{"orders", "alarm"} {
+		if err := s.SaveDefinition(name, []byte(`{"name": "`+name+`"}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SaveDefinition("../etc", nil); err == nil {
+		t.Error("Saved a definition with an invalid name")
+	}
+	names, err := s.Definitions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"alarm", "orders"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Got definitions %v, want %v", names, want)
+	}
+	// The tenants don't see each other's definitions
+	if names, _ := s.Tenant("acme").Definitions(); len(names) != 0 {
+		t.Errorf("Got definitions %v for another tenant", names)
+	}
+	if data, err := s.Definition("alarm"); err != nil || string(data) != `{"name": "alarm"}` {
+		t.Errorf("Got definition %s, %v", data, err)
+	}
+	if err := s.DeleteDefinition("alarm"); err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range []error{s.DeleteDefinition("alarm"), second(s.Definition("alarm"))} {
+		if !errors.Is(err, gofsm.ErrNotFound) {
+			t.Errorf("Got error %v for a deleted definition, want ErrNotFound", err)
+		}
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+	s, path := openStore(t)
+	snap := gofsm.Snapshot{CurrentState: "ARMED", Context: map[string]interface{}{"code": "123"}}
+	if err := s.SaveSnapshot("s1", snap); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	// The snapshot survives reopening the database
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	got, err := s.LoadSnapshot("s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.CurrentState != "ARMED" || got.Context["code"] != "123" {
+		t.Errorf("Got snapshot %+v", got)
+	}
+	if err := s.DeleteSnapshot("s1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.LoadSnapshot("s1"); !errors.Is(err, gofsm.ErrNotFound) {
+		t.Errorf("Got error %v for a deleted snapshot, want ErrNotFound", err)
+	}
+}
+
+func TestInstancesAndEvents(t *testing.T) {
+	s, _ := openStore(t)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	steps := []struct {
+		machine, from, to string
+	}{
+		{"s1", "IDLE", "ARMED"},
+		{"s2", "IDLE", "ARMED"},
+		{"s1", "ARMED", "IDLE"},
+	}
+	for i, step := range steps {
+		at := start.Add(time.Duration(i) * time.Hour)
+		record := gofsm.TransitionRecord{Machine: step.machine, From: step.from, To: step.to, Event: "go", Timestamp: at}
+		if err := s.Project(gofsm.ProjectionRecord{TransitionRecord: record}); err != nil {
+			t.Fatal(err)
+		}
+		s.Audit(gofsm.AuditRecord{Kind: "transition", Machine: step.machine, Event: "go", From: step.from, To: step.to, Time: at})
+	}
+	instances, err := s.Instances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 2 || instances[0].ID != "s1" || instances[0].State != "IDLE" || !instances[0].StartedAt.Equal(start) {
+		t.Errorf("Got instances %+v", instances)
+	}
+	events, err := s.Events("s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[1].To != "IDLE" {
+		t.Errorf("Got events %+v", events)
+	}
+
+	// s1 has more events than the retention keeps
+	retention := gofsm.Retention{MaxEvents: 1}
+	exceeding, err := s.Exceeding(retention, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"s1"}; !reflect.DeepEqual(exceeding, want) {
+		t.Errorf("Got machines %v, want %v", exceeding, want)
+	}
+	if n, err := s.Truncate("s1", retention, start); err != nil || n != 1 {
+		t.Errorf("Truncated %d events, %v, want 1", n, err)
+	}
+
+	if err := s.DeleteInstance("s1"); err != nil {
+		t.Fatal(err)
+	}
+	if events, _ := s.Events("s1"); len(events) != 0 {
+		t.Errorf("Got events %+v of a deleted instance", events)
+	}
+}
+
+func TestTenantsAndCompact(t *testing.T) {
+	s, _ := openStore(t)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tenant := range []string{"acme", "globex"} {
+		store := s.Tenant(tenant)
+		for i := 0; i < 3; i++ {
+			store.Audit(gofsm.AuditRecord{Kind: "transition", Machine: "s1", Time: start.Add(time.Duration(i) * time.Hour)})
+		}
+	}
+	tenants, err := s.Tenants()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"acme", "globex"}; !reflect.DeepEqual(tenants, want) {
+		t.Errorf("Got tenants %v, want %v", tenants, want)
+	}
+	// Only the records of the tenant before the time are removed
+	acme := s.Tenant("acme")
+	if n, err := acme.Compact(start.Add(90 * time.Minute)); err != nil || n != 2 {
+		t.Errorf("Compacted %d records, %v, want 2", n, err)
+	}
+	if events, _ := acme.Events("s1"); len(events) != 1 {
+		t.Errorf("Got %d records left, want 1", len(events))
+	}
+	if events, _ := s.Tenant("globex").Events("s1"); len(events) != 3 {
+		t.Errorf("Got %d records of another tenant, want 3", len(events))
+	}
+	if n, err := acme.Compact(start.Add(24 * time.Hour)); err != nil || n != 1 {
+		t.Errorf("Compacted %d records, %v, want 1", n, err)
+	}
+}
+
+// second returns the error of a call returning a value and an error
+func second(_ []byte, err error) error {
+	return err
+}
@@ -0,0 +1,199 @@
+package gofsm
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is matched by errors.Is for the actions short-circuited by
+// their open circuit breaker
+var ErrCircuitOpen = errors.New("Error: Circuit breaker open")
+
+// circuitOpenError names the action whose circuit is open
+type circuitOpenError struct {
+	action string
+	state  string
+}
+
+func (e circuitOpenError) Error() string {
+	return fmt.Sprintf("Error: Circuit breaker of action '%s' is open in state '%s'", e.action, e.state)
+}
+
+func (e circuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// States of a circuit breaker
+const (
+	// BreakerClosed lets the calls through and counts their failures
+	BreakerClosed = "closed"
+	// BreakerOpen fails the calls without running the action
+	BreakerOpen = "open"
+	// BreakerHalfOpen lets one trial call through after the cooldown
+	BreakerHalfOpen = "halfOpen"
+)
+
+// BreakerPolicy sets when the circuit of an action opens and for how long
+type BreakerPolicy struct {
+	// Failures is the number of failures in a row that opens the circuit
+	Failures int
+	// Cooldown is the time the circuit stays open before a trial call
+	Cooldown time.Duration
+}
+
+// BreakerStats describes the circuit of an action
+type BreakerStats struct {
+	State string `json:"state"`
+	// Failures is the number of failures in a row of the closed circuit
+	Failures int `json:"failures"`
+	// OpenedAt is the time the circuit last opened, if it isn't closed
+	OpenedAt *time.Time `json:"openedAt,omitempty"`
+	// Opened counts the times the circuit opened, Rejected the calls it short-circuited
+	Opened   uint64 `json:"opened"`
+	Rejected uint64 `json:"rejected"`
+}
+
+// breaker is the circuit of an action
+type breaker struct {
+	policy BreakerPolicy
+	stats  BreakerStats
+	// openedAt is the start of the cooldown of the open circuit
+	openedAt time.Time
+	// trial is set while the call of the half-open circuit runs
+	trial bool
+}
+
+// CircuitBreakers protect the services called by actions: once an action
+// failed too many times in a row, its calls fail at once without running it
+// until a cooldown expires, then a single trial call decides if the circuit
+// closes again or stays open for another cooldown
+// An action fails when it returns false or an error, timeouts included.
+// The breakers can be shared by machines, so the failures of one count for all
+type CircuitBreakers struct {
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+// NewCircuitBreakers creates the breakers of the given actions, the actions
+// that aren't listed are never short-circuited
+func NewCircuitBreakers(policies map[string]BreakerPolicy) (*CircuitBreakers, error) {
+	b := &CircuitBreakers{breakers: make(map[string]*breaker, len(policies))}
+	for name, policy := range policies {
+		if policy.Failures <= 0 {
+			return nil, fmt.Errorf("Error: Invalid failure threshold %d of the circuit breaker of action '%s'", policy.Failures, name)
+		}
+		if policy.Cooldown <= 0 {
+			return nil, fmt.Errorf("Error: Invalid cooldown %s of the circuit breaker of action '%s'", policy.Cooldown, name)
+		}
+		b.breakers[name] = &breaker{policy: policy, stats: BreakerStats{State: BreakerClosed}}
+	}
+	return b, nil
+}
+
+// WithCircuitBreakers short-circuits the actions of the machine whose
+// circuit is open, they fail like actions that timed out
+// Sub-machines and clones use the breakers of the machine they come from
+func WithCircuitBreakers(breakers *CircuitBreakers) Option {
+	return func(fsm *Machine) {
+		fsm.breakers = breakers
+	}
+}
+
+// allow tells if a call of an action may run now, and if it is the trial
+// call of a half-open circuit
+func (b *CircuitBreakers) allow(name string, now time.Time) (ok, trial bool) {
+	if b == nil {
+		return true, false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	br := b.breakers[name]
+	if br == nil {
+		return true, false
+	}
+	switch br.stats.State {
+	case BreakerOpen:
+		if now.Sub(br.openedAt) < br.policy.Cooldown {
+			br.stats.Rejected++
+			return false, false
+		}
+		log.Printf("Circuit breaker of action '%s' half-open", name)
+		br.stats.State = BreakerHalfOpen
+		br.trial = true
+		return true, true
+	case BreakerHalfOpen:
+		if br.trial {
+			// Only one call tests the service
+			br.stats.Rejected++
+			return false, false
+		}
+		br.trial = true
+		return true, true
+	}
+	return true, false
+}
+
+// record counts the result of a call of an action
+// Only the trial call decides the fate of a half-open circuit, the calls
+// started before the circuit opened don't count anymore
+func (b *CircuitBreakers) record(name string, trial, success bool, now time.Time) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	br := b.breakers[name]
+	if br == nil {
+		return
+	}
+	if br.stats.State != BreakerClosed {
+		if !trial || br.stats.State != BreakerHalfOpen {
+			return
+		}
+		br.trial = false
+		if success {
+			log.Printf("Circuit breaker of action '%s' closed", name)
+			br.stats.State = BreakerClosed
+			br.stats.Failures = 0
+		} else {
+			br.open(name, now)
+		}
+		return
+	}
+	if success {
+		br.stats.Failures = 0
+		return
+	}
+	br.stats.Failures++
+	if br.stats.Failures >= br.policy.Failures {
+		br.open(name, now)
+	}
+}
+
+// open starts a cooldown during which the calls fail at once
+func (br *breaker) open(name string, now time.Time) {
+	log.Printf("Circuit breaker of action '%s' open for %s", name, br.policy.Cooldown)
+	br.stats.State = BreakerOpen
+	br.stats.Failures = 0
+	br.openedAt = now
+	br.stats.Opened++
+}
+
+// Stats returns the circuits of the actions
+func (b *CircuitBreakers) Stats() map[string]BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := make(map[string]BreakerStats, len(b.breakers))
+	for name, br := range b.breakers {
+		s := br.stats
+		if s.State != BreakerClosed {
+			openedAt := br.openedAt
+			s.OpenedAt = &openedAt
+		}
+		stats[name] = s
+	}
+	return stats
+}
@@ -0,0 +1,55 @@
+package gofsm
+
+import "fmt"
+
+// broadcastWorkers is the number of machines a broadcast sends its event to at once
+const broadcastWorkers = 16
+
+// BroadcastResult aggregates the outcomes of an event sent to a group of instances
+type BroadcastResult struct {
+	Event   string `json:"event"`
+	Matched int    `json:"matched"`
+	// Results are the transitions of the instances that handled the event
+	Results map[string]TransitionResult `json:"results"`
+	// States counts the instances that handled the event by their new state
+	States map[string]int `json:"states"`
+	// Failed holds the errors of the instances that rejected the event
+	Failed map[string]string `json:"failed,omitempty"`
+}
+
+// Broadcast sends an event to every machine matching the selector, e.g.
+// "market_closed" to the machines whose definition has a label, and returns
+// the outcome for each of them
+// The machines handle the event concurrently, each one under the lock of its
+// session, and a machine rejecting it doesn't stop the others
+// Like the bulk operations, it only reaches the sessions this manager holds,
+// so in a cluster the sessions of the other nodes need a broadcast of their own
+func (m *Manager) Broadcast(sel Selector, event Event) (BroadcastResult, error) {
+	if event.Action == "" {
+		return BroadcastResult{}, fmt.Errorf("Error: The broadcast has no event to send")
+	}
+	ids, err := m.selectSessions(sel)
+	if err != nil {
+		return BroadcastResult{}, err
+	}
+	result := BroadcastResult{
+		Event:   event.Action,
+		Matched: len(ids),
+		Results: make(map[string]TransitionResult, len(ids)),
+		States:  map[string]int{},
+	}
+	forEach(ids, broadcastWorkers, func(id string) (TransitionResult, error) {
+		return m.sendSelected(id, event)
+	}, func(id string, res TransitionResult, err error) {
+		if err != nil {
+			if result.Failed == nil {
+				result.Failed = map[string]string{}
+			}
+			result.Failed[id] = err.Error()
+			return
+		}
+		result.Results[id] = res
+		result.States[res.ToState]++
+	})
+	return result, nil
+}
@@ -0,0 +1,197 @@
+package gofsm
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrEmptySelector is returned for bulk operations that would apply to every session
+var ErrEmptySelector = errors.New("Error: The selector of the bulk operation matches nothing specific")
+
+// BulkOperation is what a bulk operation does to the selected machines
+type BulkOperation string
+
+const (
+	// BulkPause pauses the machines, see Machine.Pause
+	BulkPause BulkOperation = "pause"
+	// BulkResume resumes the paused machines, see Machine.Resume
+	BulkResume BulkOperation = "resume"
+	// BulkAbort aborts the machines with the reason of the request, see Machine.Abort
+	BulkAbort BulkOperation = "abort"
+	// BulkSend sends the event of the request to the machines
+	BulkSend BulkOperation = "send"
+)
+
+// Selector picks the sessions of a bulk operation
+// A machine must match every criterion that is set
+type Selector struct {
+	// State is the current state of the machines
+	State string `json:"state,omitempty"`
+	// Labels are labels the definition of the machines must have, with these values
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// BulkRequest applies an operation to all the machines matching a selector
+type BulkRequest struct {
+	Operation BulkOperation `json:"operation"`
+	Selector  Selector      `json:"selector"`
+	// Event is sent by BulkSend, to the session of each machine
+	Event Event `json:"event,omitempty"`
+	// Reason is given to the machines aborted by BulkAbort
+	Reason string `json:"reason,omitempty"`
+	// DryRun only reports the matching sessions, nothing is applied
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+// BulkResult reports the sessions a bulk operation matched and how it went for each
+type BulkResult struct {
+	Operation BulkOperation `json:"operation"`
+	DryRun    bool          `json:"dryRun,omitempty"`
+	Matched   []string      `json:"matched"`
+	Succeeded []string      `json:"succeeded"`
+	// Failed holds the errors of the sessions the operation failed for
+	Failed map[string]string `json:"failed,omitempty"`
+}
+
+// Select returns the sessions whose machine matches a selector, evicted ones included
+// The state is read from the snapshots and the labels from the index of the
+// manager, so the evicted machines are not loaded
+func (m *Manager) Select(sel Selector) []string {
+	ids := []string{}
+	for _, id := range m.Sessions() {
+		if len(sel.Labels) > 0 {
+			s := m.shard(id)
+			s.mu.Lock()
+			labels, ok := s.labels(id)
+			s.mu.Unlock()
+			if !ok || !hasLabels(labels, sel.Labels) {
+				continue
+			}
+		}
+		if sel.State != "" {
+			snap, ok := m.Snapshot(id)
+			if !ok || snap.CurrentState != sel.State {
+				continue
+			}
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// selectSessions returns the sessions of a bulk operation
+// The selector must set a criterion, so an operation never applies to all
+// the sessions by mistake
+func (m *Manager) selectSessions(sel Selector) ([]string, error) {
+	if sel.State == "" && len(sel.Labels) == 0 {
+		return nil, ErrEmptySelector
+	}
+	return m.Select(sel), nil
+}
+
+// sendSelected sends the event of a bulk operation to one of its sessions
+func (m *Manager) sendSelected(id string, event Event) (TransitionResult, error) {
+	event.Session = id
+	event.CorrelationKey = ""
+	return m.SendEvent(event)
+}
+
+// forEach applies op to the sessions, up to workers of them at once, and
+// passes the outcome of each one to done, one session at a time
+// A session op fails for doesn't stop the others
+func forEach(ids []string, workers int, op func(id string) (TransitionResult, error), done func(id string, res TransitionResult, err error)) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sessions := make(chan string)
+	for i := 0; i < workers && i < len(ids); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range sessions {
+				res, err := op(id)
+				mu.Lock()
+				done(id, res, err)
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, id := range ids {
+		sessions <- id
+	}
+	close(sessions)
+	wg.Wait()
+}
+
+// hasLabels tells if labels has all the wanted labels
+func hasLabels(labels, wanted map[string]string) bool {
+	for k, v := range wanted {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// labelsOf returns the labels of the metadata of a definition, nil without metadata
+func labelsOf(metadata *Metadata) map[string]string {
+	if metadata == nil {
+		return nil
+	}
+	return metadata.Labels
+}
+
+// Bulk applies an operation to every machine matching the selector of the
+// request, one after the other. A machine the operation fails for doesn't
+// stop the others, its error is reported in the result
+// The selector must set a criterion, so an operation never applies to all
+// the sessions by mistake
+func (m *Manager) Bulk(req BulkRequest) (BulkResult, error) {
+	var op func(id string) error
+	switch req.Operation {
+	case BulkPause:
+		op = m.Pause
+	case BulkResume:
+		op = m.Resume
+	case BulkAbort:
+		op = func(id string) error { return m.Abort(id, req.Reason) }
+	case BulkSend:
+		if req.Event.Action == "" {
+			return BulkResult{}, fmt.Errorf("Error: The bulk operation has no event to send")
+		}
+		op = func(id string) error {
+			_, err := m.sendSelected(id, req.Event)
+			return err
+		}
+	default:
+		return BulkResult{}, fmt.Errorf("Error: Unknown bulk operation '%s'", req.Operation)
+	}
+	ids, err := m.selectSessions(req.Selector)
+	if err != nil {
+		return BulkResult{}, err
+	}
+
+	result := BulkResult{
+		Operation: req.Operation,
+		DryRun:    req.DryRun,
+		Matched:   ids,
+		Succeeded: []string{},
+	}
+	if req.DryRun {
+		return result, nil
+	}
+	// A single worker applies the operation in the order of the sessions
+	forEach(ids, 1, func(id string) (TransitionResult, error) {
+		return TransitionResult{}, op(id)
+	}, func(id string, _ TransitionResult, err error) {
+		if err != nil {
+			if result.Failed == nil {
+				result.Failed = map[string]string{}
+			}
+			result.Failed[id] = err.Error()
+			return
+		}
+		result.Succeeded = append(result.Succeeded, id)
+	})
+	return result, nil
+}
@@ -0,0 +1,54 @@
+package gofsm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSelectDoesNotLoadEvicted(t *testing.T) {
+	def, err := LoadDefinition([]byte(`{
+		"metadata": {"name": "alarm", "labels": {"team": "security"}},
+		"initialState": "IDLE",
+		"states": [
+			{"name": "IDLE", "action": "Log", "waitForEvent": true},
+			{"name": "ARMED", "action": "Log", "waitForEvent": true}
+		],
+		"transitions": [
+			{"from": "IDLE", "toSuccess": "ARMED", "event": "arm"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(func() (*Machine, error) {
+		fsm := NewMachine(def)
+		fsm.Init()
+		return fsm, nil
+	}, WithEviction(1, NewMemorySnapshotStore()))
+	for _, id := range []string{"s1", "s2", "s3"} {
+		if _, err := m.Session(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := m.SendEvent(Event{Session: "s3", Action: "arm"}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		sel  Selector
+		want []string
+	}{
+		{"labels", Selector{Labels: map[string]string{"team": "security"}}, []string{"s1", "s2", "s3"}},
+		{"labels and state", Selector{State: "IDLE", Labels: map[string]string{"team": "security"}}, []string{"s1", "s2"}},
+		{"other labels", Selector{Labels: map[string]string{"team": "shop"}}, []string{}},
+		{"state", Selector{State: "ARMED"}, []string{"s3"}},
+	}
+	for _, test := range tests {
+		if got := m.Select(test.sel); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+	if stats := m.Stats()[0]; stats.Loads != 0 || stats.Evicted != 2 {
+		t.Errorf("Got %d machines loaded back and %d evicted, want 0 and 2", stats.Loads, stats.Evicted)
+	}
+}
@@ -0,0 +1,96 @@
+package gofsm
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/ditek/jsonfsm/gofsm/expr"
+)
+
+// SetOutcome sets the outcome of the running action, which selects the
+// destination of a transition declaring 'outcomes'
+// The last outcome set by the actions of a state wins, abandoned actions
+// don't set it
+func (fsm *Machine) SetOutcome(outcome string) {
+	// Actions running in parallel may set it at the same time
+	fsm.actionMu.Lock()
+	defer fsm.actionMu.Unlock()
+	if !fsm.abandoned() {
+		fsm.outcome = outcome
+	}
+}
+
+// chooseState returns the destination of a transition declaring 'outcomes'
+// The outcome is the value of the 'choice' expression, or the one set by the
+// state action, and outcomes without a destination go to 'toSuccess'
+func (fsm *Machine) chooseState(t Transition, event Event) (string, error) {
+	outcome := fsm.outcome
+	if t.Choice != "" {
+		e, err := parseGuard(t.Choice)
+		if err != nil {
+			return "", fmt.Errorf("Error: Invalid choice '%s': %v", t.Choice, err)
+		}
+		value, err := e.Eval(fsm.exprVars(event))
+		if err != nil {
+			return "", fmt.Errorf("Error: Cannot evaluate choice '%s': %v", t.Choice, err)
+		}
+		outcome = outcomeOf(value)
+	}
+	if to, ok := t.Outcomes[outcome]; ok {
+		return to, nil
+	}
+	if t.ToSuccess == "" {
+		return "", fmt.Errorf("Error: No destination for outcome '%s' of the transition from '%s'", outcome, t.From)
+	}
+	return t.ToSuccess, nil
+}
+
+// outcomeOf formats the value of a choice expression as an outcome
+func outcomeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return fmt.Sprint(v)
+	}
+	if n, ok := expr.ToNumber(value); ok {
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// targets returns the states a transition can lead to
+func (t Transition) targets() []string {
+	targets := []string{}
+	seen := map[string]bool{}
+	add := func(to string) {
+		if to != "" && !seen[to] {
+			seen[to] = true
+			targets = append(targets, to)
+		}
+	}
+	add(t.ToSuccess)
+	add(t.ToFailure)
+	outcomes := make([]string, 0, len(t.Outcomes))
+	for outcome := range t.Outcomes {
+		outcomes = append(outcomes, outcome)
+	}
+	sort.Strings(outcomes)
+	for _, outcome := range outcomes {
+		add(t.Outcomes[outcome])
+	}
+	return targets
+}
+
+// leadsTo returns true if the transition can lead to the state
+func (t Transition) leadsTo(name string) bool {
+	for _, to := range t.targets() {
+		if to == name {
+			return true
+		}
+	}
+	return false
+}
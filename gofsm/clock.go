@@ -0,0 +1,69 @@
+package gofsm
+
+import "time"
+
+// Clock is the source of time of a machine
+// Timers, timeouts, schedules and the Sleep action all go through it,
+// so tests can replace it to control time without waiting
+type Clock interface {
+	Now() time.Time
+	// After sends the current time on the returned channel once the duration has elapsed
+	After(d time.Duration) <-chan time.Time
+	// NewTimer creates a timer sending the current time on its channel once the duration has elapsed
+	NewTimer(d time.Duration) Timer
+	// AfterFunc calls f in its own goroutine once the duration has elapsed
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a pending call or channel send scheduled on a Clock
+type Timer interface {
+	// C returns the channel the timer sends on, nil for AfterFunc timers
+	C() <-chan time.Time
+	// Stop prevents the timer from firing, returns false if it already fired or was stopped
+	Stop() bool
+	// Reset makes the timer fire once the duration has elapsed,
+	// returns false if it already fired or was stopped
+	Reset(d time.Duration) bool
+}
+
+// RealClock is the Clock of the time package, used by machines without a clock
+type RealClock struct{}
+
+var _ Clock = RealClock{}
+
+// Now returns the current time
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+// After waits for the duration to elapse
+func (RealClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// NewTimer creates a timer of the time package
+func (RealClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+// AfterFunc calls f once the duration has elapsed
+func (RealClock) AfterFunc(d time.Duration, f func()) Timer {
+	return realTimer{time.AfterFunc(d, f)}
+}
+
+// realTimer adapts a timer of the time package
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// clock returns the clock of the machine, the real clock by default
+func (fsm *Machine) clock() Clock {
+	if fsm.Clock == nil {
+		return RealClock{}
+	}
+	return fsm.Clock
+}
@@ -0,0 +1,40 @@
+package gofsm
+
+import "log"
+
+// Clone creates an independent machine from the same definition
+// The definition is shared. The current state, the context, the action
+// registry and a running sub-machine are copied, and the clone gets its own
+// timers and schedules. Listeners, processed event IDs and state metrics are
+// not copied
+// Cloning a machine that wasn't initialized gives a fresh instance to be
+// initialized with Init, like NewMachine
+func (fsm *Machine) Clone() *Machine {
+	fsm = fsm.root()
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	clone := NewMachine(fsm.Definition, WithActions(fsm.actions), WithClock(fsm.Clock), WithWorkerPool(fsm.pool), WithCircuitBreakers(fsm.breakers))
+	clone.Tenant = fsm.Tenant
+	clone.CurrentState = fsm.CurrentState
+	clone.enteredAt = clone.clock().Now()
+	clone.Context = copyContext(fsm.Context)
+	clone.terminated, clone.abortReason, clone.abortedAt = fsm.terminated, fsm.abortReason, fsm.abortedAt
+	clone.paused = fsm.paused
+	if fsm.child != nil {
+		clone.child = fsm.child.Clone()
+	}
+	if fsm.timer != nil {
+		if err := clone.scheduleAfter(Event{}); err != nil {
+			log.Println(err)
+		}
+	}
+	if fsm.timeouts != nil {
+		clone.scheduleTimeouts("")
+	}
+	if fsm.scheduleTimers != nil && !fsm.stopped {
+		if err := clone.startSchedules(); err != nil {
+			log.Println(err)
+		}
+	}
+	return clone
+}
@@ -0,0 +1,216 @@
+// Package cloudevents maps CloudEvents to the events of state machines and
+// transitions to CloudEvents, in the HTTP binding of CloudEvents 1.0
+package cloudevents
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ditek/jsonfsm/gofsm"
+)
+
+// SpecVersion is the supported version of the specification
+const SpecVersion = "1.0"
+
+// ContentType is the media type of CloudEvents in structured mode
+const ContentType = "application/cloudevents+json"
+
+// TransitionType is the type of the CloudEvents describing transitions
+const TransitionType = "io.jsonfsm.transition"
+
+// AuditTypePrefix prefixes the kind of an audit record in the type of its CloudEvent,
+// e.g. "io.jsonfsm.instance.stuck"
+const AuditTypePrefix = "io.jsonfsm."
+
+// Extension attributes routing the events and carrying their parameter
+const (
+	ExtSession        = "session"
+	ExtCorrelationKey = "correlationkey"
+	ExtParam          = "param"
+	ExtPriority       = "priority"
+)
+
+// headerPrefix prefixes the attributes sent as headers in binary mode
+const headerPrefix = "Ce-"
+
+// IsCloudEvent tells if a request carries a CloudEvent, in structured or binary mode
+func IsCloudEvent(header http.Header) bool {
+	return mediaType(header.Get("Content-Type")) == ContentType || header.Get(headerPrefix+"Specversion") != ""
+}
+
+// Decode converts the CloudEvent of a request to an event
+// The type is the action and the id is the event ID. The session is the
+// 'session' extension or else the subject, and the 'correlationkey',
+// 'param' and 'priority' extensions give the correlation key, the parameter
+// and the priority
+// A JSON object as data is the payload of the event, and a string or
+// non-JSON data is its parameter, unless the 'param' extension is set
+func Decode(header http.Header, body []byte) (gofsm.Event, error) {
+	attrs := map[string]string{}
+	var data []byte
+	contentType := header.Get("Content-Type")
+	if mediaType(contentType) == ContentType {
+		// Structured mode, the attributes and the data are in the body
+		var doc map[string]json.RawMessage
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return gofsm.Event{}, fmt.Errorf("Error: Invalid CloudEvent: %v", err)
+		}
+		for name, raw := range doc {
+			switch name {
+			case "data":
+				data = raw
+			case "data_base64":
+				var encoded string
+				if err := json.Unmarshal(raw, &encoded); err != nil {
+					return gofsm.Event{}, fmt.Errorf("Error: Invalid CloudEvent data_base64: %v", err)
+				}
+				var err error
+				if data, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+					return gofsm.Event{}, fmt.Errorf("Error: Invalid CloudEvent data_base64: %v", err)
+				}
+			default:
+				attrs[name] = attribute(raw)
+			}
+		}
+		contentType = attrs["datacontenttype"]
+		if _, ok := doc["data"]; ok && contentType == "" {
+			contentType = "application/json"
+		}
+	} else {
+		// Binary mode, the attributes are headers and the body is the data
+		for name, values := range header {
+			if !strings.HasPrefix(name, headerPrefix) || len(values) == 0 {
+				continue
+			}
+			value, err := url.PathUnescape(values[0])
+			if err != nil {
+				value = values[0]
+			}
+			attrs[strings.ToLower(strings.TrimPrefix(name, headerPrefix))] = value
+		}
+		data = body
+	}
+
+	if attrs["specversion"] != SpecVersion {
+		return gofsm.Event{}, fmt.Errorf("Error: Unsupported CloudEvents version '%s'", attrs["specversion"])
+	}
+	for _, name := range []string{"id", "source", "type"} {
+		if attrs[name] == "" {
+			return gofsm.Event{}, fmt.Errorf("Error: The CloudEvent has no '%s'", name)
+		}
+	}
+	event := gofsm.Event{
+		ID:             attrs["id"],
+		Session:        attrs[ExtSession],
+		CorrelationKey: attrs[ExtCorrelationKey],
+		Action:         attrs["type"],
+		Param:          attrs[ExtParam],
+	}
+	if event.Session == "" {
+		event.Session = attrs["subject"]
+	}
+	if priority := attrs[ExtPriority]; priority != "" {
+		var err error
+		if event.Priority, err = strconv.Atoi(priority); err != nil {
+			return gofsm.Event{}, fmt.Errorf("Error: Invalid CloudEvent priority '%s'", priority)
+		}
+	}
+	if err := setData(&event, contentType, data); err != nil {
+		return gofsm.Event{}, err
+	}
+	return event, nil
+}
+
+// setData sets the payload or the parameter of the event from the data of a CloudEvent
+func setData(event *gofsm.Event, contentType string, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	media := mediaType(contentType)
+	if media != "" && media != "application/json" && !strings.HasSuffix(media, "+json") {
+		if event.Param == "" {
+			event.Param = string(data)
+		}
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("Error: Invalid CloudEvent data: %v", err)
+	}
+	switch v := value.(type) {
+	case nil:
+	case map[string]interface{}:
+		event.Data = v
+	case string:
+		if event.Param == "" {
+			event.Param = v
+		}
+	default:
+		return fmt.Errorf("Error: The CloudEvent data must be a JSON object or a string")
+	}
+	return nil
+}
+
+// attribute returns the text of an attribute of a structured CloudEvent
+func attribute(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return string(raw)
+}
+
+// mediaType returns the media type of a Content-Type without its parameters
+func mediaType(contentType string) string {
+	media, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return media
+}
+
+// FromTransition returns a transition as a CloudEvent in structured mode
+// The subject is the machine and the data is the transition record
+func FromTransition(record gofsm.TransitionRecord, source string) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"specversion":     SpecVersion,
+		"id":              newID(),
+		"source":          source,
+		"type":            TransitionType,
+		"subject":         record.Machine,
+		"time":            record.Timestamp.UTC().Format(time.RFC3339Nano),
+		"datacontenttype": "application/json",
+		"data":            record,
+	})
+}
+
+// FromAudit returns an audit record as a CloudEvent in structured mode
+// The subject is the machine and the data is the audit record
+func FromAudit(record gofsm.AuditRecord, source string) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"specversion":     SpecVersion,
+		"id":              newID(),
+		"source":          source,
+		"type":            AuditTypePrefix + record.Kind,
+		"subject":         record.Machine,
+		"time":            record.Time.UTC().Format(time.RFC3339Nano),
+		"datacontenttype": "application/json",
+		"data":            record,
+	})
+}
+
+// newID returns a random event ID
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
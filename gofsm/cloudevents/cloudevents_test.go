@@ -0,0 +1,125 @@
+package cloudevents
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ditek/jsonfsm/gofsm"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name   string
+		header map[string]string
+		body   string
+		want   gofsm.Event
+	}{
+		{
+			"structured",
+			map[string]string{"Content-Type": ContentType},
+			`{"specversion": "1.0", "id": "e1", "source": "/shop", "type": "ARM", "subject": "order-42", "priority": "2", "data": {"total": 10}}`,
+			gofsm.Event{ID: "e1", Session: "order-42", Action: "ARM", Priority: 2, Data: map[string]interface{}{"total": 10.0}},
+		},
+		{
+			"structured base64",
+			map[string]string{"Content-Type": ContentType + "; charset=utf-8"},
+			`{"specversion": "1.0", "id": "e2", "source": "/shop", "type": "CODE", "session": "s", "datacontenttype": "text/plain", "data_base64": "MTIz"}`,
+			gofsm.Event{ID: "e2", Session: "s", Action: "CODE", Param: "123"},
+		},
+		{
+			"binary",
+			map[string]string{"Ce-Specversion": "1.0", "Ce-Id": "e3", "Ce-Source": "/shop", "Ce-Type": "CODE", "Ce-Correlationkey": "order%2F42", "Content-Type": "application/json"},
+			`"123"`,
+			gofsm.Event{ID: "e3", CorrelationKey: "order/42", Action: "CODE", Param: "123"},
+		},
+		{
+			"param extension",
+			map[string]string{"Ce-Specversion": "1.0", "Ce-Id": "e4", "Ce-Source": "/shop", "Ce-Type": "CODE", "Ce-Param": "456", "Content-Type": "text/plain"},
+			`123`,
+			gofsm.Event{ID: "e4", Action: "CODE", Param: "456"},
+		},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		for k, v := range test.header {
+			header.Set(k, v)
+		}
+		if !IsCloudEvent(header) {
+			t.Errorf("%s: not recognized as a CloudEvent", test.name)
+		}
+		event, err := Decode(header, []byte(test.body))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(event, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, event, test.want)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		err  string
+	}{
+		{"invalid JSON", `{`, "Invalid CloudEvent"},
+		{"version", `{"specversion": "0.3", "id": "e", "source": "/", "type": "A"}`, "Unsupported CloudEvents version '0.3'"},
+		{"no type", `{"specversion": "1.0", "id": "e", "source": "/"}`, "has no 'type'"},
+		{"priority", `{"specversion": "1.0", "id": "e", "source": "/", "type": "A", "priority": "high"}`, "Invalid CloudEvent priority"},
+		{"data", `{"specversion": "1.0", "id": "e", "source": "/", "type": "A", "data": [1]}`, "must be a JSON object or a string"},
+	}
+	header := http.Header{"Content-Type": {ContentType}}
+	for _, test := range tests {
+		_, err := Decode(header, []byte(test.body))
+		if err == nil {
+			t.Errorf("%s: decoded without error", test.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got error %q, want %q", test.name, err, test.err)
+		}
+	}
+	if IsCloudEvent(http.Header{"Content-Type": {"application/json"}}) {
+		t.Error("A plain JSON request was recognized as a CloudEvent")
+	}
+}
+
+func TestFromTransition(t *testing.T) {
+	record := gofsm.TransitionRecord{Machine: "order-42", From: "A", To: "B", Event: "go", Timestamp: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	data, err := FromTransition(record, "/fsm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The CloudEvent decodes back to the transition
+	var doc struct {
+		SpecVersion string                 `json:"specversion"`
+		ID          string                 `json:"id"`
+		Type        string                 `json:"type"`
+		Subject     string                 `json:"subject"`
+		Time        string                 `json:"time"`
+		Data        gofsm.TransitionRecord `json:"data"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.SpecVersion != SpecVersion || doc.ID == "" || doc.Type != TransitionType || doc.Subject != "order-42" || doc.Time != "2020-01-02T03:04:05Z" {
+		t.Errorf("Got attributes %+v", doc)
+	}
+	if doc.Data.From != "A" || doc.Data.To != "B" || doc.Data.Event != "go" {
+		t.Errorf("Got data %+v", doc.Data)
+	}
+
+	data, err = FromAudit(gofsm.AuditRecord{Kind: "instance.stuck", Machine: "order-42"}, "/fsm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"type":"io.jsonfsm.instance.stuck"`) {
+		t.Errorf("Got %s, want the type of the audit record", data)
+	}
+}
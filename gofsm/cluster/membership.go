@@ -0,0 +1,186 @@
+package cluster
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of the membership
+const (
+	defaultInterval = 2 * time.Second
+	// maxFailures is the number of failed probes in a row after which a node is down
+	maxFailures = 2
+)
+
+// HealthPath is the end point probed on each node
+const HealthPath = "/cluster/health"
+
+// Membership probes the nodes of the cluster and keeps the ring of the live ones
+// Every node probes all the others, so the nodes agree on the ring without
+// an election, and the leader is the live node with the smallest name
+type Membership struct {
+	self     string
+	nodes    []string
+	interval time.Duration
+	client   *http.Client
+
+	mu        sync.RWMutex
+	failures  map[string]int
+	ring      *Ring
+	listeners []func(live []string)
+}
+
+// NewMembership creates the membership of the node self among the given
+// nodes, which are base URLs such as "http://10.0.0.1:3000"
+// All nodes are assumed to be live until they are probed
+func NewMembership(self string, nodes []string, interval time.Duration) *Membership {
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	all := []string{strings.TrimSuffix(self, "/")}
+	for _, node := range nodes {
+		node = strings.TrimSuffix(node, "/")
+		if node != all[0] {
+			all = append(all, node)
+		}
+	}
+	return &Membership{
+		self:     all[0],
+		nodes:    all,
+		interval: interval,
+		client:   &http.Client{Timeout: interval},
+		failures: map[string]int{},
+		ring:     NewRing(all, 0),
+	}
+}
+
+// SetTransport sets the transport of the probes, e.g. for nodes serving
+// over mutual TLS, before Run is called
+func (m *Membership) SetTransport(transport http.RoundTripper) {
+	m.client.Transport = transport
+}
+
+// OnChange registers a function called with the live nodes when they change
+func (m *Membership) OnChange(listener func(live []string)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listeners = append(m.listeners, listener)
+}
+
+// Run probes the nodes until stop is closed
+func (m *Membership) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		m.probe()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe checks the health of the other nodes and rebuilds the ring if the live nodes changed
+func (m *Membership) probe() {
+	healthy := map[string]bool{}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for _, node := range m.nodes[1:] {
+		wg.Add(1)
+		go func(node string) {
+			defer wg.Done()
+			ok := m.check(node)
+			mu.Lock()
+			healthy[node] = ok
+			mu.Unlock()
+		}(node)
+	}
+	wg.Wait()
+
+	m.mu.Lock()
+	live := []string{m.self}
+	for _, node := range m.nodes[1:] {
+		if healthy[node] {
+			m.failures[node] = 0
+		} else {
+			m.failures[node]++
+		}
+		if m.failures[node] < maxFailures {
+			live = append(live, node)
+		}
+	}
+	sort.Strings(live)
+	if equal(live, m.ring.Nodes()) {
+		m.mu.Unlock()
+		return
+	}
+	m.ring = NewRing(live, 0)
+	listeners := m.listeners
+	m.mu.Unlock()
+
+	log.Println("Cluster members:", strings.Join(live, ", "))
+	for _, listener := range listeners {
+		listener(live)
+	}
+}
+
+// check tells if a node answers its health end point
+func (m *Membership) check(node string) bool {
+	resp, err := m.client.Get(node + HealthPath)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// Self returns the URL of this node
+func (m *Membership) Self() string {
+	return m.self
+}
+
+// Owner returns the node of a session
+func (m *Membership) Owner(id string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.ring.Owner(id)
+}
+
+// Owns tells if this node owns a session
+func (m *Membership) Owns(id string) bool {
+	return m.Owner(id) == m.self
+}
+
+// Live returns the sorted live nodes
+func (m *Membership) Live() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.ring.Nodes()
+}
+
+// Leader returns the live node with the smallest name
+func (m *Membership) Leader() string {
+	return m.Live()[0]
+}
+
+// IsLeader tells if this node is the leader
+func (m *Membership) IsLeader() bool {
+	return m.Leader() == m.self
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,71 @@
+// Package cluster assigns sessions to server replicas by consistent hashing
+// and tracks which replicas are alive
+package cluster
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// defaultVirtualNodes is the number of points of each node on the ring
+const defaultVirtualNodes = 100
+
+// Ring assigns keys to nodes by consistent hashing
+// Adding or removing a node only moves the keys of that node
+// A ring is immutable, a new one is built when the nodes change
+type Ring struct {
+	points []uint32
+	owners map[uint32]string
+	nodes  []string
+}
+
+// NewRing creates a ring of the given nodes, each with vnodes points
+// spreading its keys, 100 if vnodes isn't positive
+func NewRing(nodes []string, vnodes int) *Ring {
+	if vnodes <= 0 {
+		vnodes = defaultVirtualNodes
+	}
+	r := &Ring{owners: map[uint32]string{}}
+	for _, node := range nodes {
+		r.nodes = append(r.nodes, node)
+		for i := 0; i < vnodes; i++ {
+			p := hash(node + "#" + strconv.Itoa(i))
+			// Collisions are rare, the smallest node name wins so all replicas agree
+			if other, ok := r.owners[p]; ok && other < node {
+				continue
+			} else if !ok {
+				r.points = append(r.points, p)
+			}
+			r.owners[p] = node
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	sort.Strings(r.nodes)
+	return r
+}
+
+// Owner returns the node of a key, or "" if the ring is empty
+func (r *Ring) Owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+// Nodes returns the sorted nodes of the ring
+func (r *Ring) Nodes() []string {
+	return append([]string{}, r.nodes...)
+}
+
+// hash spreads keys over the ring, FNV clusters the short and similar keys of sessions
+func hash(s string) uint32 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint32(sum[:4])
+}
@@ -0,0 +1,258 @@
+// Package codegen generates a typed Go package from a state machine definition
+//
+// The generated package has constants for the state and event names, a
+// SendXxx method per event and a Handlers interface with the actions the
+// application must implement, so that typos are caught by the compiler
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/ditek/jsonfsm/gofsm"
+)
+
+// name is a definition name with the Go identifier generated for it
+type name struct {
+	Value string
+	Ident string
+}
+
+// data is the input of the template
+type data struct {
+	Source      string
+	Package     string
+	Definition  string
+	States      []name
+	Events      []name
+	Actions     []name
+	HasHandlers bool
+}
+
+// Generate returns the formatted source of a package for the definition
+// The definition JSON is embedded, source names the file it comes from in the header
+// Returns an error if the definition is invalid or if two names map to the same identifier
+func Generate(definition []byte, pkg, source string) ([]byte, error) {
+	if !token.IsIdentifier(pkg) || token.IsKeyword(pkg) {
+		return nil, fmt.Errorf("Error: Invalid package name '%s'", pkg)
+	}
+	def, err := gofsm.LoadDefinition(definition)
+	if err != nil {
+		return nil, err
+	}
+	d := data{Source: source, Package: pkg, Definition: quote(string(definition))}
+
+	// Names are listed in order of first appearance
+	states := newNames("State")
+	for _, s := range def.States {
+		states.add(s.Name)
+	}
+	events := newNames("Event")
+	for _, e := range def.Events {
+		events.add(e)
+	}
+	for _, t := range def.Transitions {
+		events.add(t.Event)
+	}
+	actions := newNames("")
+	builtins := map[string]bool{}
+	for _, n := range gofsm.NewDefaultActionRegistry().Names() {
+		builtins[n] = true
+	}
+	addAction := func(n string) {
+		if !builtins[n] {
+			actions.add(n)
+		}
+	}
+	for _, s := range def.States {
+		if s.Script == nil {
+			addAction(s.Action)
+		}
+		for _, a := range s.Actions {
+			addAction(a)
+		}
+		addAction(s.Compensation)
+	}
+	for _, t := range def.Transitions {
+		addAction(t.Action)
+	}
+	// SendEvent is the untyped method of the machine
+	if events.idents["EventEvent"] != "" {
+		events.err = fmt.Errorf("Error: Event '%s' would generate the method SendEvent of gofsm.Machine", events.idents["EventEvent"])
+	}
+	for _, n := range []*names{states, events, actions} {
+		if n.err != nil {
+			return nil, n.err
+		}
+	}
+	d.States, d.Events, d.Actions = states.list, events.list, actions.list
+	d.HasHandlers = len(d.Actions) > 0
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, d); err != nil {
+		return nil, err
+	}
+	return format.Source(buf.Bytes())
+}
+
+// names collects unique names and their identifiers
+type names struct {
+	prefix string
+	seen   map[string]bool
+	idents map[string]string
+	list   []name
+	err    error
+}
+
+func newNames(prefix string) *names {
+	return &names{prefix: prefix, seen: map[string]bool{}, idents: map[string]string{}}
+}
+
+// add adds a name unless it is empty or already known
+func (n *names) add(value string) {
+	if value == "" || n.seen[value] {
+		return
+	}
+	n.seen[value] = true
+	ident := n.prefix + Ident(value)
+	if other, ok := n.idents[ident]; ok && n.err == nil {
+		n.err = fmt.Errorf("Error: '%s' and '%s' both generate the identifier '%s'", other, value, ident)
+	}
+	n.idents[ident] = value
+	n.list = append(n.list, name{Value: value, Ident: ident})
+}
+
+// Ident converts a name to an exported Go identifier
+// Words are split on any character that is not a letter or a digit, and
+// upper case words are capitalized: "ENTER_CODE" gives "EnterCode",
+// "order.created" gives "OrderCreated" and "ValidateCode" is kept
+func Ident(s string) string {
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var b strings.Builder
+	for _, w := range words {
+		if strings.ToUpper(w) == w {
+			w = strings.ToLower(w)
+		}
+		runes := []rune(w)
+		runes[0] = unicode.ToUpper(runes[0])
+		b.WriteString(string(runes))
+	}
+	ident := b.String()
+	if ident == "" || unicode.IsDigit([]rune(ident)[0]) {
+		ident = "X" + ident
+	}
+	return ident
+}
+
+// quote returns a Go literal of the string, a raw one when possible
+func quote(s string) string {
+	if strings.Contains(s, "`") || strings.Contains(s, "\r") {
+		return strconv.Quote(s)
+	}
+	return "`" + s + "`"
+}
+
+var tmpl = template.Must(template.New("package").Parse(`// Code generated by "jsonfsm generate {{.Source}}". DO NOT EDIT.
+
+// Package {{.Package}} is the typed API of the {{.Source}} state machine
+package {{.Package}}
+
+import "github.com/ditek/jsonfsm/gofsm"
+
+// State is a state of the machine
+type State string
+
+// States of the machine
+const (
+{{- range .States}}
+	{{.Ident}} State = {{printf "%q" .Value}}
+{{- end}}
+)
+
+// Event is an event the machine accepts
+type Event string
+
+// Events of the machine
+const (
+{{- range .Events}}
+	{{.Ident}} Event = {{printf "%q" .Value}}
+{{- end}}
+)
+
+// Definition is the JSON definition the package was generated from
+const Definition = {{.Definition}}
+
+// LoadDefinition parses the embedded definition
+func LoadDefinition() (*gofsm.Definition, error) {
+	return gofsm.LoadDefinition([]byte(Definition))
+}
+{{if .HasHandlers}}
+// Handlers implements the actions of the definition that are not built in
+type Handlers interface {
+{{- range .Actions}}
+	// {{.Ident}} implements the action {{printf "%q" .Value}}
+	{{.Ident}}(fsm *gofsm.Machine, arg string) bool
+{{- end}}
+}
+
+// Register adds the handlers to an action registry
+func Register(registry *gofsm.ActionRegistry, h Handlers) error {
+{{- range .Actions}}
+	if err := registry.Register({{printf "%q" .Value}}, h.{{.Ident}}); err != nil {
+		return err
+	}
+{{- end}}
+	return nil
+}
+{{end}}
+// Machine is a machine of the definition with a typed API
+type Machine struct {
+	*gofsm.Machine
+}
+
+// New creates and initializes a machine of the embedded definition
+{{- if .HasHandlers}}
+// Its actions are looked up in a copy of gofsm.Actions extended with the handlers
+func New(h Handlers, opts ...gofsm.Option) (*Machine, error) {
+{{- else}}
+func New(opts ...gofsm.Option) (*Machine, error) {
+{{- end}}
+	def, err := LoadDefinition()
+	if err != nil {
+		return nil, err
+	}
+{{- if .HasHandlers}}
+	registry := gofsm.Actions.Clone()
+	if err := Register(registry, h); err != nil {
+		return nil, err
+	}
+	opts = append([]gofsm.Option{gofsm.WithActions(registry)}, opts...)
+{{- end}}
+	m := &Machine{gofsm.NewMachine(def, opts...)}
+	m.Init()
+	return m, nil
+}
+
+// State returns the current state of the machine
+func (m *Machine) State() State {
+	return State(m.Snapshot().CurrentState)
+}
+
+// Send sends an event with a parameter
+func (m *Machine) Send(event Event, param string) (gofsm.TransitionResult, error) {
+	return m.SendEvent(gofsm.Event{Action: string(event), Param: param})
+}
+{{range .Events}}
+// Send{{slice .Ident 5}} sends the event {{printf "%q" .Value}}
+func (m *Machine) Send{{slice .Ident 5}}(param string) (gofsm.TransitionResult, error) {
+	return m.Send({{.Ident}}, param)
+}
+{{end}}`))
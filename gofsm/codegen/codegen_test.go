@@ -0,0 +1,95 @@
+package codegen
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+func TestIdent(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"ENTER_CODE", "EnterCode"},
+		{"order.created", "OrderCreated"},
+		{"ValidateCode", "ValidateCode"},
+		{"payments.Charge", "PaymentsCharge"},
+		{"2fa", "X2fa"},
+		{"--", "X"},
+	}
+	for _, test := range tests {
+		if got := Ident(test.name); got != test.want {
+			t.Errorf("Ident(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+const alarmDefinition = `{
+	"initialState": "DISARMED",
+	"states": [
+		{"name": "DISARMED", "action": "Log", "waitForEvent": true},
+		{"name": "ENTER_CODE", "action": "CheckCode", "waitForEvent": true},
+		{"name": "ARMED", "action": "Log", "waitForEvent": true}
+	],
+	"transitions": [
+		{"from": "DISARMED", "toSuccess": "ENTER_CODE", "event": "ARM"},
+		{"from": "ENTER_CODE", "toSuccess": "ARMED", "toFailure": "DISARMED", "branch": true, "event": "USER_CODE"}
+	]
+}`
+
+func TestGenerate(t *testing.T) {
+	src, err := Generate([]byte(alarmDefinition), "alarm", "alarm.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := parser.ParseFile(token.NewFileSet(), "alarm.go", src, 0)
+	if err != nil {
+		t.Fatalf("The generated source doesn't parse: %v\n%s", err, src)
+	}
+	if file.Name.Name != "alarm" {
+		t.Errorf("Got package %s, want alarm", file.Name.Name)
+	}
+	for _, want := range []string{"StateEnterCode", "EventUserCode", "SendArm(", "CheckCode(", "type Handlers interface"} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("The generated source has no %s", want)
+		}
+	}
+	// The built-in actions aren't handlers
+	if strings.Contains(string(src), "Log(") {
+		t.Error("The generated source has a handler for the built-in Log action")
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		def  string
+		pkg  string
+		err  string
+	}{
+		{"invalid package", alarmDefinition, "func", "Invalid package name"},
+		{"invalid definition", `{"initialState": "NOWHERE", "states": [], "transitions": []}`, "alarm", "NOWHERE"},
+		{"same identifier", `{
+			"initialState": "A_B",
+			"states": [{"name": "A_B", "action": "Log", "waitForEvent": true}, {"name": "a.b", "action": "Log", "waitForEvent": true}],
+			"transitions": [{"from": "A_B", "toSuccess": "a.b", "event": "go"}]
+		}`, "alarm", "both generate the identifier 'StateAB'"},
+		{"SendEvent", `{
+			"initialState": "A",
+			"states": [{"name": "A", "action": "Log", "waitForEvent": true}],
+			"transitions": [{"from": "A", "toSuccess": "A", "event": "EVENT"}]
+		}`, "alarm", "SendEvent"},
+	}
+	for _, test := range tests {
+		_, err := Generate([]byte(test.def), test.pkg, "fsm.json")
+		if err == nil {
+			t.Errorf("%s: generated without error", test.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got error %q, want %q", test.name, err, test.err)
+		}
+	}
+}
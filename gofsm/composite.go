@@ -0,0 +1,80 @@
+package gofsm
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Execution modes of the 'actions' of a state
+const (
+	// ActionModeSequential runs every action in order, the state
+	// action succeeds if all of them succeed
+	ActionModeSequential = "sequential"
+	// ActionModeFirstFailure runs the actions in order and stops at the first one that fails
+	ActionModeFirstFailure = "firstFailure"
+	// ActionModeParallel runs the actions concurrently, the state action
+	// succeeds if all of them succeed
+	ActionModeParallel = "parallel"
+)
+
+// actionResult is the outcome of one of the actions of a state
+type actionResult struct {
+	success bool
+	err     error
+}
+
+// callActions runs the actions of a state with the given mode
+// Returns the first error of an action that is not registered or panics
+func (fsm *Machine) callActions(names []string, mode string, event Event) (bool, error) {
+	switch mode {
+	case "", ActionModeSequential, ActionModeFirstFailure:
+		success := true
+		for _, name := range names {
+			ok, err := fsm.callNamedAction(name, event)
+			fsm.notifyAction(name, event, ok, err)
+			if err != nil {
+				return false, err
+			}
+			if !ok {
+				success = false
+				if mode == ActionModeFirstFailure {
+					break
+				}
+			}
+		}
+		return success, nil
+
+	case ActionModeParallel:
+		// Each action changes its own copy of the context, merged once they all returned
+		results := make([]actionResult, len(names))
+		branches := make([]*Machine, len(names))
+		var wg sync.WaitGroup
+		for i, name := range names {
+			branches[i] = fsm.handle(fsm.ActionContext(), true)
+			wg.Add(1)
+			go func(i int, name string) {
+				defer wg.Done()
+				ok, err := branches[i].callNamedAction(name, event)
+				results[i] = actionResult{success: ok, err: err}
+			}(i, name)
+		}
+		wg.Wait()
+		fsm.merge(branches)
+
+		// Listeners are notified in the order of the definition
+		success := true
+		var firstErr error
+		for i, name := range names {
+			fsm.notifyAction(name, event, results[i].success, results[i].err)
+			success = success && results[i].success
+			if firstErr == nil {
+				firstErr = results[i].err
+			}
+		}
+		if firstErr != nil {
+			return false, firstErr
+		}
+		return success, nil
+	}
+	return false, fmt.Errorf("Error: Unknown action mode '%s' in state '%s'", mode, fsm.CurrentState.Name)
+}
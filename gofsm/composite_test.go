@@ -0,0 +1,48 @@
+package gofsm
+
+import "testing"
+
+// TestParallelActionsContext checks that the parallel actions change their
+// own copy of the context, run with -race
+func TestParallelActionsContext(t *testing.T) {
+	actions := NewDefaultActionRegistry()
+	actions.Register("SetA", func(fsm *Machine, arg string) bool {
+		return fsm.SetVariable("a=1") && fsm.SetVariable("last=a")
+	})
+	actions.Register("SetB", func(fsm *Machine, arg string) bool {
+		delete(fsm.Context, "old")
+		return fsm.SetVariable("b=2") && fsm.SetVariable("last=b")
+	})
+	def, err := LoadDefinition([]byte(`{
+		"initialState": "IDLE",
+		"context": {"old": true, "kept": true},
+		"states": [
+			{"name": "IDLE", "actions": ["SetA", "SetB"], "actionMode": "parallel", "waitForEvent": true},
+			{"name": "DONE", "action": "Log", "waitForEvent": true}
+		],
+		"transitions": [
+			{"from": "IDLE", "toSuccess": "DONE", "event": "start"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		fsm := NewMachine(def, WithActions(actions))
+		fsm.Init()
+		if _, err := fsm.SendEvent(Event{Action: "start"}); err != nil {
+			t.Fatal(err)
+		}
+		ctx := fsm.Snapshot().Context
+		if ctx["a"] != "1" || ctx["b"] != "2" || ctx["kept"] != true {
+			t.Fatalf("Got context %v, want the variables of both actions", ctx)
+		}
+		// The changes are merged in the order of the actions
+		if ctx["last"] != "b" {
+			t.Errorf("Got last=%v, want b", ctx["last"])
+		}
+		if _, ok := ctx["old"]; ok {
+			t.Error("The variable removed by an action is still there")
+		}
+	}
+}
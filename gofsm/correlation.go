@@ -0,0 +1,69 @@
+package gofsm
+
+import (
+	"errors"
+	"log"
+)
+
+// ErrNoCorrelation is returned when no machine waits for the correlation key of an event
+var ErrNoCorrelation = errors.New("Error: No machine is waiting for the correlation key")
+
+// updateCorrelation computes the correlation key of the current state and
+// tells the manager of the machine when it changes
+// The key is taken by the state's selector from the event that entered the
+// state or from the context, e.g. "$.ctx.orderId"
+func (fsm *Machine) updateCorrelation(event Event) {
+	key := ""
+	// Terminated machines wait for nothing
+	if sel := fsm.CurrentState.CorrelationKey; sel != "" && !fsm.terminated {
+		var err error
+		if key, err = fsm.resolveSelector(sel, event); err != nil {
+			log.Printf("Error: No correlation key in state '%s': %v\n", fsm.CurrentState.Name, err)
+			key = ""
+		}
+	}
+	if key == fsm.correlationKey {
+		return
+	}
+	previous := fsm.correlationKey
+	fsm.correlationKey = key
+	if fsm.onCorrelate != nil {
+		fsm.onCorrelate(fsm, previous, key)
+	}
+}
+
+// correlate moves the registration of a machine from one correlation key to another
+// It is called with the machine locked, so it only takes the correlation lock
+func (m *Manager) correlate(fsm *Machine, previous, key string) {
+	m.correlationsMu.Lock()
+	defer m.correlationsMu.Unlock()
+	if previous != "" && m.correlations[previous] == fsm {
+		delete(m.correlations, previous)
+	}
+	if key == "" {
+		return
+	}
+	if other, ok := m.correlations[key]; ok && other != fsm {
+		log.Printf("Session '%s' takes over correlation key '%s' from session '%s'\n", fsm.ID, key, other.ID)
+	}
+	m.correlations[key] = fsm
+}
+
+// Correlated returns the machine waiting for a correlation key
+func (m *Manager) Correlated(key string) (*Machine, bool) {
+	m.correlationsMu.Lock()
+	defer m.correlationsMu.Unlock()
+	fsm, ok := m.correlations[key]
+	return fsm, ok
+}
+
+// forget removes the correlation keys of a machine
+func (m *Manager) forget(fsm *Machine) {
+	m.correlationsMu.Lock()
+	defer m.correlationsMu.Unlock()
+	for key, other := range m.correlations {
+		if other == fsm {
+			delete(m.correlations, key)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package gofsm
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Coverage counts the states entered and the transitions taken by the
+// machines of definitions, e.g. during the tests or in production, to find
+// the parts of the definitions that are never exercised
+// A coverage can be shared by the machines of many definitions and tenants
+type Coverage struct {
+	mu          sync.Mutex
+	definitions map[*Definition]*definitionCoverage
+}
+
+// definitionCoverage holds the hits of the machines of a definition
+type definitionCoverage struct {
+	states      map[string]*coverageHit
+	transitions map[TransitionEdge]*coverageHit
+}
+
+// coverageHit counts the hits of a state or transition
+type coverageHit struct {
+	count int
+	last  time.Time
+}
+
+// TransitionEdge is a transition to one of its destinations, e.g. the
+// failure destination of a branch
+type TransitionEdge struct {
+	From  string `json:"from"`
+	Event string `json:"event,omitempty"`
+	To    string `json:"to"`
+}
+
+// CoverageReport tells how much of a definition was exercised
+type CoverageReport struct {
+	Definition string `json:"definition,omitempty"`
+	// Since is the start of the window of the report, if any
+	Since       *time.Time      `json:"since,omitempty"`
+	States      CoverageSummary `json:"states"`
+	Transitions CoverageSummary `json:"transitions"`
+	// UnvisitedStates and NeverFired list what wasn't exercised in the window
+	UnvisitedStates []string         `json:"unvisitedStates"`
+	NeverFired      []TransitionEdge `json:"neverFired"`
+	StateHits       []StateHits      `json:"stateHits"`
+	TransitionHits  []TransitionHits `json:"transitionHits"`
+}
+
+// CoverageSummary is the share of the states or transitions exercised
+type CoverageSummary struct {
+	Covered int     `json:"covered"`
+	Total   int     `json:"total"`
+	Percent float64 `json:"percent"`
+}
+
+// StateHits counts the entries of a state in the window
+type StateHits struct {
+	State   string     `json:"state"`
+	Hits    int        `json:"hits"`
+	LastHit *time.Time `json:"lastHit,omitempty"`
+}
+
+// TransitionHits counts the times a transition was taken in the window
+type TransitionHits struct {
+	TransitionEdge
+	Hits    int        `json:"hits"`
+	LastHit *time.Time `json:"lastHit,omitempty"`
+}
+
+// NewCoverage creates an empty coverage
+func NewCoverage() *Coverage {
+	return &Coverage{definitions: map[*Definition]*definitionCoverage{}}
+}
+
+// WithCoverage counts the states and transitions of the machine in the given coverage
+// Sub-machines are not counted
+func WithCoverage(coverage *Coverage) Option {
+	return func(fsm *Machine) {
+		fsm.coverage = coverage
+	}
+}
+
+// coverState counts the entry of the current state
+func (fsm *Machine) coverState() {
+	if fsm.coverage != nil {
+		fsm.coverage.hit(fsm.Definition, fsm.CurrentState.Name, nil, fsm.clock().Now())
+	}
+}
+
+// coverTransition counts a transition taken to the given state
+func (fsm *Machine) coverTransition(t Transition, to string) {
+	if fsm.coverage != nil {
+		edge := TransitionEdge{From: t.From, Event: t.Event, To: to}
+		fsm.coverage.hit(fsm.Definition, "", &edge, fsm.clock().Now())
+	}
+}
+
+// hit counts the entry of a state or a transition of a definition
+func (c *Coverage) hit(def *Definition, state string, edge *TransitionEdge, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d := c.definitions[def]
+	if d == nil {
+		d = &definitionCoverage{states: map[string]*coverageHit{}, transitions: map[TransitionEdge]*coverageHit{}}
+		c.definitions[def] = d
+	}
+	var h *coverageHit
+	if edge != nil {
+		if h = d.transitions[*edge]; h == nil {
+			h = &coverageHit{}
+			d.transitions[*edge] = h
+		}
+	} else if h = d.states[state]; h == nil {
+		h = &coverageHit{}
+		d.states[state] = h
+	}
+	h.count++
+	h.last = now
+}
+
+// Report tells which states and transitions of a definition were exercised,
+// within the window before now unless it is 0
+// The hits of the states and transitions exercised in the window count
+// every hit since the coverage started
+func (c *Coverage) Report(def *Definition, window time.Duration, now time.Time) CoverageReport {
+	report := CoverageReport{
+		UnvisitedStates: []string{},
+		NeverFired:      []TransitionEdge{},
+		StateHits:       []StateHits{},
+		TransitionHits:  []TransitionHits{},
+	}
+	if def.Metadata != nil {
+		report.Definition = def.Metadata.Name
+	}
+	var since time.Time
+	if window > 0 {
+		since = now.Add(-window)
+		report.Since = &since
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d := c.definitions[def]
+	if d == nil {
+		d = &definitionCoverage{}
+	}
+	covered := func(h *coverageHit) (int, *time.Time, bool) {
+		if h == nil || h.last.Before(since) {
+			return 0, nil, false
+		}
+		last := h.last
+		return h.count, &last, true
+	}
+	for _, s := range def.States {
+		hits, last, ok := covered(d.states[s.Name])
+		report.States.add(ok)
+		if !ok {
+			report.UnvisitedStates = append(report.UnvisitedStates, s.Name)
+		}
+		report.StateHits = append(report.StateHits, StateHits{State: s.Name, Hits: hits, LastHit: last})
+	}
+	for _, edge := range def.Edges() {
+		hits, last, ok := covered(d.transitions[edge])
+		report.Transitions.add(ok)
+		if !ok {
+			report.NeverFired = append(report.NeverFired, edge)
+		}
+		report.TransitionHits = append(report.TransitionHits, TransitionHits{TransitionEdge: edge, Hits: hits, LastHit: last})
+	}
+	return report
+}
+
+// add counts a state or transition in the summary
+func (s *CoverageSummary) add(covered bool) {
+	s.Total++
+	if covered {
+		s.Covered++
+	}
+	s.Percent = float64(s.Covered) * 100 / float64(s.Total)
+}
+
+// Edges returns the transitions of the definition to each of their
+// destinations, without duplicates, e.g. the transitions guarded differently
+func (def *Definition) Edges() []TransitionEdge {
+	edges := []TransitionEdge{}
+	seen := map[TransitionEdge]bool{}
+	add := func(edge TransitionEdge) {
+		if edge.To != "" && !seen[edge] {
+			seen[edge] = true
+			edges = append(edges, edge)
+		}
+	}
+	for _, t := range def.Transitions {
+		add(TransitionEdge{From: t.From, Event: t.Event, To: t.ToSuccess})
+		if t.Branch {
+			add(TransitionEdge{From: t.From, Event: t.Event, To: t.ToFailure})
+		}
+		outcomes := make([]string, 0, len(t.Outcomes))
+		for outcome := range t.Outcomes {
+			outcomes = append(outcomes, outcome)
+		}
+		sort.Strings(outcomes)
+		for _, outcome := range outcomes {
+			add(TransitionEdge{From: t.From, Event: t.Event, To: t.Outcomes[outcome]})
+		}
+	}
+	return edges
+}
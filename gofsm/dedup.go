@@ -0,0 +1,65 @@
+package gofsm
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultDedupWindow is how long event IDs are remembered when
+// the definition doesn't set 'dedupWindow'
+const DefaultDedupWindow = 10 * time.Minute
+
+// ErrDuplicateEvent is returned when an event with an already processed ID is sent again
+var ErrDuplicateEvent = errors.New("Error: Event was already processed")
+
+// dedup remembers the IDs of the processed events
+type dedup struct {
+	seen      map[string]time.Time
+	lastPrune time.Time
+}
+
+// dedupWindow returns how long event IDs are remembered
+func (fsm *Machine) dedupWindow() (time.Duration, error) {
+	if fsm.DedupWindow == "" {
+		return DefaultDedupWindow, nil
+	}
+	d, err := time.ParseDuration(fsm.DedupWindow)
+	if err != nil {
+		return 0, fmt.Errorf("Error: Invalid dedup window '%s': %v", fsm.DedupWindow, err)
+	}
+	return d, nil
+}
+
+// isDuplicate reports whether an event with the same ID was processed within the window
+func (fsm *Machine) isDuplicate(event Event) (bool, error) {
+	if event.ID == "" {
+		return false, nil
+	}
+	window, err := fsm.dedupWindow()
+	if err != nil {
+		return false, err
+	}
+	now := fsm.clock().Now()
+	if now.Sub(fsm.dedup.lastPrune) > time.Minute {
+		fsm.dedup.lastPrune = now
+		for id, at := range fsm.dedup.seen {
+			if now.Sub(at) > window {
+				delete(fsm.dedup.seen, id)
+			}
+		}
+	}
+	at, ok := fsm.dedup.seen[event.ID]
+	return ok && now.Sub(at) <= window, nil
+}
+
+// markProcessed remembers the ID of a processed event
+func (fsm *Machine) markProcessed(event Event) {
+	if event.ID == "" {
+		return
+	}
+	if fsm.dedup.seen == nil {
+		fsm.dedup.seen = map[string]time.Time{}
+	}
+	fsm.dedup.seen[event.ID] = fsm.clock().Now()
+}
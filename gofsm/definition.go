@@ -0,0 +1,164 @@
+package gofsm
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sync"
+)
+
+// Definition is the immutable description of a state machine
+// It is parsed once and can be shared by any number of machines
+type Definition struct {
+	Version        string                 `json:"version,omitempty"`
+	SchemaVersion  int                    `json:"schemaVersion,omitempty"`
+	Metadata       *Metadata              `json:"metadata,omitempty"`
+	InitialState   string                 `json:"initialState"`
+	States         []State                `json:"states"`
+	Transitions    []Transition           `json:"transitions"`
+	Events         []string               `json:"events,omitempty"`
+	ExpectedCode   string                 `json:"expectedCode"`
+	ErrorState     string                 `json:"errorState,omitempty"`
+	DedupWindow    string                 `json:"dedupWindow,omitempty"`
+	MaxMicrosteps  int                    `json:"maxMicrosteps,omitempty"`
+	UnknownEvents  string                 `json:"unknownEvents,omitempty"`
+	Schedules      []Schedule             `json:"schedules,omitempty"`
+	InitialContext map[string]interface{} `json:"context,omitempty"`
+	// Variables are typed context variables, initialized to their default
+	Variables []Variable `json:"variables,omitempty"`
+	// Tick is the interval of the '_tick' events, e.g. "1m", none if empty
+	Tick string `json:"tick,omitempty"`
+	// ReservedEvents set to false makes '_reset', '_noop' and '_tick' ordinary events
+	ReservedEvents *bool `json:"reservedEvents,omitempty"`
+	// ResetRoles are the roles allowed to send '_reset', one of them is needed
+	ResetRoles []string `json:"resetRoles,omitempty"`
+	// Sensitive are the selectors of the payload fields whose values are
+	// redacted from the logs, traces and audit records, e.g. "$.event.card"
+	Sensitive []string `json:"sensitive,omitempty"`
+
+	// stateIndex maps state names to their position in States
+	stateIndex map[string]int
+	// children caches the definitions of the invoked sub-machines by file name
+	children   map[string]*Definition
+	childrenMu sync.Mutex
+}
+
+// Metadata tells the machines of a definition apart in deployments running many
+// It is surfaced by the introspection and attached to the audit records
+type Metadata struct {
+	Name        string            `json:"name,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Owner       string            `json:"owner,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// LoadDefinition parses and validates a JSON definition
+// Definitions are user input, so LoadDefinition returns an error rather than panicking
+func LoadDefinition(data []byte) (def *Definition, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			def = nil
+			err = fmt.Errorf("Error: Malformed definition: %v", r)
+		}
+	}()
+	if err := ValidateSchema(data); err != nil {
+		return nil, err
+	}
+	def = &Definition{}
+	if err := json.Unmarshal(upgradeDefinition(data), def); err != nil {
+		return nil, err
+	}
+	def.indexStates()
+	return def, nil
+}
+
+// LoadDefinitionFile parses and validates the JSON definition in the given
+// file, merged with the base it extends
+func LoadDefinitionFile(fileName string) (*Definition, error) {
+	data, err := ReadDefinitionFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return LoadDefinition(data)
+}
+
+// Load creates a state machine from a JSON definition
+// The definition is validated and the returned machine is not initialized
+func Load(data []byte) (*Machine, error) {
+	def, err := LoadDefinition(data)
+	if err != nil {
+		return nil, err
+	}
+	return NewMachine(def), nil
+}
+
+// LoadFile creates a state machine from the JSON definition in the given file
+// The definition is validated and the returned machine is not initialized
+func LoadFile(fileName string) (*Machine, error) {
+	def, err := LoadDefinitionFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return NewMachine(def), nil
+}
+
+// indexStates builds the lookup table of the states by name
+func (def *Definition) indexStates() {
+	def.stateIndex = make(map[string]int, len(def.States))
+	for i, s := range def.States {
+		def.stateIndex[s.Name] = i
+	}
+}
+
+// AddState adds a new state to the definition
+// Definitions must not be modified once they are used by a machine
+func (def *Definition) AddState(stateName string, action string,
+	actionArg string, waitForEvent bool) {
+	s := State{
+		Name:         stateName,
+		Action:       action,
+		ActionArg:    actionArg,
+		WaitForEvent: waitForEvent,
+	}
+	def.States = append(def.States, s)
+	if def.stateIndex != nil {
+		def.stateIndex[stateName] = len(def.States) - 1
+	}
+}
+
+// GetState returns the state with the matching name
+// and an error if not found
+func (def *Definition) GetState(name string) (State, error) {
+	if def.stateIndex != nil {
+		if i, ok := def.stateIndex[name]; ok {
+			return def.States[i], nil
+		}
+	} else {
+		for _, s := range def.States {
+			if s.Name == name {
+				return s, nil
+			}
+		}
+	}
+	return State{}, stateNotFoundError{name}
+}
+
+// childDefinition returns the definition of an invoked sub-machine,
+// loading it on first use
+func (def *Definition) childDefinition(fileName string) (*Definition, error) {
+	def.childrenMu.Lock()
+	defer def.childrenMu.Unlock()
+	key := filepath.Clean(fileName)
+	if child, ok := def.children[key]; ok {
+		return child, nil
+	}
+	child, err := LoadDefinitionFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if def.children == nil {
+		def.children = map[string]*Definition{}
+	}
+	def.children[key] = child
+	return child, nil
+}
@@ -0,0 +1,121 @@
+package gofsm
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// DefaultMaxMicrosteps is the number of transitions an event can take
+// when the definition doesn't set 'maxMicrosteps'
+const DefaultMaxMicrosteps = 100
+
+// ErrMicrostepLimit is matched by errors.Is for the events whose transitions
+// don't settle within the microstep limit, e.g. a loop of eventless transitions
+var ErrMicrostepLimit = errors.New("Error: Microstep limit reached")
+
+// microstepLimitError names the state where the limit was reached
+type microstepLimitError struct {
+	max   int
+	state string
+}
+
+func (e microstepLimitError) Error() string {
+	return fmt.Sprintf("Error: More than %d microsteps in a row from state '%s'", e.max, e.state)
+}
+
+func (e microstepLimitError) Is(target error) bool {
+	return target == ErrMicrostepLimit
+}
+
+// maxMicrosteps returns the number of transitions an event can take
+func (fsm *Machine) maxMicrosteps() int {
+	if fsm.MaxMicrosteps <= 0 {
+		return DefaultMaxMicrosteps
+	}
+	return fsm.MaxMicrosteps
+}
+
+// Emit queues an internal event, processed once the current transition
+// and the transitions it chains are complete
+// It is meant to be called by actions, which run while the machine is
+// locked. SendEvent called by an action queues its event the same way
+// The internal events are sent on behalf of the event being processed, see
+// onBehalf
+func (fsm *Machine) Emit(action, param string) {
+	fsm.actionMu.Lock()
+	defer fsm.actionMu.Unlock()
+	if fsm.abandoned() {
+		log.Printf("Error: Event '%s' emitted by an abandoned action is dropped\n", action)
+		return
+	}
+	fsm.queue = append(fsm.queue, fsm.onBehalf(Event{Action: action, Param: param}))
+}
+
+// onBehalf gives an internal event the sender of the event that started the
+// macrostep, so its transitions check the roles of that sender, and the
+// events emitted while processing the events of the machine itself aren't
+// checked like them
+func (fsm *Machine) onBehalf(event Event) Event {
+	trigger := fsm.lastEvent
+	event.Roles = trigger.Roles
+	event.internal = trigger.internal || trigger.scheduled || trigger.task || trigger.sentBy != ""
+	return event
+}
+
+// runToCompletion runs a macrostep: the transition of an external event, a timer
+// or the initial state, followed by every transition it causes
+// Each transition is a microstep: the eventless transitions are chained first,
+// then the internal events emitted by the actions are processed in order
+// The machine is locked meanwhile, so the next external event is only
+// processed once the machine is stable again
+// The internal events, and the events sent to other instances, are dropped if
+// the step fails
+// With a history, the machine is captured beforehand so StepBack can rewind it
+// The kind of the step is one of the Step constants, for the recorder
+func (fsm *Machine) runToCompletion(kind string, event Event, step func() error) error {
+	fsm.microsteps = 0
+	fsm.lastEvent = event
+	before := fsm.beginStep()
+	fsm.beginRecording(kind, event)
+	fsm.beginTrace(kind, event)
+	err := step()
+	if err != nil {
+		fsm.queue = nil
+	} else {
+		err = fsm.drainQueue()
+	}
+	fsm.deliverOutbox(err)
+	fsm.endStep(event, before, err)
+	fsm.commit()
+	fsm.endRecording(err)
+	fsm.endTrace(err)
+	return err
+}
+
+// drainQueue processes the internal events emitted by the actions, including
+// the ones emitted while processing them
+// Returns the first error, the remaining events are still processed unless
+// the microstep limit is reached
+func (fsm *Machine) drainQueue() error {
+	var first error
+	for len(fsm.queue) > 0 {
+		if max := fsm.maxMicrosteps(); fsm.microsteps >= max {
+			fsm.queue = nil
+			err := microstepLimitError{max: max, state: fsm.CurrentState.Name}
+			if first == nil {
+				first = err
+			}
+			break
+		}
+		event := fsm.queue[0]
+		fsm.queue = fsm.queue[1:]
+		if err := fsm.dispatch(event); err != nil {
+			log.Println(err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
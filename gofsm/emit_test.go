@@ -0,0 +1,189 @@
+package gofsm
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// emitActions emit the events named by their argument, which is the parameter
+// of the event for the states waiting for one
+// Emit passes its argument on, so the emitted event emits it again in turn
+// EmitAndPanic panics once it emitted
+func emitActions() *ActionRegistry {
+	r := NewDefaultActionRegistry()
+	r.Register("Emit", func(fsm *Machine, arg string) bool {
+		fsm.Emit(arg, arg)
+		return true
+	})
+	r.Register("EmitAll", func(fsm *Machine, arg string) bool {
+		for _, event := range strings.Split(arg, ",") {
+			fsm.Emit(event, "")
+		}
+		return true
+	})
+	r.Register("EmitAndPanic", func(fsm *Machine, arg string) bool {
+		fsm.Emit(arg, "")
+		panic("failed after emitting")
+	})
+	return r
+}
+
+// newEmitMachine creates an initialized machine of a definition with the
+// emitting actions
+func newEmitMachine(t *testing.T, definition string) *Machine {
+	def, err := LoadDefinition([]byte(definition))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsm := NewMachine(def, WithActions(emitActions()))
+	fsm.Init()
+	return fsm
+}
+
+func TestInternalEventsAfterEventlessChain(t *testing.T) {
+	fsm := newEmitMachine(t, `{
+		"initialState": "IDLE",
+		"states": [
+			{"name": "IDLE", "action": "Log", "waitForEvent": true},
+			{"name": "A", "action": "Emit", "actionArg": "next"},
+			{"name": "B", "action": "Log"},
+			{"name": "C", "action": "Log", "waitForEvent": true},
+			{"name": "D", "action": "Log", "waitForEvent": true}
+		],
+		"transitions": [
+			{"from": "IDLE", "toSuccess": "A", "event": "start"},
+			{"from": "A", "toSuccess": "B"},
+			{"from": "B", "toSuccess": "C"},
+			{"from": "C", "toSuccess": "D", "event": "next"}
+		]
+	}`)
+	result, err := fsm.SendEvent(Event{Action: "start"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The emitted event is only processed once the eventless chain settled in C
+	if want := []string{"A", "B", "C", "D"}; !reflect.DeepEqual(result.Path, want) {
+		t.Errorf("Got path %v, want %v", result.Path, want)
+	}
+	if result.Microsteps != 4 {
+		t.Errorf("Got %d microsteps, want 4", result.Microsteps)
+	}
+	if fsm.CurrentState.Name != "D" {
+		t.Errorf("Got state %s, want D", fsm.CurrentState.Name)
+	}
+}
+
+func TestInternalEventsInOrder(t *testing.T) {
+	fsm := newEmitMachine(t, `{
+		"initialState": "IDLE",
+		"states": [
+			{"name": "IDLE", "action": "EmitAll", "waitForEvent": true},
+			{"name": "A", "action": "Log", "waitForEvent": true},
+			{"name": "B", "action": "Log", "waitForEvent": true},
+			{"name": "C", "action": "Log", "waitForEvent": true},
+			{"name": "D", "action": "Log", "waitForEvent": true}
+		],
+		"transitions": [
+			{"from": "IDLE", "toSuccess": "A", "event": "start"},
+			{"from": "A", "toSuccess": "B", "event": "first"},
+			{"from": "A", "toSuccess": "D", "event": "second"},
+			{"from": "B", "toSuccess": "C", "event": "second"},
+			{"from": "B", "toSuccess": "D", "event": "first"}
+		]
+	}`)
+	result, err := fsm.SendEvent(Event{Action: "start", Param: "first,second"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The events are processed in the order they were emitted
+	if want := []string{"A", "B", "C"}; !reflect.DeepEqual(result.Path, want) {
+		t.Errorf("Got path %v, want %v", result.Path, want)
+	}
+}
+
+func TestMicrostepLimit(t *testing.T) {
+	fsm := newEmitMachine(t, `{
+		"initialState": "IDLE",
+		"maxMicrosteps": 3,
+		"states": [
+			{"name": "IDLE", "action": "Log", "waitForEvent": true},
+			{"name": "S1", "action": "Log"},
+			{"name": "S2", "action": "Log"},
+			{"name": "S3", "action": "Log"},
+			{"name": "S4", "action": "Log", "waitForEvent": true}
+		],
+		"transitions": [
+			{"from": "IDLE", "toSuccess": "S1", "event": "start"},
+			{"from": "S1", "toSuccess": "S2"},
+			{"from": "S2", "toSuccess": "S3"},
+			{"from": "S3", "toSuccess": "S4"}
+		]
+	}`)
+	_, err := fsm.SendEvent(Event{Action: "start"})
+	if !errors.Is(err, ErrMicrostepLimit) {
+		t.Fatalf("Got error %v, want ErrMicrostepLimit", err)
+	}
+	// The chain stops at the limit
+	if fsm.CurrentState.Name != "S3" {
+		t.Errorf("Got state %s, want S3", fsm.CurrentState.Name)
+	}
+}
+
+func TestMicrostepLimitOfInternalEvents(t *testing.T) {
+	fsm := newEmitMachine(t, `{
+		"initialState": "IDLE",
+		"maxMicrosteps": 5,
+		"states": [
+			{"name": "IDLE", "action": "Emit", "waitForEvent": true},
+			{"name": "LOOP", "action": "Emit", "waitForEvent": true}
+		],
+		"transitions": [
+			{"from": "IDLE", "toSuccess": "LOOP", "event": "start"},
+			{"from": "LOOP", "toSuccess": "LOOP", "event": "again"}
+		]
+	}`)
+	_, err := fsm.SendEvent(Event{Action: "start", Param: "again"})
+	if !errors.Is(err, ErrMicrostepLimit) {
+		t.Fatalf("Got error %v, want ErrMicrostepLimit", err)
+	}
+	if len(fsm.queue) != 0 {
+		t.Errorf("Got %d queued events after the limit, want none", len(fsm.queue))
+	}
+}
+
+func TestQueueDroppedOnFailure(t *testing.T) {
+	fsm := newEmitMachine(t, `{
+		"initialState": "IDLE",
+		"states": [
+			{"name": "IDLE", "action": "Log", "waitForEvent": true},
+			{"name": "A", "action": "Log", "waitForEvent": true},
+			{"name": "B", "action": "Log", "waitForEvent": true},
+			{"name": "C", "action": "Log", "waitForEvent": true}
+		],
+		"transitions": [
+			{"from": "IDLE", "toSuccess": "A", "event": "start", "action": "EmitAndPanic", "actionArg": "next"},
+			{"from": "IDLE", "toSuccess": "B", "event": "next"},
+			{"from": "IDLE", "toSuccess": "C", "event": "poke"}
+		]
+	}`)
+	if _, err := fsm.SendEvent(Event{Action: "start"}); !errors.Is(err, ErrActionPanicked) {
+		t.Fatalf("Got error %v, want ErrActionPanicked", err)
+	}
+	// The machine stays where the chain stopped, without the emitted event
+	if fsm.CurrentState.Name != "IDLE" {
+		t.Errorf("Got state %s, want IDLE", fsm.CurrentState.Name)
+	}
+	if len(fsm.queue) != 0 {
+		t.Fatalf("Got %d queued events after the failure, want none", len(fsm.queue))
+	}
+	// The dropped event isn't processed with the next one either
+	result, err := fsm.SendEvent(Event{Action: "poke"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"C"}; !reflect.DeepEqual(result.Path, want) {
+		t.Errorf("Got path %v, want %v", result.Path, want)
+	}
+}
@@ -0,0 +1,174 @@
+package gofsm
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrDecryption is returned for data that can't be decrypted, e.g. altered
+// or encrypted with another key
+var ErrDecryption = errors.New("Error: Cannot decrypt the data, it was altered or encrypted with another key")
+
+// Encrypter encrypts the data persisted by the stores, for machines carrying
+// personal data, see EncryptSnapshots and EncryptAudit
+// Implementations must be safe for concurrent use
+type Encrypter interface {
+	Encrypt(plaintext []byte) ([]byte, error)
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+// AESGCM encrypts with AES-GCM, each ciphertext starting with its random nonce
+type AESGCM struct {
+	aead cipher.AEAD
+}
+
+var _ Encrypter = (*AESGCM)(nil)
+
+// NewAESGCM creates an AES-GCM encrypter with a key of 16, 24 or 32 bytes,
+// for AES-128, AES-192 or AES-256
+func NewAESGCM(key []byte) (*AESGCM, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("Error: Invalid encryption key: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &AESGCM{aead: aead}, nil
+}
+
+// Encrypt seals the plaintext with a new random nonce
+func (e *AESGCM) Encrypt(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, e.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return e.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Decrypt opens a ciphertext of Encrypt, or returns ErrDecryption
+func (e *AESGCM) Decrypt(ciphertext []byte) ([]byte, error) {
+	n := e.aead.NonceSize()
+	if len(ciphertext) < n {
+		return nil, ErrDecryption
+	}
+	plaintext, err := e.aead.Open(nil, ciphertext[:n], ciphertext[n:], nil)
+	if err != nil {
+		return nil, ErrDecryption
+	}
+	return plaintext, nil
+}
+
+// sealedSnapshot is the part of a snapshot encrypted by EncryptSnapshots
+type sealedSnapshot struct {
+	Context map[string]interface{} `json:"context,omitempty"`
+	Child   *Snapshot              `json:"child,omitempty"`
+}
+
+// encryptedSnapshots encrypts the snapshots of a store, see EncryptSnapshots
+type encryptedSnapshots struct {
+	store SnapshotStore
+	enc   Encrypter
+}
+
+// EncryptSnapshots wraps a snapshot store so the context of the snapshots,
+// and the snapshot of their sub-machine, are encrypted before they are saved
+// and decrypted when they are loaded, whatever the store
+// The state and the other fields stay readable. Snapshots saved before the
+// encryption was enabled are still loaded
+func EncryptSnapshots(store SnapshotStore, enc Encrypter) SnapshotStore {
+	return &encryptedSnapshots{store: store, enc: enc}
+}
+
+// SaveSnapshot encrypts the snapshot and saves it
+func (s *encryptedSnapshots) SaveSnapshot(id string, snap Snapshot) error {
+	data, err := json.Marshal(sealedSnapshot{Context: snap.Context, Child: snap.Child})
+	if err != nil {
+		return err
+	}
+	if snap.Sealed, err = s.enc.Encrypt(data); err != nil {
+		return err
+	}
+	snap.Context, snap.Child = nil, nil
+	return s.store.SaveSnapshot(id, snap)
+}
+
+// LoadSnapshot loads a snapshot and decrypts it
+func (s *encryptedSnapshots) LoadSnapshot(id string) (Snapshot, error) {
+	snap, err := s.store.LoadSnapshot(id)
+	if err != nil || snap.Sealed == nil {
+		return snap, err
+	}
+	data, err := s.enc.Decrypt(snap.Sealed)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("Error: Cannot load the snapshot of '%s': %w", id, err)
+	}
+	var sealed sealedSnapshot
+	if err := json.Unmarshal(data, &sealed); err != nil {
+		return Snapshot{}, err
+	}
+	snap.Context, snap.Child, snap.Sealed = sealed.Context, sealed.Child, nil
+	return snap, nil
+}
+
+// DeleteSnapshot removes a snapshot
+func (s *encryptedSnapshots) DeleteSnapshot(id string) error {
+	return s.store.DeleteSnapshot(id)
+}
+
+// EncryptedPrefix starts the encrypted parameters of audit records
+const EncryptedPrefix = "encrypted:"
+
+// encryptedAudit encrypts the records of a sink, see EncryptAudit
+type encryptedAudit struct {
+	sink AuditSink
+	enc  Encrypter
+}
+
+// EncryptAudit wraps an audit sink so the parameters of the records, which
+// carry the payloads of the events, are encrypted, e.g. for the events table
+// of a database
+// The encrypted parameters are the EncryptedPrefix followed by the base64 of
+// the ciphertext, DecryptRecord restores them
+func EncryptAudit(sink AuditSink, enc Encrypter) AuditSink {
+	return &encryptedAudit{sink: sink, enc: enc}
+}
+
+// Audit encrypts the parameter of the record and hands it to the sink
+// Sinks can't fail the event, so a record that can't be encrypted is dropped
+func (s *encryptedAudit) Audit(record AuditRecord) {
+	if record.Param != "" {
+		sealed, err := s.enc.Encrypt([]byte(record.Param))
+		if err != nil {
+			return
+		}
+		record.Param = EncryptedPrefix + base64.StdEncoding.EncodeToString(sealed)
+	}
+	s.sink.Audit(record)
+}
+
+// DecryptRecord decrypts the parameter of a record written through EncryptAudit
+// Records whose parameter isn't encrypted are returned as they are
+func DecryptRecord(record AuditRecord, enc Encrypter) (AuditRecord, error) {
+	encoded, ok := strings.CutPrefix(record.Param, EncryptedPrefix)
+	if !ok {
+		return record, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return record, ErrDecryption
+	}
+	param, err := enc.Decrypt(sealed)
+	if err != nil {
+		return record, err
+	}
+	record.Param = string(param)
+	return record, nil
+}
@@ -0,0 +1,66 @@
+package gofsm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrStateNotFound is matched by errors.Is for references to unknown states
+var ErrStateNotFound = errors.New("Error: State not found")
+
+// stateNotFoundError names the unknown state
+type stateNotFoundError struct {
+	state string
+}
+
+func (e stateNotFoundError) Error() string {
+	return fmt.Sprintf("Error: State '%s' not found in states list", e.state)
+}
+
+func (e stateNotFoundError) Is(target error) bool {
+	return target == ErrStateNotFound
+}
+
+// ErrNoTransition is returned when no transition of the current state
+// supports an event, Event is empty for eventless transitions
+// Reason tells why, see Rejection
+type ErrNoTransition struct {
+	State  string
+	Event  string
+	Reason *RejectionReason
+}
+
+func (e *ErrNoTransition) Error() string {
+	if e.Event == "" {
+		return fmt.Sprintf("Error: No transition supports the current state - '%s'", e.State)
+	}
+	return fmt.Sprintf("Error: No transition supports the current state ('%s') and the sent event ('%s')", e.State, e.Event)
+}
+
+// ErrActionPanicked is matched by errors.Is for the actions that panicked,
+// which fail instead of crashing the machine
+var ErrActionPanicked = errors.New("Error: Action panicked")
+
+// actionPanicError names the action that panicked
+type actionPanicError struct {
+	action string
+	state  string
+	value  interface{}
+}
+
+func (e actionPanicError) Error() string {
+	return fmt.Sprintf("Error: Action '%s' panicked in state '%s': %v", e.action, e.state, e.value)
+}
+
+func (e actionPanicError) Is(target error) bool {
+	return target == ErrActionPanicked
+}
+
+// ErrHandlerMissing is returned when an action is not registered
+type ErrHandlerMissing struct {
+	Action string
+}
+
+func (e *ErrHandlerMissing) Error() string {
+	return fmt.Sprintf("Error: Action '%s' is not registered", e.Action)
+}
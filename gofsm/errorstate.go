@@ -0,0 +1,29 @@
+package gofsm
+
+import "log"
+
+// Context keys describing the last error that sent the machine to the error state
+const (
+	ContextError      = "error"
+	ContextErrorFrom  = "errorFrom"
+	ContextErrorEvent = "errorEvent"
+	ContextErrorParam = "errorParam"
+)
+
+// enterErrorState moves the machine to the error state after a failed action
+// and records the error and the offending event in the context
+// Returns the original error if the machine has no error state
+func (fsm *Machine) enterErrorState(event Event, err error) error {
+	if fsm.ErrorState == "" || fsm.CurrentState.Name == fsm.ErrorState {
+		return err
+	}
+	log.Println(err)
+	if fsm.Context == nil {
+		fsm.Context = map[string]interface{}{}
+	}
+	fsm.Context[ContextError] = err.Error()
+	fsm.Context[ContextErrorFrom] = fsm.CurrentState.Name
+	fsm.Context[ContextErrorEvent] = event.Action
+	fsm.Context[ContextErrorParam] = event.Param
+	return fsm.SetState(fsm.ErrorState, event)
+}
@@ -0,0 +1,250 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+type node interface {
+	eval(vars map[string]interface{}) (interface{}, error)
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (n *literalNode) eval(vars map[string]interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+// identNode looks up a variable, walking nested maps for dotted names
+// Unknown variables evaluate to nil
+type identNode struct {
+	path []string
+}
+
+func (n *identNode) eval(vars map[string]interface{}) (interface{}, error) {
+	var value interface{} = vars
+	for _, name := range n.path {
+		switch m := value.(type) {
+		case map[string]interface{}:
+			value = m[name]
+		case map[string]string:
+			value = m[name]
+		default:
+			return nil, nil
+		}
+	}
+	return normalize(value), nil
+}
+
+type unaryNode struct {
+	op      string
+	operand node
+}
+
+func (n *unaryNode) eval(vars map[string]interface{}) (interface{}, error) {
+	v, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "!":
+		return !Truthy(v), nil
+	case "-":
+		f, ok := ToNumber(v)
+		if !ok {
+			return nil, fmt.Errorf("cannot negate %v", v)
+		}
+		return -f, nil
+	}
+	return nil, fmt.Errorf("unknown operator '%s'", n.op)
+}
+
+type binaryNode struct {
+	op          string
+	left, right node
+}
+
+func (n *binaryNode) eval(vars map[string]interface{}) (interface{}, error) {
+	l, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	// Logical operators short-circuit
+	switch n.op {
+	case "&&":
+		if !Truthy(l) {
+			return false, nil
+		}
+		r, err := n.right.eval(vars)
+		return Truthy(r), err
+	case "||":
+		if Truthy(l) {
+			return true, nil
+		}
+		r, err := n.right.eval(vars)
+		return Truthy(r), err
+	}
+
+	r, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return Equal(l, r), nil
+	case "!=":
+		return !Equal(l, r), nil
+	case "<", "<=", ">", ">=":
+		c, err := compare(l, r)
+		if err != nil {
+			return nil, err
+		}
+		switch n.op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		default:
+			return c >= 0, nil
+		}
+	case "+":
+		// '+' concatenates unless both sides are numbers
+		lf, lok := ToNumber(l)
+		rf, rok := ToNumber(r)
+		if lok && rok {
+			return lf + rf, nil
+		}
+		return stringOf(l) + stringOf(r), nil
+	case "-", "*", "/", "%":
+		lf, lok := ToNumber(l)
+		rf, rok := ToNumber(r)
+		if !lok || !rok {
+			return nil, fmt.Errorf("operator '%s' needs numbers, got %v and %v", n.op, l, r)
+		}
+		switch n.op {
+		case "-":
+			return lf - rf, nil
+		case "*":
+			return lf * rf, nil
+		case "/":
+			if rf == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			return lf / rf, nil
+		default:
+			if rf == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			return math.Mod(lf, rf), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown operator '%s'", n.op)
+}
+
+// normalize converts Go numeric types to float64
+func normalize(v interface{}) interface{} {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case uint:
+		return float64(n)
+	case uint64:
+		return float64(n)
+	case float32:
+		return float64(n)
+	}
+	return v
+}
+
+// ToNumber converts numbers and numeric strings to float64
+func ToNumber(v interface{}) (float64, bool) {
+	switch n := normalize(v).(type) {
+	case float64:
+		return n, true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// Truthy reports whether a value counts as true
+func Truthy(v interface{}) bool {
+	switch b := normalize(v).(type) {
+	case nil:
+		return false
+	case bool:
+		return b
+	case float64:
+		return b != 0
+	case string:
+		return b != ""
+	}
+	return true
+}
+
+// Equal compares two values, numerically if both are numbers
+// or if one is a number and the other a numeric string
+func Equal(l, r interface{}) bool {
+	l, r = normalize(l), normalize(r)
+	_, lnum := l.(float64)
+	_, rnum := r.(float64)
+	if lnum || rnum {
+		lf, lok := ToNumber(l)
+		rf, rok := ToNumber(r)
+		if lok && rok {
+			return lf == rf
+		}
+	}
+	if l == nil || r == nil {
+		return l == nil && r == nil
+	}
+	return stringOf(l) == stringOf(r)
+}
+
+// compare orders two values numerically if possible, otherwise as strings
+func compare(l, r interface{}) (int, error) {
+	lf, lok := ToNumber(l)
+	rf, rok := ToNumber(r)
+	if lok && rok {
+		switch {
+		case lf < rf:
+			return -1, nil
+		case lf > rf:
+			return 1, nil
+		}
+		return 0, nil
+	}
+	ls, lok := normalize(l).(string)
+	rs, rok := normalize(r).(string)
+	if !lok || !rok {
+		return 0, fmt.Errorf("cannot compare %v and %v", l, r)
+	}
+	switch {
+	case ls < rs:
+		return -1, nil
+	case ls > rs:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// stringOf formats a value, printing integral numbers without decimals
+func stringOf(v interface{}) string {
+	if f, ok := v.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,272 @@
+// Package expr implements the small expression language used by
+// transition guards and variable assignments, e.g.
+//
+//	event.param == ctx.expectedCode && retries < 3
+//
+// Values are numbers (float64), strings, booleans and nil.
+// Identifiers are looked up in the variables passed to Eval and
+// dotted identifiers walk nested maps.
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expr is a parsed expression
+type Expr struct {
+	source string
+	root   node
+}
+
+// Parse parses an expression
+func Parse(source string) (*Expr, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokEOF {
+		return nil, fmt.Errorf("unexpected '%s' at position %d", p.peek().text, p.peek().pos)
+	}
+	return &Expr{source: source, root: root}, nil
+}
+
+// String returns the source of the expression
+func (e *Expr) String() string {
+	return e.source
+}
+
+// Eval evaluates the expression against the given variables
+func (e *Expr) Eval(vars map[string]interface{}) (interface{}, error) {
+	return e.root.eval(vars)
+}
+
+// EvalBool evaluates the expression and checks that the result is a boolean
+func (e *Expr) EvalBool(vars map[string]interface{}) (bool, error) {
+	v, err := e.Eval(vars)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("'%s' is not a boolean expression", e.source)
+	}
+	return b, nil
+}
+
+// Eval parses and evaluates an expression in one step
+func Eval(source string, vars map[string]interface{}) (interface{}, error) {
+	e, err := Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	return e.Eval(vars)
+}
+
+/****** Tokenizer *******/
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// operators lists the operators, longest first
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "+", "-", "*", "/", "%"}
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(s) {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokLParen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokRParen, ")", i})
+			i++
+		case c == '\'' || c == '"':
+			start := i
+			i++
+			var sb strings.Builder
+			for i < len(s) && rune(s[i]) != c {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				sb.WriteByte(s[i])
+				i++
+			}
+			if i >= len(s) {
+				return nil, fmt.Errorf("unterminated string at position %d", start)
+			}
+			i++
+			tokens = append(tokens, token{tokString, sb.String(), start})
+		case unicode.IsDigit(c):
+			start := i
+			for i < len(s) && (unicode.IsDigit(rune(s[i])) || s[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{tokNumber, s[start:i], start})
+		case c == '_' || unicode.IsLetter(c):
+			start := i
+			for i < len(s) && (s[i] == '_' || s[i] == '.' || unicode.IsLetter(rune(s[i])) || unicode.IsDigit(rune(s[i]))) {
+				i++
+			}
+			tokens = append(tokens, token{tokIdent, s[start:i], start})
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(s[i:], op) {
+					tokens = append(tokens, token{tokOp, op, i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character '%c' at position %d", c, i)
+			}
+		}
+	}
+	return append(tokens, token{tokEOF, "end of expression", len(s)}), nil
+}
+
+/****** Parser *******/
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// acceptOp consumes the next token if it is one of the given operators
+func (p *parser) acceptOp(ops ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tokOp {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+// binaryLevel parses a left-associative level of binary operators
+func (p *parser) binaryLevel(next func() (node, error), ops ...string) (node, error) {
+	left, err := next()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.acceptOp(ops...)
+		if !ok {
+			return left, nil
+		}
+		right, err := next()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseOr() (node, error) {
+	return p.binaryLevel(p.parseAnd, "||")
+}
+
+func (p *parser) parseAnd() (node, error) {
+	return p.binaryLevel(p.parseComparison, "&&")
+}
+
+func (p *parser) parseComparison() (node, error) {
+	return p.binaryLevel(p.parseSum, "==", "!=", "<=", ">=", "<", ">")
+}
+
+func (p *parser) parseSum() (node, error) {
+	return p.binaryLevel(p.parseProduct, "+", "-")
+}
+
+func (p *parser) parseProduct() (node, error) {
+	return p.binaryLevel(p.parseUnary, "*", "/", "%")
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if op, ok := p.acceptOp("!", "-"); ok {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: op, operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number '%s' at position %d", t.text, t.pos)
+		}
+		return &literalNode{value: f}, nil
+	case tokString:
+		return &literalNode{value: t.text}, nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		case "nil", "null":
+			return &literalNode{value: nil}, nil
+		}
+		return &identNode{path: strings.Split(t.text, ".")}, nil
+	case tokLParen:
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next().kind != tokRParen {
+			return nil, fmt.Errorf("missing ')' for '(' at position %d", t.pos)
+		}
+		return n, nil
+	}
+	return nil, fmt.Errorf("unexpected '%s' at position %d", t.text, t.pos)
+}
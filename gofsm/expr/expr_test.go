@@ -0,0 +1,107 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	vars := map[string]interface{}{
+		"retries": 2,
+		"name":    "alarm",
+		"ctx":     map[string]interface{}{"code": "123", "limit": 3.0},
+		"event":   map[string]string{"param": "123"},
+	}
+	tests := []struct {
+		source string
+		want   interface{}
+	}{
+		{"1 + 2 * 3", 7.0},
+		{"(1 + 2) * 3", 9.0},
+		{"7 % 4", 3.0},
+		{"-retries", -2.0},
+		{"retries < ctx.limit", true},
+		{"retries >= 3", false},
+		{"event.param == ctx.code", true},
+		{"name != 'alarm'", false},
+		{`"a" + name`, "aalarm"},
+		{"!missing", true},
+		{"missing == nil", true},
+		{"ctx.unknown.field", nil},
+		{"retries > 1 && name == 'alarm'", true},
+		{"false || retries == 2", true},
+		// The right side isn't evaluated once the result is known
+		{"false && 1 / 0", false},
+		{"true || 1 / 0", true},
+	}
+	for _, test := range tests {
+		got, err := Eval(test.source, vars)
+		if err != nil {
+			t.Errorf("%s: %v", test.source, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %v, want %v", test.source, got, test.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		err    string
+	}{
+		{"1 +", "unexpected 'end of expression'"},
+		{"(1 + 2", "missing ')'"},
+		{"1 2", "unexpected '2' at position 2"},
+		{"'open", "unterminated string at position 0"},
+		{"a # b", "unexpected character '#' at position 2"},
+		{"1.2.3", "invalid number '1.2.3'"},
+	}
+	for _, test := range tests {
+		_, err := Parse(test.source)
+		if err == nil {
+			t.Errorf("%s: parsed without error", test.source)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got error %q, want %q", test.source, err, test.err)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		err    string
+	}{
+		{"1 / 0", "division by zero"},
+		{"1 % 0", "division by zero"},
+		{"'a' - 1", "operator '-' needs numbers"},
+		{"-'a'", "cannot negate"},
+		{"'a' < 1", "cannot compare"},
+	}
+	for _, test := range tests {
+		_, err := Eval(test.source, nil)
+		if err == nil {
+			t.Errorf("%s: evaluated without error", test.source)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got error %q, want %q", test.source, err, test.err)
+		}
+	}
+}
+
+func TestEvalBool(t *testing.T) {
+	e, err := Parse("1 + 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.EvalBool(nil); err == nil {
+		t.Error("A number was accepted as a boolean")
+	}
+	if e.String() != "1 + 1" {
+		t.Errorf("Got source %q", e.String())
+	}
+}
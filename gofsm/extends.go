@@ -0,0 +1,206 @@
+package gofsm
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+)
+
+// BaseLoader returns the JSON of the base definition 'name' extended by the
+// definition 'from', and the key identifying the base, e.g. its path, which
+// is the 'from' of the bases it extends in turn
+type BaseLoader func(name, from string) (key string, data []byte, err error)
+
+// Extend merges a JSON definition with the base definition it extends, if any
+// Bases can extend other bases. The merged definition has no "extends":
+//   - states override the base state of the same name field by field, new
+//     states are added after the states of the base
+//   - transitions replace the base transition with the same from, event and
+//     guard, new transitions are added after the transitions of the base
+//   - variables replace the base variable of the same name, the context and
+//     the metadata with its labels are merged by key, events and schedules are
+//     added to the ones of the base
+//   - the other fields replace the ones of the base
+func Extend(data []byte, from string, load BaseLoader) ([]byte, error) {
+	return extend(data, from, load, map[string]bool{from: true})
+}
+
+func extend(data []byte, from string, load BaseLoader, seen map[string]bool) ([]byte, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		// Left to the validation to report
+		return data, nil
+	}
+	name, ok := doc["extends"].(string)
+	if !ok {
+		return data, nil
+	}
+	// Bases and definitions extending them can use different schema versions
+	upgradeNames(doc)
+	key, baseData, err := load(name, from)
+	if err != nil {
+		return nil, fmt.Errorf("Error: Cannot load base definition '%s': %v", name, err)
+	}
+	if seen[key] {
+		return nil, fmt.Errorf("Error: Definition '%s' extends itself", key)
+	}
+	seen[key] = true
+	if baseData, err = extend(baseData, key, load, seen); err != nil {
+		return nil, err
+	}
+	var base map[string]interface{}
+	if err := json.Unmarshal(baseData, &base); err != nil {
+		return nil, fmt.Errorf("Error: Invalid base definition '%s': %v", name, err)
+	}
+	upgradeNames(base)
+	delete(doc, "extends")
+	for field, value := range doc {
+		switch field {
+		case "states":
+			base[field] = mergeByKey(base[field], value, stateKey, true)
+		case "transitions":
+			base[field] = mergeByKey(base[field], value, transitionKey, false)
+		case "variables":
+			base[field] = mergeByKey(base[field], value, stateKey, false)
+		case "context":
+			base[field] = mergeObjects(base[field], value)
+		case "metadata":
+			baseMetadata, _ := base[field].(map[string]interface{})
+			metadata := mergeObjects(base[field], value)
+			if merged, ok := metadata.(map[string]interface{}); ok && baseMetadata != nil {
+				if labels, ok := value.(map[string]interface{})["labels"]; ok {
+					merged["labels"] = mergeObjects(baseMetadata["labels"], labels)
+				}
+			}
+			base[field] = metadata
+		case "events":
+			base[field] = union(base[field], value)
+		case "schedules":
+			base[field] = appendArrays(base[field], value)
+		default:
+			base[field] = value
+		}
+	}
+	return json.Marshal(base)
+}
+
+// ReadDefinitionFile reads the JSON definition in the given file, with its
+// fragments included and merged with the base it extends
+// The names of bases and fragments are paths relative to the file using them
+func ReadDefinitionFile(fileName string) ([]byte, error) {
+	data, err := readIncluded(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return Extend(data, filepath.Clean(fileName), loadBaseFile)
+}
+
+// loadBaseFile loads a base definition relative to the file extending it
+func loadBaseFile(name, from string) (string, []byte, error) {
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(from), name)
+	}
+	data, err := readIncluded(path)
+	return filepath.Clean(path), data, err
+}
+
+// stateKey identifies states and variables by name
+func stateKey(item map[string]interface{}) string {
+	name, _ := item["name"].(string)
+	return name
+}
+
+// transitionKey identifies transitions by their source, event and guard
+func transitionKey(item map[string]interface{}) string {
+	key, _ := json.Marshal([]interface{}{item["from"], item["event"], item["guard"]})
+	return string(key)
+}
+
+// mergeByKey merges the items of two JSON arrays of objects, the items of
+// the child replacing, or with fields set overriding, the base items with
+// the same key
+// Anything that is not an array of objects is left to the validation
+func mergeByKey(base, child interface{}, key func(map[string]interface{}) string, fields bool) interface{} {
+	baseItems, ok := base.([]interface{})
+	childItems, ok2 := child.([]interface{})
+	if !ok || !ok2 {
+		return child
+	}
+	merged := append([]interface{}{}, baseItems...)
+	index := map[string]int{}
+	for i, item := range merged {
+		if obj, ok := item.(map[string]interface{}); ok {
+			index[key(obj)] = i
+		}
+	}
+	for _, item := range childItems {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			merged = append(merged, item)
+			continue
+		}
+		i, ok := index[key(obj)]
+		switch {
+		case !ok:
+			index[key(obj)] = len(merged)
+			merged = append(merged, item)
+		case fields:
+			merged[i] = mergeObjects(merged[i], obj)
+		default:
+			merged[i] = item
+		}
+	}
+	return merged
+}
+
+// mergeObjects returns the fields of two JSON objects, the fields of the
+// child replacing the ones of the base
+func mergeObjects(base, child interface{}) interface{} {
+	baseObj, ok := base.(map[string]interface{})
+	childObj, ok2 := child.(map[string]interface{})
+	if !ok || !ok2 {
+		return child
+	}
+	merged := make(map[string]interface{}, len(baseObj)+len(childObj))
+	for k, v := range baseObj {
+		merged[k] = v
+	}
+	for k, v := range childObj {
+		merged[k] = v
+	}
+	return merged
+}
+
+// union adds the strings of the child array missing from the base array
+func union(base, child interface{}) interface{} {
+	baseItems, ok := base.([]interface{})
+	childItems, ok2 := child.([]interface{})
+	if !ok || !ok2 {
+		return child
+	}
+	merged := append([]interface{}{}, baseItems...)
+	for _, item := range childItems {
+		found := false
+		for _, b := range baseItems {
+			if s, ok := item.(string); ok && b == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, item)
+		}
+	}
+	return merged
+}
+
+// appendArrays adds the items of the child array to the base array
+func appendArrays(base, child interface{}) interface{} {
+	baseItems, ok := base.([]interface{})
+	childItems, ok2 := child.([]interface{})
+	if !ok || !ok2 {
+		return child
+	}
+	return append(append([]interface{}{}, baseItems...), childItems...)
+}
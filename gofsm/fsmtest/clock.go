@@ -0,0 +1,152 @@
+package fsmtest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ditek/jsonfsm/gofsm"
+)
+
+// FakeClock is a gofsm.Clock whose time only moves when Advance is called
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+var _ gofsm.Clock = (*FakeClock)(nil)
+
+// NewFakeClock creates a clock set to the given time
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// fakeTimer either calls f or sends on c when it fires
+type fakeTimer struct {
+	clock   *FakeClock
+	at      time.Time
+	f       func()
+	c       chan time.Time
+	stopped bool
+}
+
+// C returns the channel of the timer, nil for AfterFunc timers
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+// Stop cancels the timer
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	active := !t.stopped
+	t.clock.remove(t)
+	return active
+}
+
+// Reset makes the timer fire once the clock has advanced by d
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	active := !t.stopped
+	t.clock.remove(t)
+	t.at = t.clock.now.Add(d)
+	t.stopped = false
+	t.clock.timers = append(t.clock.timers, t)
+	return active
+}
+
+// fire calls the function of the timer or sends the time on its channel
+// Sends never block, like the timers of the time package
+func (t *fakeTimer) fire(now time.Time) {
+	if t.f != nil {
+		t.f()
+		return
+	}
+	select {
+	case t.c <- now:
+	default:
+	}
+}
+
+// Now returns the current fake time
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After returns a channel receiving the time once the clock has advanced by d
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+// NewTimer creates a timer firing once the clock has advanced by d
+func (c *FakeClock) NewTimer(d time.Duration) gofsm.Timer {
+	return c.add(d, nil)
+}
+
+// AfterFunc schedules f to be called once the clock has advanced by d
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) gofsm.Timer {
+	return c.add(d, f)
+}
+
+// add schedules a timer, with a channel unless it calls a function
+func (c *FakeClock) add(d time.Duration, f func()) *fakeTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), f: f}
+	if f == nil {
+		t.c = make(chan time.Time, 1)
+	}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// remove takes a timer out of the pending ones and marks it stopped
+// The clock must be locked
+func (c *FakeClock) remove(t *fakeTimer) {
+	t.stopped = true
+	for i, pending := range c.timers {
+		if pending == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return
+		}
+	}
+}
+
+// Advance moves the clock forward and synchronously runs the timers that
+// became due, in order, including timers scheduled by those timers
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	c.mu.Unlock()
+	for {
+		t := c.nextDue(end)
+		if t == nil {
+			break
+		}
+		t.fire(t.at)
+	}
+	c.mu.Lock()
+	c.now = end
+	c.mu.Unlock()
+}
+
+// nextDue removes and returns the earliest timer due before end
+// and moves the clock to its time
+func (c *FakeClock) nextDue(end time.Time) *fakeTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sort.SliceStable(c.timers, func(i, j int) bool {
+		return c.timers[i].at.Before(c.timers[j].at)
+	})
+	if len(c.timers) == 0 || c.timers[0].at.After(end) {
+		return nil
+	}
+	t := c.timers[0]
+	c.remove(t)
+	c.now = t.at
+	return t
+}
@@ -0,0 +1,46 @@
+package fsmtest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"sync"
+
+	"github.com/ditek/jsonfsm/gofsm"
+)
+
+// Coverage counts the states entered and the transitions taken by every test machine
+var Coverage = gofsm.NewCoverage()
+
+// definitions are the definitions of the test machines by their JSON
+var (
+	definitionsMu sync.Mutex
+	definitions   = map[string]*gofsm.Definition{}
+)
+
+// CoverageReports reports the coverage of the definitions of the test
+// machines created so far, in the order of their names
+func CoverageReports() []gofsm.CoverageReport {
+	definitionsMu.Lock()
+	defs := make([]*gofsm.Definition, 0, len(definitions))
+	for _, d := range definitions {
+		defs = append(defs, d)
+	}
+	definitionsMu.Unlock()
+	reports := make([]gofsm.CoverageReport, 0, len(defs))
+	for _, d := range defs {
+		reports = append(reports, Coverage.Report(d, 0, Epoch))
+	}
+	sort.SliceStable(reports, func(i, j int) bool { return reports[i].Definition < reports[j].Definition })
+	return reports
+}
+
+// WriteCoverage writes the coverage reports of the test machines to a JSON
+// file, which `jsonfsm coverage` prints, e.g. from TestMain once the tests ran
+func WriteCoverage(fileName string) error {
+	data, err := json.MarshalIndent(CoverageReports(), "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, data, 0644)
+}
@@ -0,0 +1,204 @@
+// Package fsmtest helps writing tests for state machine definitions
+//
+//	func TestAlarm(t *testing.T) {
+//		m := fsmtest.NewTestFSMFromFile(t, "../fsm.json")
+//		m.ExpectTransition("DISARMED", "ARM", "ENTER_CODE")
+//		m.Send("USER_CODE", "123")
+//		m.ExpectState("ARMED")
+//	}
+package fsmtest
+
+import (
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ditek/jsonfsm/gofsm"
+)
+
+// Epoch is the initial time of the fake clock of test machines
+var Epoch = time.Date(2019, 5, 15, 10, 0, 0, 0, time.UTC)
+
+// TestFSM is a state machine wired for tests
+// It runs on a fake clock with its own action registry and records the
+// actions and transitions
+type TestFSM struct {
+	*gofsm.Machine
+	Clock *FakeClock
+	// Registry holds the actions of the machine, a copy of gofsm.Actions
+	Registry *gofsm.ActionRegistry
+
+	t           testing.TB
+	mu          sync.Mutex
+	actions     []gofsm.ActionCall
+	transitions []gofsm.TransitionRecord
+}
+
+// NewTestFSM creates and initializes a machine from a JSON definition
+// The test fails immediately if the definition is invalid
+func NewTestFSM(t testing.TB, def []byte) *TestFSM {
+	t.Helper()
+	return newTestFSM(t, loadDefinition(t, def), Epoch, nil)
+}
+
+// loadDefinition parses a definition, failing the test immediately if it is invalid
+// The machines of the same definition share it, so their coverage adds up
+func loadDefinition(t testing.TB, def []byte) *gofsm.Definition {
+	t.Helper()
+	definitionsMu.Lock()
+	defer definitionsMu.Unlock()
+	if d, ok := definitions[string(def)]; ok {
+		return d
+	}
+	d, err := gofsm.LoadDefinition(def)
+	if err != nil {
+		t.Fatalf("invalid definition:\n%v", err)
+	}
+	definitions[string(def)] = d
+	return d
+}
+
+// newTestFSM creates a test machine whose clock is set to now, setup being
+// called before its initialization
+func newTestFSM(t testing.TB, d *gofsm.Definition, now time.Time, setup func(*TestFSM)) *TestFSM {
+	clock := NewFakeClock(now)
+	registry := gofsm.Actions.Clone()
+	// Sleep would wait for the fake clock, which can't advance while the machine is busy
+	registry.Unregister("Sleep")
+	registry.Register("Sleep", func(fsm *gofsm.Machine, arg string) bool {
+		_, err := time.ParseDuration(arg)
+		return err == nil
+	})
+	fsm := gofsm.NewMachine(d, gofsm.WithActions(registry), gofsm.WithClock(clock), gofsm.WithCoverage(Coverage))
+	m := &TestFSM{Machine: fsm, Clock: clock, Registry: registry, t: t}
+	fsm.OnAction(func(call gofsm.ActionCall) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.actions = append(m.actions, call)
+	})
+	fsm.OnTransition(func(record gofsm.TransitionRecord) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.transitions = append(m.transitions, record)
+	})
+	if setup != nil {
+		setup(m)
+	}
+	fsm.Init()
+	t.Cleanup(fsm.Stop)
+	return m
+}
+
+// NewTestFSMFromFile creates a test machine from a definition file
+func NewTestFSMFromFile(t testing.TB, fileName string) *TestFSM {
+	t.Helper()
+	def, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewTestFSM(t, def)
+}
+
+// Send sends an event and fails the test if it is rejected
+func (m *TestFSM) Send(action, param string) {
+	m.t.Helper()
+	if _, err := m.SendEvent(gofsm.Event{Action: action, Param: param}); err != nil {
+		m.t.Errorf("event '%s' in state '%s' failed: %v", action, m.CurrentState.Name, err)
+	}
+}
+
+// ExpectRejected sends an event and fails the test if it is accepted
+func (m *TestFSM) ExpectRejected(action, param string) {
+	m.t.Helper()
+	from := m.CurrentState.Name
+	if _, err := m.SendEvent(gofsm.Event{Action: action, Param: param}); err == nil {
+		m.t.Errorf("event '%s' in state '%s' was accepted, moved to '%s'", action, from, m.CurrentState.Name)
+	}
+}
+
+// ExpectState fails the test if the machine is not in the given state
+func (m *TestFSM) ExpectState(state string) {
+	m.t.Helper()
+	if m.CurrentState.Name != state {
+		m.t.Errorf("expected state '%s', got '%s'", state, m.CurrentState.Name)
+	}
+}
+
+// ExpectTransition puts the machine in the 'from' state without running any
+// action, sends the event and checks that the machine ends up in the 'to' state
+func (m *TestFSM) ExpectTransition(from, event, to string) {
+	m.ExpectTransitionWithParam(from, event, "", to)
+}
+
+// ExpectTransitionWithParam is ExpectTransition for an event with a parameter
+func (m *TestFSM) ExpectTransitionWithParam(from, event, param, to string) {
+	m.t.Helper()
+	snap := m.Snapshot()
+	snap.CurrentState = from
+	snap.Child = nil
+	if err := m.Restore(snap); err != nil {
+		m.t.Errorf("cannot move to state '%s': %v", from, err)
+		return
+	}
+	if _, err := m.SendEvent(gofsm.Event{Action: event, Param: param}); err != nil {
+		m.t.Errorf("%s --%s--> %s: %v", from, event, to, err)
+		return
+	}
+	if m.CurrentState.Name != to {
+		m.t.Errorf("%s --%s--> %s: ended in '%s'", from, event, to, m.CurrentState.Name)
+	}
+}
+
+// StubAction replaces an action of the machine with one that always returns the given result
+func (m *TestFSM) StubAction(name string, result bool) {
+	m.t.Helper()
+	m.Registry.Unregister(name)
+	if err := m.Registry.Register(name, func(*gofsm.Machine, string) bool {
+		return result
+	}); err != nil {
+		m.t.Fatal(err)
+	}
+}
+
+// Advance moves the fake clock forward, firing delayed transitions and schedules
+func (m *TestFSM) Advance(d time.Duration) {
+	m.Clock.Advance(d)
+}
+
+// Actions returns the actions run so far
+func (m *TestFSM) Actions() []gofsm.ActionCall {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]gofsm.ActionCall(nil), m.actions...)
+}
+
+// Transitions returns the transitions taken so far
+func (m *TestFSM) Transitions() []gofsm.TransitionRecord {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]gofsm.TransitionRecord(nil), m.transitions...)
+}
+
+// ExpectActions fails the test if the names of the actions run so far differ
+func (m *TestFSM) ExpectActions(names ...string) {
+	m.t.Helper()
+	actions := m.Actions()
+	if len(actions) != len(names) {
+		m.t.Errorf("expected %d actions %v, got %d", len(names), names, len(actions))
+		return
+	}
+	for i, call := range actions {
+		if call.Action != names[i] {
+			m.t.Errorf("action %d: expected '%s', got '%s'", i, names[i], call.Action)
+		}
+	}
+}
+
+// Reset clears the recorded actions and transitions
+func (m *TestFSM) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.actions = nil
+	m.transitions = nil
+}
@@ -0,0 +1,142 @@
+package fsmtest
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// alarmDefinition arms an alarm that goes off after a minute unless it is disarmed
+const alarmDefinition = `{
+	"initialState": "IDLE",
+	"states": [
+		{"name": "IDLE", "action": "Check", "waitForEvent": true},
+		{"name": "ARMED", "action": "Log", "after": "1m"},
+		{"name": "ALARM", "action": "Log", "waitForEvent": true}
+	],
+	"transitions": [
+		{"from": "IDLE", "toSuccess": "ARMED", "toFailure": "IDLE", "branch": true, "event": "arm"},
+		{"from": "ARMED", "toSuccess": "IDLE", "event": "disarm"},
+		{"from": "ARMED", "toSuccess": "ALARM"},
+		{"from": "ALARM", "toSuccess": "IDLE", "event": "disarm"}
+	]
+}`
+
+// fakeT records the failures of the assertions under test instead of
+// failing the test
+type fakeT struct {
+	testing.TB
+	failures []string
+}
+
+func (f *fakeT) Helper() {}
+
+func (f *fakeT) Errorf(format string, args ...interface{}) {
+	f.failures = append(f.failures, fmt.Sprintf(format, args...))
+}
+
+func (f *fakeT) Fatalf(format string, args ...interface{}) {
+	f.Errorf(format, args...)
+	runtime.Goexit()
+}
+
+// run calls fn with a fake test on its own goroutine, so Fatalf can stop it,
+// and returns the failures
+func run(t *testing.T, fn func(ft *fakeT)) []string {
+	ft := &fakeT{TB: t}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(ft)
+	}()
+	<-done
+	return ft.failures
+}
+
+// newAlarm creates a test machine whose Check action succeeds
+func newAlarm(ft *fakeT) *TestFSM {
+	m := NewTestFSM(ft, []byte(alarmDefinition))
+	m.StubAction("Check", true)
+	return m
+}
+
+func TestAssertions(t *testing.T) {
+	tests := []struct {
+		name     string
+		test     func(m *TestFSM)
+		failures int
+	}{
+		{"send", func(m *TestFSM) { m.Send("arm", "") }, 0},
+		{"send rejected", func(m *TestFSM) { m.Send("disarm", "") }, 1},
+		{"rejected", func(m *TestFSM) { m.ExpectRejected("disarm", "") }, 0},
+		{"rejected accepted", func(m *TestFSM) { m.ExpectRejected("arm", "") }, 1},
+		{"state", func(m *TestFSM) { m.ExpectState("IDLE") }, 0},
+		{"other state", func(m *TestFSM) { m.ExpectState("ARMED") }, 1},
+		{"transition", func(m *TestFSM) { m.ExpectTransition("ALARM", "disarm", "IDLE") }, 0},
+		{"transition elsewhere", func(m *TestFSM) { m.ExpectTransition("IDLE", "arm", "ALARM") }, 1},
+		{"transition rejected", func(m *TestFSM) { m.ExpectTransition("IDLE", "disarm", "IDLE") }, 1},
+		{"unknown state", func(m *TestFSM) { m.ExpectTransition("NOWHERE", "arm", "IDLE") }, 1},
+		{"actions", func(m *TestFSM) { m.Send("arm", ""); m.ExpectActions("Check") }, 0},
+		{"other actions", func(m *TestFSM) { m.Send("arm", ""); m.ExpectActions("Log") }, 1},
+		{"missing actions", func(m *TestFSM) { m.ExpectActions("Check") }, 1},
+		{"stubbed failure", func(m *TestFSM) {
+			m.StubAction("Check", false)
+			m.Send("arm", "")
+			m.ExpectState("IDLE")
+		}, 0},
+		{"advance", func(m *TestFSM) {
+			m.Send("arm", "")
+			m.Advance(59 * time.Second)
+			m.ExpectState("ARMED")
+			m.Advance(time.Second)
+			m.ExpectState("ALARM")
+		}, 0},
+		{"reset", func(m *TestFSM) {
+			m.Send("arm", "")
+			m.Reset()
+			if len(m.Actions()) != 0 || len(m.Transitions()) != 0 {
+				m.t.Errorf("the records were kept")
+			}
+		}, 0},
+	}
+	for _, test := range tests {
+		failures := run(t, func(ft *fakeT) { test.test(newAlarm(ft)) })
+		if len(failures) != test.failures {
+			t.Errorf("%s: got failures %q, want %d", test.name, failures, test.failures)
+		}
+	}
+}
+
+func TestInvalidDefinition(t *testing.T) {
+	failures := run(t, func(ft *fakeT) {
+		NewTestFSM(ft, []byte(`{"initialState": "NOWHERE", "states": [], "transitions": []}`))
+		ft.Errorf("the machine was created")
+	})
+	if len(failures) != 1 {
+		t.Errorf("Got failures %q, want the invalid definition", failures)
+	}
+}
+
+func TestCheckProperties(t *testing.T) {
+	props := Properties{
+		Sequences: 20,
+		MaxDelay:  2 * time.Minute,
+		Seed:      1,
+		Setup:     func(m *TestFSM) { m.StubAction("Check", true) },
+	}
+	if failures := run(t, func(ft *fakeT) { CheckProperties(ft, []byte(alarmDefinition), props) }); len(failures) != 0 {
+		t.Errorf("Got failures %q, want none", failures)
+	}
+	// An invariant that breaks once the alarm goes off fails the sequence
+	props.Invariants = append(props.Invariants, func(m *TestFSM) error {
+		if m.CurrentState.Name == "ALARM" {
+			return errors.New("the alarm went off")
+		}
+		return nil
+	})
+	if failures := run(t, func(ft *fakeT) { CheckProperties(ft, []byte(alarmDefinition), props) }); len(failures) != 1 {
+		t.Errorf("Got failures %q, want the broken invariant", failures)
+	}
+}
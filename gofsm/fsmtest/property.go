@@ -0,0 +1,167 @@
+package fsmtest
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/ditek/jsonfsm/gofsm"
+)
+
+// Properties configures the random event sequences run on a definition by
+// Property and CheckProperties, and the invariants checked after each step
+// Every sequence is checked to never enter a state missing from the
+// definition, to never let an action or a listener panic, and to settle
+// within the microstep limit after each event
+type Properties struct {
+	// Sequences is the number of sequences run by CheckProperties, 100 by default
+	Sequences int
+	// Length is the number of events of a sequence, 20 by default
+	Length int
+	// Terminates requires every sequence to reach a final state within Length events
+	Terminates bool
+	// MaxDelay advances the fake clock by a random duration up to MaxDelay
+	// before each event, firing the timers due in between
+	MaxDelay time.Duration
+	// Event builds the event sent for an event name, e.g. to add a parameter
+	// or data, the event has no parameter by default
+	Event func(r *rand.Rand, name string) gofsm.Event
+	// Setup is called on each machine before its initialization, e.g. to stub actions
+	Setup func(m *TestFSM)
+	// Invariants are checked after each step, a non-nil error failing the sequence
+	Invariants []func(m *TestFSM) error
+	// Seed seeds the seeds of the sequences run by CheckProperties, the
+	// current time by default
+	Seed int64
+}
+
+// Property returns a function running the sequence of events generated from
+// a seed on a new test machine of the definition, for testing/quick:
+//
+//	if err := quick.Check(fsmtest.Property(t, def, props), nil); err != nil {
+//		t.Fatal(err)
+//	}
+//
+// A failing sequence is reported with its seed and its events, and the
+// function returns false
+// Events are picked among the ones the current state has a transition for,
+// and pending asynchronous actions are completed with a random result
+func Property(t testing.TB, def []byte, props Properties) func(seed int64) bool {
+	t.Helper()
+	d := loadDefinition(t, def)
+	if props.Length <= 0 {
+		props.Length = 20
+	}
+	return func(seed int64) bool {
+		steps, err := runSequence(t, d, props, seed)
+		if err != nil {
+			t.Errorf("sequence %d failed after [%s]: %v", seed, strings.Join(steps, ", "), err)
+			return false
+		}
+		return true
+	}
+}
+
+// CheckProperties runs random event sequences on machines of the definition
+// and fails the test at the first sequence breaking an invariant
+func CheckProperties(t testing.TB, def []byte, props Properties) {
+	t.Helper()
+	if props.Sequences <= 0 {
+		props.Sequences = 100
+	}
+	if props.Seed == 0 {
+		props.Seed = time.Now().UnixNano()
+	}
+	config := &quick.Config{MaxCount: props.Sequences, Rand: rand.New(rand.NewSource(props.Seed))}
+	if err := quick.Check(Property(t, def, props), config); err != nil {
+		var failed *quick.CheckError
+		if !errors.As(err, &failed) {
+			t.Fatal(err)
+		}
+	}
+}
+
+// runSequence runs a sequence on a new machine and returns its steps so far
+// with the first broken invariant
+func runSequence(t testing.TB, d *gofsm.Definition, props Properties, seed int64) (steps []string, err error) {
+	r := rand.New(rand.NewSource(seed))
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	m := newTestFSM(t, d, Epoch, props.Setup)
+	if err := checkStep(m, d, props, nil); err != nil {
+		return []string{"init"}, err
+	}
+	for n := 0; n < props.Length && !m.CurrentState.Final; n++ {
+		if props.MaxDelay > 0 {
+			delay := time.Duration(r.Int63n(int64(props.MaxDelay) + 1))
+			steps = append(steps, "+"+delay.String())
+			m.Advance(delay)
+			if err := checkStep(m, d, props, nil); err != nil {
+				return steps, err
+			}
+			if m.CurrentState.Final {
+				break
+			}
+		}
+		var stepErr error
+		if pending, ok := m.PendingAction(); ok {
+			result := gofsm.ActionResult{Success: r.Intn(2) == 0}
+			steps = append(steps, fmt.Sprintf("complete(%t)", result.Success))
+			_, stepErr = m.CompleteAction(pending.Token, result)
+		} else {
+			events := m.AcceptedEvents()
+			if len(events) == 0 {
+				// Dead end, no event can move the machine anymore
+				break
+			}
+			name := events[r.Intn(len(events))]
+			event := gofsm.Event{Action: name}
+			if props.Event != nil {
+				event = props.Event(r, name)
+			}
+			steps = append(steps, event.Action)
+			_, stepErr = m.SendEvent(event)
+		}
+		if err := checkStep(m, d, props, stepErr); err != nil {
+			return steps, err
+		}
+	}
+	if props.Terminates && !m.CurrentState.Final {
+		return steps, fmt.Errorf("no final state reached, the machine is in state '%s'", m.CurrentState.Name)
+	}
+	return steps, nil
+}
+
+// checkStep checks the invariants after a step, err being the one of the event
+// The events rejected by their guards or by the actions are not failures
+func checkStep(m *TestFSM, d *gofsm.Definition, props Properties, err error) error {
+	if errors.Is(err, gofsm.ErrMicrostepLimit) {
+		return err
+	}
+	for _, record := range m.Transitions() {
+		if _, err := d.GetState(record.To); err != nil {
+			return fmt.Errorf("entered undefined state '%s'", record.To)
+		}
+	}
+	if _, err := d.GetState(m.CurrentState.Name); err != nil {
+		return fmt.Errorf("in undefined state '%s'", m.CurrentState.Name)
+	}
+	for _, call := range m.Actions() {
+		if errors.Is(call.Err, gofsm.ErrActionPanicked) {
+			return call.Err
+		}
+	}
+	for _, invariant := range props.Invariants {
+		if err := invariant(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package fsmtest
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ditek/jsonfsm/gofsm"
+)
+
+// Replay replays a recording of a machine, captured by a gofsm.Recorder, on a
+// test machine of the definition and checks that every step enters the
+// recorded states, and that the events and the results of asynchronous
+// actions fail or succeed like they did
+// The actions are stubbed with their recorded results, so no service is
+// called, and the fake clock starts at the time of the recording and moves
+// like it did between the steps, firing the same timers
+// The test stops replaying at the first step that differs
+func Replay(t testing.TB, def []byte, rec *gofsm.Recording) *TestFSM {
+	t.Helper()
+	d := loadDefinition(t, def)
+	stubs := newReplayStubs(t, d, rec)
+	m := newTestFSM(t, d, rec.StartedAt, stubs.install)
+	if rec.Start != nil {
+		if err := m.Restore(*rec.Start); err != nil {
+			t.Fatalf("cannot restore the start of the recording: %v", err)
+		}
+		m.Reset()
+	}
+	stubs.start()
+	for i, step := range rec.Steps {
+		mark := len(m.Transitions())
+		if step.Kind == gofsm.StepInit {
+			// The machine was initialized on creation
+			mark = 0
+		}
+		if step.Elapsed != "" {
+			elapsed, err := time.ParseDuration(step.Elapsed)
+			if err != nil {
+				t.Fatalf("step %d: invalid elapsed time '%s': %v", i, step.Elapsed, err)
+			}
+			m.Advance(elapsed)
+		}
+		var err error
+		switch step.Kind {
+		case gofsm.StepEvent:
+			_, err = m.SendEvent(*step.Event)
+		case gofsm.StepCompletion:
+			pending, ok := m.PendingAction()
+			if !ok {
+				t.Errorf("step %d: no action is pending in state '%s'", i, m.CurrentState.Name)
+				return m
+			}
+			_, err = m.CompleteAction(pending.Token, *step.Result)
+		case gofsm.StepRestore:
+			if err := m.Restore(*step.Snapshot); err != nil {
+				t.Fatalf("step %d: cannot restore the machine: %v", i, err)
+			}
+		}
+		var path []string
+		for _, record := range m.Transitions()[mark:] {
+			path = append(path, record.To)
+		}
+		if !samePath(path, step.Path) {
+			t.Errorf("step %d (%s): recorded path %v, replayed %v", i, describeStep(step), step.Path, path)
+			return m
+		}
+		// The errors of the initialization and of the timers are only logged
+		replied := step.Kind == gofsm.StepEvent || step.Kind == gofsm.StepCompletion
+		if replied && (err != nil) != (step.Error != "") {
+			t.Errorf("step %d (%s): recorded error '%s', replayed '%v'", i, describeStep(step), step.Error, err)
+			return m
+		}
+	}
+	return m
+}
+
+// ReplayFile replays a recording file on a machine of a definition file
+func ReplayFile(t testing.TB, defFile, recordingFile string) *TestFSM {
+	t.Helper()
+	def, err := ioutil.ReadFile(defFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := gofsm.LoadRecording(recordingFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Replay(t, def, rec)
+}
+
+// ReplayDir replays each recording file of a directory in a subtest named
+// after the file, e.g. the fixtures recorded by a staging server
+func ReplayDir(t *testing.T, defFile, dir string) {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatalf("no recording in '%s'", dir)
+	}
+	for _, file := range files {
+		file := file
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			ReplayFile(t, defFile, file)
+		})
+	}
+}
+
+// samePath tells if two paths enter the same states
+func samePath(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// describeStep names a step in the failures
+func describeStep(step gofsm.RecordedStep) string {
+	if step.Kind == gofsm.StepEvent && step.Event != nil {
+		return step.Kind + " '" + step.Event.Action + "'"
+	}
+	return step.Kind
+}
+
+// replayStubs replace the actions of a definition with their recorded results
+type replayStubs struct {
+	t       testing.TB
+	names   map[string]bool
+	mu      sync.Mutex
+	results map[string][]gofsm.RecordedAction
+	// replaying is set once the machine is restored from the start of the
+	// recording, or from the start without one
+	replaying bool
+}
+
+// newReplayStubs collects the recorded results of the actions in order
+func newReplayStubs(t testing.TB, d *gofsm.Definition, rec *gofsm.Recording) *replayStubs {
+	s := &replayStubs{t: t, names: map[string]bool{}, results: map[string][]gofsm.RecordedAction{}}
+	for _, state := range d.States {
+		for _, name := range append([]string{state.Action, state.Compensation}, state.Actions...) {
+			if name != "" {
+				s.names[name] = true
+			}
+		}
+	}
+	for _, tr := range d.Transitions {
+		if tr.Action != "" {
+			s.names[tr.Action] = true
+		}
+	}
+	for _, step := range rec.Steps {
+		for _, action := range step.Actions {
+			s.names[action.Action] = true
+			s.results[action.Action] = append(s.results[action.Action], action)
+		}
+	}
+	// Without a start, the initialization is the first step and its actions are recorded
+	s.replaying = rec.Start == nil
+	return s
+}
+
+// install replaces the actions of the machine with the stubs
+func (s *replayStubs) install(m *TestFSM) {
+	for name := range s.names {
+		name := name
+		m.Registry.Unregister(name)
+		m.Registry.Register(name, func(fsm *gofsm.Machine, arg string) bool {
+			return s.call(fsm, name)
+		})
+	}
+}
+
+// start makes the stubs return the recorded results
+func (s *replayStubs) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replaying = true
+}
+
+// call returns the next recorded result of an action
+// The actions run by a machine restored from the start of the recording
+// succeed without using any recorded result
+func (s *replayStubs) call(fsm *gofsm.Machine, name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.replaying {
+		return true
+	}
+	results := s.results[name]
+	if len(results) == 0 {
+		s.t.Errorf("action '%s' ran more times than recorded", name)
+		return false
+	}
+	s.results[name] = results[1:]
+	if results[0].Pending {
+		fsm.Pending()
+	}
+	return results[0].Success
+}
@@ -0,0 +1,46 @@
+package gofsm
+
+import (
+	"strings"
+	"testing"
+)
+
+// FuzzLoadDefinition loads arbitrary data as a definition, which must be
+// rejected with an error rather than panic since definitions are user input
+// The machine isn't run, so no action is called while fuzzing
+func FuzzLoadDefinition(f *testing.F) {
+	seeds := []string{
+		// A valid definition
+		`{"initialState": "A", "states": [{"name": "A", "action": "Log", "waitForEvent": true}, {"name": "B", "action": "Log", "waitForEvent": true}], "transitions": [{"from": "A", "toSuccess": "B", "event": "go"}]}`,
+		// Missing states
+		`{"initialState": "A", "transitions": []}`,
+		`{"initialState": "A", "states": [], "transitions": [{"from": "A", "toSuccess": "B", "event": "go"}]}`,
+		// Null transitions
+		`{"initialState": "A", "states": [{"name": "A", "action": "Log"}], "transitions": null}`,
+		`{"initialState": "A", "states": [{"name": "A", "action": "Log"}], "transitions": [null]}`,
+		// Cyclic states that don't wait for an event
+		`{"initialState": "A", "states": [{"name": "A", "action": "Log"}, {"name": "B", "action": "Log"}], "transitions": [{"from": "A", "toSuccess": "B"}, {"from": "B", "toSuccess": "A"}]}`,
+		// Bad selectors
+		`{"initialState": "A", "states": [{"name": "A", "action": "Log", "actionArg": "$.bogus[[", "waitForEvent": true}], "transitions": []}`,
+		`{"schemaVersion": 2, "initialState": "A", "states": [{"name": "A", "action": "Log", "correlationKey": "$.ctx.", "waitForEvent": true}], "transitions": []}`,
+		`null`,
+		`{`,
+	}
+	for _, seed := range seeds {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// ValidateSchema doesn't recover from panics, so the fuzzer sees them
+		ValidateSchema(data)
+		def, err := LoadDefinition(data)
+		if err != nil {
+			if strings.HasPrefix(err.Error(), "Error: Malformed definition") {
+				t.Fatalf("Loading panicked: %v", err)
+			}
+			return
+		}
+		if def.InitialState == "" {
+			t.Fatal("Loaded a definition without an initial state")
+		}
+	})
+}
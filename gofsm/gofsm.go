@@ -1,175 +1,646 @@
 package gofsm
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"reflect"
+	"sync"
+	"time"
 )
 
-// Transition represents an FSM transition
+// Transition represents a transition between two states
 type Transition struct {
 	From      string `json:"from"`
-	ToSuccess string `json:"toSuccess"`
+	ToSuccess string `json:"toSuccess,omitempty"`
 	ToFailure string `json:"toFailure,omitempty"`
 	Branch    bool   `json:"branch"`
 	Event     string `json:"event,omitempty"`
+	Guard     string `json:"guard,omitempty"`
+	// Outcomes maps the outcomes of the state action, or of the Choice
+	// expression, to the destination states
+	Outcomes map[string]string `json:"outcomes,omitempty"`
+	Choice   string            `json:"choice,omitempty"`
+	// Action runs while the transition is taken, after the action of the
+	// source state and before the destination state is entered
+	Action    string `json:"action,omitempty"`
+	ActionArg string `json:"actionArg,omitempty"`
+	// PayloadSchema validates the data of the event before the transition is taken
+	PayloadSchema *PayloadSchema `json:"payloadSchema,omitempty"`
+	// Response is sent to the sender of the event when the transition is taken
+	Response *ResponseTemplate `json:"response,omitempty"`
+	// Roles are the roles or scopes allowed to send the event, the sender
+	// needs one of them, see Event.Roles
+	Roles []string `json:"roles,omitempty"`
+	// SendTo sends an event to another instance when the transition is taken
+	SendTo *SendTo `json:"sendTo,omitempty"`
 }
 
-// State presents an FSM state
+// State presents a state of the machine
 type State struct {
-	Name         string `json:"name"`
-	Action       string `json:"action"`
-	ActionArg    string `json:"action_arg,omitempty"`
-	WaitForEvent bool   `json:"waitForEvent"`
-	SendResponse bool   `json:"sendResponse"`
+	Name         string            `json:"name"`
+	Action       string            `json:"action"`
+	Actions      []string          `json:"actions,omitempty"`
+	ActionMode   string            `json:"actionMode,omitempty"`
+	ActionArg    string            `json:"actionArg,omitempty"`
+	Args         map[string]string `json:"args,omitempty"`
+	WaitForEvent bool              `json:"waitForEvent"`
+	SendResponse bool              `json:"sendResponse"`
+	After        string            `json:"after,omitempty"`
+	Timeouts     []Timeout         `json:"timeouts,omitempty"`
+	Invoke       string            `json:"invoke,omitempty"`
+	Final        bool              `json:"final,omitempty"`
+	// ActionTimeout bounds the time the actions of the state run, e.g. "30s"
+	ActionTimeout string `json:"actionTimeout,omitempty"`
+	// SLA is how long machines are expected to stay in the state, e.g. "4h"
+	// Machines still in it afterwards are stuck, see Machine.Stuck
+	SLA string `json:"sla,omitempty"`
+	// CorrelationKey selects the key routing events to the machine while
+	// it is in this state, e.g. "$.ctx.orderId"
+	CorrelationKey string `json:"correlationKey,omitempty"`
+	// Response is sent to the sender of the event when the state is entered
+	Response *ResponseTemplate `json:"response,omitempty"`
+	// Compensation is the action undoing the work of the state once it
+	// completed, run when the machine enters a state that compensates
+	Compensation    string `json:"compensation,omitempty"`
+	CompensationArg string `json:"compensationArg,omitempty"`
+	// HumanTask makes the state wait for a person to complete a task
+	HumanTask *HumanTask `json:"humanTask,omitempty"`
+	// Compensate runs the compensations of the completed states when the
+	// state is entered, which the error state always does
+	Compensate bool `json:"compensate,omitempty"`
+	// Script is the inline action, set when 'action' is an object
+	Script *Script `json:"-"`
 }
 
-// Event represents a received HTTP event
+// Event represents a received event
 type Event struct {
-	Action string              `json:"action"`
-	Param  string              `json:"param"`
-	Writer http.ResponseWriter `json:"writer,omitempty"`
+	ID      string `json:"eventId,omitempty"`
+	Session string `json:"session,omitempty"`
+	// CorrelationKey routes events without a session to the machine waiting for the key
+	CorrelationKey string `json:"correlationKey,omitempty"`
+	Action         string `json:"action"`
+	Param          string `json:"param"`
+	// Data is the structured payload of the event, its fields can be
+	// passed to actions with selectors such as "$.event.code"
+	Data map[string]interface{} `json:"data,omitempty"`
+	// Priority moves a queued event ahead of the queued events of lower
+	// priority, e.g. "abort" or "cancel", 0 by default
+	Priority int `json:"priority,omitempty"`
+	// Roles are the roles or scopes of the sender, e.g. from the claims of
+	// its token, checked against the roles of the transitions
+	Roles []string `json:"-"`
+
+	// scheduled is set on the events of the schedules
+	scheduled bool
+	// internal is set on the events of the machine itself: the
+	// initialization, the timers and the timeouts of the states, and the
+	// events emitted while processing one of them
+	internal bool
+	// task is set on the events of the completed tasks, whose user was
+	// checked against the task instead of the roles
+	task bool
+	// sentBy is the instance that sent the event with SendTo, if any
+	sentBy string
 }
 
-// FSM represents the state machine
-type FSM struct {
-	InitialState string       `json:"initialState"`
-	States       []State      `json:"states"`
-	CurrentState State        `json:"omitempty"`
-	Transitions  []Transition `json:"transitions"`
-	ExpectedCode string       `json:"expectedCode"`
+// Machine is a running instance of a state machine definition
+type Machine struct {
+	// Definition is shared between all the machines created from it
+	*Definition
+
+	CurrentState State `json:"omitempty"`
+
+	// ID identifies the machine, e.g. the session it belongs to
+	ID string `json:"-"`
+	// Tenant is the customer the machine belongs to, if any
+	Tenant string `json:"-"`
+	// Clock is the source of time of timers and schedules, the real clock if nil
+	Clock Clock `json:"-"`
+
+	// Context holds the machine variables
+	Context map[string]interface{} `json:"context,omitempty"`
+
+	// lastEvent is the event of the last step, whose sensitive fields are redacted
+	lastEvent Event
+	// call is set on the handles given to the actions, see actionCall
+	call *actionCall
+	// machine is the state shared by the machine and the handles of its actions
+	*machine
 }
 
-// Init initializes the state machine
-func (fsm *FSM) Init() {
-	fsm.SetState(fsm.InitialState, Event{})
+// machine is the state of a Machine shared with the handles of its actions
+type machine struct {
+	// actions is the registry of the machine, Actions if nil
+	actions *ActionRegistry
+	// pool runs the actions if set, see WithWorkerPool
+	pool *WorkerPool
+	// breakers short-circuit the failing actions if set, see WithCircuitBreakers
+	breakers *CircuitBreakers
+	// pendingToken is set by the running actions that complete later, see Pending
+	pendingToken string
+	// actionCtx is the context of the running actions of a state with an
+	// actionTimeout, see ActionContext
+	actionCtx context.Context
+	// child is the sub-machine started by the current invoke state
+	child *Machine
+	// timer is the pending delayed transition of the current state
+	timer *stateTimer
+	// timeouts are the pending timeouts of the current state
+	timeouts *stateTimeouts
+	// timeoutEntries counts the state entries that scheduled timeouts
+	timeoutEntries uint64
+	// generation is incremented on every state entry
+	generation uint64
+	// stateMetrics counts the entries and time spent per state
+	stateMetrics map[string]StateMetrics
+	// enteredAt is the time the current state was entered
+	enteredAt time.Time
+	// listeners are notified of every transition
+	listeners []TransitionListener
+	// actionListeners are notified of every action
+	actionListeners []ActionListener
+	// auditSinks receive the audit trail of the machine
+	auditSinks []AuditSink
+	// projections receive the committed transitions
+	projections []Projection
+	// beforeHooks can veto the transitions, afterHooks receive the committed ones
+	beforeHooks []BeforeTransitionHook
+	afterHooks  []AfterTransitionHook
+	// staged holds the transitions of the current step until it is committed
+	staged []ProjectionRecord
+	// dedup remembers the IDs of the processed events
+	dedup dedup
+	// scheduleTimers are the pending timers of the schedules
+	scheduleTimers []Timer
+	// stopped is set once the machine is stopped
+	stopped bool
+	// terminated is set once the machine is aborted, for abortReason
+	terminated  bool
+	abortReason string
+	abortedAt   time.Time
+	// paused is set while the machine is paused, its timers are stopped
+	paused bool
+	// microsteps is the number of transitions taken by the current macrostep
+	microsteps int
+	// correlationKey is the key of the current state, if any
+	correlationKey string
+	// onCorrelate is called by the manager of the machine when its correlation key changes
+	onCorrelate func(fsm *Machine, previous, key string)
+	// outcome is the outcome set by the action of the current transition
+	outcome string
+	// result collects the transitions of the event being processed
+	result *TransitionResult
+	// mu serializes events and timers
+	mu sync.Mutex
+	// actionMu serializes the responses and emitted events of parallel actions
+	// and guards actionCtx, the action calls and middleware
+	actionMu sync.Mutex
+	// queue holds the internal events emitted by the actions
+	queue []Event
+	// outbox holds the events sent to other instances by the current step,
+	// delivered with onSend once it succeeded, see SendTo
+	outbox []Event
+	onSend func(event Event)
+	// middleware wraps the processing of the events, see Use
+	middleware []Middleware
+	// history holds the last historySize steps, for StepBack
+	history     []HistoryEntry
+	historySize int
+	// recorder records the steps if set, see WithRecorder, recording is
+	// the step being recorded
+	recorder  *Recorder
+	recording *stepRecording
+	// coverage counts the states and transitions if set, see WithCoverage
+	coverage *Coverage
+	// tracer logs the steps if set, see WithTrace
+	tracer *tracer
 }
 
-// AddState adds a new state to the state machine
-func (fsm *FSM) AddState(stateName string, action string,
-	actionArg string, waitForEvent bool) {
-	s := State{
-		Name:         stateName,
-		Action:       action,
-		ActionArg:    actionArg,
-		WaitForEvent: waitForEvent,
+// FSM is the former name of Machine, kept for compatibility
+type FSM = Machine
+
+// Option customizes a machine created by NewMachine
+type Option func(*Machine)
+
+// WithActions makes the machine look up its actions in the given registry
+// instead of the package-level Actions registry, so that machines created
+// from the same definition can bind action names to different implementations
+func WithActions(registry *ActionRegistry) Option {
+	return func(fsm *Machine) {
+		fsm.actions = registry
+	}
+}
+
+// WithClock sets the source of time of the machine
+func WithClock(clock Clock) Option {
+	return func(fsm *Machine) {
+		fsm.Clock = clock
 	}
-	fsm.States = append(fsm.States, s)
 }
 
-// GetState returns the state with the matching name
-// and an error if not found
-func (fsm *FSM) GetState(name string) (State, error) {
-	for _, s := range fsm.States {
-		if s.Name == name {
-			return s, nil
+// WithContext sets variables of the machine context on top of the definition
+// context, so that the actions of the initial state can use them
+func WithContext(values map[string]interface{}) Option {
+	return func(fsm *Machine) {
+		if len(values) > 0 && fsm.Context == nil {
+			fsm.Context = make(map[string]interface{}, len(values))
+		}
+		for k, v := range values {
+			fsm.Context[k] = v
 		}
 	}
-	return State{}, fmt.Errorf("Error: State '%s' not found in states list", name)
+}
+
+// NewMachine creates a machine from a definition
+// The machine starts with a copy of the definition context and its declared
+// variables, and needs to be initialized with Init
+func NewMachine(def *Definition, opts ...Option) *Machine {
+	fsm := &Machine{
+		Definition: def,
+		Context:    copyContext(def.InitialContext),
+		machine:    &machine{},
+	}
+	fsm.initVariables()
+	for _, opt := range opts {
+		opt(fsm)
+	}
+	return fsm
+}
+
+// actionRegistry returns the registry the machine looks up its actions in
+func (fsm *Machine) actionRegistry() *ActionRegistry {
+	if fsm.actions == nil {
+		return Actions
+	}
+	return fsm.actions
+}
+
+// Init initializes the state machine
+func (fsm *Machine) Init() {
+	fsm = fsm.root()
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	if err := fsm.runToCompletion(StepInit, Event{internal: true}, func() error {
+		return fsm.SetState(fsm.InitialState, Event{internal: true})
+	}); err != nil {
+		log.Println(err)
+	}
+	if err := fsm.startSchedules(); err != nil {
+		log.Println(err)
+	}
 }
 
 // SetState sets the state machine to the specified state
 // Returns an error if the state is not found
-func (fsm *FSM) SetState(name string, event Event) error {
+func (fsm *Machine) SetState(name string, event Event) error {
 	newState, err := fsm.GetState(name)
 	if err != nil {
 		return err
 	}
+	fsm.cancelTimer()
+	fsm.generation++
+	previous := fsm.CurrentState.Name
 	fsm.CurrentState = newState
 	log.Println("Current state: ", fsm.CurrentState.Name)
+	fsm.trackEntry(previous)
+	fsm.recordState()
+	fsm.coverState()
+	fsm.traceState()
+	if fsm.CurrentState.Compensate || fsm.CurrentState.Name == fsm.ErrorState {
+		fsm.compensate(event)
+	}
+	// Leaving the state drops the action it was waiting for
+	delete(fsm.Context, ContextPendingAction)
+	fsm.openTask(event)
+	if fsm.CurrentState.Response != nil {
+		if err := fsm.respondWithTemplate(fsm.CurrentState.Response, event); err != nil {
+			return err
+		}
+	}
+	fsm.updateCorrelation(event)
+	if previous != "" {
+		fsm.notifyTransition(previous, event)
+		fsm.auditTransition(previous, event)
+	}
+	fsm.scheduleTimeouts(previous)
+	if fsm.CurrentState.Invoke != "" {
+		return fsm.startInvoke(event)
+	}
+	if fsm.CurrentState.After != "" {
+		return fsm.scheduleAfter(event)
+	}
 	if fsm.CurrentState.WaitForEvent {
 		return nil
 	}
 
 	// The state doesn't wait for an event so perform next transition
 	// Find the transition that matches the state
-	event.Param = fsm.CurrentState.ActionArg
-	for _, t := range fsm.Transitions {
-		if t.From == fsm.CurrentState.Name {
-			fsm.beginTransition(t, event)
-			return nil
-		}
+	if !isSelector(fsm.CurrentState.ActionArg) {
+		event.Param = fsm.CurrentState.ActionArg
+	}
+	i, err := fsm.matchTransition("", event)
+	if err != nil {
+		return err
+	}
+	if i >= 0 {
+		return fsm.beginTransition(i, event)
 	}
-	return fmt.Errorf("Error: No transition supports the current state - '%s'", fsm.CurrentState.Name)
+	return fsm.noTransition("")
 }
 
 // SendEvent sends a new event to the state machine
 // Takes event name and a parameter to be passed to the action
-// Returns an error if the state/event combination is not found
-func (fsm *FSM) SendEvent(event Event) error {
+// The event is processed to completion, see runToCompletion
+// Returns the states the machine went through, and an error if the
+// state/event combination is not found
+// Events carrying an ID that was already processed return ErrDuplicateEvent
+// Events sent by the running actions of the machine, on the handle they got
+// as their machine, are queued like the ones of Emit, and get an empty result
+// Other events go through the middleware of the machine, see Use
+// Abandoned actions get ErrActionAbandoned
+func (fsm *Machine) SendEvent(event Event) (TransitionResult, error) {
+	if queued, err := fsm.reenter(event); queued || err != nil {
+		// Sent by an action of the machine, the event is processed after the current step
+		return TransitionResult{Path: []string{}, ActionOutcome: OutcomeNone}, err
+	}
+	return fsm.root().processor()(event)
+}
+
+// processEvent processes an event once it went through the middleware
+func (fsm *Machine) processEvent(event Event) (TransitionResult, error) {
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+
+	result := TransitionResult{FromState: fsm.CurrentState.Name, Path: []string{}}
+	duplicate, err := fsm.isDuplicate(event)
+	if err == nil && duplicate {
+		err = ErrDuplicateEvent
+	}
+	// A reset abandons the pending action
+	if _, pending := fsm.pendingRecord(); pending && err == nil && fsm.reserved(event) != EventReset {
+		err = ErrActionPending
+	}
+	if fsm.paused {
+		err = ErrInstancePaused
+	}
+	if fsm.terminated {
+		err = ErrInstanceTerminated
+	}
+	if err == nil && fsm.reserved(event) == EventNoop {
+		// Health checks only tell the machine is responsive
+		result.ToState = fsm.CurrentState.Name
+		result.ActionOutcome = OutcomeNone
+		result.Ignored = true
+		return result, nil
+	}
+	if err == nil {
+		fsm.result = &result
+		kind := StepEvent
+		if event.scheduled {
+			kind = StepTimer
+		}
+		err = fsm.runToCompletion(kind, event, func() error {
+			return fsm.dispatch(event)
+		})
+		result.Microsteps = fsm.microsteps
+		fsm.result = nil
+	}
+	result.ToState = fsm.CurrentState.Name
+	if result.ActionOutcome == "" {
+		result.ActionOutcome = OutcomeNone
+	}
+	fsm.auditEvent(event, result, err)
+	if err != nil {
+		return result, err
+	}
+	fsm.markProcessed(event)
+	return result, nil
+}
+
+// dispatch finds the transition of an event and performs it
+func (fsm *Machine) dispatch(event Event) error {
+	if fsm.reserved(event) != "" {
+		return fsm.dispatchReserved(event)
+	}
+	// Events received while a sub-machine is running are forwarded to it
+	if fsm.child != nil {
+		return fsm.forwardEvent(event)
+	}
+
 	// Find the transition that matches the state/event
 	// fmt.Println("SendEvent:", event.Action, event.Param)
-	for _, t := range fsm.Transitions {
-		if t.From == fsm.CurrentState.Name && t.Event == event.Action {
-			fsm.beginTransition(t, event)
-			return nil
+	i, err := fsm.matchTransition(event.Action, event)
+	if err != nil {
+		return err
+	}
+	if i >= 0 {
+		// Invalid events are rejected before any action runs
+		if err := fsm.checkRoles(fsm.Transitions[i], event); err != nil {
+			return err
 		}
+		if err := checkPayload(fsm.Transitions[i], event); err != nil {
+			return err
+		}
+		return fsm.beginTransition(i, event)
 	}
-	return fmt.Errorf("Error: No transition supports the current state ('%s') and the sent event ('%s')", fsm.CurrentState.Name, event.Action)
+	return fsm.unknownEvent(event)
 }
 
-// beginTransition begins a new transition
+// beginTransition begins the transition at the given index
 // Returns an error if the state is not found
-func (fsm *FSM) beginTransition(t Transition, event Event) error {
+func (fsm *Machine) beginTransition(index int, event Event) error {
+	t := fsm.Transitions[index]
+	// Eventless transitions and internal events chain transitions, stop runaway loops
+	fsm.microsteps++
+	if max := fsm.maxMicrosteps(); fsm.microsteps > max {
+		return microstepLimitError{max: max, state: fsm.CurrentState.Name}
+	}
+	if err := fsm.checkTransition(t, event); err != nil {
+		return err
+	}
+
 	// fmt.Println("beginTransition: actionArg =", event.Param, t)
-	success := fsm.callAction(event)
+	fsm.outcome = ""
+	success, err := fsm.callAction(event)
+	if token := fsm.takePending(); token != "" && success && err == nil {
+		// The action completes later, the machine waits in the state
+		return fsm.suspend(token, index, event)
+	}
+	return fsm.endTransition(t, event, success, err)
+}
+
+// endTransition takes a transition once the action of the state returned
+func (fsm *Machine) endTransition(t Transition, event Event, success bool, err error) error {
+	if (errors.Is(err, ErrActionTimeout) || errors.Is(err, ErrCircuitOpen)) && fsm.ErrorState == "" {
+		// Without an error state, an action that timed out or was short-circuited failed
+		log.Println(err)
+		err = nil
+	}
+	fsm.recordOutcome(success, err)
+	if err == nil && !success && !t.Branch && fsm.ErrorState != "" {
+		err = fmt.Errorf("Error: Action '%s' failed in state '%s'", fsm.CurrentState.Action, fsm.CurrentState.Name)
+	}
+	if err != nil {
+		return fsm.enterErrorState(event, err)
+	}
+	if success {
+		fsm.completeStep()
+	}
 
 	// Choose the next state depending on the action returned
 	// value and whether the transition supports branching
 	var nextState string
-	if t.Branch && !success {
+	switch {
+	case t.Branch && !success:
 		nextState = t.ToFailure
-	} else {
+	case len(t.Outcomes) > 0:
+		if nextState, err = fsm.chooseState(t, event); err != nil {
+			return fsm.enterErrorState(event, err)
+		}
+	default:
 		nextState = t.ToSuccess
 	}
 
+	if fsm.tracing() {
+		fsm.traceDestination(t, nextState, success)
+	}
+	if t.Action != "" {
+		if err := fsm.callTransitionAction(t, event); err != nil {
+			return fsm.enterErrorState(event, err)
+		}
+	}
+	if t.SendTo != nil {
+		if err := fsm.sendTo(*t.SendTo, event); err != nil {
+			return fsm.enterErrorState(event, err)
+		}
+	}
+	if t.Response != nil {
+		if err := fsm.respondWithTemplate(t.Response, event); err != nil {
+			return err
+		}
+	}
+	fsm.coverTransition(t, nextState)
 	return fsm.SetState(nextState, event)
 }
 
-// callAction uses reflection to call an action using its name
-func (fsm *FSM) callAction(event Event) bool {
-	obj := reflect.ValueOf(fsm)
-	method := obj.MethodByName(fsm.CurrentState.Action)
-	// Convert to a function with the right signature
-	callable := method.Interface().(func(string, http.ResponseWriter) bool)
-	return callable(event.Param, event.Writer)
+// callAction runs the action, script or composite actions of the current state
+// and notifies the action listeners
+func (fsm *Machine) callAction(event Event) (bool, error) {
+	state := fsm.CurrentState
+	arg, err := fsm.actionArg(event)
+	if err != nil {
+		return false, fmt.Errorf("%v in state '%s'", err, state.Name)
+	}
+	event.Param = arg
+	if state.ActionTimeout != "" {
+		stop, err := fsm.startActionTimeout()
+		if err != nil {
+			return false, err
+		}
+		defer stop()
+	}
+	if len(state.Actions) > 0 {
+		return fsm.callActions(state.Actions, state.ActionMode, event)
+	}
+	var success bool
+	name := state.Action
+	switch {
+	case state.Script != nil:
+		name = state.Script.Lang
+		success, err = fsm.runScript(state.Script, event)
+	case state.Action == "":
+		success = true
+	default:
+		success, err = fsm.callNamedAction(state.Action, event)
+	}
+	fsm.notifyAction(name, event, success, err)
+	return success, err
+}
+
+// callTransitionAction runs the action of a transition
+// Its result doesn't change the destination, which is chosen by the state action
+// Returns an error if the action is not registered or if it panics
+func (fsm *Machine) callTransitionAction(t Transition, event Event) error {
+	switch {
+	case isSelector(t.ActionArg):
+		arg, err := fsm.resolveSelector(t.ActionArg, event)
+		if err != nil {
+			return fmt.Errorf("%v in transition from '%s'", err, t.From)
+		}
+		event.Param = arg
+	case t.ActionArg != "":
+		event.Param = t.ActionArg
+	}
+	success, err := fsm.callNamedAction(t.Action, event)
+	fsm.notifyAction(t.Action, event, success, err)
+	if err == nil && !success {
+		log.Printf("Transition action '%s' from state '%s' failed\n", t.Action, t.From)
+	}
+	return err
+}
+
+// callNamedAction looks up an action in the registry and calls it
+// Returns an error if the action is not registered, if it panics or if it
+// exceeds the actionTimeout of the state
+func (fsm *Machine) callNamedAction(name string, event Event) (bool, error) {
+	action := fsm.actionRegistry().Get(name)
+	if action == nil {
+		return false, &ErrHandlerMissing{Action: name}
+	}
+	state := fsm.CurrentState.Name
+	ok, trial := fsm.breakers.allow(name, fsm.clock().Now())
+	if !ok {
+		return false, circuitOpenError{action: name, state: state}
+	}
+	success, err := fsm.callBounded(name, func(h *Machine) (success bool, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				success = false
+				err = actionPanicError{action: name, state: state, value: r}
+			}
+		}()
+		return action(h, event.Param), nil
+	})
+	fsm.breakers.record(name, trial, success && err == nil, fsm.clock().Now())
+	return success, err
 }
 
-// New creates and initializes a new state machine
-func New(startState string, expectedCode string) *FSM {
-	fsm := &FSM{
+// New creates a new state machine with an empty definition
+func New(startState string, expectedCode string) *Machine {
+	return NewMachine(&Definition{
 		InitialState: startState,
 		States:       []State{},
 		Transitions:  []Transition{},
 		ExpectedCode: expectedCode,
-	}
-	return fsm
+	})
 }
 
 /******* Callable Actions ********/
 
 // Log prints out the received message
-func (fsm *FSM) Log(arg string, w http.ResponseWriter) bool {
+func (fsm *Machine) Log(arg string) bool {
 	if fsm.CurrentState.SendResponse {
-		RespondWithJSON(w, http.StatusOK, "")
+		fsm.Respond(http.StatusOK, "")
 	}
-	log.Println(arg)
+	log.Println(fsm.redact(arg))
 	return true
 }
 
 // ValidateCode checks the received code against the expected one
-func (fsm *FSM) ValidateCode(code string, w http.ResponseWriter) bool {
+func (fsm *Machine) ValidateCode(code string) bool {
 	return code == fsm.ExpectedCode
 }
 
 // SendResponse send and http response
-func (fsm *FSM) SendResponse(response string, w http.ResponseWriter) bool {
+// Deprecated: use a 'response' block on the state or the transition
+func (fsm *Machine) SendResponse(response string) bool {
 	if response == "OK" {
-		RespondWithJSON(w, http.StatusOK, "CODE OK")
+		fsm.Respond(http.StatusOK, "CODE OK")
 	} else {
-		RespondWithError(w, http.StatusNotAcceptable, "WRONG CODE")
+		fsm.RespondWithError(http.StatusNotAcceptable, "WRONG CODE")
 	}
 	return true
 }
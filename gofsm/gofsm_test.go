@@ -0,0 +1,83 @@
+package gofsm
+
+import (
+	"fmt"
+	"testing"
+)
+
+// benchDefinition toggles between two states without actions
+const benchDefinition = `{
+	"initialState": "OFF",
+	"states": [
+		{"name": "OFF", "waitForEvent": true},
+		{"name": "ON", "waitForEvent": true}
+	],
+	"transitions": [
+		{"from": "OFF", "toSuccess": "ON", "event": "toggle"},
+		{"from": "ON", "toSuccess": "OFF", "event": "toggle"}
+	]
+}`
+
+// loadBenchDefinition loads the definition of the benchmarks
+func loadBenchDefinition(b *testing.B) *Definition {
+	def, err := LoadDefinition([]byte(benchDefinition))
+	if err != nil {
+		b.Fatal(err)
+	}
+	return def
+}
+
+func BenchmarkSendEvent(b *testing.B) {
+	fsm := NewMachine(loadBenchDefinition(b))
+	fsm.Init()
+	event := Event{Action: "toggle"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := fsm.SendEvent(event); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSendEventParallel(b *testing.B) {
+	fsm := NewMachine(loadBenchDefinition(b))
+	fsm.Init()
+	event := Event{Action: "toggle"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := fsm.SendEvent(event); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkManagerSendEvent(b *testing.B) {
+	def := loadBenchDefinition(b)
+	m := NewManager(func() (*Machine, error) {
+		return NewMachine(def), nil
+	})
+	const sessions = 64
+	for i := 0; i < sessions; i++ {
+		if _, err := m.Start(fmt.Sprintf("s%d", i), def); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			event := Event{Session: fmt.Sprintf("s%d", i%sessions), Action: "toggle"}
+			if _, err := m.SendEvent(event); err != nil {
+				b.Error(err)
+				return
+			}
+			i++
+		}
+	})
+}
@@ -0,0 +1,321 @@
+package gofsm
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"math"
+	"sort"
+)
+
+// Dimensions of the graph drawings, in pixels
+const (
+	graphMargin    = 24
+	graphNodeH     = 32
+	graphNodeGap   = 48
+	graphLayerGap  = 80
+	graphCharWidth = 7
+)
+
+// graphNode is a state placed in the drawing, x and y being its center
+type graphNode struct {
+	state State
+	layer int
+	order float64
+	x, y  float64
+	w     float64
+	// loops is the room taken by the transitions to the state itself, on its right
+	loops float64
+}
+
+// graphEdge is a transition between two states of the drawing
+type graphEdge struct {
+	from, to int
+	label    string
+	failure  bool
+}
+
+// graphBounds is the box containing everything drawn
+type graphBounds struct {
+	minX, minY, maxX, maxY float64
+}
+
+func (b *graphBounds) add(x, y float64) {
+	b.minX = math.Min(b.minX, x)
+	b.minY = math.Min(b.minY, y)
+	b.maxX = math.Max(b.maxX, x)
+	b.maxY = math.Max(b.maxY, y)
+}
+
+// RenderSVG draws the states and transitions of the definition as an SVG
+// image, with the given state highlighted if it isn't empty
+// The states are laid out in layers by their distance from the initial state,
+// and ordered in their layer to limit the crossings
+func (def *Definition) RenderSVG(current string) []byte {
+	nodes, edges := def.graph()
+	layoutGraph(nodes, edges, def.stateIndex[def.InitialState])
+
+	var body bytes.Buffer
+	bounds := graphBounds{minX: math.Inf(1), minY: math.Inf(1), maxX: math.Inf(-1), maxY: math.Inf(-1)}
+	// Parallel transitions between the same states are drawn apart
+	parallel := map[[2]int]int{}
+	for _, e := range edges {
+		key := [2]int{e.from, e.to}
+		drawEdge(&body, &bounds, nodes, e, parallel[key])
+		parallel[key]++
+	}
+	for _, n := range nodes {
+		class := "state"
+		if n.state.Final {
+			class += " final"
+		}
+		if n.state.Name == current {
+			class += " current"
+		}
+		left, top := n.x-n.w/2, n.y-graphNodeH/2
+		fmt.Fprintf(&body, `<g class="%s"><rect x="%.1f" y="%.1f" width="%.1f" height="%d" rx="6"/><text x="%.1f" y="%.1f" text-anchor="middle">%s</text></g>`+"\n",
+			class, left, top, n.w, graphNodeH, n.x, n.y+4, html.EscapeString(n.state.Name))
+		bounds.add(left, top)
+		bounds.add(left+n.w, top+graphNodeH)
+		if n.state.Name == def.InitialState {
+			// The initial state is pointed at by a dot
+			fmt.Fprintf(&body, `<circle class="initial" cx="%.1f" cy="%.1f" r="5"/><path class="edge" d="M%.1f,%.1f L%.1f,%.1f"/>`+"\n",
+				left-30, n.y, left-25, n.y, left, n.y)
+			bounds.add(left-36, n.y)
+		}
+	}
+	if len(nodes) == 0 {
+		bounds = graphBounds{}
+	}
+
+	var out bytes.Buffer
+	x, y := bounds.minX-graphMargin, bounds.minY-graphMargin
+	w, h := bounds.maxX-bounds.minX+2*graphMargin, bounds.maxY-bounds.minY+2*graphMargin
+	fmt.Fprintf(&out, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="%.1f %.1f %.1f %.1f" width="%.0f" height="%.0f" font-family="sans-serif" font-size="12">`+"\n", x, y, w, h, w, h)
+	out.WriteString(`<style>
+.state rect { fill: #f4f4f4; stroke: #555; }
+.state.current rect { fill: #ffe08a; stroke: #c08000; stroke-width: 2; }
+.state.final rect { stroke-width: 3; }
+.initial { fill: #555; }
+.edge { stroke: #888; fill: none; marker-end: url(#arrow); }
+.edge.failure { stroke-dasharray: 4 3; }
+.label { font-size: 11px; fill: #333; }
+</style>
+<defs><marker id="arrow" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="6" markerHeight="6" orient="auto"><path d="M0,0 L10,5 L0,10 z" fill="#888"/></marker></defs>
+`)
+	out.Write(body.Bytes())
+	out.WriteString("</svg>\n")
+	return out.Bytes()
+}
+
+// SVG draws the definition of the machine with its current state highlighted
+func (fsm *Machine) SVG() []byte {
+	fsm = fsm.root()
+	fsm.mu.Lock()
+	current := fsm.CurrentState.Name
+	fsm.mu.Unlock()
+	return fsm.RenderSVG(current)
+}
+
+// graph returns the states of the definition and the transitions between them
+// Transitions to unknown states are left out
+func (def *Definition) graph() ([]*graphNode, []graphEdge) {
+	nodes := make([]*graphNode, len(def.States))
+	index := make(map[string]int, len(def.States))
+	for i, s := range def.States {
+		nodes[i] = &graphNode{state: s, w: math.Max(80, float64(len(s.Name)*graphCharWidth+24))}
+		index[s.Name] = i
+	}
+	var edges []graphEdge
+	loops := map[int]int{}
+	add := func(from, to, label string, failure bool) {
+		f, ok := index[from]
+		t, ok2 := index[to]
+		if !ok || !ok2 {
+			return
+		}
+		if f == t {
+			loops[f]++
+			reach := loopReach(loops[f] - 1)
+			nodes[f].loops = math.Max(nodes[f].loops, math.Max(reach, reach*0.75+4+labelWidth(label)))
+		}
+		edges = append(edges, graphEdge{from: f, to: t, label: label, failure: failure})
+	}
+	for _, t := range def.Transitions {
+		if t.ToSuccess != "" {
+			add(t.From, t.ToSuccess, t.Event, false)
+		}
+		if t.Branch && t.ToFailure != "" {
+			add(t.From, t.ToFailure, t.Event+" ✗", true)
+		}
+		outcomes := make([]string, 0, len(t.Outcomes))
+		for outcome := range t.Outcomes {
+			outcomes = append(outcomes, outcome)
+		}
+		sort.Strings(outcomes)
+		for _, outcome := range outcomes {
+			add(t.From, t.Outcomes[outcome], t.Event+" ["+outcome+"]", false)
+		}
+	}
+	return nodes, edges
+}
+
+// layoutGraph places the nodes in layers by their distance from the initial
+// node, then orders the layers by the mean position of their neighbors in the
+// previous layer and centers them
+func layoutGraph(nodes []*graphNode, edges []graphEdge, initial int) {
+	next := make([][]int, len(nodes))
+	prev := make([][]int, len(nodes))
+	for _, e := range edges {
+		next[e.from] = append(next[e.from], e.to)
+		prev[e.to] = append(prev[e.to], e.from)
+	}
+	// The unreachable states are laid out from the first layer too
+	visited := make([]bool, len(nodes))
+	starts := make([]int, 0, len(nodes)+1)
+	if initial < len(nodes) {
+		starts = append(starts, initial)
+	}
+	for i := range nodes {
+		starts = append(starts, i)
+	}
+	for _, start := range starts {
+		if visited[start] {
+			continue
+		}
+		visited[start] = true
+		queue := []int{start}
+		for len(queue) > 0 {
+			n := queue[0]
+			queue = queue[1:]
+			for _, m := range next[n] {
+				if !visited[m] {
+					visited[m] = true
+					nodes[m].layer = nodes[n].layer + 1
+					queue = append(queue, m)
+				}
+			}
+		}
+	}
+
+	var layers [][]int
+	for i, n := range nodes {
+		for len(layers) <= n.layer {
+			layers = append(layers, nil)
+		}
+		n.order = float64(len(layers[n.layer]))
+		layers[n.layer] = append(layers[n.layer], i)
+	}
+	for l := 1; l < len(layers); l++ {
+		for _, i := range layers[l] {
+			sum, count := 0.0, 0
+			for _, p := range prev[i] {
+				if nodes[p].layer == l-1 {
+					sum += nodes[p].order
+					count++
+				}
+			}
+			if count > 0 {
+				nodes[i].order = sum / float64(count)
+			}
+		}
+		layer := layers[l]
+		sort.SliceStable(layer, func(a, b int) bool { return nodes[layer[a]].order < nodes[layer[b]].order })
+		for pos, i := range layer {
+			nodes[i].order = float64(pos)
+		}
+	}
+
+	widths := make([]float64, len(layers))
+	widest := 0.0
+	for l, layer := range layers {
+		for _, i := range layer {
+			widths[l] += nodes[i].w + nodes[i].loops + graphNodeGap
+		}
+		widest = math.Max(widest, widths[l])
+	}
+	for l, layer := range layers {
+		x := (widest - widths[l]) / 2
+		for _, i := range layer {
+			nodes[i].x = x + nodes[i].w/2
+			nodes[i].y = float64(l * (graphNodeH + graphLayerGap))
+			x += nodes[i].w + nodes[i].loops + graphNodeGap
+		}
+	}
+}
+
+// drawEdge draws a transition, the nth between its states
+// Transitions to the next layer are straight lines, the others are curved so
+// they go around the states in between and apart from the opposite transition
+func drawEdge(buf *bytes.Buffer, bounds *graphBounds, nodes []*graphNode, e graphEdge, nth int) {
+	class := "edge"
+	if e.failure {
+		class += " failure"
+	}
+	a, b := nodes[e.from], nodes[e.to]
+	var path string
+	var lx, ly float64
+	anchor := "middle"
+	if e.from == e.to {
+		right := a.x + a.w/2
+		reach := loopReach(nth)
+		path = fmt.Sprintf("M%.1f,%.1f C%.1f,%.1f %.1f,%.1f %.1f,%.1f", right, a.y-6, right+reach, a.y-30, right+reach, a.y+30, right, a.y+6)
+		lx, ly, anchor = right+reach*0.75+4, a.y+4, "start"
+		bounds.add(right+reach, a.y)
+	} else {
+		dx, dy := b.x-a.x, b.y-a.y
+		dist := math.Hypot(dx, dy)
+		bend := 0.0
+		if b.layer != a.layer+1 {
+			bend = math.Max(40, dist/4)
+		}
+		bend += float64(nth) * 24
+		// The control point is off the middle of the line, on its left
+		cx, cy := (a.x+b.x)/2+dy/dist*bend, (a.y+b.y)/2-dx/dist*bend
+		sx, sy := clipToNode(a, cx, cy)
+		ex, ey := clipToNode(b, cx, cy)
+		path = fmt.Sprintf("M%.1f,%.1f Q%.1f,%.1f %.1f,%.1f", sx, sy, cx, cy, ex, ey)
+		lx, ly = 0.25*sx+0.5*cx+0.25*ex, 0.25*sy+0.5*cy+0.25*ey-4
+		bounds.add(cx, cy)
+	}
+	fmt.Fprintf(buf, `<path class="%s" d="%s"/>`+"\n", class, path)
+	if e.label == "" {
+		return
+	}
+	width := labelWidth(e.label)
+	switch anchor {
+	case "start":
+		bounds.add(lx+width, ly)
+	default:
+		bounds.add(lx-width/2, ly-12)
+		bounds.add(lx+width/2, ly)
+	}
+	fmt.Fprintf(buf, `<text class="label" x="%.1f" y="%.1f" text-anchor="%s">%s</text>`+"\n", lx, ly, anchor, html.EscapeString(e.label))
+}
+
+// clipToNode returns where the line from the center of a node to a point
+// leaves the node
+func clipToNode(n *graphNode, x, y float64) (float64, float64) {
+	dx, dy := x-n.x, y-n.y
+	if dx == 0 && dy == 0 {
+		return n.x, n.y
+	}
+	scale := math.Inf(1)
+	if dx != 0 {
+		scale = math.Min(scale, n.w/2/math.Abs(dx))
+	}
+	if dy != 0 {
+		scale = math.Min(scale, graphNodeH/2/math.Abs(dy))
+	}
+	return n.x + dx*scale, n.y + dy*scale
+}
+
+// loopReach is how far the nth transition from a state to itself goes right
+func loopReach(nth int) float64 {
+	return 40 + float64(nth)*16
+}
+
+// labelWidth estimates the width of a transition label
+func labelWidth(label string) float64 {
+	return float64(len([]rune(label)) * graphCharWidth)
+}
@@ -0,0 +1,132 @@
+package gofsm
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ditek/jsonfsm/gofsm/expr"
+)
+
+// guards caches the parsed guard expressions by source
+var guards sync.Map
+
+// parseGuard returns the parsed guard expression
+func parseGuard(source string) (*expr.Expr, error) {
+	if e, ok := guards.Load(source); ok {
+		return e.(*expr.Expr), nil
+	}
+	e, err := expr.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	guards.Store(source, e)
+	return e, nil
+}
+
+// FlushGuards empties the cache of the parsed guard and choice expressions,
+// which grows with the definitions loaded since the start
+func FlushGuards() {
+	guards.Range(func(key, _ interface{}) bool {
+		guards.Delete(key)
+		return true
+	})
+}
+
+// exprVars returns the variables available to expressions
+// The context variables are available both directly and under 'ctx'
+func (fsm *Machine) exprVars(event Event) map[string]interface{} {
+	vars := make(map[string]interface{}, len(fsm.Context)+4)
+	for k, v := range fsm.Context {
+		vars[k] = v
+	}
+	vars["ctx"] = fsm.Context
+	vars["event"] = map[string]interface{}{
+		"action": event.Action,
+		"param":  event.Param,
+		"data":   event.Data,
+	}
+	vars["state"] = fsm.CurrentState.Name
+	vars["expectedCode"] = fsm.ExpectedCode
+	return vars
+}
+
+// matchTransition returns the index of the first transition from the current
+// state for the given event name whose guard passes, or -1 if there is none
+// With a trace, every transition is logged with the reason it was skipped
+func (fsm *Machine) matchTransition(eventName string, event Event) (int, error) {
+	trace := fsm.tracing()
+	if trace {
+		fsm.traceMatching(eventName)
+	}
+	for i, t := range fsm.Transitions {
+		if t.From != fsm.CurrentState.Name {
+			if trace {
+				fsm.traceTransition(i, t, "skipped, from another state")
+			}
+			continue
+		}
+		if t.Event != eventName {
+			if trace {
+				fsm.traceTransition(i, t, "skipped, for another event")
+			}
+			continue
+		}
+		if t.Guard != "" {
+			ok, err := fsm.checkGuard(t.Guard, event)
+			if err != nil {
+				if trace {
+					fsm.traceTransition(i, t, "failed, "+err.Error())
+				}
+				return -1, err
+			}
+			if !ok {
+				if trace {
+					fsm.traceTransition(i, t, "skipped, guard '"+t.Guard+"' is false")
+				}
+				continue
+			}
+		}
+		if trace {
+			fsm.traceTransition(i, t, "matched")
+		}
+		return i, nil
+	}
+	if trace {
+		fsm.tracef("  no transition matched")
+	}
+	return -1, nil
+}
+
+// AcceptedEvents returns the events the current state has a transition for,
+// regardless of their guards, or the events of the running sub-machine
+func (fsm *Machine) AcceptedEvents() []string {
+	fsm = fsm.root()
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	if fsm.child != nil {
+		return fsm.child.AcceptedEvents()
+	}
+	events := []string{}
+	seen := map[string]bool{}
+	for _, t := range fsm.Transitions {
+		if t.From != fsm.CurrentState.Name || t.Event == "" || seen[t.Event] {
+			continue
+		}
+		seen[t.Event] = true
+		events = append(events, t.Event)
+	}
+	return events
+}
+
+// checkGuard evaluates a guard expression against the event and the context
+func (fsm *Machine) checkGuard(guard string, event Event) (bool, error) {
+	e, err := parseGuard(guard)
+	if err != nil {
+		return false, fmt.Errorf("Error: Invalid guard '%s': %v", guard, err)
+	}
+	ok, err := e.EvalBool(fsm.exprVars(event))
+	if err != nil {
+		return false, fmt.Errorf("Error: Cannot evaluate guard '%s': %v", guard, err)
+	}
+	return ok, nil
+}
@@ -0,0 +1,101 @@
+package gofsm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+)
+
+// ErrActionAbandoned is matched by errors.Is for the events sent by the
+// actions that were abandoned once their actionTimeout expired
+var ErrActionAbandoned = errors.New("Error: The action was abandoned after its timeout")
+
+// actionCall is a running action, whose handle on the machine is the
+// Machine the action gets
+// The handle shares the state of the machine, but has its own copy of the
+// current state and of the last event, and of the context for the actions
+// that may time out, so an abandoned action can't change the machine
+type actionCall struct {
+	owner *Machine
+	// ctx is the action context of the call, see ActionContext
+	ctx context.Context
+	// done is set once the action returned, abandoned once it timed out,
+	// both guarded by actionMu
+	done      bool
+	abandoned bool
+}
+
+// handle creates the handle of an action on the machine, with a copy of
+// the context if the action may be abandoned
+func (fsm *Machine) handle(ctx context.Context, bounded bool) *Machine {
+	// Parallel actions get their handles at the same time
+	fsm.actionMu.Lock()
+	defer fsm.actionMu.Unlock()
+	h := &Machine{
+		Definition:   fsm.Definition,
+		CurrentState: fsm.CurrentState,
+		ID:           fsm.ID,
+		Tenant:       fsm.Tenant,
+		Clock:        fsm.Clock,
+		Context:      fsm.Context,
+		lastEvent:    fsm.lastEvent,
+		call:         &actionCall{owner: fsm, ctx: ctx},
+		machine:      fsm.machine,
+	}
+	if bounded {
+		h.Context = copyContext(fsm.Context)
+	}
+	return h
+}
+
+// release ends the call of an action, whose changes of the context are
+// kept unless it was abandoned
+func (fsm *Machine) release(h *Machine, abandoned bool) {
+	fsm.actionMu.Lock()
+	defer fsm.actionMu.Unlock()
+	h.call.done = true
+	h.call.abandoned = abandoned
+	if !abandoned {
+		fsm.Context = h.Context
+	}
+}
+
+// merge ends the handles of parallel actions, each with its own copy of the
+// context, and applies the changes they made to it in their order, so the
+// last action setting a variable wins
+func (fsm *Machine) merge(branches []*Machine) {
+	fsm.actionMu.Lock()
+	defer fsm.actionMu.Unlock()
+	base := copyContext(fsm.Context)
+	for _, b := range branches {
+		b.call.done = true
+		for k, v := range b.Context {
+			if old, ok := base[k]; !ok || !reflect.DeepEqual(old, v) {
+				fsm.Context[k] = v
+			}
+		}
+		for k := range base {
+			if _, ok := b.Context[k]; !ok {
+				delete(fsm.Context, k)
+			}
+		}
+	}
+}
+
+// abandoned tells if the machine is the handle of an abandoned action,
+// whose responses and events are dropped
+// It is called with actionMu held
+func (fsm *Machine) abandoned() bool {
+	return fsm.call != nil && fsm.call.abandoned
+}
+
+// root returns the machine behind the handle of an action, or the machine
+// itself
+// The methods locking the machine go through it, so the actions can keep
+// their handle, e.g. to complete later
+func (fsm *Machine) root() *Machine {
+	for fsm.call != nil {
+		fsm = fsm.call.owner
+	}
+	return fsm
+}
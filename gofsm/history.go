@@ -0,0 +1,74 @@
+package gofsm
+
+import "fmt"
+
+// HistoryEntry is a step of the history of a machine
+type HistoryEntry struct {
+	// Event is the event of the step, empty for eventless steps such as delayed transitions
+	Event Event `json:"event"`
+	// Before is the machine as it was before the step
+	Before Snapshot `json:"before"`
+	// State is the state the step ended in
+	State string `json:"state"`
+}
+
+// WithHistory keeps the last n steps of the machine so it can be rewound with StepBack
+// Every event then takes a snapshot of the machine, so it is meant for debugging
+func WithHistory(n int) Option {
+	return func(fsm *Machine) {
+		fsm.historySize = n
+	}
+}
+
+// beginStep captures the machine before a step if the history is enabled
+func (fsm *Machine) beginStep() *Snapshot {
+	// The initialization is not a step, there is nothing to go back to
+	if fsm.historySize <= 0 || fsm.CurrentState.Name == "" {
+		return nil
+	}
+	snap := fsm.snapshot()
+	return &snap
+}
+
+// endStep keeps a step in the history unless it took no transition, e.g. a
+// stale timer, or failed without changing the state
+func (fsm *Machine) endStep(event Event, before *Snapshot, err error) {
+	if before == nil || fsm.microsteps == 0 || (err != nil && before.CurrentState == fsm.CurrentState.Name) {
+		return
+	}
+	fsm.history = append(fsm.history, HistoryEntry{Event: event, Before: *before, State: fsm.CurrentState.Name})
+	if len(fsm.history) > fsm.historySize {
+		fsm.history = fsm.history[len(fsm.history)-fsm.historySize:]
+	}
+}
+
+// History returns the steps kept for StepBack, oldest first
+func (fsm *Machine) History() []HistoryEntry {
+	fsm = fsm.root()
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	return append([]HistoryEntry(nil), fsm.history...)
+}
+
+// StepBack rewinds the machine by n steps, which are removed from the history
+// The machine is restored from the snapshot taken before the step, so no
+// action runs again and the side effects of the rewound steps are kept
+// Returns an error if the history is disabled or shorter than n steps
+func (fsm *Machine) StepBack(n int) error {
+	fsm = fsm.root()
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	if fsm.historySize <= 0 {
+		return fmt.Errorf("Error: The history of the machine is disabled")
+	}
+	if n < 1 || n > len(fsm.history) {
+		return fmt.Errorf("Error: Cannot step back %d steps, the history has %d", n, len(fsm.history))
+	}
+	entry := fsm.history[len(fsm.history)-n]
+	if err := fsm.restore(entry.Before); err != nil {
+		return err
+	}
+	fsm.recordRestore(entry.Before)
+	fsm.history = fsm.history[:len(fsm.history)-n]
+	return nil
+}
@@ -0,0 +1,104 @@
+package gofsm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrTransitionVetoed is matched by errors.Is for the transitions vetoed by
+// a BeforeTransition hook, the error of the hook is wrapped as well
+var ErrTransitionVetoed = errors.New("Error: Transition vetoed")
+
+// vetoError names the transition a hook vetoed and why
+type vetoError struct {
+	from  string
+	event string
+	err   error
+}
+
+func (e vetoError) Error() string {
+	return fmt.Sprintf("Error: Transition from state '%s' on event '%s' vetoed: %v", e.from, e.event, e.err)
+}
+
+func (e vetoError) Is(target error) bool {
+	return target == ErrTransitionVetoed
+}
+
+func (e vetoError) Unwrap() error {
+	return e.err
+}
+
+// TransitionRequest describes a transition about to be taken
+type TransitionRequest struct {
+	Machine    string
+	Tenant     string
+	From       string
+	Transition Transition
+	Event      Event
+}
+
+// BeforeTransitionHook is called before each transition of a machine, before
+// the action of the state runs. An error vetoes the transition: the machine
+// stays in its state and the error is returned to the sender of the event
+// Hooks are called while the machine is locked, like TransitionListener
+type BeforeTransitionHook func(TransitionRequest) error
+
+// AfterTransitionHook is called with each transition of a machine once the
+// step that took it is committed, after the projections
+// The same restrictions as for TransitionListener apply
+type AfterTransitionHook func(TransitionRecord)
+
+// BeforeTransition registers a hook that can veto the transitions of the machine
+func (fsm *Machine) BeforeTransition(hook BeforeTransitionHook) {
+	fsm = fsm.root()
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	fsm.beforeHooks = append(fsm.beforeHooks, hook)
+}
+
+// AfterTransition registers a hook for the committed transitions of the machine
+func (fsm *Machine) AfterTransition(hook AfterTransitionHook) {
+	fsm = fsm.root()
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	fsm.afterHooks = append(fsm.afterHooks, hook)
+}
+
+// BeforeTransition registers a hook that can veto the transitions of the
+// machines of all sessions
+// Only sessions created afterwards are affected
+func (m *Manager) BeforeTransition(hook BeforeTransitionHook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.beforeHooks = append(m.beforeHooks, hook)
+}
+
+// AfterTransition registers a hook for the committed transitions of the
+// machines of all sessions
+// Only sessions created afterwards are affected
+func (m *Manager) AfterTransition(hook AfterTransitionHook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.afterHooks = append(m.afterHooks, hook)
+}
+
+// checkTransition asks the BeforeTransition hooks if a transition may be taken
+// The first hook to veto it stops the others
+func (fsm *Machine) checkTransition(t Transition, event Event) error {
+	if len(fsm.beforeHooks) == 0 {
+		return nil
+	}
+	req := TransitionRequest{
+		Machine:    fsm.ID,
+		Tenant:     fsm.Tenant,
+		From:       fsm.CurrentState.Name,
+		Transition: t,
+		Event:      event,
+	}
+	for _, hook := range fsm.beforeHooks {
+		if err := hook(req); err != nil {
+			return vetoError{from: req.From, event: event.Action, err: err}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package gofsm
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Keys of the state args used by the HTTPRequest action
+const (
+	argMethod         = "method"
+	argURL            = "url"
+	argBody           = "body"
+	argContentType    = "contentType"
+	argExpectedStatus = "expectedStatus"
+	argTimeout        = "timeout"
+	argAsync          = "async"
+)
+
+// defaultHTTPTimeout bounds requests that don't specify a timeout
+const defaultHTTPTimeout = 30 * time.Second
+
+// templateData is the data available to the URL and body templates
+type templateData struct {
+	Param   string
+	State   string
+	Context map[string]interface{}
+	// Token is the token of the result of an async request, see Machine.Pending
+	Token string
+}
+
+// HTTPRequest performs an outbound HTTP request described by the state args:
+//
+//	method          HTTP method, defaults to GET
+//	url             URL template, defaults to the action argument
+//	body            Body template, optional
+//	contentType     Content type of the body, defaults to application/json
+//	expectedStatus  Status code that counts as success, defaults to any 2xx
+//	timeout         Request timeout, e.g. "5s"
+//	async           "true" if the request only starts a job that reports its
+//	                result later with the token, see Machine.Pending
+//
+// Templates use text/template syntax with .Param, .State, .Context and .Token
+func (fsm *Machine) HTTPRequest(arg string) bool {
+	args := fsm.CurrentState.Args
+	data := templateData{
+		Param:   arg,
+		State:   fsm.CurrentState.Name,
+		Context: fsm.Context,
+	}
+	if args[argAsync] == "true" {
+		data.Token = fsm.Pending()
+	}
+
+	urlTemplate := args[argURL]
+	if urlTemplate == "" {
+		urlTemplate = arg
+	}
+	url, err := renderTemplate(urlTemplate, data)
+	if err != nil {
+		fsm.logf("Error: Invalid URL template: %v", err)
+		return false
+	}
+
+	var body io.Reader
+	if args[argBody] != "" {
+		rendered, err := renderTemplate(args[argBody], data)
+		if err != nil {
+			fsm.logf("Error: Invalid body template: %v", err)
+			return false
+		}
+		body = strings.NewReader(rendered)
+	}
+
+	method := strings.ToUpper(args[argMethod])
+	if method == "" {
+		method = http.MethodGet
+	}
+	// The request is cancelled when the action timeout of the state expires
+	req, err := http.NewRequestWithContext(fsm.ActionContext(), method, url, body)
+	if err != nil {
+		fsm.logf("Error: Invalid HTTP request: %v", err)
+		return false
+	}
+	if body != nil {
+		contentType := args[argContentType]
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	timeout := defaultHTTPTimeout
+	if args[argTimeout] != "" {
+		if timeout, err = time.ParseDuration(args[argTimeout]); err != nil {
+			log.Println("Error: Invalid HTTP timeout:", err)
+			return false
+		}
+	}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		fsm.logf("Error: HTTP request failed: %v", err)
+		return false
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if args[argExpectedStatus] != "" {
+		expected, err := strconv.Atoi(args[argExpectedStatus])
+		if err != nil {
+			log.Println("Error: Invalid expected status:", err)
+			return false
+		}
+		return resp.StatusCode == expected
+	}
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+// renderTemplate executes a text template against the given data
+func renderTemplate(text string, data interface{}) (string, error) {
+	tmpl, err := template.New("").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
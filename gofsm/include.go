@@ -0,0 +1,120 @@
+package gofsm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// includeKey is the field of the objects replaced by a fragment file
+const includeKey = "$include"
+
+// readIncluded reads a JSON file with its fragments included
+func readIncluded(fileName string) ([]byte, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return includeFragments(data, fileName, map[string]bool{filepath.Clean(fileName): true})
+}
+
+// includeFragments replaces the objects {"$include": "file.json"} of a JSON
+// document by the content of the file, relative to the including file 'from'
+// A fragment that is an array included in an array is spliced into it, and the
+// other fields of an object including an object override its fields
+// Fragments can include other fragments, 'stack' holds the files being
+// included to detect cycles
+func includeFragments(data []byte, from string, stack map[string]bool) ([]byte, error) {
+	if !strings.Contains(string(data), includeKey) {
+		return data, nil
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		// Left to the validation to report
+		return data, nil
+	}
+	doc, err := include(doc, from, stack)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+// include resolves the fragments of a JSON value
+func include(value interface{}, from string, stack map[string]bool) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if name, ok := v[includeKey].(string); ok {
+			fragment, err := loadFragment(name, from, stack)
+			if err != nil {
+				return nil, err
+			}
+			if len(v) == 1 {
+				return fragment, nil
+			}
+			obj, ok := fragment.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("Error: Fragment '%s' is not an object and cannot have fields overridden", name)
+			}
+			for k, field := range v {
+				if k == includeKey {
+					continue
+				}
+				if obj[k], err = include(field, from, stack); err != nil {
+					return nil, err
+				}
+			}
+			return obj, nil
+		}
+		for k, field := range v {
+			resolved, err := include(field, from, stack)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = resolved
+		}
+		return v, nil
+	case []interface{}:
+		items := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			obj, isObj := item.(map[string]interface{})
+			_, included := obj[includeKey]
+			resolved, err := include(item, from, stack)
+			if err != nil {
+				return nil, err
+			}
+			if fragment, ok := resolved.([]interface{}); ok && isObj && included {
+				items = append(items, fragment...)
+				continue
+			}
+			items = append(items, resolved)
+		}
+		return items, nil
+	}
+	return value, nil
+}
+
+// loadFragment reads a fragment relative to the file including it, with its
+// own fragments included
+func loadFragment(name, from string, stack map[string]bool) (interface{}, error) {
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(from), name)
+	}
+	if stack[path] {
+		return nil, fmt.Errorf("Error: Fragment '%s' includes itself", path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error: Cannot include fragment '%s': %v", name, err)
+	}
+	var fragment interface{}
+	if err := json.Unmarshal(data, &fragment); err != nil {
+		return nil, fmt.Errorf("Error: Invalid fragment '%s': %v", name, err)
+	}
+	stack[path] = true
+	defer delete(stack, path)
+	return include(fragment, path, stack)
+}
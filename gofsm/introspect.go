@@ -0,0 +1,99 @@
+package gofsm
+
+import "time"
+
+// StateMetrics are the statistics of a state of a machine
+type StateMetrics struct {
+	// Entries counts the times the state was entered
+	Entries uint64 `json:"entries"`
+	// TimeSpent is the cumulative time spent in the state, including the current visit
+	TimeSpent time.Duration `json:"timeSpent"`
+}
+
+// Introspection describes the runtime state of a machine
+type Introspection struct {
+	ID           string    `json:"id"`
+	Tenant       string    `json:"tenant,omitempty"`
+	Metadata     *Metadata `json:"metadata,omitempty"`
+	CurrentState string    `json:"currentState"`
+	EnteredAt    time.Time `json:"enteredAt"`
+	// Terminated is set once the machine is aborted, for AbortReason
+	Terminated  bool                   `json:"terminated,omitempty"`
+	AbortReason string                 `json:"abortReason,omitempty"`
+	Paused      bool                   `json:"paused,omitempty"`
+	Context     map[string]interface{} `json:"context,omitempty"`
+	// States holds the metrics of the states entered so far
+	States map[string]StateMetrics `json:"states"`
+	// Child describes the running sub-machine, if any
+	Child *Introspection `json:"child,omitempty"`
+}
+
+// Introspect returns the current state of the machine and the metrics of its states
+// Useful to spot the states where workflows spend most of their time
+func (fsm *Machine) Introspect() Introspection {
+	fsm = fsm.root()
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	now := fsm.clock().Now()
+	in := Introspection{
+		ID:           fsm.ID,
+		Tenant:       fsm.Tenant,
+		Metadata:     fsm.Metadata,
+		CurrentState: fsm.CurrentState.Name,
+		EnteredAt:    fsm.enteredAt,
+		Terminated:   fsm.terminated,
+		AbortReason:  fsm.abortReason,
+		Paused:       fsm.paused,
+		Context:      copyContext(fsm.Context),
+		States:       make(map[string]StateMetrics, len(fsm.stateMetrics)),
+	}
+	for name, metrics := range fsm.stateMetrics {
+		in.States[name] = metrics
+	}
+	// Restored and cloned machines may not have entered their current state
+	if name := fsm.CurrentState.Name; name != "" {
+		current := in.States[name]
+		current.TimeSpent += now.Sub(fsm.enteredAt)
+		in.States[name] = current
+	}
+	if fsm.child != nil {
+		child := fsm.child.Introspect()
+		in.Child = &child
+	}
+	return in
+}
+
+// Completed tells if the machine reached a final state or was aborted, and when
+func (fsm *Machine) Completed() (time.Time, bool) {
+	fsm = fsm.root()
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	if fsm.terminated {
+		return fsm.abortedAt, true
+	}
+	return fsm.enteredAt, fsm.CurrentState.Final
+}
+
+// trackEntry adds the time spent in the previous state to its metrics
+// and counts the entry in the current state
+func (fsm *Machine) trackEntry(previous string) {
+	fsm.trackExit(previous)
+	metrics := fsm.stateMetrics[fsm.CurrentState.Name]
+	metrics.Entries++
+	fsm.stateMetrics[fsm.CurrentState.Name] = metrics
+}
+
+// trackExit adds the time spent in a state since it was entered to its metrics
+// and restarts the count for the current state
+func (fsm *Machine) trackExit(previous string) {
+	now := fsm.clock().Now()
+	if fsm.stateMetrics == nil {
+		fsm.stateMetrics = map[string]StateMetrics{}
+	}
+	if previous != "" {
+		metrics := fsm.stateMetrics[previous]
+		metrics.TimeSpent += now.Sub(fsm.enteredAt)
+		fsm.stateMetrics[previous] = metrics
+	}
+	fsm.enteredAt = now
+}
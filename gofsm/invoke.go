@@ -0,0 +1,89 @@
+package gofsm
+
+import (
+	"fmt"
+	"log"
+)
+
+// startInvoke loads and starts the sub-machine of the current invoke state
+func (fsm *Machine) startInvoke(event Event) error {
+	def, err := fsm.childDefinition(fsm.CurrentState.Invoke)
+	if err != nil {
+		return fmt.Errorf("Error: Cannot invoke '%s' from state '%s': %v",
+			fsm.CurrentState.Invoke, fsm.CurrentState.Name, err)
+	}
+	log.Println("Invoking sub-machine: ", fsm.CurrentState.Invoke)
+	child := NewMachine(def, WithActions(fsm.actions), WithClock(fsm.Clock), WithWorkerPool(fsm.pool), WithCircuitBreakers(fsm.breakers))
+	child.Tenant = fsm.Tenant
+	child.onSend = fsm.onSend
+	if fsm.tracer != nil {
+		child.tracer = &tracer{logger: fsm.tracer.logger, sw: fsm.tracer.sw}
+	}
+	fsm.child = child
+	// The first actions of the sub-machine may reply to the sender of the event
+	var result TransitionResult
+	child.result = &result
+	err = child.runToCompletion(StepEvent, event, func() error {
+		return child.SetState(child.InitialState, event)
+	})
+	child.result = nil
+	fsm.microsteps += child.microsteps
+	fsm.relayResponse(result)
+	if err != nil {
+		fsm.child = nil
+		return err
+	}
+	if child.CurrentState.Final {
+		return fsm.finishInvoke(event)
+	}
+	return nil
+}
+
+// forwardEvent passes an event to the running sub-machine and
+// resumes the parent once the sub-machine reaches a final state
+func (fsm *Machine) forwardEvent(event Event) error {
+	result, err := fsm.child.SendEvent(event)
+	// The transitions of the sub-machine are part of the parent's macrostep
+	fsm.microsteps += result.Microsteps
+	if fsm.result != nil {
+		// The parent stays in the invoke state, the action ran in the sub-machine
+		fsm.result.ActionOutcome = result.ActionOutcome
+		fsm.result.Ignored = result.Ignored
+	}
+	fsm.relayResponse(result)
+	if err != nil {
+		return err
+	}
+	if fsm.child.CurrentState.Final {
+		return fsm.finishInvoke(event)
+	}
+	return nil
+}
+
+// relayResponse passes the reply of the sub-machine to the sender of the event
+func (fsm *Machine) relayResponse(result TransitionResult) {
+	if result.Response != nil {
+		fsm.Respond(result.Response.Code, result.Response.Body)
+	}
+}
+
+// finishInvoke leaves the invoke state once the sub-machine is done
+// The transition whose event matches the name of the sub-machine's final
+// state is preferred, otherwise the transition without an event is taken
+func (fsm *Machine) finishInvoke(event Event) error {
+	final := fsm.child.CurrentState.Name
+	fsm.child = nil
+	log.Println("Sub-machine finished in state: ", final)
+
+	i, err := fsm.matchTransition(final, event)
+	if err == nil && i < 0 {
+		i, err = fsm.matchTransition("", event)
+	}
+	if err != nil {
+		return err
+	}
+	if i >= 0 {
+		return fsm.beginTransition(i, event)
+	}
+	return fsm.noTransition(final)
+}
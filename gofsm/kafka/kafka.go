@@ -0,0 +1,117 @@
+// Package kafka provides an event source consuming events from a Kafka topic
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ditek/jsonfsm/gofsm"
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// Events failing for another reason than being rejected, e.g. a snapshot
+// that can't be saved, are tried again after retryDelay, up to maxTries times
+const (
+	maxTries   = 3
+	retryDelay = time.Second
+)
+
+// Consumer reads JSON events from a Kafka topic
+// Messages look like {"action": "ARM", "param": "", "session": "order-42"}
+type Consumer struct {
+	reader     *kafka.Reader
+	deadLetter *kafka.Writer
+}
+
+var _ gofsm.EventSource = (*Consumer)(nil)
+
+// NewConsumer creates a consumer for the given reader
+// The reader should belong to a consumer group so that offsets can be committed
+// The messages that can't be processed are written with the deadLetter
+// writer, which may be nil to only log them
+func NewConsumer(reader *kafka.Reader, deadLetter *kafka.Writer) *Consumer {
+	return &Consumer{reader: reader, deadLetter: deadLetter}
+}
+
+// Run consumes messages until the context is cancelled, the reader fails
+// or an event keeps failing without a dead letter writer
+// The offset of a message is committed once its event has been processed
+// successfully, or once it was dead-lettered. Malformed events and events
+// the machine rejects are dead-lettered at once, other failures after
+// maxTries. Without a dead letter writer the rejected events are dropped,
+// and Run stops on the other failures so their offset isn't committed
+func (c *Consumer) Run(ctx context.Context, sink gofsm.EventSink) error {
+	for {
+		msg, err := c.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if err := c.process(ctx, msg, sink); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if err := c.reader.CommitMessages(ctx, msg); err != nil {
+			return err
+		}
+	}
+}
+
+// process sends the event of a message to the sink, trying it again if it
+// failed for another reason than being rejected
+// It returns an error if the message failed and couldn't be dead-lettered
+func (c *Consumer) process(ctx context.Context, msg kafka.Message, sink gofsm.EventSink) error {
+	event, err := gofsm.DecodeEvent(msg.Value)
+	if err != nil {
+		log.Printf("Error: Malformed event at offset %d of partition %d: %v\n", msg.Offset, msg.Partition, err)
+		return c.reject(ctx, msg)
+	}
+	for try := 1; ; try++ {
+		_, err = sink.SendEvent(event)
+		if err == nil || err == gofsm.ErrDuplicateEvent {
+			return nil
+		}
+		log.Println(err)
+		if gofsm.Rejected(err) {
+			return c.reject(ctx, msg)
+		}
+		if try == maxTries {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retryDelay):
+		}
+	}
+	if c.deadLetter == nil {
+		return fmt.Errorf("Error: Event at offset %d of partition %d failed %d times: %w", msg.Offset, msg.Partition, maxTries, err)
+	}
+	return c.reject(ctx, msg)
+}
+
+// reject writes a message that can't be processed with the dead letter
+// writer, if any
+func (c *Consumer) reject(ctx context.Context, msg kafka.Message) error {
+	if c.deadLetter == nil {
+		return nil
+	}
+	return c.deadLetter.WriteMessages(ctx, kafka.Message{Key: msg.Key, Value: msg.Value, Headers: msg.Headers})
+}
+
+// Close closes the underlying reader and dead letter writer
+func (c *Consumer) Close() error {
+	err := c.reader.Close()
+	if c.deadLetter != nil {
+		if werr := c.deadLetter.Close(); err == nil {
+			err = werr
+		}
+	}
+	return err
+}
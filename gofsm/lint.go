@@ -0,0 +1,204 @@
+package gofsm
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxAutoChain is the longest chain of eventless transitions not reported by Lint
+const maxAutoChain = 5
+
+// Lint rules
+const (
+	LintIgnoredFailure    = "ignored-failure"
+	LintUnreachableBranch = "unreachable-branch"
+	LintUnusedOutcomes    = "unused-outcomes"
+	LintUnusedEvent       = "unused-event"
+	LintUndeclaredEvent   = "undeclared-event"
+	LintActionName        = "action-name"
+	LintLongChain         = "long-chain"
+)
+
+// LintFinding is a smell found in a valid definition
+type LintFinding struct {
+	Rule    string `json:"rule"`
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (f LintFinding) String() string {
+	return fmt.Sprintf("%s: %s [%s]", f.Path, f.Message, f.Rule)
+}
+
+// actionName matches the exported Go identifiers actions are named after
+var actionName = regexp.MustCompile(`^[A-Z][A-Za-z0-9]*$`)
+
+// Lint looks for the smells of a definition that passed validation:
+// failure branches and outcomes that can't be taken, events that are declared but never
+// used or used but not declared, action names that are not exported Go
+// identifiers and long chains of eventless transitions
+func Lint(def *Definition) []LintFinding {
+	l := &linter{def: def}
+	l.checkBranches()
+	l.checkEvents()
+	l.checkActionNames()
+	l.checkChains()
+	return l.findings
+}
+
+// linter accumulates the findings of a definition
+type linter struct {
+	def      *Definition
+	findings []LintFinding
+}
+
+func (l *linter) add(rule, path, format string, args ...interface{}) {
+	l.findings = append(l.findings, LintFinding{Rule: rule, Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// checkBranches flags the failure destinations that are never taken
+func (l *linter) checkBranches() {
+	for i, t := range l.def.Transitions {
+		path := fmt.Sprintf("transitions[%d]", i)
+		if t.ToFailure != "" && !t.Branch {
+			l.add(LintIgnoredFailure, path+".toFailure", "'%s' is never entered since the transition doesn't branch", t.ToFailure)
+		}
+		// Without an action the state always succeeds and sets no outcome
+		state, err := l.def.GetState(t.From)
+		noAction := err == nil && state.Action == "" && state.Script == nil && len(state.Actions) == 0
+		if len(t.Outcomes) > 0 && t.Choice == "" && noAction {
+			l.add(LintUnusedOutcomes, path+".outcomes", "the outcomes are never used since state '%s' has no action and the transition has no choice", t.From)
+		}
+		if t.Branch && noAction {
+			l.add(LintUnreachableBranch, path+".toFailure", "'%s' is never entered since state '%s' has no action", t.ToFailure, t.From)
+		}
+	}
+}
+
+// checkEvents compares the declared events with the ones the transitions expect
+// Timeouts and schedules inject events, so the events they use count as used
+func (l *linter) checkEvents() {
+	if len(l.def.Events) == 0 {
+		return
+	}
+	declared := map[string]bool{}
+	for _, e := range l.def.Events {
+		declared[e] = true
+	}
+	used := map[string]bool{}
+	for i, t := range l.def.Transitions {
+		if t.Event == "" {
+			continue
+		}
+		used[t.Event] = true
+		// The ticks are sent by the machine, declaring them is optional
+		if t.Event == EventTick && l.def.ReservesEvents() {
+			continue
+		}
+		if !declared[t.Event] {
+			l.add(LintUndeclaredEvent, fmt.Sprintf("transitions[%d].event", i), "event '%s' is not declared in 'events'", t.Event)
+		}
+	}
+	for _, s := range l.def.States {
+		for _, timeout := range s.Timeouts {
+			used[timeout.Event] = true
+		}
+	}
+	for _, s := range l.def.Schedules {
+		used[s.Event] = true
+	}
+	for i, e := range l.def.Events {
+		if !used[e] {
+			l.add(LintUnusedEvent, fmt.Sprintf("events[%d]", i), "event '%s' is never used by a transition", e)
+		}
+	}
+}
+
+// checkActionNames flags action names that are not exported Go identifiers
+// Only the last part of namespaced names, e.g. "Charge" in "payments.Charge", is checked
+func (l *linter) checkActionNames() {
+	check := func(path, name string) {
+		if name == "" {
+			return
+		}
+		base := name[strings.LastIndex(name, ".")+1:]
+		if !actionName.MatchString(base) {
+			l.add(LintActionName, path, "action '%s' should be named like an exported Go identifier, e.g. 'SendReminder'", name)
+		}
+	}
+	for i, s := range l.def.States {
+		path := fmt.Sprintf("states[%d]", i)
+		if s.Script == nil {
+			check(path+".action", s.Action)
+		}
+		for j, name := range s.Actions {
+			check(fmt.Sprintf("%s.actions[%d]", path, j), name)
+		}
+		check(path+".compensation", s.Compensation)
+	}
+	for i, t := range l.def.Transitions {
+		check(fmt.Sprintf("transitions[%d].action", i), t.Action)
+	}
+}
+
+// checkChains flags the states starting chains of more than maxAutoChain
+// eventless transitions, which are hard to follow and to debug
+// Validation rejects cycles of eventless transitions, so the chains are finite
+func (l *linter) checkChains() {
+	lengths := map[string]int{}
+	var length func(name string, seen map[string]bool) int
+	length = func(name string, seen map[string]bool) int {
+		if n, ok := lengths[name]; ok {
+			return n
+		}
+		state, err := l.def.GetState(name)
+		if err != nil || seen[name] || !chains(state) {
+			return 0
+		}
+		seen[name] = true
+		longest := 0
+		for _, t := range l.def.Transitions {
+			if t.From != name || t.Event != "" {
+				continue
+			}
+			for _, to := range t.targets() {
+				if n := 1 + length(to, seen); n > longest {
+					longest = n
+				}
+			}
+		}
+		delete(seen, name)
+		lengths[name] = longest
+		return longest
+	}
+	for i, s := range l.def.States {
+		n := length(s.Name, map[string]bool{})
+		if n <= maxAutoChain {
+			continue
+		}
+		// Only report the start of a chain, not every state along it
+		if l.entersChain(s.Name) {
+			continue
+		}
+		l.add(LintLongChain, fmt.Sprintf("states[%d]", i), "state '%s' starts a chain of %d eventless transitions, more than %d", s.Name, n, maxAutoChain)
+	}
+}
+
+// entersChain returns true if an eventless transition leads to the state
+func (l *linter) entersChain(name string) bool {
+	for _, t := range l.def.Transitions {
+		if t.Event != "" || !t.leadsTo(name) {
+			continue
+		}
+		if from, err := l.def.GetState(t.From); err == nil && chains(from) {
+			return true
+		}
+	}
+	return false
+}
+
+// chains returns true if the state takes its transition right away
+func chains(s State) bool {
+	return !s.WaitForEvent && s.After == "" && s.Invoke == "" && !s.Final
+}
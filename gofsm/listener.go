@@ -0,0 +1,86 @@
+package gofsm
+
+import "time"
+
+// TransitionRecord describes a transition that was taken
+type TransitionRecord struct {
+	Machine   string    `json:"machine"`
+	Tenant    string    `json:"tenant,omitempty"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Event     string    `json:"event,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// TransitionListener is notified of every transition of a machine
+// Listeners are called while the machine is locked, so they must not
+// send events to it and should hand slow work off to another goroutine
+type TransitionListener func(TransitionRecord)
+
+// OnTransition registers a listener for the transitions of the machine
+func (fsm *Machine) OnTransition(listener TransitionListener) {
+	fsm = fsm.root()
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	fsm.listeners = append(fsm.listeners, listener)
+}
+
+// ActionCall describes an action that was run
+type ActionCall struct {
+	State   string
+	Action  string
+	Arg     string
+	Success bool
+	Err     error
+}
+
+// ActionListener is notified of every action run by a machine
+// The same restrictions as for TransitionListener apply
+type ActionListener func(ActionCall)
+
+// OnAction registers a listener for the actions run by the machine
+func (fsm *Machine) OnAction(listener ActionListener) {
+	fsm = fsm.root()
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	fsm.actionListeners = append(fsm.actionListeners, listener)
+}
+
+// notifyAction calls the listeners with the outcome of an action of the current state
+func (fsm *Machine) notifyAction(action string, event Event, success bool, err error) {
+	if len(fsm.actionListeners) == 0 && fsm.recording == nil {
+		return
+	}
+	call := ActionCall{
+		State:   fsm.CurrentState.Name,
+		Action:  action,
+		Arg:     event.Param,
+		Success: success,
+		Err:     err,
+	}
+	fsm.recordAction(call)
+	for _, listener := range fsm.actionListeners {
+		listener(call)
+	}
+}
+
+// notifyTransition calls the listeners with the transition from one state to the current one
+// and stages it for the projections
+func (fsm *Machine) notifyTransition(from string, event Event) {
+	fsm.recordTransition(fsm.CurrentState.Name)
+	if len(fsm.listeners) == 0 && len(fsm.projections) == 0 && len(fsm.afterHooks) == 0 {
+		return
+	}
+	record := TransitionRecord{
+		Machine:   fsm.ID,
+		Tenant:    fsm.Tenant,
+		From:      from,
+		To:        fsm.CurrentState.Name,
+		Event:     event.Action,
+		Timestamp: fsm.clock().Now(),
+	}
+	for _, listener := range fsm.listeners {
+		listener(record)
+	}
+	fsm.stage(record, event)
+}
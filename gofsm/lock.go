@@ -0,0 +1,48 @@
+package gofsm
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// lockWait is how long an event waits for the lock of its session
+const lockWait = 30 * time.Second
+
+// Locker serializes the events of a session across server replicas
+// Implementations are found in the gofsm/lock package
+type Locker interface {
+	// Lock blocks until the lock of the session is held or the context is done
+	// The returned function releases the lock
+	Lock(ctx context.Context, id string) (unlock func() error, err error)
+}
+
+// WithLocker lets several replicas serve the same sessions
+// Each event sent with SendEvent takes the lock of its session, loads the
+// machine from the shared store if another replica changed it, and saves it
+// back before the lock is released. Delayed transitions, timeouts and
+// schedules are not locked and run on the replica that loaded the machine
+func WithLocker(locker Locker, store SnapshotStore) ManagerOption {
+	return func(m *Manager) {
+		m.locker = locker
+		m.snapshots = store
+		m.shared = true
+	}
+}
+
+// lock takes the distributed lock of a session
+// Returns the function releasing it
+func (m *Manager) lock(id string) (func(), error) {
+	ctx, cancel := context.WithTimeout(context.Background(), lockWait)
+	defer cancel()
+	unlock, err := m.locker.Lock(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("Error: Lock of session '%s' not acquired: %v", id, err)
+	}
+	return func() {
+		if err := unlock(); err != nil {
+			log.Printf("Error: Lock of session '%s' not released: %v\n", id, err)
+		}
+	}, nil
+}
@@ -0,0 +1,133 @@
+package lock
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ditek/jsonfsm/gofsm"
+)
+
+// EtcdLocker takes locks with transactions on the JSON gateway of etcd v3
+// The lock key is attached to a lease, so it disappears with a dead replica
+type EtcdLocker struct {
+	url    string
+	prefix string
+	ttl    time.Duration
+	client *http.Client
+}
+
+var _ gofsm.Locker = (*EtcdLocker)(nil)
+
+// NewEtcdLocker creates a locker using the etcd endpoint at the given URL
+func NewEtcdLocker(url, prefix string, ttl time.Duration) (*EtcdLocker, error) {
+	if url == "" {
+		return nil, fmt.Errorf("Error: The etcd lock needs a URL")
+	}
+	return &EtcdLocker{
+		url:    strings.TrimSuffix(url, "/"),
+		prefix: prefix,
+		ttl:    ttl,
+		client: &http.Client{Timeout: dialTimeout},
+	}, nil
+}
+
+// Lock grants a lease and retries to create the key of the session until it
+// is free or the context is done
+// The lease is kept alive until the lock is released, which fails with
+// ErrLockLost if the lease expired meanwhile
+func (l *EtcdLocker) Lock(ctx context.Context, id string) (func() error, error) {
+	var lease struct {
+		ID string `json:"ID"`
+	}
+	if err := l.call(ctx, "/v3/lease/grant", map[string]interface{}{"TTL": int64(l.ttl / time.Second)}, &lease); err != nil {
+		return nil, err
+	}
+	revoke := func() error {
+		// Revoking the lease deletes the key
+		return l.call(context.Background(), "/v3/lease/revoke", map[string]string{"ID": lease.ID}, nil)
+	}
+
+	key := base64.StdEncoding.EncodeToString([]byte(l.prefix + id))
+	txn := map[string]interface{}{
+		"compare": []interface{}{
+			map[string]string{"target": "CREATE", "key": key, "createRevision": "0"},
+		},
+		"success": []interface{}{
+			map[string]interface{}{"requestPut": map[string]string{"key": key, "value": key, "lease": lease.ID}},
+		},
+	}
+	for {
+		var result struct {
+			Succeeded bool `json:"succeeded"`
+		}
+		if err := l.call(ctx, "/v3/kv/txn", txn, &result); err != nil {
+			revoke()
+			return nil, err
+		}
+		if result.Succeeded {
+			stop := keepAlive(l.ttl, func(ctx context.Context) (bool, error) {
+				return l.keepAlive(ctx, lease.ID)
+			})
+			unlock := func() error {
+				lost := stop()
+				err := revoke()
+				if lost != nil {
+					return lost
+				}
+				return err
+			}
+			return unlock, nil
+		}
+		select {
+		case <-ctx.Done():
+			revoke()
+			return nil, ctx.Err()
+		case <-time.After(retryDelay):
+		}
+	}
+}
+
+// keepAlive renews a lease and tells if it was still alive
+func (l *EtcdLocker) keepAlive(ctx context.Context, lease string) (bool, error) {
+	var reply struct {
+		Result struct {
+			// The gateway encodes the int64 as a string, missing once the lease expired
+			TTL string `json:"TTL"`
+		} `json:"result"`
+	}
+	if err := l.call(ctx, "/v3/lease/keepalive", map[string]string{"ID": lease}, &reply); err != nil {
+		return false, err
+	}
+	return reply.Result.TTL != "" && reply.Result.TTL != "0", nil
+}
+
+// call posts a request to the gateway and decodes the response into out, if not nil
+func (l *EtcdLocker) call(ctx context.Context, path string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.url+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Error: etcd replied %s to %s", resp.Status, path)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,115 @@
+// Package lock ships distributed locks for server replicas serving the same
+// sessions, backed by Redis or etcd
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ditek/jsonfsm/gofsm"
+)
+
+// DefaultPrefix is prepended to the session IDs to form the lock keys by default
+const DefaultPrefix = "jsonfsm/lock/"
+
+// Defaults of the lockers
+const (
+	defaultTTL  = 30 * time.Second
+	retryDelay  = 50 * time.Millisecond
+	dialTimeout = 5 * time.Second
+)
+
+// ErrLockLost is returned when releasing a lock that expired or was taken
+// over by another replica while the event was processed
+var ErrLockLost = errors.New("Error: The lock expired before it was released")
+
+// Config describes a distributed lock
+type Config struct {
+	// Type is "redis" or "etcd"
+	Type string `json:"type"`
+	// Address is the host:port of the Redis server
+	Address string `json:"address,omitempty"`
+	// Password authenticates to Redis (optional)
+	Password string `json:"password,omitempty"`
+	// URL is the etcd endpoint, e.g. "http://127.0.0.1:2379"
+	URL string `json:"url,omitempty"`
+	// Prefix is prepended to the session IDs to form the lock keys, "jsonfsm/lock/" by default
+	Prefix string `json:"prefix,omitempty"`
+	// TTL releases the lock of a replica that died while holding it, "30s" by default
+	TTL string `json:"ttl,omitempty"`
+}
+
+// New creates the locker described by the config
+func New(cfg Config) (gofsm.Locker, error) {
+	ttl := defaultTTL
+	if cfg.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(cfg.TTL); err != nil || ttl < time.Second {
+			return nil, fmt.Errorf("Error: Invalid lock TTL '%s', at least 1s is needed", cfg.TTL)
+		}
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = DefaultPrefix
+	}
+	switch cfg.Type {
+	case "redis":
+		return NewRedisLocker(cfg.Address, cfg.Password, cfg.Prefix, ttl)
+	case "etcd":
+		return NewEtcdLocker(cfg.URL, cfg.Prefix, ttl)
+	}
+	return nil, fmt.Errorf("Error: Unknown lock type '%s'", cfg.Type)
+}
+
+// newToken returns a random value identifying the holder of a lock
+func newToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// keepAlive renews a lock every third of its TTL until the returned function
+// is called, so the lock outlives the slow actions of the event
+// renew tells if the lock was still held. The returned function stops the
+// renewals and returns ErrLockLost if the lock wasn't held all along
+func keepAlive(ttl time.Duration, renew func(ctx context.Context) (bool, error)) func() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	var lost error
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		renewed := time.Now()
+		for {
+			select {
+			case <-ctx.Done():
+				if time.Since(renewed) >= ttl {
+					lost = ErrLockLost
+				}
+				return
+			case <-ticker.C:
+			}
+			held, err := renew(ctx)
+			switch {
+			case err == nil && !held:
+				lost = ErrLockLost
+				return
+			case err == nil:
+				renewed = time.Now()
+			case ctx.Err() == nil:
+				// The next renewal may still succeed before the TTL expires
+				log.Printf("Error: Lock not renewed: %v\n", err)
+			}
+		}
+	}()
+	return func() error {
+		cancel()
+		<-done
+		return lost
+	}
+}
@@ -0,0 +1,158 @@
+package lock
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/ditek/jsonfsm/gofsm"
+)
+
+// unlockScript deletes the lock key only if it still holds the token, so an
+// expired lock taken over by another replica is not released
+const unlockScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`
+
+// renewScript extends the TTL of the lock key only if it still holds the token
+const renewScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) else return 0 end`
+
+// RedisLocker takes locks with SET NX on a Redis server
+// Each command uses its own connection, so no client library is needed
+type RedisLocker struct {
+	address  string
+	password string
+	prefix   string
+	ttl      time.Duration
+}
+
+var _ gofsm.Locker = (*RedisLocker)(nil)
+
+// NewRedisLocker creates a locker using the Redis server at the given address
+func NewRedisLocker(address, password, prefix string, ttl time.Duration) (*RedisLocker, error) {
+	if address == "" {
+		return nil, fmt.Errorf("Error: The Redis lock needs an address")
+	}
+	return &RedisLocker{address: address, password: password, prefix: prefix, ttl: ttl}, nil
+}
+
+// Lock retries to set the key of the session until it is free or the context is done
+// The key is renewed until the lock is released, which fails with
+// ErrLockLost if the key no longer holds the token of the lock
+func (l *RedisLocker) Lock(ctx context.Context, id string) (func() error, error) {
+	key := l.prefix + id
+	token := newToken()
+	ttl := strconv.FormatInt(l.ttl.Milliseconds(), 10)
+	for {
+		reply, err := l.do(ctx, "SET", key, token, "NX", "PX", ttl)
+		if err != nil {
+			return nil, err
+		}
+		if reply == "OK" {
+			stop := keepAlive(l.ttl, func(ctx context.Context) (bool, error) {
+				reply, err := l.do(ctx, "EVAL", renewScript, "1", key, token, ttl)
+				return reply == int64(1), err
+			})
+			unlock := func() error {
+				lost := stop()
+				reply, err := l.do(context.Background(), "EVAL", unlockScript, "1", key, token)
+				switch {
+				case lost != nil:
+					return lost
+				case err != nil:
+					return err
+				case reply != int64(1):
+					return ErrLockLost
+				}
+				return nil
+			}
+			return unlock, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(retryDelay):
+		}
+	}
+}
+
+// do sends a command and returns its reply, nil for a null reply
+func (l *RedisLocker) do(ctx context.Context, args ...string) (interface{}, error) {
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", l.address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(dialTimeout)
+	}
+	conn.SetDeadline(deadline)
+
+	r := bufio.NewReader(conn)
+	if l.password != "" {
+		if _, err := command(conn, r, "AUTH", l.password); err != nil {
+			return nil, err
+		}
+	}
+	return command(conn, r, args...)
+}
+
+// command writes a command in the RESP protocol and reads the reply
+func command(conn net.Conn, r *bufio.Reader, args ...string) (interface{}, error) {
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(arg))+"\r\n"+arg+"\r\n"...)
+	}
+	if _, err := conn.Write(buf); err != nil {
+		return nil, err
+	}
+	return readReply(r)
+}
+
+// readReply reads a RESP reply
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 {
+		return nil, fmt.Errorf("Error: Malformed Redis reply %q", line)
+	}
+	kind, value := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return value, nil
+	case '-':
+		return nil, errors.New("Error: Redis: " + value)
+	case ':':
+		return strconv.ParseInt(value, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("Error: Malformed Redis reply %q", line)
+}
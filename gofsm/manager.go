@@ -0,0 +1,350 @@
+package gofsm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrSessionExists is returned when starting a session whose ID is already in use
+var ErrSessionExists = errors.New("Error: Session already exists")
+
+// ErrSessionNotFound is returned for events of unknown sessions by managers without factory
+var ErrSessionNotFound = errors.New("Error: Session not found")
+
+// Manager routes events to a state machine per session
+// Machines are created on the first event of their session
+// The sessions are spread over shards with their own lock, see WithShards
+type Manager struct {
+	factory   func() (*Machine, error)
+	shards    []*shard
+	mu        sync.Mutex
+	listeners []TransitionListener
+	sinks     []AuditSink
+	// projections are given to the machines of the manager
+	projections []Projection
+	// beforeHooks and afterHooks are given to the machines of the manager
+	beforeHooks []BeforeTransitionHook
+	afterHooks  []AfterTransitionHook
+	// middleware is given to the machines of the manager
+	middleware []Middleware
+	// tenant is given to the machines of the manager, see WithTenant
+	tenant string
+	// def is the definition of the new sessions, for the events rejected
+	// before they reach a machine, see newDefinition
+	def     *Definition
+	defOnce sync.Once
+
+	// maxLoaded is the number of machines kept in memory, unlimited if zero
+	// Idle machines beyond it are evicted to the snapshot store
+	maxLoaded int
+	snapshots SnapshotStore
+	// locker serializes the events of a session across replicas, see WithLocker
+	locker Locker
+	// shared is set when other replicas read and write the snapshot store
+	shared bool
+
+	// correlations maps correlation keys to the machine waiting for them
+	// They have their own lock since machines update them while locked
+	correlationsMu sync.Mutex
+	correlations   map[string]*Machine
+
+	// queues hold the events sent with Enqueue by session, see WithEventQueue
+	queuesMu     sync.Mutex
+	queues       map[string]*eventQueue
+	queueSize    int
+	overflow     OverflowPolicy
+	queueTimeout time.Duration
+	queueStats   QueueStats
+
+	// stuckAlerted maps the stuck sessions already alerted to the entry of
+	// their state, see WithStuckScan
+	stuckMu       sync.Mutex
+	stuckAlerted  map[string]time.Time
+	stuckInterval time.Duration
+
+	// compaction truncates the history in a store, see WithCompaction
+	compaction *compaction
+}
+
+// ManagerOption customizes a manager created by NewManager
+type ManagerOption func(*Manager)
+
+// NewManager creates a manager that uses the factory to create the machine of a new session
+// Without factory, only the sessions created with Start exist
+func NewManager(factory func() (*Machine, error), opts ...ManagerOption) *Manager {
+	m := &Manager{
+		factory:      factory,
+		correlations: map[string]*Machine{},
+		queues:       map[string]*eventQueue{},
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if len(m.shards) == 0 {
+		m.shards = newShards(1)
+	}
+	for _, s := range m.shards {
+		if m.maxLoaded > 0 && m.snapshots != nil {
+			// Round up so the shards together hold at least maxLoaded machines
+			s.capacity = (m.maxLoaded + len(m.shards) - 1) / len(m.shards)
+		}
+	}
+	if m.stuckInterval > 0 {
+		go m.watchStuck(m.stuckInterval)
+	}
+	if m.compaction != nil {
+		go m.watchHistory(m.compaction)
+	}
+	return m
+}
+
+// Session returns the machine of a session, creating and initializing it if needed
+func (m *Manager) Session(id string) (*Machine, error) {
+	s := m.shard(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, err := m.load(s, id, true)
+	if err != nil {
+		return nil, err
+	}
+	return sess.fsm, nil
+}
+
+// Start creates and initializes the machine of a new session from the given definition
+// Returns ErrSessionExists if the session already has a machine
+func (m *Manager) Start(id string, def *Definition, opts ...Option) (*Machine, error) {
+	s := m.shard(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.has(id) {
+		return nil, ErrSessionExists
+	}
+	create := func() (*Machine, error) {
+		return NewMachine(def, opts...), nil
+	}
+	fsm, _ := create()
+	m.add(id, fsm)
+	s.insert(&session{id: id, fsm: fsm, create: create})
+	m.evict(s)
+	return fsm, nil
+}
+
+// add initializes the machine of a session
+func (m *Manager) add(id string, fsm *Machine) {
+	m.attach(id, fsm)
+	fsm.Init()
+}
+
+// attach connects a machine to the listeners, sinks and correlations of the manager
+func (m *Manager) attach(id string, fsm *Machine) {
+	m.mu.Lock()
+	listeners, sinks, projections := m.listeners, m.sinks, m.projections
+	beforeHooks, afterHooks, middleware := m.beforeHooks, m.afterHooks, m.middleware
+	m.mu.Unlock()
+	fsm.ID = id
+	fsm.Tenant = m.tenant
+	fsm.onCorrelate = m.correlate
+	fsm.onSend = m.deliver
+	for _, listener := range listeners {
+		fsm.OnTransition(listener)
+	}
+	for _, sink := range sinks {
+		fsm.OnAudit(sink)
+	}
+	for _, projection := range projections {
+		fsm.OnCommit(projection)
+	}
+	for _, hook := range beforeHooks {
+		fsm.BeforeTransition(hook)
+	}
+	for _, hook := range afterHooks {
+		fsm.AfterTransition(hook)
+	}
+	fsm.Use(middleware...)
+}
+
+// Get returns the machine of a session without creating it
+// An evicted machine is loaded back from the snapshot store
+func (m *Manager) Get(id string) (*Machine, bool) {
+	s := m.shard(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, err := m.load(s, id, false)
+	if err != nil || sess == nil {
+		return nil, false
+	}
+	return sess.fsm, true
+}
+
+// Snapshot captures the machine of a session without loading it if it was evicted
+func (m *Manager) Snapshot(id string) (Snapshot, bool) {
+	s := m.shard(id)
+	s.mu.Lock()
+	if e, ok := s.sessions[id]; ok {
+		s.mu.Unlock()
+		return e.Value.(*session).fsm.Snapshot(), true
+	}
+	_, evicted := s.evicted[id]
+	s.mu.Unlock()
+	if !evicted {
+		return Snapshot{}, false
+	}
+	snap, err := m.snapshots.LoadSnapshot(id)
+	return snap, err == nil
+}
+
+// OnTransition registers a listener for the transitions of the machines of all sessions
+// Only sessions created afterwards are affected
+func (m *Manager) OnTransition(listener TransitionListener) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listeners = append(m.listeners, listener)
+}
+
+// OnAudit registers a sink for the audit records of the machines of all sessions
+// Only sessions created afterwards are affected, events rejected before
+// reaching a machine are recorded as well
+func (m *Manager) OnAudit(sink AuditSink) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sinks = append(m.sinks, sink)
+}
+
+// SendEvent sends an event to the machine of its session
+// Events without a session but with a correlation key go to the machine waiting for the key
+// The machine is not evicted while it processes the event
+func (m *Manager) SendEvent(event Event) (TransitionResult, error) {
+	id := event.Session
+	if event.Session == "" && event.CorrelationKey != "" {
+		fsm, ok := m.Correlated(event.CorrelationKey)
+		if !ok {
+			m.AuditRejected(event, ErrNoCorrelation)
+			return TransitionResult{}, ErrNoCorrelation
+		}
+		id = fsm.ID
+	}
+	reject := func(err error) {
+		m.AuditRejected(event, err)
+	}
+	return m.run(id, reject, func(fsm *Machine) (TransitionResult, error) {
+		return fsm.SendEvent(event)
+	})
+}
+
+// run calls fn with the machine of a session the way events are processed:
+// the machine is kept in memory meanwhile and, with a shared store, it is
+// brought up to date beforehand and saved afterwards, under the lock of the
+// session if there is a locker
+// The errors that prevent fn from being called are passed to reject
+func (m *Manager) run(id string, reject func(error), fn func(fsm *Machine) (TransitionResult, error)) (TransitionResult, error) {
+	if m.locker != nil {
+		unlock, err := m.lock(id)
+		if err != nil {
+			reject(err)
+			return TransitionResult{}, err
+		}
+		defer unlock()
+	}
+	fsm, release, err := m.acquire(id)
+	if err != nil {
+		reject(err)
+		return TransitionResult{}, err
+	}
+	defer release()
+	if !m.shared {
+		return fn(fsm)
+	}
+	if err := m.refresh(id, fsm); err != nil {
+		reject(err)
+		return TransitionResult{}, err
+	}
+	result, err := fn(fsm)
+	if saveErr := m.snapshots.SaveSnapshot(id, fsm.Snapshot()); saveErr != nil && err == nil {
+		err = saveErr
+	}
+	return result, err
+}
+
+// refresh restores the snapshot another replica saved for the session, if
+// it differs from the machine, so the event is processed on the latest state
+func (m *Manager) refresh(id string, fsm *Machine) error {
+	stored, err := m.snapshots.LoadSnapshot(id)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// Snapshots are compared as JSON, the stored context lost its Go types
+	a, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(fsm.Snapshot())
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(a, b) {
+		return nil
+	}
+	return fsm.Restore(stored)
+}
+
+// AuditRejected records an event that didn't reach any machine,
+// e.g. because the sender is not allowed to send it
+func (m *Manager) AuditRejected(event Event, err error) {
+	m.mu.Lock()
+	sinks := m.sinks
+	m.mu.Unlock()
+	record := AuditRecord{
+		Time:    time.Now(),
+		Kind:    AuditEventRejected,
+		Machine: event.Session,
+		Tenant:  m.tenant,
+		EventID: event.ID,
+		Event:   event.Action,
+		Param:   m.redactRejected(event, event.Param),
+		Error:   m.redactRejected(event, err.Error()),
+	}
+	for _, sink := range sinks {
+		sink.Audit(record)
+	}
+}
+
+// Sessions returns the IDs of all sessions, including the evicted ones
+func (m *Manager) Sessions() []string {
+	var ids []string
+	for _, s := range m.shards {
+		s.mu.Lock()
+		for id := range s.sessions {
+			ids = append(ids, id)
+		}
+		for id := range s.evicted {
+			ids = append(ids, id)
+		}
+		s.mu.Unlock()
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Remove stops and forgets the machine of a session, and deletes its snapshot if it was evicted
+func (m *Manager) Remove(id string) {
+	s := m.shard(id)
+	s.mu.Lock()
+	sess := s.remove(id)
+	_, evicted := s.evicted[id]
+	delete(s.evicted, id)
+	s.mu.Unlock()
+	if sess != nil {
+		sess.fsm.Stop()
+		m.forget(sess.fsm)
+	}
+	if evicted || m.shared {
+		m.deleteSnapshot(id)
+	}
+}
@@ -0,0 +1,137 @@
+package gofsm
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// EventlessColumn names the column of the transitions without event in the matrix
+const EventlessColumn = "(eventless)"
+
+// TransitionMatrix is the state × event table of a definition, each cell
+// holding the destinations of the transitions of a state for an event
+// A cell lists the transitions in the order they are tried, separated by
+// "; ", with their guard, failure destination and outcomes
+type TransitionMatrix struct {
+	States []string
+	Events []string
+	// Cells is indexed by state then event, empty where there is no transition
+	Cells [][]string
+}
+
+// Matrix builds the transition matrix of the definition
+// The events are in the order of 'events' then of their first transition,
+// and the eventless transitions come last
+func (def *Definition) Matrix() TransitionMatrix {
+	m := TransitionMatrix{}
+	columns := map[string]int{}
+	addColumn := func(event string) {
+		if _, ok := columns[event]; !ok {
+			columns[event] = len(m.Events)
+			m.Events = append(m.Events, event)
+		}
+	}
+	eventless := false
+	for _, event := range def.Events {
+		addColumn(event)
+	}
+	for _, t := range def.Transitions {
+		if t.Event == "" {
+			eventless = true
+			continue
+		}
+		addColumn(t.Event)
+	}
+	if eventless {
+		addColumn(EventlessColumn)
+	}
+	rows := map[string]int{}
+	for _, s := range def.States {
+		rows[s.Name] = len(m.States)
+		m.States = append(m.States, s.Name)
+		m.Cells = append(m.Cells, make([]string, len(m.Events)))
+	}
+	for _, t := range def.Transitions {
+		row, ok := rows[t.From]
+		if !ok {
+			continue
+		}
+		event := t.Event
+		if event == "" {
+			event = EventlessColumn
+		}
+		cell := &m.Cells[row][columns[event]]
+		if *cell != "" {
+			*cell += "; "
+		}
+		*cell += describeDestinations(t)
+	}
+	return m
+}
+
+// describeDestinations describes where a transition goes in a cell of the matrix
+func describeDestinations(t Transition) string {
+	var parts []string
+	if t.ToSuccess != "" {
+		parts = append(parts, t.ToSuccess)
+	}
+	if t.Branch && t.ToFailure != "" {
+		parts = append(parts, "failure: "+t.ToFailure)
+	}
+	outcomes := make([]string, 0, len(t.Outcomes))
+	for outcome := range t.Outcomes {
+		outcomes = append(outcomes, outcome)
+	}
+	sort.Strings(outcomes)
+	for _, outcome := range outcomes {
+		parts = append(parts, outcome+": "+t.Outcomes[outcome])
+	}
+	desc := strings.Join(parts, ", ")
+	if t.Guard != "" {
+		desc += fmt.Sprintf(" (if %s)", t.Guard)
+	}
+	return desc
+}
+
+// WriteCSV writes the matrix as CSV, with the events as header row and the
+// states as first column
+func (m TransitionMatrix) WriteCSV(w io.Writer) error {
+	out := csv.NewWriter(w)
+	if err := out.Write(append([]string{"state"}, m.Events...)); err != nil {
+		return err
+	}
+	for i, state := range m.States {
+		if err := out.Write(append([]string{state}, m.Cells[i]...)); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// Markdown returns the matrix as a Markdown table, the empty cells being dashes
+func (m TransitionMatrix) Markdown() string {
+	var b strings.Builder
+	row := func(cells []string) {
+		b.WriteString("|")
+		for _, cell := range cells {
+			if cell == "" {
+				cell = "-"
+			}
+			b.WriteString(" " + markdownEscaper.Replace(cell) + " |")
+		}
+		b.WriteString("\n")
+	}
+	row(append([]string{"State"}, m.Events...))
+	b.WriteString("|" + strings.Repeat("---|", len(m.Events)+1) + "\n")
+	for i, state := range m.States {
+		row(append([]string{"**" + state + "**"}, m.Cells[i]...))
+	}
+	return b.String()
+}
+
+// markdownEscaper escapes the characters breaking the cells of Markdown tables
+var markdownEscaper = strings.NewReplacer("|", "\\|", "\n", " ")
@@ -0,0 +1,43 @@
+package gofsm
+
+// EventProcessor processes an event sent to a machine
+type EventProcessor func(event Event) (TransitionResult, error)
+
+// Middleware wraps the processing of the events sent to a machine, e.g. to
+// log, measure, authorize or trace them, the same way HTTP middleware wraps
+// a handler. It calls next to process the event, or returns without calling
+// it to reject the event
+type Middleware func(next EventProcessor) EventProcessor
+
+// Use adds middleware around the processing of the events sent to the
+// machine with SendEvent. The first middleware added is the outermost
+// Middleware runs before the machine is locked, so it sees the time spent
+// waiting for the previous event too. Scheduled events and completed tasks
+// go through it, but not the internal events emitted by the actions, the
+// delayed transitions and the timeouts
+func (fsm *Machine) Use(middleware ...Middleware) {
+	fsm.actionMu.Lock()
+	defer fsm.actionMu.Unlock()
+	fsm.middleware = append(fsm.middleware, middleware...)
+}
+
+// Use adds middleware around the processing of the events sent to the
+// machines of all sessions
+// Only sessions created afterwards are affected
+func (m *Manager) Use(middleware ...Middleware) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.middleware = append(m.middleware, middleware...)
+}
+
+// processor returns the processing of the events wrapped in the middleware
+func (fsm *Machine) processor() EventProcessor {
+	fsm.actionMu.Lock()
+	middleware := fsm.middleware
+	fsm.actionMu.Unlock()
+	p := EventProcessor(fsm.processEvent)
+	for i := len(middleware) - 1; i >= 0; i-- {
+		p = middleware[i](p)
+	}
+	return p
+}
@@ -0,0 +1,170 @@
+package gofsm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// MigrateDefinition converts a JSON definition to the current schema
+// version: the fields are renamed and 'schemaVersion' is set, while the rest
+// of the file is kept byte for byte, formatting included. Fragments, i.e. a
+// state or transition or an array of them, are converted too but get no version
+func MigrateDefinition(data []byte) ([]byte, error) {
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	role := roleList
+	if obj, ok := doc.(map[string]interface{}); ok {
+		role = roleItem
+		for _, key := range []string{"initialState", "extends", "states", "transitions"} {
+			if _, ok := obj[key]; ok {
+				role = roleDefinition
+			}
+		}
+	} else if _, ok := doc.([]interface{}); !ok {
+		return nil, fmt.Errorf("Error: A definition must be a JSON object")
+	}
+	m := &migrator{data: data, dec: json.NewDecoder(bytes.NewReader(data))}
+	if err := m.value(role, ""); err != nil {
+		return nil, err
+	}
+	// The edits don't overlap, applying them from the end keeps the offsets valid
+	out := append([]byte{}, data...)
+	for i := len(m.edits) - 1; i >= 0; i-- {
+		e := m.edits[i]
+		out = append(out[:e.start], append([]byte(e.text), out[e.end:]...)...)
+	}
+	return out, nil
+}
+
+// Roles of the JSON values in a definition
+const (
+	roleOther = iota
+	// roleDefinition is the top-level object of a definition
+	roleDefinition
+	// roleList is a list of states or transitions
+	roleList
+	// roleItem is a state or a transition
+	roleItem
+)
+
+// edit replaces the bytes from start to end
+type edit struct {
+	start, end int
+	text       string
+}
+
+// migrator collects the edits converting a definition while reading it
+type migrator struct {
+	data  []byte
+	dec   *json.Decoder
+	edits []edit
+}
+
+// value reads a JSON value with the given role
+func (m *migrator) value(role int, path string) error {
+	tok, err := m.dec.Token()
+	if err != nil {
+		return err
+	}
+	switch tok {
+	case json.Delim('['):
+		for i := 0; m.dec.More(); i++ {
+			child := roleOther
+			if role == roleList {
+				child = roleItem
+			}
+			if err := m.value(child, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		_, err = m.dec.Token()
+		return err
+	case json.Delim('{'):
+		return m.object(role, path, int(m.dec.InputOffset()))
+	}
+	return nil
+}
+
+// object reads the fields of a JSON object whose '{' ends at open
+func (m *migrator) object(role int, path string, open int) error {
+	present := map[string]bool{}
+	hasVersion := false
+	first := -1
+	for m.dec.More() {
+		tok, err := m.dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := tok.(string)
+		end := int(m.dec.InputOffset())
+		quoted, _ := json.Marshal(key)
+		start := end - len(quoted)
+		if first < 0 {
+			first = start
+		}
+		present[key] = true
+		if name, ok := renamedFields[key]; ok && role == roleItem {
+			if !bytes.Equal(m.data[start:end], quoted) {
+				return fmt.Errorf("Error: %s: cannot rename the escaped field '%s'", path, key)
+			}
+			m.edits = append(m.edits, edit{start: start, end: end, text: `"` + name + `"`})
+		}
+		child := roleOther
+		switch {
+		case role == roleDefinition && (key == "states" || key == "transitions"):
+			child = roleList
+		case role == roleDefinition && key == "schemaVersion":
+			hasVersion = true
+			if err := m.version(end); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := m.value(child, join(path, key)); err != nil {
+			return err
+		}
+	}
+	if _, err := m.dec.Token(); err != nil {
+		return err
+	}
+	if role == roleItem {
+		for old, name := range renamedFields {
+			if present[old] && present[name] {
+				return fmt.Errorf("Error: %s has both '%s' and '%s'", path, old, name)
+			}
+		}
+	}
+	if role == roleDefinition && !hasVersion {
+		// The version goes first, indented like the first field
+		version := fmt.Sprintf(`"schemaVersion": %d`, SchemaVersion)
+		if first < 0 {
+			m.edits = append([]edit{{start: open, end: open, text: version}}, m.edits...)
+		} else {
+			indent := string(m.data[open:first])
+			if indent == "" {
+				indent = " "
+			}
+			m.edits = append([]edit{{start: first, end: first, text: version + "," + indent}}, m.edits...)
+		}
+	}
+	return nil
+}
+
+// version replaces the value of the 'schemaVersion' field whose key ends at
+// keyEnd with the current version
+func (m *migrator) version(keyEnd int) error {
+	var value json.RawMessage
+	if err := m.dec.Decode(&value); err != nil {
+		return err
+	}
+	end := int(m.dec.InputOffset())
+	start := end - len(value)
+	if start < keyEnd {
+		return fmt.Errorf("Error: Cannot locate the schema version")
+	}
+	m.edits = append(m.edits, edit{start: start, end: end, text: fmt.Sprint(SchemaVersion)})
+	return nil
+}
@@ -0,0 +1,51 @@
+// Package mqtt provides an event source subscribing to an MQTT topic
+package mqtt
+
+import (
+	"context"
+	"log"
+
+	"github.com/ditek/jsonfsm/gofsm"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Source receives JSON events published on an MQTT topic
+type Source struct {
+	client mqtt.Client
+	topic  string
+	qos    byte
+}
+
+var _ gofsm.EventSource = (*Source)(nil)
+
+// NewSource creates a source for the given topic filter
+// The client must already be connected
+func NewSource(client mqtt.Client, topic string, qos byte) *Source {
+	return &Source{client: client, topic: topic, qos: qos}
+}
+
+// Run subscribes to the topic until the context is cancelled
+func (s *Source) Run(ctx context.Context, sink gofsm.EventSink) error {
+	token := s.client.Subscribe(s.topic, s.qos, func(_ mqtt.Client, msg mqtt.Message) {
+		event, err := gofsm.DecodeEvent(msg.Payload())
+		if err == nil {
+			_, err = sink.SendEvent(event)
+		}
+		if err != nil && err != gofsm.ErrDuplicateEvent {
+			log.Println(err)
+		}
+	})
+	if token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	<-ctx.Done()
+	token = s.client.Unsubscribe(s.topic)
+	token.Wait()
+	return token.Error()
+}
+
+// Close disconnects the client
+func (s *Source) Close() error {
+	s.client.Disconnect(250)
+	return nil
+}
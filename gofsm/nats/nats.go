@@ -0,0 +1,64 @@
+// Package nats provides an event source subscribing to a NATS subject
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/ditek/jsonfsm/gofsm"
+	"github.com/nats-io/nats.go"
+)
+
+// Source receives JSON events published on a NATS subject
+// Messages published as requests get a reply with the outcome of the event
+type Source struct {
+	conn    *nats.Conn
+	subject string
+	queue   string
+}
+
+var _ gofsm.EventSource = (*Source)(nil)
+
+// NewSource creates a source for the given subject
+// Sources sharing a non-empty queue group split the messages between them
+func NewSource(conn *nats.Conn, subject, queue string) *Source {
+	return &Source{conn: conn, subject: subject, queue: queue}
+}
+
+// Run subscribes to the subject until the context is cancelled
+func (s *Source) Run(ctx context.Context, sink gofsm.EventSink) error {
+	handler := func(msg *nats.Msg) {
+		reply := `{"status":"ok"}`
+		event, err := gofsm.DecodeEvent(msg.Data)
+		if err == nil {
+			_, err = sink.SendEvent(event)
+		}
+		if err != nil && err != gofsm.ErrDuplicateEvent {
+			log.Println(err)
+			data, _ := json.Marshal(map[string]string{"error": err.Error()})
+			reply = string(data)
+		}
+		if msg.Reply != "" {
+			msg.Respond([]byte(reply))
+		}
+	}
+
+	var sub *nats.Subscription
+	var err error
+	if s.queue != "" {
+		sub, err = s.conn.QueueSubscribe(s.subject, s.queue, handler)
+	} else {
+		sub, err = s.conn.Subscribe(s.subject, handler)
+	}
+	if err != nil {
+		return err
+	}
+	<-ctx.Done()
+	return sub.Unsubscribe()
+}
+
+// Close drains and closes the connection
+func (s *Source) Close() error {
+	return s.conn.Drain()
+}
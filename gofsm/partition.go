@@ -0,0 +1,47 @@
+package gofsm
+
+import "log"
+
+// WithSharedStore saves the machine of a session to a store shared by the
+// replicas after each event, and loads the sessions it doesn't know from it
+// Replicas that partition the sessions between them use it to hand sessions
+// over, see Handoff. Unlike WithLocker, a session must only be served by one
+// replica at a time
+func WithSharedStore(store SnapshotStore) ManagerOption {
+	return func(m *Manager) {
+		m.snapshots = store
+		m.shared = true
+	}
+}
+
+// Handoff saves the loaded machines of the sessions the replica no longer
+// owns to the shared store and drops them, so their new owner can load them
+// Their timers are stopped and start again on the new owner. A machine
+// processing an event is handed off once the event is processed, and the
+// snapshot saved after the event replaces the one saved here
+// Returns the number of sessions handed off
+func (m *Manager) Handoff(owns func(id string) bool) int {
+	if !m.shared {
+		return 0
+	}
+	count := 0
+	for _, s := range m.shards {
+		s.mu.Lock()
+		for id, e := range s.sessions {
+			sess := e.Value.(*session)
+			if owns(id) {
+				continue
+			}
+			if err := m.snapshots.SaveSnapshot(id, sess.fsm.Snapshot()); err != nil {
+				log.Printf("Error: Session '%s' could not be handed off: %v\n", id, err)
+				continue
+			}
+			s.remove(id)
+			sess.fsm.Stop()
+			m.forget(sess.fsm)
+			count++
+		}
+		s.mu.Unlock()
+	}
+	return count
+}
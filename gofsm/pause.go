@@ -0,0 +1,109 @@
+package gofsm
+
+import (
+	"errors"
+)
+
+// ErrInstancePaused is returned for the events of a paused machine
+var ErrInstancePaused = errors.New("Error: The instance is paused")
+
+// Audit record kinds of the paused and resumed machines
+const (
+	AuditPaused  = "instance.paused"
+	AuditResumed = "instance.resumed"
+)
+
+// Pause suspends the machine: its timers, timeouts and schedules are
+// stopped, as well as the ones of its running sub-machine, and events are
+// rejected with ErrInstancePaused until it is resumed
+// Pausing a paused machine does nothing
+func (fsm *Machine) Pause() error {
+	fsm = fsm.root()
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	if fsm.terminated {
+		return ErrInstanceTerminated
+	}
+	if fsm.paused {
+		return nil
+	}
+	fsm.halt()
+	if fsm.child != nil {
+		fsm.child.Pause()
+	}
+	fsm.paused = true
+	fsm.audit(AuditRecord{
+		Kind: AuditPaused,
+		From: fsm.CurrentState.Name,
+		To:   fsm.CurrentState.Name,
+	})
+	return nil
+}
+
+// Resume ends the pause of the machine
+// The timeouts and the delay of the current state count again from now,
+// the schedules start again and the sub-machine is resumed as well
+// Resuming a machine that isn't paused does nothing
+func (fsm *Machine) Resume() error {
+	fsm = fsm.root()
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	if fsm.terminated {
+		return ErrInstanceTerminated
+	}
+	if !fsm.paused {
+		return nil
+	}
+	fsm.paused = false
+	fsm.stopped = false
+	if fsm.child != nil {
+		if err := fsm.child.Resume(); err != nil {
+			return err
+		}
+	}
+	fsm.scheduleTimeouts("")
+	if err := fsm.startSchedules(); err != nil {
+		return err
+	}
+	if fsm.CurrentState.After != "" {
+		if err := fsm.scheduleAfter(Event{}); err != nil {
+			return err
+		}
+	}
+	fsm.audit(AuditRecord{
+		Kind: AuditResumed,
+		From: fsm.CurrentState.Name,
+		To:   fsm.CurrentState.Name,
+	})
+	return nil
+}
+
+// Paused tells if the machine is paused
+func (fsm *Machine) Paused() bool {
+	fsm = fsm.root()
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	return fsm.paused
+}
+
+// Pause suspends the machine of a session, see Machine.Pause
+func (m *Manager) Pause(id string) error {
+	if _, ok := m.Snapshot(id); !ok {
+		return ErrSessionNotFound
+	}
+	_, err := m.run(id, func(error) {}, func(fsm *Machine) (TransitionResult, error) {
+		return TransitionResult{}, fsm.Pause()
+	})
+	return err
+}
+
+// Resume ends the pause of the machine of a session, see Machine.Resume
+func (m *Manager) Resume(id string) error {
+	if _, ok := m.Snapshot(id); !ok {
+		return ErrSessionNotFound
+	}
+	_, err := m.run(id, func(error) {}, func(fsm *Machine) (TransitionResult, error) {
+		return TransitionResult{}, fsm.Resume()
+	})
+	return err
+}
@@ -0,0 +1,200 @@
+package gofsm
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// PayloadSchema is the subset of JSON Schema used to validate the data of events
+type PayloadSchema struct {
+	Type       string                    `json:"type,omitempty"`
+	Required   []string                  `json:"required,omitempty"`
+	Properties map[string]*PayloadSchema `json:"properties,omitempty"`
+	Items      *PayloadSchema            `json:"items,omitempty"`
+	Enum       []interface{}             `json:"enum,omitempty"`
+	MinLength  *int                      `json:"minLength,omitempty"`
+	MaxLength  *int                      `json:"maxLength,omitempty"`
+	Minimum    *float64                  `json:"minimum,omitempty"`
+	Maximum    *float64                  `json:"maximum,omitempty"`
+	Pattern    string                    `json:"pattern,omitempty"`
+
+	// pattern is the compiled Pattern
+	pattern *regexp.Regexp
+}
+
+// payloadSchemaAlias has the fields of PayloadSchema without its JSON methods
+type payloadSchemaAlias PayloadSchema
+
+// UnmarshalJSON decodes a schema and compiles its pattern
+func (s *PayloadSchema) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*payloadSchemaAlias)(s)); err != nil {
+		return err
+	}
+	return s.Check()
+}
+
+// Check reports the problems of the schema itself, such as unknown types
+func (s *PayloadSchema) Check() error {
+	switch s.Type {
+	case "", "object", "array", "string", "number", "integer", "boolean", "null":
+	default:
+		return fmt.Errorf("unknown type '%s'", s.Type)
+	}
+	if s.Pattern != "" && s.pattern == nil {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern '%s': %v", s.Pattern, err)
+		}
+		s.pattern = re
+	}
+	for name, prop := range s.Properties {
+		if prop == nil {
+			continue
+		}
+		if err := prop.Check(); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	if s.Items != nil {
+		return s.Items.Check()
+	}
+	return nil
+}
+
+// Validate checks a decoded JSON value against the schema
+// Returns nil if the value is valid or ValidationErrors with the path of every problem
+func (s *PayloadSchema) Validate(value interface{}) error {
+	var errs ValidationErrors
+	s.validate("data", value, &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (s *PayloadSchema) validate(path string, value interface{}, errs *ValidationErrors) {
+	add := func(format string, args ...interface{}) {
+		*errs = append(*errs, ValidationError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+	if s.Type != "" && !hasJSONType(value, s.Type) {
+		add("expected %s", s.Type)
+		return
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, allowed := range s.Enum {
+			if fmt.Sprint(allowed) == fmt.Sprint(value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			add("must be one of %v", s.Enum)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				*errs = append(*errs, ValidationError{Path: path + "." + name, Message: "required"})
+			}
+		}
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if item, ok := v[name]; ok && s.Properties[name] != nil {
+				s.Properties[name].validate(path+"."+name, item, errs)
+			}
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, errs)
+			}
+		}
+	case string:
+		length := len([]rune(v))
+		if s.MinLength != nil && length < *s.MinLength {
+			add("shorter than %d characters", *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			add("longer than %d characters", *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			add("does not match '%s'", s.Pattern)
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			add("less than %v", *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			add("greater than %v", *s.Maximum)
+		}
+	}
+}
+
+// hasJSONType reports whether a decoded JSON value has the given JSON Schema type
+func hasJSONType(value interface{}, typ string) bool {
+	switch typ {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return true
+}
+
+// PayloadError is returned when the data of an event doesn't match
+// the payload schema of its transition
+type PayloadError struct {
+	Event  string
+	Errors ValidationErrors
+}
+
+func (e *PayloadError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("Error: Invalid payload for event '%s': %s", e.Event, strings.Join(msgs, ", "))
+}
+
+// checkPayload validates the data of an event against the payload schema of a transition
+func checkPayload(t Transition, event Event) error {
+	if t.PayloadSchema == nil {
+		return nil
+	}
+	// Events without data are checked as an empty object
+	data := event.Data
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	if err := t.PayloadSchema.Validate(data); err != nil {
+		return &PayloadError{Event: event.Action, Errors: err.(ValidationErrors)}
+	}
+	return nil
+}
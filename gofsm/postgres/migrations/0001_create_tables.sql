@@ -0,0 +1,40 @@
+-- Definitions are kept as uploaded, the other tables hold the sessions
+CREATE TABLE definitions (
+    tenant     TEXT NOT NULL,
+    name       TEXT NOT NULL,
+    data       BYTEA NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (tenant, name)
+);
+
+CREATE TABLE snapshots (
+    tenant     TEXT NOT NULL,
+    id         TEXT NOT NULL,
+    data       JSONB NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (tenant, id)
+);
+
+-- The row of an instance is locked while one of its events is processed
+CREATE TABLE instances (
+    tenant     TEXT NOT NULL,
+    id         TEXT NOT NULL,
+    state      TEXT NOT NULL DEFAULT '',
+    started_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (tenant, id)
+);
+
+CREATE TABLE events (
+    seq        BIGSERIAL PRIMARY KEY,
+    tenant     TEXT NOT NULL,
+    machine    TEXT NOT NULL,
+    kind       TEXT NOT NULL,
+    event_id   TEXT NOT NULL,
+    event      TEXT NOT NULL,
+    param      TEXT NOT NULL,
+    from_state TEXT NOT NULL,
+    to_state   TEXT NOT NULL,
+    error      TEXT NOT NULL,
+    time       TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1 @@
+CREATE INDEX events_machine ON events (tenant, machine, seq);
@@ -0,0 +1,183 @@
+//go:build integration
+
+// The tests run against the database of JSONFSM_POSTGRES_URL, e.g.
+// JSONFSM_POSTGRES_URL=postgres://localhost/jsonfsm_test?sslmode=disable go test -tags integration ./gofsm/postgres
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ditek/jsonfsm/gofsm"
+)
+
+const definition = `{
+	"initialState": "IDLE",
+	"states": [
+		{"name": "IDLE", "action": "Log", "waitForEvent": true},
+		{"name": "RUNNING", "action": "Log", "waitForEvent": true}
+	],
+	"transitions": [
+		{"from": "IDLE", "toSuccess": "RUNNING", "event": "START"},
+		{"from": "RUNNING", "toSuccess": "IDLE", "event": "STOP"}
+	]
+}`
+
+// open returns the store of a tenant unique to the test
+func open(t *testing.T) *Store {
+	url := os.Getenv("JSONFSM_POSTGRES_URL")
+	if url == "" {
+		t.Skip("JSONFSM_POSTGRES_URL is not set")
+	}
+	db, err := Open(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db.Tenant(fmt.Sprintf("%s-%d", t.Name(), time.Now().UnixNano()))
+}
+
+// replica returns a session manager sharing the store like a server would
+func replica(t *testing.T, s *Store) *gofsm.Manager {
+	def, err := gofsm.LoadDefinition([]byte(definition))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := gofsm.NewManager(func() (*gofsm.Machine, error) {
+		return gofsm.NewMachine(def), nil
+	}, gofsm.WithLocker(s, s))
+	m.OnCommit(s)
+	m.OnAudit(s)
+	return m
+}
+
+func TestMigrationsAreApplied(t *testing.T) {
+	s := open(t)
+	// Opening an up to date database applies nothing
+	if err := migrate(s.db); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := migrationFiles.ReadDir("migrations")
+	if n != len(files) {
+		t.Fatalf("%d migrations applied, want %d", n, len(files))
+	}
+}
+
+func TestDefinitions(t *testing.T) {
+	s := open(t)
+	if err := s.SaveDefinition("alarm", []byte(definition)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := s.Definition("alarm")
+	if err != nil || string(data) != definition {
+		t.Fatalf("definition %q, %v", data, err)
+	}
+	names, err := s.Definitions()
+	if err != nil || len(names) != 1 || names[0] != "alarm" {
+		t.Fatalf("definitions %v, %v", names, err)
+	}
+	if err := s.DeleteDefinition("alarm"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Definition("alarm"); err != gofsm.ErrNotFound {
+		t.Fatalf("deleted definition: %v", err)
+	}
+}
+
+func TestLockExcludesOtherReplicas(t *testing.T) {
+	s := open(t)
+	unlock, err := s.Lock(context.Background(), "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another replica has its own connection to the database
+	other, err := Open(os.Getenv("JSONFSM_POSTGRES_URL"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := other.Tenant(s.tenant).Lock(ctx, "s1"); err == nil {
+		t.Fatal("the lock was taken twice")
+	}
+	if err := unlock(); err != nil {
+		t.Fatal(err)
+	}
+	unlock, err = other.Tenant(s.tenant).Lock(context.Background(), "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+}
+
+func TestReplicasShareSessions(t *testing.T) {
+	s := open(t)
+	a, b := replica(t, s), replica(t, s)
+	if _, err := a.SendEvent(gofsm.Event{Session: "s1", Action: "START"}); err != nil {
+		t.Fatal(err)
+	}
+	// The other replica resumes the session from the database
+	if _, err := b.SendEvent(gofsm.Event{Session: "s1", Action: "STOP"}); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := s.LoadSnapshot("s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.CurrentState != "IDLE" {
+		t.Fatalf("state %s, want IDLE", snap.CurrentState)
+	}
+	instances, err := s.Instances()
+	if err != nil || len(instances) != 1 || instances[0].State != "IDLE" {
+		t.Fatalf("instances %v, %v", instances, err)
+	}
+	events, err := s.Events("s1")
+	if err != nil || len(events) == 0 {
+		t.Fatalf("events %v, %v", events, err)
+	}
+}
+
+func TestConcurrentEventsAreSerialized(t *testing.T) {
+	s := open(t)
+	a, b := replica(t, s), replica(t, s)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		m := a
+		if i%2 == 1 {
+			m = b
+		}
+		wg.Add(1)
+		go func(m *gofsm.Manager, action string) {
+			defer wg.Done()
+			m.SendEvent(gofsm.Event{Session: "s1", Action: action})
+		}(m, []string{"START", "STOP"}[i%2])
+	}
+	wg.Wait()
+	// Every event was processed on the state the previous one saved
+	var accepted int
+	events, err := s.Events("s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range events {
+		if e.Kind == gofsm.AuditTransition {
+			accepted++
+		}
+	}
+	snap, err := s.LoadSnapshot("s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[bool]string{true: "IDLE", false: "RUNNING"}[accepted%2 == 0]; snap.CurrentState != want {
+		t.Fatalf("state %s after %d transitions, want %s", snap.CurrentState, accepted, want)
+	}
+}
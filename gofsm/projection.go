@@ -0,0 +1,131 @@
+package gofsm
+
+import (
+	"log"
+	"sync"
+)
+
+// ProjectionRecord is a committed transition with the event that caused it
+// and the context of the machine once the transition was taken
+type ProjectionRecord struct {
+	TransitionRecord
+	EventID string                 `json:"eventId,omitempty"`
+	Param   string                 `json:"param,omitempty"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+	Context map[string]interface{} `json:"context,omitempty"`
+}
+
+// Projection maintains a read model, e.g. a table of the orders by status,
+// from the committed transitions of machines
+// Projections are called in the order of the transitions while the machine
+// is locked, wrap slow ones in an AsyncProjection
+type Projection interface {
+	Project(record ProjectionRecord) error
+}
+
+// ProjectionFunc adapts a function to a Projection
+type ProjectionFunc func(record ProjectionRecord) error
+
+// Project calls the function
+func (f ProjectionFunc) Project(record ProjectionRecord) error {
+	return f(record)
+}
+
+// OnCommit registers a projection for the committed transitions of the machine
+// The transitions of an event, or of a timer, are committed together once
+// the machine has processed it, including the transitions of the events
+// emitted by its actions
+func (fsm *Machine) OnCommit(projection Projection) {
+	fsm = fsm.root()
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	fsm.projections = append(fsm.projections, projection)
+}
+
+// OnCommit registers a projection for the committed transitions of the
+// machines of all sessions
+// Only sessions created afterwards are affected
+func (m *Manager) OnCommit(projection Projection) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.projections = append(m.projections, projection)
+}
+
+// stage keeps a transition until the step that took it is committed
+func (fsm *Machine) stage(record TransitionRecord, event Event) {
+	if len(fsm.projections) == 0 && len(fsm.afterHooks) == 0 {
+		return
+	}
+	fsm.staged = append(fsm.staged, ProjectionRecord{
+		TransitionRecord: record,
+		EventID:          event.ID,
+		Param:            event.Param,
+		Data:             event.Data,
+		Context:          copyContext(fsm.Context),
+	})
+}
+
+// commit sends the staged transitions to the projections, then to the
+// AfterTransition hooks
+// A projection that fails doesn't undo the transitions, which already took
+// place, so the failure is logged
+func (fsm *Machine) commit() {
+	staged := fsm.staged
+	fsm.staged = nil
+	for _, record := range staged {
+		for _, projection := range fsm.projections {
+			if err := projection.Project(record); err != nil {
+				log.Printf("Error: Projection of the transition of machine '%s' from '%s' to '%s' failed: %v\n",
+					record.Machine, record.From, record.To, err)
+			}
+		}
+		for _, hook := range fsm.afterHooks {
+			hook(record.TransitionRecord)
+		}
+	}
+}
+
+// AsyncProjection feeds a projection from a buffered queue on its own
+// goroutine, so slow read models don't hold the machines up
+// The records keep their order. When the queue is full, the machines wait
+// for the projection to catch up rather than losing records
+type AsyncProjection struct {
+	projection Projection
+	queue      chan ProjectionRecord
+	wg         sync.WaitGroup
+}
+
+var _ Projection = (*AsyncProjection)(nil)
+
+// NewAsyncProjection starts feeding a projection from a queue of the given size
+func NewAsyncProjection(projection Projection, buffer int) *AsyncProjection {
+	p := &AsyncProjection{projection: projection, queue: make(chan ProjectionRecord, buffer)}
+	p.wg.Add(1)
+	go p.run()
+	return p
+}
+
+// Project queues a record for the projection
+func (p *AsyncProjection) Project(record ProjectionRecord) error {
+	p.queue <- record
+	return nil
+}
+
+// run feeds the queued records to the projection until the queue is closed
+func (p *AsyncProjection) run() {
+	defer p.wg.Done()
+	for record := range p.queue {
+		if err := p.projection.Project(record); err != nil {
+			log.Printf("Error: Projection of the transition of machine '%s' from '%s' to '%s' failed: %v\n",
+				record.Machine, record.From, record.To, err)
+		}
+	}
+}
+
+// Close waits for the queued records to be projected
+// The machines must not commit transitions afterwards
+func (p *AsyncProjection) Close() error {
+	close(p.queue)
+	p.wg.Wait()
+	return nil
+}
@@ -0,0 +1,216 @@
+package gofsm
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+// ErrQueueFull is returned when the event queue of a session has no room for an event
+var ErrQueueFull = errors.New("Error: The event queue of the session is full")
+
+// ErrEventDropped is the audited reason of the events dropped from a full queue
+var ErrEventDropped = errors.New("Error: Event dropped from the full queue of the session")
+
+// OverflowPolicy decides what happens to an event sent to a full queue
+type OverflowPolicy string
+
+const (
+	// OverflowBlock waits for room in the queue, failing with ErrQueueFull after the timeout
+	OverflowBlock OverflowPolicy = "block"
+	// OverflowReject fails with ErrQueueFull at once
+	OverflowReject OverflowPolicy = "reject"
+	// OverflowDropOldest drops the oldest queued event of the lowest priority to make room
+	OverflowDropOldest OverflowPolicy = "dropOldest"
+)
+
+// QueueStats counts the outcomes of the events sent to the queues
+type QueueStats struct {
+	// Pending is the number of events waiting in the queues
+	Pending   int    `json:"pending"`
+	Enqueued  uint64 `json:"enqueued"`
+	Processed uint64 `json:"processed"`
+	// Blocked counts the events that waited for room, TimedOut the ones that gave up
+	Blocked  uint64 `json:"blocked"`
+	TimedOut uint64 `json:"timedOut"`
+	Rejected uint64 `json:"rejected"`
+	Dropped  uint64 `json:"dropped"`
+}
+
+// eventQueue holds the events of a session waiting to be processed
+type eventQueue struct {
+	events []Event
+	// room is closed when an event leaves the queue, to wake the blocked senders
+	room chan struct{}
+}
+
+// insert queues an event behind the events of the same or a higher priority
+// and ahead of the others, so the events of a priority keep their order
+func (q *eventQueue) insert(event Event) {
+	i := len(q.events)
+	for i > 0 && q.events[i-1].Priority < event.Priority {
+		i--
+	}
+	q.events = append(q.events, Event{})
+	copy(q.events[i+1:], q.events[i:])
+	q.events[i] = event
+}
+
+// dropOldest removes the oldest event of the lowest priority, so the priority
+// events are the last to be dropped
+func (q *eventQueue) dropOldest() Event {
+	lowest := q.events[len(q.events)-1].Priority
+	i := len(q.events) - 1
+	for i > 0 && q.events[i-1].Priority == lowest {
+		i--
+	}
+	dropped := q.events[i]
+	q.events = append(q.events[:i], q.events[i+1:]...)
+	return dropped
+}
+
+// WithEventQueue bounds the queue of each session used by Enqueue to size
+// events and sets what happens to the events sent to a full queue
+// The timeout only applies to OverflowBlock
+// Without it, the queues are unbounded
+func WithEventQueue(size int, policy OverflowPolicy, timeout time.Duration) ManagerOption {
+	return func(m *Manager) {
+		m.queueSize = size
+		m.overflow = policy
+		m.queueTimeout = timeout
+	}
+}
+
+// ValidOverflowPolicy tells if a policy is known
+func ValidOverflowPolicy(policy OverflowPolicy) bool {
+	switch policy {
+	case OverflowBlock, OverflowReject, OverflowDropOldest:
+		return true
+	}
+	return false
+}
+
+// Enqueue queues an event for its session and returns without waiting for it
+// The events of a session are processed in order by SendEvent, one at a
+// time, on a goroutine that lives while the queue isn't empty. Their results
+// are only visible in the audit trail and the transitions
+// Events of a higher priority jump ahead of the queued events of lower
+// priority. They don't interrupt the event being processed: its macrostep
+// runs to completion, including the internal events it emits, and the
+// priority event is processed next
+// When the queue is full, the overflow policy applies
+func (m *Manager) Enqueue(event Event) error {
+	key := event.Session
+	if key == "" {
+		// Correlated events are queued by key, the machine is found when they are processed
+		key = "\x00" + event.CorrelationKey
+	}
+	m.queuesMu.Lock()
+	q, ok := m.queues[key]
+	if !ok {
+		q = &eventQueue{room: make(chan struct{})}
+		m.queues[key] = q
+		go m.drain(key, q)
+	}
+	if m.queueSize > 0 && len(q.events) >= m.queueSize {
+		switch m.overflow {
+		case OverflowDropOldest:
+			m.queueStats.Dropped++
+			if event.Priority < q.events[len(q.events)-1].Priority {
+				// Every queued event is more urgent than this one
+				m.queuesMu.Unlock()
+				m.AuditRejected(event, ErrEventDropped)
+				return nil
+			}
+			defer m.AuditRejected(q.dropOldest(), ErrEventDropped)
+		case OverflowBlock:
+			if err := m.waitForRoom(q); err != nil {
+				m.queuesMu.Unlock()
+				m.AuditRejected(event, err)
+				return err
+			}
+		default:
+			m.queueStats.Rejected++
+			m.queuesMu.Unlock()
+			m.AuditRejected(event, ErrQueueFull)
+			return ErrQueueFull
+		}
+	}
+	q.insert(event)
+	m.queueStats.Enqueued++
+	m.queuesMu.Unlock()
+	return nil
+}
+
+// waitForRoom waits until the queue has room or the timeout expires
+// queuesMu is held when it is called and when it returns
+func (m *Manager) waitForRoom(q *eventQueue) error {
+	m.queueStats.Blocked++
+	timer := time.NewTimer(m.queueTimeout)
+	defer timer.Stop()
+	for len(q.events) >= m.queueSize {
+		room := q.room
+		m.queuesMu.Unlock()
+		select {
+		case <-room:
+			m.queuesMu.Lock()
+		case <-timer.C:
+			m.queuesMu.Lock()
+			m.queueStats.TimedOut++
+			return ErrQueueFull
+		}
+	}
+	return nil
+}
+
+// drain processes the events of a queue until it is empty, then forgets it
+func (m *Manager) drain(key string, q *eventQueue) {
+	for {
+		m.queuesMu.Lock()
+		if len(q.events) == 0 {
+			delete(m.queues, key)
+			m.queuesMu.Unlock()
+			return
+		}
+		event := q.events[0]
+		q.events = q.events[1:]
+		close(q.room)
+		q.room = make(chan struct{})
+		m.queuesMu.Unlock()
+
+		// Rejected events are audited by SendEvent
+		if _, err := m.SendEvent(event); err != nil {
+			log.Println(err)
+		}
+		m.queuesMu.Lock()
+		m.queueStats.Processed++
+		m.queuesMu.Unlock()
+	}
+}
+
+// QueueDepths returns the number of events waiting in the queue of each
+// session, correlation keys being prefixed with "correlation:"
+func (m *Manager) QueueDepths() map[string]int {
+	m.queuesMu.Lock()
+	defer m.queuesMu.Unlock()
+	depths := make(map[string]int, len(m.queues))
+	for key, q := range m.queues {
+		if strings.HasPrefix(key, "\x00") {
+			key = "correlation:" + key[1:]
+		}
+		depths[key] = len(q.events)
+	}
+	return depths
+}
+
+// QueueStats returns the outcomes of the events sent to the queues so far
+func (m *Manager) QueueStats() QueueStats {
+	m.queuesMu.Lock()
+	defer m.queuesMu.Unlock()
+	stats := m.queueStats
+	for _, q := range m.queues {
+		stats.Pending += len(q.events)
+	}
+	return stats
+}
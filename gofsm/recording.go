@@ -0,0 +1,283 @@
+package gofsm
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Kinds of the steps of a recording
+const (
+	// StepInit is the initialization of the machine
+	StepInit = "init"
+	// StepEvent is an event sent to the machine
+	StepEvent = "event"
+	// StepTimer is a delayed transition, a timeout or a scheduled event
+	StepTimer = "timer"
+	// StepCompletion is the result of an asynchronous action
+	StepCompletion = "completion"
+	// StepRestore is the restore of the machine from a snapshot, e.g. once
+	// evicted, which starts its timers again
+	StepRestore = "restore"
+)
+
+// Recording is the sequence of steps of a machine captured by a Recorder,
+// which fsmtest.Replay replays to check that a definition still takes the
+// same path
+type Recording struct {
+	Machine    string `json:"machine"`
+	Tenant     string `json:"tenant,omitempty"`
+	Definition string `json:"definition,omitempty"`
+	// StartedAt is the time of the first step
+	StartedAt time.Time `json:"startedAt"`
+	// Start is the machine the recording started from, restored from a
+	// snapshot, unless the recording started with its initialization
+	Start *Snapshot      `json:"start,omitempty"`
+	Steps []RecordedStep `json:"steps"`
+}
+
+// RecordedStep is a step of a machine, with the actions it ran and the states
+// it entered
+type RecordedStep struct {
+	Kind string `json:"kind"`
+	// Elapsed is the time since the previous step, or since StartedAt
+	Elapsed string `json:"elapsed,omitempty"`
+	// Event is the event of the step, the one of the timer for timers
+	Event *Event `json:"event,omitempty"`
+	// Result is the result of the asynchronous action of a completion
+	Result *ActionResult `json:"result,omitempty"`
+	// Snapshot is the snapshot of a restore
+	Snapshot *Snapshot        `json:"snapshot,omitempty"`
+	Actions  []RecordedAction `json:"actions,omitempty"`
+	// Path lists the states entered during the step
+	Path []string `json:"path"`
+	// Error is set if the step failed, e.g. an event without transition
+	Error string `json:"error,omitempty"`
+}
+
+// RecordedAction is the result of an action run during a step
+type RecordedAction struct {
+	State   string `json:"state"`
+	Action  string `json:"action"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+	// Pending is set for the actions that completed later, see Machine.Pending
+	Pending bool `json:"pending,omitempty"`
+}
+
+// Recorder writes the steps of machines to a directory, one JSON file per
+// machine named after it, in a subdirectory for each tenant. The files are
+// rewritten after each step, so the recording is meant for staging or for
+// a sample of the traffic rather than for every production machine
+// The events of the recordings are kept with their data
+type Recorder struct {
+	dir string
+
+	mu         sync.Mutex
+	recordings map[string]*Recording
+	// last is the time of the last step of each recording
+	last map[string]time.Time
+	// done holds the recordings of the completed machines, which are not
+	// kept in memory anymore
+	done map[string]bool
+}
+
+// NewRecorder creates a recorder writing to the given directory, creating it if needed
+func NewRecorder(dir string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Recorder{
+		dir:        dir,
+		recordings: map[string]*Recording{},
+		last:       map[string]time.Time{},
+		done:       map[string]bool{},
+	}, nil
+}
+
+// WithRecorder records the steps of the machine with the given recorder
+// Sub-machines are part of the steps of their parent and aren't recorded
+func WithRecorder(recorder *Recorder) Option {
+	return func(fsm *Machine) {
+		fsm.recorder = recorder
+	}
+}
+
+// LoadRecording reads a recording file
+func LoadRecording(fileName string) (*Recording, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	rec := &Recording{}
+	if err := json.Unmarshal(data, rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// path returns the file of the recording of a machine
+func (r *Recorder) path(tenant, machine string) string {
+	name := machine
+	if name == "" {
+		name = "default"
+	}
+	dir := r.dir
+	if tenant != "" {
+		dir = filepath.Join(dir, url.PathEscape(tenant))
+	}
+	return filepath.Join(dir, url.PathEscape(name)+".json")
+}
+
+// stepRecording is the step of a machine being recorded
+type stepRecording struct {
+	step RecordedStep
+	// before is the machine before the step if it starts the recording
+	before *Snapshot
+}
+
+// beginRecording starts recording a step of the machine
+func (fsm *Machine) beginRecording(kind string, event Event) {
+	r := fsm.recorder
+	if r == nil {
+		return
+	}
+	rec := &stepRecording{step: RecordedStep{Kind: kind, Path: []string{}}}
+	if kind != StepInit {
+		e := event
+		rec.step.Event = &e
+		r.mu.Lock()
+		path := r.path(fsm.Tenant, fsm.ID)
+		_, recording := r.recordings[path]
+		done := r.done[path]
+		r.mu.Unlock()
+		if done {
+			return
+		}
+		if !recording && fsm.CurrentState.Name != "" {
+			// The machine was neither initialized nor restored while recorded
+			snap := fsm.snapshot()
+			rec.before = &snap
+		}
+	}
+	fsm.recording = rec
+}
+
+// endRecording adds the recorded step to the recording of the machine
+func (fsm *Machine) endRecording(err error) {
+	rec := fsm.recording
+	if rec == nil {
+		return
+	}
+	fsm.recording = nil
+	if err != nil {
+		rec.step.Error = err.Error()
+	}
+	fsm.recorder.add(fsm, rec.step, rec.before)
+}
+
+// recordRestore records the restore of the machine from a snapshot
+// A recording can start with a restore, e.g. of a machine saved before the
+// server restarted
+func (fsm *Machine) recordRestore(snap Snapshot) {
+	if fsm.recorder != nil {
+		fsm.recorder.add(fsm, RecordedStep{Kind: StepRestore, Path: []string{}, Snapshot: &snap}, nil)
+	}
+}
+
+// add adds a step to the recording of a machine and writes it
+// A recording starts with the initialization or a restore of the machine,
+// or from start for the other steps
+func (r *Recorder) add(fsm *Machine, step RecordedStep, start *Snapshot) {
+	path := r.path(fsm.Tenant, fsm.ID)
+	now := fsm.clock().Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	recording := r.recordings[path]
+	if step.Kind == StepInit {
+		// The session may have been created again
+		recording = nil
+		delete(r.done, path)
+	}
+	if r.done[path] {
+		return
+	}
+	if recording == nil {
+		if step.Kind == StepRestore {
+			start = step.Snapshot
+		}
+		recording = &Recording{Machine: fsm.ID, Tenant: fsm.Tenant, StartedAt: now, Start: start, Steps: []RecordedStep{}}
+		if fsm.Metadata != nil {
+			recording.Definition = fsm.Metadata.Name
+		}
+		r.recordings[path] = recording
+		r.last[path] = now
+	}
+	// The restore starting the recording is its start rather than a step
+	if step.Kind != StepRestore || recording.Start != step.Snapshot {
+		if elapsed := now.Sub(r.last[path]); elapsed > 0 {
+			step.Elapsed = elapsed.String()
+		}
+		r.last[path] = now
+		recording.Steps = append(recording.Steps, step)
+	}
+	if fsm.CurrentState.Final || fsm.terminated {
+		// Completed machines don't take any step anymore
+		delete(r.recordings, path)
+		delete(r.last, path)
+		r.done[path] = true
+	}
+	data, err := json.MarshalIndent(recording, "", "    ")
+	if err == nil {
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+			err = writeFile(path, data)
+		}
+	}
+	if err != nil {
+		log.Printf("Error: Cannot record the step of machine '%s': %v\n", fsm.ID, err)
+	}
+}
+
+// recordAction adds the result of an action to the recorded step
+func (fsm *Machine) recordAction(call ActionCall) {
+	if fsm.recording == nil || call.Action == "" {
+		return
+	}
+	action := RecordedAction{State: call.State, Action: call.Action, Success: call.Success}
+	if call.Err != nil {
+		action.Error = call.Err.Error()
+	}
+	fsm.recording.step.Actions = append(fsm.recording.step.Actions, action)
+}
+
+// recordTransition adds the state entered to the recorded step
+func (fsm *Machine) recordTransition(to string) {
+	if fsm.recording != nil {
+		fsm.recording.step.Path = append(fsm.recording.step.Path, to)
+	}
+}
+
+// recordPending marks the actions of the current state as pending
+func (fsm *Machine) recordPending() {
+	if fsm.recording == nil {
+		return
+	}
+	for i := range fsm.recording.step.Actions {
+		action := &fsm.recording.step.Actions[i]
+		if action.State == fsm.CurrentState.Name && action.Success {
+			action.Pending = true
+		}
+	}
+}
+
+// recordResult keeps the result of the asynchronous action of a completion
+func (fsm *Machine) recordResult(result ActionResult) {
+	if fsm.recording != nil {
+		fsm.recording.step.Result = &result
+	}
+}
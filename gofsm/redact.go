@@ -0,0 +1,103 @@
+package gofsm
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Redacted replaces the values of the sensitive fields in the logs, the
+// traces and the audit records
+const Redacted = "[REDACTED]"
+
+// redact replaces the values of the sensitive fields of the definition in a
+// text to emit, the fields being taken from the last event of the machine and
+// from its context
+// Objects and arrays have each of their values redacted
+func (fsm *Machine) redact(s string) string {
+	return fsm.redactEvent(fsm.lastEvent, s)
+}
+
+// redactEvent replaces the values of the sensitive fields of an event and of
+// the context in a text to emit, e.g. for an event rejected without a step
+func (fsm *Machine) redactEvent(event Event, s string) string {
+	if fsm.Definition == nil || len(fsm.Sensitive) == 0 || s == "" {
+		return s
+	}
+	var values []string
+	for _, sel := range fsm.Sensitive {
+		if value, err := fsm.selectValue(sel, event); err == nil {
+			values = appendLeaves(values, value)
+		}
+	}
+	// Longer values first, so a value containing another is redacted whole
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	for _, value := range values {
+		s = strings.ReplaceAll(s, value, Redacted)
+	}
+	return s
+}
+
+// appendLeaves appends the strings and numbers of a JSON value
+func appendLeaves(values []string, value interface{}) []string {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for _, v := range value {
+			values = appendLeaves(values, v)
+		}
+	case []interface{}:
+		for _, v := range value {
+			values = appendLeaves(values, v)
+		}
+	case string:
+		if value != "" {
+			values = append(values, value)
+		}
+	case nil, bool:
+	default:
+		if data, err := json.Marshal(value); err == nil {
+			values = append(values, string(data))
+		}
+	}
+	return values
+}
+
+// logf logs a message about the payload of the machine, e.g. an action
+// argument, with the values of the sensitive fields redacted
+func (fsm *Machine) logf(format string, args ...interface{}) {
+	log.Print(fsm.redact(fmt.Sprintf(format, args...)))
+}
+
+// redactRejected redacts an event refused before it reached its machine with
+// the sensitive fields of the definition of its session: the definition of
+// the machine if it is in memory, or else the one of the new sessions
+// The context of the machine isn't read, it may be processing another event
+func (m *Manager) redactRejected(event Event, s string) string {
+	var def *Definition
+	shard := m.shard(event.Session)
+	shard.mu.Lock()
+	if e, ok := shard.sessions[event.Session]; ok {
+		def = e.Value.(*session).fsm.Definition
+	}
+	shard.mu.Unlock()
+	if def == nil {
+		def = m.newDefinition()
+	}
+	return (&Machine{Definition: def, machine: &machine{}}).redactEvent(event, s)
+}
+
+// newDefinition returns the definition of the machines of the new sessions,
+// nil without factory
+func (m *Manager) newDefinition() *Definition {
+	m.defOnce.Do(func() {
+		if m.factory == nil {
+			return
+		}
+		if fsm, err := m.factory(); err == nil {
+			m.def = fsm.Definition
+		}
+	})
+	return m.def
+}
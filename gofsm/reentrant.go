@@ -0,0 +1,24 @@
+package gofsm
+
+// reenter queues an event sent to the machine by one of its running actions
+// through its handle as an internal event, like Emit, and tells if it did
+// The action runs while the machine is locked, so the event can't be
+// processed right away without corrupting the transition in progress
+// The goroutines started by the action send with the handle too, their
+// events are processed like the other events once the action returned
+func (fsm *Machine) reenter(event Event) (bool, error) {
+	if fsm.call == nil {
+		return false, nil
+	}
+	owner := fsm.call.owner
+	owner.actionMu.Lock()
+	defer owner.actionMu.Unlock()
+	switch {
+	case fsm.call.abandoned:
+		return false, ErrActionAbandoned
+	case fsm.call.done:
+		return false, nil
+	}
+	owner.queue = append(owner.queue, fsm.onBehalf(event))
+	return true, nil
+}
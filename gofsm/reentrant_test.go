@@ -0,0 +1,90 @@
+package gofsm
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestReentrantSendEvent(t *testing.T) {
+	actions := NewDefaultActionRegistry()
+	actions.Register("Send", func(fsm *Machine, arg string) bool {
+		// The handle queues the events of the goroutines the action waits for
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := fsm.SendEvent(Event{Action: "first"}); err != nil {
+				t.Error(err)
+			}
+		}()
+		wg.Wait()
+		if _, err := fsm.SendEvent(Event{Action: "second"}); err != nil {
+			t.Error(err)
+		}
+		return true
+	})
+	def, err := LoadDefinition([]byte(`{
+		"initialState": "IDLE",
+		"states": [
+			{"name": "IDLE", "action": "Send", "waitForEvent": true},
+			{"name": "A", "action": "Log", "waitForEvent": true},
+			{"name": "B", "action": "Log", "waitForEvent": true},
+			{"name": "C", "action": "Log", "waitForEvent": true}
+		],
+		"transitions": [
+			{"from": "IDLE", "toSuccess": "A", "event": "start"},
+			{"from": "A", "toSuccess": "B", "event": "first"},
+			{"from": "B", "toSuccess": "C", "event": "second"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsm := NewMachine(def, WithActions(actions))
+	fsm.Init()
+	result, err := fsm.SendEvent(Event{Action: "start"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"A", "B", "C"}; !reflect.DeepEqual(result.Path, want) {
+		t.Errorf("Got path %v, want %v", result.Path, want)
+	}
+}
+
+func TestSendEventAfterActionReturned(t *testing.T) {
+	handles := make(chan *Machine, 1)
+	actions := NewDefaultActionRegistry()
+	actions.Register("Keep", func(fsm *Machine, arg string) bool {
+		handles <- fsm
+		return true
+	})
+	def, err := LoadDefinition([]byte(`{
+		"initialState": "IDLE",
+		"states": [
+			{"name": "IDLE", "action": "Keep", "waitForEvent": true},
+			{"name": "A", "action": "Log", "waitForEvent": true},
+			{"name": "B", "action": "Log", "waitForEvent": true}
+		],
+		"transitions": [
+			{"from": "IDLE", "toSuccess": "A", "event": "start"},
+			{"from": "A", "toSuccess": "B", "event": "next"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsm := NewMachine(def, WithActions(actions))
+	fsm.Init()
+	if _, err := fsm.SendEvent(Event{Action: "start"}); err != nil {
+		t.Fatal(err)
+	}
+	// The handle kept by the action sends like the machine once it returned
+	result, err := (<-handles).SendEvent(Event{Action: "next"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ToState != "B" || fsm.Snapshot().CurrentState != "B" {
+		t.Errorf("Got state %s, want B", result.ToState)
+	}
+}
@@ -0,0 +1,116 @@
+package gofsm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Codes of the reasons an event was rejected
+const (
+	// RejectedFinalized is an event sent to a machine in a final state, or terminated
+	RejectedFinalized = "finalized"
+	// RejectedNoTransitions is an event sent to a state without any transition
+	RejectedNoTransitions = "no_transitions"
+	// RejectedUnknownEvent is an event no transition of the machine is for
+	RejectedUnknownEvent = "unknown_event"
+	// RejectedWrongState is an event whose transitions are all from other states
+	RejectedWrongState = "wrong_state"
+	// RejectedGuardFailed is an event whose transitions from the state all
+	// have a guard that is false
+	RejectedGuardFailed = "guard_failed"
+)
+
+// RejectionReason tells why a machine had no transition for an event
+type RejectionReason struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+	Event string `json:"event,omitempty"`
+	// Guards are the guards that were false, for RejectedGuardFailed
+	Guards []string `json:"guards,omitempty"`
+	// States are the states with a transition for the event, for RejectedWrongState
+	States  []string `json:"states,omitempty"`
+	Message string   `json:"message"`
+}
+
+// Rejection returns the reason of an error returned for a rejected event,
+// e.g. by SendEvent, or false if the event wasn't rejected for lack of a
+// transition or because the machine is terminated
+func Rejection(err error) (*RejectionReason, bool) {
+	var noTransition *ErrNoTransition
+	if errors.As(err, &noTransition) && noTransition.Reason != nil {
+		return noTransition.Reason, true
+	}
+	if errors.Is(err, ErrInstanceTerminated) {
+		return &RejectionReason{Code: RejectedFinalized, Message: "the machine is terminated"}, true
+	}
+	return nil, false
+}
+
+// noTransition returns the error of an event without a transition from the
+// current state, the event name being empty for eventless transitions
+func (fsm *Machine) noTransition(eventName string) *ErrNoTransition {
+	return &ErrNoTransition{State: fsm.CurrentState.Name, Event: eventName, Reason: fsm.rejectionReason(eventName)}
+}
+
+// rejectionReason finds why no transition of the current state matched an event
+func (fsm *Machine) rejectionReason(eventName string) *RejectionReason {
+	state := fsm.CurrentState.Name
+	reason := &RejectionReason{State: state, Event: eventName}
+	fromState, forEvent := false, false
+	seen := map[string]bool{}
+	for _, t := range fsm.Transitions {
+		switch {
+		case t.From == state && t.Event == eventName:
+			if t.Guard != "" {
+				reason.Guards = append(reason.Guards, t.Guard)
+			}
+			fromState, forEvent = true, true
+		case t.From == state:
+			fromState = true
+		case t.Event == eventName:
+			forEvent = true
+			if !seen[t.From] {
+				seen[t.From] = true
+				reason.States = append(reason.States, t.From)
+			}
+		}
+	}
+	describe := fmt.Sprintf("event '%s'", eventName)
+	if eventName == "" {
+		describe = "eventless transition"
+	}
+	switch {
+	case fsm.CurrentState.Final || fsm.terminated:
+		reason.Code = RejectedFinalized
+		reason.Message = fmt.Sprintf("the machine is done, state '%s' is final", state)
+		if fsm.terminated {
+			reason.Message = "the machine is terminated"
+		}
+		reason.Guards, reason.States = nil, nil
+	case len(reason.Guards) > 0:
+		reason.Code = RejectedGuardFailed
+		reason.Message = fmt.Sprintf("the guards of the transitions from state '%s' for %s are false", state, describe)
+		reason.States = nil
+	case !fromState:
+		reason.Code = RejectedNoTransitions
+		reason.Message = fmt.Sprintf("state '%s' has no transition", state)
+		reason.States = nil
+	case !forEvent && eventName != "" && !fsm.declaresEvent(eventName):
+		reason.Code = RejectedUnknownEvent
+		reason.Message = fmt.Sprintf("the machine has no transition for %s", describe)
+	default:
+		reason.Code = RejectedWrongState
+		reason.Message = fmt.Sprintf("state '%s' has no transition for %s", state, describe)
+	}
+	return reason
+}
+
+// declaresEvent tells if an event is listed in the events of the definition
+func (fsm *Machine) declaresEvent(name string) bool {
+	for _, event := range fsm.Events {
+		if event == name {
+			return true
+		}
+	}
+	return false
+}
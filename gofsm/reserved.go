@@ -0,0 +1,115 @@
+package gofsm
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Reserved events, handled by the machine itself unless the definition sets
+// 'reservedEvents' to false
+const (
+	// EventReset returns the machine to its initial state and context, after
+	// leaving the current state like an abort does: its timers are stopped,
+	// its sub-machine is aborted and its compensations are run
+	EventReset = "_reset"
+	// EventNoop does nothing, e.g. for health checks, and is not audited
+	EventNoop = "_noop"
+	// EventTick is sent every 'tick' interval of the definition, it takes the
+	// transitions of the current state for it and is ignored otherwise
+	EventTick = "_tick"
+)
+
+// reservedEvents are the events the transitions can't be for
+var reservedEvents = map[string]bool{EventReset: true, EventNoop: true}
+
+// ReservesEvents tells if the machines of the definition handle the reserved events
+func (def *Definition) ReservesEvents() bool {
+	return def.ReservedEvents == nil || *def.ReservedEvents
+}
+
+// reserved returns the reserved event handled by the machine, empty for the other events
+func (fsm *Machine) reserved(event Event) string {
+	switch event.Action {
+	case EventReset, EventNoop, EventTick:
+		if fsm.ReservesEvents() {
+			return event.Action
+		}
+	}
+	return ""
+}
+
+// dispatchReserved handles a reserved event
+// Its sender is checked like for a transition, with the 'resetRoles' of the
+// definition for '_reset' and the roles of the transition for '_tick'
+func (fsm *Machine) dispatchReserved(event Event) error {
+	switch event.Action {
+	case EventReset:
+		if err := fsm.checkRoles(Transition{Roles: fsm.ResetRoles}, event); err != nil {
+			return err
+		}
+		return fsm.resetMachine(event)
+	case EventTick:
+		i, err := fsm.matchTransition(EventTick, event)
+		if err != nil {
+			return err
+		}
+		if i >= 0 {
+			if err := fsm.checkRoles(fsm.Transitions[i], event); err != nil {
+				return err
+			}
+			return fsm.beginTransition(i, event)
+		}
+	}
+	if fsm.result != nil && fsm.microsteps == 0 {
+		fsm.result.Ignored = true
+	}
+	return nil
+}
+
+// resetMachine leaves the current state like an abort does, then enters the
+// initial state with the initial context
+// The schedules keep running
+func (fsm *Machine) resetMachine(event Event) error {
+	fsm.cancelTimer()
+	fsm.cancelTimeouts()
+	if fsm.child != nil {
+		// The child compensates its own steps
+		fsm.child.Abort("reset")
+		fsm.child = nil
+	}
+	fsm.compensate(event)
+	fsm.Context = copyContext(fsm.InitialContext)
+	fsm.initVariables()
+	log.Printf("Resetting machine '%s' from state '%s'\n", fsm.ID, fsm.CurrentState.Name)
+	// The reset counts as a transition of the step, e.g. for StepBack
+	fsm.microsteps++
+	return fsm.SetState(fsm.InitialState, event)
+}
+
+// scheduleTick arms the timer of the next tick, after the schedules
+func (fsm *Machine) scheduleTick(interval time.Duration) {
+	i := len(fsm.Schedules)
+	fsm.scheduleTimers[i] = fsm.clock().AfterFunc(interval, func() {
+		if _, err := fsm.SendEvent(Event{Action: EventTick, scheduled: true}); err != nil {
+			log.Println(err)
+		}
+		fsm.mu.Lock()
+		defer fsm.mu.Unlock()
+		if !fsm.stopped {
+			fsm.scheduleTick(interval)
+		}
+	})
+}
+
+// tickInterval returns the interval of the ticks of the definition, 0 without ticks
+func (def *Definition) tickInterval() (time.Duration, error) {
+	if def.Tick == "" || !def.ReservesEvents() {
+		return 0, nil
+	}
+	interval, err := time.ParseDuration(def.Tick)
+	if err != nil || interval <= 0 {
+		return 0, fmt.Errorf("Error: Invalid tick interval '%s'", def.Tick)
+	}
+	return interval, nil
+}
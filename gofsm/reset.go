@@ -0,0 +1,58 @@
+package gofsm
+
+import "time"
+
+// AuditReset is the kind of the audit records of reset machines, marking
+// where their new run starts in the audit trail
+const AuditReset = "instance.reset"
+
+// Reset returns the machine to its initial state and context like the
+// '_reset' event, for operators recovering a machine
+// Unlike the event, it also applies to paused and terminated machines, which
+// run again afterwards
+// The transition to the initial state is notified for the '_reset' event, and
+// the steps kept for StepBack are dropped unless keepHistory is set, in which
+// case the reset is one of them
+func (fsm *Machine) Reset(keepHistory bool) error {
+	fsm = fsm.root()
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	from := fsm.CurrentState.Name
+	halted := fsm.paused || fsm.terminated
+	fsm.paused, fsm.terminated = false, false
+	fsm.abortReason, fsm.abortedAt = "", time.Time{}
+	event := Event{Action: EventReset, internal: true}
+	err := fsm.runToCompletion(StepEvent, event, func() error {
+		return fsm.resetMachine(event)
+	})
+	if !keepHistory {
+		fsm.history = nil
+	}
+	if halted {
+		fsm.stopped = false
+		if err := fsm.startSchedules(); err != nil {
+			return err
+		}
+	}
+	record := AuditRecord{
+		Kind: AuditReset,
+		From: from,
+		To:   fsm.CurrentState.Name,
+	}
+	if keepHistory {
+		record.Param = "keepHistory"
+	}
+	fsm.audit(record)
+	return err
+}
+
+// Reset returns the machine of a session to its initial state, see Machine.Reset
+func (m *Manager) Reset(id string, keepHistory bool) error {
+	if _, ok := m.Snapshot(id); !ok {
+		return ErrSessionNotFound
+	}
+	_, err := m.run(id, func(error) {}, func(fsm *Machine) (TransitionResult, error) {
+		return TransitionResult{}, fsm.Reset(keepHistory)
+	})
+	return err
+}
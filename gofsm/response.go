@@ -0,0 +1,111 @@
+package gofsm
+
+import (
+	"fmt"
+	"net/http"
+	"text/template"
+)
+
+// ResponseTemplate describes the reply of a state or a transition to the
+// sender of the event
+// The strings of the body are text templates with access to .Event,
+// .Param, .Data, .State and .Context
+type ResponseTemplate struct {
+	// Status is the HTTP status code, 200 by default
+	Status int `json:"status,omitempty"`
+	// Body is any JSON value
+	Body interface{} `json:"body,omitempty"`
+}
+
+// responseData is the data available to the response templates
+type responseData struct {
+	Event   string
+	Param   string
+	Data    map[string]interface{}
+	State   string
+	Context map[string]interface{}
+}
+
+// respondWithTemplate renders a response template and sends it to the sender of the event
+func (fsm *Machine) respondWithTemplate(tmpl *ResponseTemplate, event Event) error {
+	data := responseData{
+		Event:   event.Action,
+		Param:   event.Param,
+		Data:    event.Data,
+		State:   fsm.CurrentState.Name,
+		Context: fsm.Context,
+	}
+	body, err := renderBody(tmpl.Body, data)
+	if err != nil {
+		return fmt.Errorf("Error: Invalid response template in state '%s': %v", fsm.CurrentState.Name, err)
+	}
+	status := tmpl.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	fsm.Respond(status, body)
+	return nil
+}
+
+// renderBody renders the strings of a JSON value, other values are kept as they are
+func renderBody(body interface{}, data interface{}) (interface{}, error) {
+	switch b := body.(type) {
+	case string:
+		return renderTemplate(b, data)
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(b))
+		for k, v := range b {
+			r, err := renderBody(v, data)
+			if err != nil {
+				return nil, err
+			}
+			rendered[k] = r
+		}
+		return rendered, nil
+	case []interface{}:
+		rendered := make([]interface{}, len(b))
+		for i, v := range b {
+			r, err := renderBody(v, data)
+			if err != nil {
+				return nil, err
+			}
+			rendered[i] = r
+		}
+		return rendered, nil
+	}
+	return body, nil
+}
+
+// checkResponse returns the problems of a response template of a definition
+func checkResponse(response map[string]interface{}) []string {
+	var problems []string
+	if status, ok := response["status"].(float64); ok && (status < 100 || status > 599 || status != float64(int(status))) {
+		problems = append(problems, fmt.Sprintf("invalid status %v", status))
+	}
+	if err := parseBody(response["body"]); err != nil {
+		problems = append(problems, err.Error())
+	}
+	return problems
+}
+
+// parseBody checks the syntax of the templates of a JSON value
+func parseBody(body interface{}) error {
+	switch b := body.(type) {
+	case string:
+		_, err := template.New("").Parse(b)
+		return err
+	case map[string]interface{}:
+		for _, v := range b {
+			if err := parseBody(v); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, v := range b {
+			if err := parseBody(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
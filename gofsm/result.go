@@ -0,0 +1,83 @@
+package gofsm
+
+// Outcome is the result of the action run by a transition
+type Outcome string
+
+// Action outcomes
+const (
+	// OutcomeNone means that no action was run
+	OutcomeNone    Outcome = "none"
+	OutcomeSuccess Outcome = "success"
+	OutcomeFailure Outcome = "failure"
+	// OutcomeError means that the action is missing or panicked
+	OutcomeError Outcome = "error"
+)
+
+// TransitionResult describes what an event did to a machine
+type TransitionResult struct {
+	FromState string `json:"fromState"`
+	ToState   string `json:"toState"`
+	// ActionOutcome is the outcome of the action run by the event's transition
+	ActionOutcome Outcome `json:"actionOutcome"`
+	// Path lists the states entered in order, including the ones left
+	// right away by eventless transitions
+	Path []string `json:"path"`
+	// Microsteps is the number of transitions taken to process the event,
+	// including the ones of the internal events it caused
+	Microsteps int `json:"microsteps"`
+	// Ignored is set when the event had no transition and the definition
+	// ignores the unknown events
+	Ignored bool `json:"ignored,omitempty"`
+	// Response is the reply of the actions to the sender of the event, if any
+	Response *Response `json:"response,omitempty"`
+}
+
+// Response is a reply to the sender of an event
+// The HTTP server writes it as the JSON response to the request
+type Response struct {
+	Code int         `json:"code"`
+	Body interface{} `json:"body"`
+}
+
+// Respond sets the reply to the sender of the event being processed
+// Only the first response of an event is kept, and events that don't
+// come from a sender, e.g. timers and schedules, have nobody to reply to
+// The responses of abandoned actions are dropped
+func (fsm *Machine) Respond(code int, body interface{}) {
+	// Actions running in parallel may respond at the same time
+	fsm.actionMu.Lock()
+	defer fsm.actionMu.Unlock()
+	if fsm.abandoned() || fsm.result == nil || fsm.result.Response != nil {
+		return
+	}
+	fsm.result.Response = &Response{Code: code, Body: body}
+}
+
+// RespondWithError sets an error reply to the sender of the event being processed
+func (fsm *Machine) RespondWithError(code int, msg string) {
+	fsm.Respond(code, map[string]string{"error": msg})
+}
+
+// recordState adds the current state to the path of the event being processed
+func (fsm *Machine) recordState() {
+	if fsm.result != nil {
+		fsm.result.Path = append(fsm.result.Path, fsm.CurrentState.Name)
+	}
+}
+
+// recordOutcome keeps the outcome of the first action run by the event being processed
+func (fsm *Machine) recordOutcome(success bool, err error) {
+	if fsm.result == nil || fsm.result.ActionOutcome != "" {
+		return
+	}
+	switch {
+	case err != nil:
+		fsm.result.ActionOutcome = OutcomeError
+	case fsm.CurrentState.Action == "" && fsm.CurrentState.Script == nil && len(fsm.CurrentState.Actions) == 0:
+		fsm.result.ActionOutcome = OutcomeNone
+	case success:
+		fsm.result.ActionOutcome = OutcomeSuccess
+	default:
+		fsm.result.ActionOutcome = OutcomeFailure
+	}
+}
@@ -0,0 +1,80 @@
+package gofsm
+
+import (
+	"log"
+	"time"
+)
+
+// Retention limits the audit records an event store keeps for each machine
+type Retention struct {
+	// MaxEvents is the number of records kept per machine, all of them if 0
+	MaxEvents int
+	// MaxAge is how long the records are kept, forever if 0
+	MaxAge time.Duration
+}
+
+// HistoryStore keeps the audit records of the machines as their history,
+// e.g. a database
+type HistoryStore interface {
+	// Exceeding returns the machines with records beyond the retention
+	Exceeding(r Retention, now time.Time) ([]string, error)
+	// Truncate removes the records of a machine beyond the retention, oldest
+	// first, and returns how many were removed
+	Truncate(machine string, r Retention, now time.Time) (int, error)
+}
+
+// compaction is the history compacted at regular intervals, see WithCompaction
+type compaction struct {
+	store     HistoryStore
+	retention Retention
+	interval  time.Duration
+}
+
+// WithCompaction compacts the history of the machines in the store at every
+// interval, see Manager.CompactHistory
+func WithCompaction(store HistoryStore, r Retention, interval time.Duration) ManagerOption {
+	return func(m *Manager) {
+		m.compaction = &compaction{store: store, retention: r, interval: interval}
+	}
+}
+
+// CompactHistory compacts the history of each machine whose records exceed
+// the retention: the snapshot of the machine is saved first to the snapshot
+// store of the manager, so the state the removed records led to is kept, then
+// its oldest records are removed
+// Machines the manager doesn't know, e.g. of previous runs, keep their last
+// snapshot
+// Returns the number of records removed
+func (m *Manager) CompactHistory(store HistoryStore, r Retention) (int, error) {
+	now := time.Now()
+	machines, err := store.Exceeding(r, now)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, id := range machines {
+		if snap, ok := m.Snapshot(id); ok && m.snapshots != nil {
+			if err := m.snapshots.SaveSnapshot(id, snap); err != nil {
+				return removed, err
+			}
+		}
+		n, err := store.Truncate(id, r, now)
+		removed += n
+		if err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// watchHistory compacts the history at every interval of the compaction
+func (m *Manager) watchHistory(c *compaction) {
+	for range time.Tick(c.interval) {
+		n, err := m.CompactHistory(c.store, c.retention)
+		if err != nil {
+			log.Printf("Error: Cannot compact the event history: %v\n", err)
+		} else if n > 0 {
+			log.Printf("Removed %d events beyond the retention\n", n)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package gofsm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrForbidden is matched by errors.Is for the events whose sender has none
+// of the roles of the transition
+var ErrForbidden = errors.New("Error: Forbidden")
+
+// forbiddenError names the event, its state and the roles it needs
+type forbiddenError struct {
+	event string
+	state string
+	roles []string
+}
+
+func (e forbiddenError) Error() string {
+	return fmt.Sprintf("Error: The event '%s' needs one of the roles '%s' in state '%s'",
+		e.event, strings.Join(e.roles, "', '"), e.state)
+}
+
+func (e forbiddenError) Is(target error) bool {
+	return target == ErrForbidden
+}
+
+// checkRoles rejects an event whose sender has none of the roles of the
+// transition
+// The events of the timers, of the schedules, of the completed tasks and of
+// the other instances are sent by the machines themselves, so they are not
+// checked. Internal events are checked with the roles of the event they were
+// emitted for
+func (fsm *Machine) checkRoles(t Transition, event Event) error {
+	if len(t.Roles) == 0 || event.internal || event.scheduled || event.task || event.sentBy != "" {
+		return nil
+	}
+	for _, role := range t.Roles {
+		for _, r := range event.Roles {
+			if r == role {
+				return nil
+			}
+		}
+	}
+	return forbiddenError{event: event.Action, state: fsm.CurrentState.Name, roles: t.Roles}
+}
@@ -0,0 +1,124 @@
+package gofsm
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// rolesDefinition emits 'approve' once started, which needs the admin role
+const rolesDefinition = `{
+	"initialState": "IDLE",
+	"states": [
+		{"name": "IDLE", "action": "Emit", "waitForEvent": true},
+		{"name": "PENDING", "action": "Log", "waitForEvent": true},
+		{"name": "APPROVED", "action": "Log", "waitForEvent": true}
+	],
+	"transitions": [
+		{"from": "IDLE", "toSuccess": "PENDING", "event": "start"},
+		{"from": "PENDING", "toSuccess": "APPROVED", "event": "approve", "roles": ["admin"]}
+	]
+}`
+
+func TestInternalEventsInheritRoles(t *testing.T) {
+	tests := []struct {
+		roles []string
+		state string
+		err   error
+	}{
+		{roles: []string{"admin"}, state: "APPROVED"},
+		{roles: []string{"viewer"}, state: "PENDING", err: ErrForbidden},
+		{state: "PENDING", err: ErrForbidden},
+	}
+	for _, test := range tests {
+		fsm := newEmitMachine(t, rolesDefinition)
+		_, err := fsm.SendEvent(Event{Action: "start", Param: "approve", Roles: test.roles})
+		if !errors.Is(err, test.err) {
+			t.Errorf("Roles %v: got error %v, want %v", test.roles, err, test.err)
+		}
+		if fsm.CurrentState.Name != test.state {
+			t.Errorf("Roles %v: got state %s, want %s", test.roles, fsm.CurrentState.Name, test.state)
+		}
+	}
+}
+
+func TestInternalEventsOfTimeoutsNotChecked(t *testing.T) {
+	fsm := newEmitMachine(t, `{
+		"initialState": "IDLE",
+		"states": [
+			{"name": "IDLE", "action": "Emit", "waitForEvent": true,
+				"timeouts": [{"after": "1ms", "event": "start", "param": "approve"}]},
+			{"name": "PENDING", "action": "Log", "waitForEvent": true},
+			{"name": "APPROVED", "action": "Log", "waitForEvent": true}
+		],
+		"transitions": [
+			{"from": "IDLE", "toSuccess": "PENDING", "event": "start"},
+			{"from": "PENDING", "toSuccess": "APPROVED", "event": "approve", "roles": ["admin"]}
+		]
+	}`)
+	defer fsm.Stop()
+	deadline := time.Now().Add(5 * time.Second)
+	for fsm.Snapshot().CurrentState != "APPROVED" {
+		if time.Now().After(deadline) {
+			t.Fatalf("Got state %s, want APPROVED", fsm.Snapshot().CurrentState)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestResetRoles(t *testing.T) {
+	tests := []struct {
+		roles []string
+		state string
+		err   error
+	}{
+		{roles: []string{"admin"}, state: "IDLE"},
+		{roles: []string{"viewer"}, state: "PENDING", err: ErrForbidden},
+		{state: "PENDING", err: ErrForbidden},
+	}
+	for _, test := range tests {
+		fsm := newEmitMachine(t, `{
+			"initialState": "IDLE",
+			"resetRoles": ["admin"],
+			"states": [
+				{"name": "IDLE", "action": "Log", "waitForEvent": true},
+				{"name": "PENDING", "action": "Log", "waitForEvent": true}
+			],
+			"transitions": [
+				{"from": "IDLE", "toSuccess": "PENDING", "event": "start"}
+			]
+		}`)
+		if _, err := fsm.SendEvent(Event{Action: "start"}); err != nil {
+			t.Fatal(err)
+		}
+		_, err := fsm.SendEvent(Event{Action: EventReset, Roles: test.roles})
+		if !errors.Is(err, test.err) {
+			t.Errorf("Roles %v: got error %v, want %v", test.roles, err, test.err)
+		}
+		if fsm.CurrentState.Name != test.state {
+			t.Errorf("Roles %v: got state %s, want %s", test.roles, fsm.CurrentState.Name, test.state)
+		}
+	}
+}
+
+func TestTickRoles(t *testing.T) {
+	fsm := newEmitMachine(t, `{
+		"initialState": "IDLE",
+		"states": [
+			{"name": "IDLE", "action": "Log", "waitForEvent": true},
+			{"name": "CHECKED", "action": "Log", "waitForEvent": true}
+		],
+		"transitions": [
+			{"from": "IDLE", "toSuccess": "CHECKED", "event": "_tick", "roles": ["admin"]}
+		]
+	}`)
+	if _, err := fsm.SendEvent(Event{Action: EventTick}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Got error %v, want %v", err, ErrForbidden)
+	}
+	if _, err := fsm.SendEvent(Event{Action: EventTick, Roles: []string{"admin"}}); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.CurrentState.Name != "CHECKED" {
+		t.Errorf("Got state %s, want CHECKED", fsm.CurrentState.Name)
+	}
+}
@@ -0,0 +1,60 @@
+package gofsm
+
+import "log"
+
+// ContextCompensations is the context key of the completed states that have a
+// compensation, in the order they completed
+// Keeping them in the context lets snapshots resume a saga
+const ContextCompensations = "compensations"
+
+// completeStep records that the current state completed, if it has a compensation
+func (fsm *Machine) completeStep() {
+	if fsm.CurrentState.Compensation == "" {
+		return
+	}
+	if fsm.Context == nil {
+		fsm.Context = map[string]interface{}{}
+	}
+	// Snapshots share the slice, so it is copied
+	steps := append([]interface{}{}, fsm.completedSteps()...)
+	fsm.Context[ContextCompensations] = append(steps, fsm.CurrentState.Name)
+}
+
+// completedSteps returns the completed states waiting for their compensation
+func (fsm *Machine) completedSteps() []interface{} {
+	steps, _ := fsm.Context[ContextCompensations].([]interface{})
+	return steps
+}
+
+// compensate runs the compensations of the completed states in reverse order
+// and forgets them
+// A compensation that fails doesn't stop the others, so every completed
+// state gets a chance to undo its work
+func (fsm *Machine) compensate(event Event) {
+	steps := fsm.completedSteps()
+	delete(fsm.Context, ContextCompensations)
+	for i := len(steps) - 1; i >= 0; i-- {
+		name, _ := steps[i].(string)
+		state, err := fsm.GetState(name)
+		if err != nil {
+			log.Printf("Error: Cannot compensate state '%s': %v\n", name, err)
+			continue
+		}
+		e := event
+		e.Param = state.CompensationArg
+		if isSelector(state.CompensationArg) {
+			if e.Param, err = fsm.resolveSelector(state.CompensationArg, event); err != nil {
+				log.Printf("Error: Cannot compensate state '%s': %v\n", name, err)
+				continue
+			}
+		}
+		success, err := fsm.callNamedAction(state.Compensation, e)
+		fsm.notifyAction(state.Compensation, e, success, err)
+		switch {
+		case err != nil:
+			log.Printf("Error: Compensation of state '%s' failed: %v\n", name, err)
+		case !success:
+			log.Printf("Error: Compensation '%s' of state '%s' failed\n", state.Compensation, name)
+		}
+	}
+}
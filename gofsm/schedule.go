@@ -0,0 +1,80 @@
+package gofsm
+
+import (
+	"fmt"
+	"log"
+)
+
+// Schedule injects an event into the machine at the times given by a cron expression
+type Schedule struct {
+	Cron  string `json:"cron"`
+	Event string `json:"event"`
+	Param string `json:"param,omitempty"`
+}
+
+// startSchedules starts a timer for every schedule of the definition
+// The timer of the ticks comes after them
+func (fsm *Machine) startSchedules() error {
+	tick, err := fsm.tickInterval()
+	if err != nil {
+		return err
+	}
+	n := len(fsm.Schedules)
+	if tick > 0 {
+		n++
+	}
+	fsm.scheduleTimers = make([]Timer, n)
+	for i, s := range fsm.Schedules {
+		cron, err := ParseCron(s.Cron)
+		if err != nil {
+			return fmt.Errorf("Error: Invalid schedule '%s': %v", s.Cron, err)
+		}
+		fsm.scheduleNext(i, cron)
+	}
+	if tick > 0 {
+		fsm.scheduleTick(tick)
+	}
+	return nil
+}
+
+// scheduleNext arms the timer of the next activation of a schedule
+func (fsm *Machine) scheduleNext(i int, cron *CronSchedule) {
+	now := fsm.clock().Now()
+	next := cron.Next(now)
+	if next.IsZero() {
+		return
+	}
+	s := fsm.Schedules[i]
+	fsm.scheduleTimers[i] = fsm.clock().AfterFunc(next.Sub(now), func() {
+		event := Event{Action: s.Event, Param: s.Param, scheduled: true}
+		if _, err := fsm.SendEvent(event); err != nil {
+			log.Println(err)
+		}
+		fsm.mu.Lock()
+		defer fsm.mu.Unlock()
+		if !fsm.stopped {
+			fsm.scheduleNext(i, cron)
+		}
+	})
+}
+
+// Stop stops the scheduled events and the pending timer of the machine
+func (fsm *Machine) Stop() {
+	fsm = fsm.root()
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	fsm.halt()
+}
+
+// halt stops the scheduled events and the pending timers of the locked machine
+func (fsm *Machine) halt() {
+	fsm.stopped = true
+	for _, t := range fsm.scheduleTimers {
+		if t != nil {
+			t.Stop()
+		}
+	}
+	fsm.scheduleTimers = nil
+	fsm.cancelTimer()
+	fsm.cancelTimeouts()
+}
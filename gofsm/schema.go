@@ -0,0 +1,698 @@
+package gofsm
+
+import (
+	// Needed for the embedded schema
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Schema is the JSON Schema of the definition format
+//
+//go:embed schema.json
+var Schema []byte
+
+// ValidationError describes a problem at a given path of a definition
+type ValidationError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// ValidationErrors is the list of problems found in a definition
+type ValidationErrors []ValidationError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Field types of the definition format
+const (
+	typeString  = "string"
+	typeBool    = "boolean"
+	typeInteger = "integer"
+	typeObject  = "object"
+	typeArray   = "array"
+	typeStrings = "object of strings"
+	typeList    = "array of strings"
+	typeAction  = "string or script object"
+	typeAny     = "any value"
+)
+
+var definitionFields = map[string]string{
+	"version":        typeString,
+	"schemaVersion":  typeInteger,
+	"extends":        typeString,
+	"metadata":       typeObject,
+	"initialState":   typeString,
+	"expectedCode":   typeString,
+	"errorState":     typeString,
+	"dedupWindow":    typeString,
+	"maxMicrosteps":  typeInteger,
+	"unknownEvents":  typeString,
+	"context":        typeObject,
+	"variables":      typeArray,
+	"states":         typeArray,
+	"transitions":    typeArray,
+	"events":         typeArray,
+	"schedules":      typeArray,
+	"tick":           typeString,
+	"reservedEvents": typeBool,
+	"resetRoles":     typeArray,
+	"sensitive":      typeArray,
+}
+
+// identifier matches the variable names that can be used in expressions
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// reservedNames are taken by the expression variables and literals
+var reservedNames = map[string]bool{
+	"ctx": true, "event": true, "state": true, "expectedCode": true,
+	"true": true, "false": true, "nil": true, "null": true,
+}
+
+var metadataFields = map[string]string{
+	"name":        typeString,
+	"description": typeString,
+	"owner":       typeString,
+	"labels":      typeStrings,
+}
+
+var variableFields = map[string]string{
+	"name":    typeString,
+	"type":    typeString,
+	"default": typeAny,
+}
+
+var stateFields = map[string]string{
+	"name":            typeString,
+	"action":          typeAction,
+	"actions":         typeList,
+	"actionMode":      typeString,
+	"actionArg":       typeString,
+	"args":            typeStrings,
+	"waitForEvent":    typeBool,
+	"sendResponse":    typeBool,
+	"after":           typeString,
+	"timeouts":        typeArray,
+	"invoke":          typeString,
+	"final":           typeBool,
+	"correlationKey":  typeString,
+	"response":        typeObject,
+	"compensation":    typeString,
+	"compensationArg": typeString,
+	"compensate":      typeBool,
+	"humanTask":       typeObject,
+	"sla":             typeString,
+	"actionTimeout":   typeString,
+}
+
+var humanTaskFields = map[string]string{
+	"title":    typeString,
+	"assignee": typeString,
+	"form":     typeObject,
+	"due":      typeString,
+	"event":    typeString,
+}
+
+var timeoutFields = map[string]string{
+	"after": typeString,
+	"event": typeString,
+	"param": typeString,
+}
+
+var responseFields = map[string]string{
+	"status": typeInteger,
+}
+
+var scheduleFields = map[string]string{
+	"cron":  typeString,
+	"event": typeString,
+	"param": typeString,
+}
+
+var sendToFields = map[string]string{
+	"instance": typeString,
+	"event":    typeString,
+	"param":    typeString,
+}
+
+var transitionFields = map[string]string{
+	"from":          typeString,
+	"toSuccess":     typeString,
+	"toFailure":     typeString,
+	"branch":        typeBool,
+	"event":         typeString,
+	"guard":         typeString,
+	"outcomes":      typeStrings,
+	"choice":        typeString,
+	"action":        typeString,
+	"actionArg":     typeString,
+	"payloadSchema": typeObject,
+	"response":      typeObject,
+	"roles":         typeArray,
+	"sendTo":        typeObject,
+}
+
+// ValidateSchema checks a JSON definition against the definition format
+// Returns nil if the definition is valid or ValidationErrors with the
+// path of every problem, e.g. "transitions[2].toSuccess: unknown state 'Foo'"
+func ValidateSchema(data []byte) error {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return ValidationErrors{{Message: "invalid JSON: " + err.Error()}}
+	}
+	v := &validator{}
+	if !upgradeDocument(v, doc) {
+		return v.errs
+	}
+	v.checkFields("", doc, definitionFields)
+	v.require("", doc, "initialState", "states", "transitions")
+	if base, ok := doc["extends"].(string); ok {
+		// Extend merges the base and removes the field
+		v.add("extends", fmt.Sprintf("base definition '%s' is not merged", base))
+	}
+	v.checkIncludes("", doc)
+	if metadata, ok := doc["metadata"].(map[string]interface{}); ok {
+		v.checkFields("metadata", metadata, metadataFields)
+	}
+	states := v.objects("states", doc["states"])
+	transitions := v.objects("transitions", doc["transitions"])
+	if events, ok := doc["events"].([]interface{}); ok {
+		for i, e := range events {
+			if _, ok := e.(string); !ok {
+				v.add(fmt.Sprintf("events[%d]", i), "expected string")
+			}
+		}
+	}
+
+	if sensitive, ok := doc["sensitive"].([]interface{}); ok {
+		for i, sel := range sensitive {
+			path := fmt.Sprintf("sensitive[%d]", i)
+			if s, ok := sel.(string); !ok {
+				v.add(path, "expected string")
+			} else if _, _, err := parseSelector(s); err != nil || !isSelector(s) {
+				v.add(path, fmt.Sprintf("invalid selector '%s', expected e.g. '$.event.card'", s))
+			}
+		}
+	}
+
+	for i, s := range v.objects("schedules", doc["schedules"]) {
+		path := fmt.Sprintf("schedules[%d]", i)
+		v.checkFields(path, s, scheduleFields)
+		v.require(path, s, "cron", "event")
+		if spec, ok := s["cron"].(string); ok {
+			if _, err := ParseCron(spec); err != nil {
+				v.add(path+".cron", err.Error())
+			}
+		}
+	}
+	if window, ok := doc["dedupWindow"].(string); ok && window != "" {
+		if _, err := time.ParseDuration(window); err != nil {
+			v.add("dedupWindow", fmt.Sprintf("invalid duration '%s'", window))
+		}
+	}
+	if tick, ok := doc["tick"].(string); ok && tick != "" {
+		if d, err := time.ParseDuration(tick); err != nil || d <= 0 {
+			v.add("tick", fmt.Sprintf("invalid duration '%s'", tick))
+		}
+	}
+	if roles, ok := doc["resetRoles"].([]interface{}); ok {
+		for j, role := range roles {
+			if s, ok := role.(string); !ok || s == "" {
+				v.add(fmt.Sprintf("resetRoles[%d]", j), "expected non-empty string")
+			}
+		}
+	}
+	if n, ok := doc["maxMicrosteps"].(float64); ok && n < 1 {
+		v.add("maxMicrosteps", "must be at least 1")
+	}
+	if policy, ok := doc["unknownEvents"].(string); ok && !ValidUnknownEvents(policy) {
+		v.add("unknownEvents", fmt.Sprintf("unknown policy '%s', expected 'error', 'ignore' or 'log'", policy))
+	}
+	v.checkVariables(doc)
+
+	// Check the states and collect their names
+	names := map[string]bool{}
+	for i, s := range states {
+		path := fmt.Sprintf("states[%d]", i)
+		v.checkRenamed(path, s)
+		v.checkFields(path, s, stateFields)
+		v.require(path, s, "name")
+		name, _ := s["name"].(string)
+		if name == "" {
+			continue
+		}
+		if names[name] {
+			v.add(path+".name", fmt.Sprintf("duplicate state '%s'", name))
+		}
+		names[name] = true
+		if script, ok := s["action"].(map[string]interface{}); ok {
+			lang, _ := script["lang"].(string)
+			source, _ := script["script"].(string)
+			if err := (&Script{Lang: lang, Source: source}).Check(); err != nil {
+				v.add(path+".action", err.Error())
+			}
+		}
+		if _, ok := s["actions"]; ok {
+			if _, ok := s["action"]; ok {
+				v.add(path+".actions", "cannot be used with 'action'")
+			}
+		}
+		if mode, ok := s["actionMode"].(string); ok {
+			switch mode {
+			case ActionModeSequential, ActionModeFirstFailure, ActionModeParallel:
+			default:
+				v.add(path+".actionMode", fmt.Sprintf("unknown action mode '%s'", mode))
+			}
+		}
+		if arg, ok := s["actionArg"].(string); ok && isSelector(arg) {
+			if _, _, err := parseSelector(arg); err != nil {
+				v.add(path+".actionArg", err.Error())
+			}
+		}
+		if arg, ok := s["compensationArg"].(string); ok && isSelector(arg) {
+			if _, _, err := parseSelector(arg); err != nil {
+				v.add(path+".compensationArg", err.Error())
+			}
+		}
+		if key, ok := s["correlationKey"].(string); ok {
+			if !isSelector(key) {
+				v.add(path+".correlationKey", fmt.Sprintf("expected a selector such as '$.ctx.orderId', got '%s'", key))
+			} else if _, _, err := parseSelector(key); err != nil {
+				v.add(path+".correlationKey", err.Error())
+			}
+		}
+		for j, timeout := range v.objects(path+".timeouts", s["timeouts"]) {
+			timeoutPath := fmt.Sprintf("%s.timeouts[%d]", path, j)
+			v.checkFields(timeoutPath, timeout, timeoutFields)
+			v.require(timeoutPath, timeout, "after", "event")
+			if after, ok := timeout["after"].(string); ok {
+				if d, err := time.ParseDuration(after); err != nil || d <= 0 {
+					v.add(timeoutPath+".after", fmt.Sprintf("invalid duration '%s'", after))
+				}
+			}
+		}
+		if response, ok := s["response"].(map[string]interface{}); ok {
+			v.checkResponse(path+".response", response)
+		}
+		if task, ok := s["humanTask"].(map[string]interface{}); ok {
+			v.checkHumanTask(path+".humanTask", task)
+			if wait, _ := s["waitForEvent"].(bool); !wait {
+				v.add(path+".waitForEvent", "must be true for a human task")
+			}
+		}
+		if after, ok := s["after"].(string); ok && after != "" {
+			if _, err := time.ParseDuration(after); err != nil {
+				v.add(path+".after", fmt.Sprintf("invalid duration '%s'", after))
+			}
+		}
+		if timeout, ok := s["actionTimeout"].(string); ok {
+			if d, err := time.ParseDuration(timeout); err != nil || d <= 0 {
+				v.add(path+".actionTimeout", fmt.Sprintf("invalid duration '%s'", timeout))
+			}
+		}
+		if sla, ok := s["sla"].(string); ok {
+			if d, err := time.ParseDuration(sla); err != nil || d <= 0 {
+				v.add(path+".sla", fmt.Sprintf("invalid duration '%s'", sla))
+			}
+		}
+	}
+
+	// Check that all references point to existing states
+	v.checkStateRef("initialState", doc["initialState"], names)
+	if _, ok := doc["errorState"]; ok {
+		v.checkStateRef("errorState", doc["errorState"], names)
+	}
+	for i, t := range transitions {
+		path := fmt.Sprintf("transitions[%d]", i)
+		v.checkRenamed(path, t)
+		v.checkFields(path, t, transitionFields)
+		v.require(path, t, "from")
+		v.checkStateRef(path+".from", t["from"], names)
+		if event, _ := t["event"].(string); reservedEvents[event] && doc["reservedEvents"] != false {
+			v.add(path+".event", fmt.Sprintf("'%s' is handled by the machine, set 'reservedEvents' to false to use it", event))
+		}
+		outcomes, _ := t["outcomes"].(map[string]interface{})
+		if _, ok := t["toSuccess"]; ok || len(outcomes) == 0 {
+			v.require(path, t, "toSuccess")
+			v.checkStateRef(path+".toSuccess", t["toSuccess"], names)
+		}
+		keys := make([]string, 0, len(outcomes))
+		for outcome := range outcomes {
+			keys = append(keys, outcome)
+		}
+		sort.Strings(keys)
+		for _, outcome := range keys {
+			if to, ok := outcomes[outcome].(string); ok {
+				v.checkStateRef(fmt.Sprintf("%s.outcomes.%s", path, outcome), to, names)
+			}
+		}
+		if choice, ok := t["choice"].(string); ok {
+			if len(outcomes) == 0 {
+				v.add(path+".choice", "requires 'outcomes'")
+			} else if _, err := parseGuard(choice); err != nil {
+				v.add(path+".choice", err.Error())
+			}
+		}
+		if branch, _ := t["branch"].(bool); branch {
+			if _, ok := t["toFailure"]; !ok {
+				v.add(path+".toFailure", "required when branch is true")
+			}
+		}
+		if _, ok := t["toFailure"]; ok {
+			v.checkStateRef(path+".toFailure", t["toFailure"], names)
+		}
+		if arg, ok := t["actionArg"].(string); ok && isSelector(arg) {
+			if _, _, err := parseSelector(arg); err != nil {
+				v.add(path+".actionArg", err.Error())
+			}
+		}
+		if schema, ok := t["payloadSchema"].(map[string]interface{}); ok {
+			data, _ := json.Marshal(schema)
+			if err := json.Unmarshal(data, &PayloadSchema{}); err != nil {
+				v.add(path+".payloadSchema", err.Error())
+			}
+		}
+		if response, ok := t["response"].(map[string]interface{}); ok {
+			v.checkResponse(path+".response", response)
+		}
+		if guard, ok := t["guard"].(string); ok && guard != "" {
+			if _, err := parseGuard(guard); err != nil {
+				v.add(path+".guard", err.Error())
+			}
+		}
+		if sendTo, ok := t["sendTo"].(map[string]interface{}); ok {
+			v.checkFields(path+".sendTo", sendTo, sendToFields)
+			v.require(path+".sendTo", sendTo, "instance", "event")
+			for _, field := range []string{"instance", "param"} {
+				if arg, ok := sendTo[field].(string); ok && isSelector(arg) {
+					if _, _, err := parseSelector(arg); err != nil {
+						v.add(path+".sendTo."+field, err.Error())
+					}
+				}
+			}
+		}
+		if roles, ok := t["roles"].([]interface{}); ok {
+			for j, role := range roles {
+				if s, ok := role.(string); !ok || s == "" {
+					v.add(fmt.Sprintf("%s.roles[%d]", path, j), "expected non-empty string")
+				}
+			}
+		}
+	}
+	v.checkAutoCycles(states, transitions)
+
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+	return nil
+}
+
+// validator accumulates the errors found in a definition
+type validator struct {
+	errs ValidationErrors
+}
+
+func (v *validator) add(path, msg string) {
+	v.errs = append(v.errs, ValidationError{Path: path, Message: msg})
+}
+
+// join builds the path of a field
+func join(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+// checkIncludes reports the fragments left, which are only included from files
+func (v *validator) checkIncludes(path string, value interface{}) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if name, ok := value[includeKey].(string); ok {
+			v.add(join(path, includeKey), fmt.Sprintf("fragment '%s' is not included", name))
+			return
+		}
+		keys := make([]string, 0, len(value))
+		for k := range value {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			v.checkIncludes(join(path, k), value[k])
+		}
+	case []interface{}:
+		for i, item := range value {
+			v.checkIncludes(fmt.Sprintf("%s[%d]", path, i), item)
+		}
+	}
+}
+
+// checkFields checks the type of the known fields of an object
+func (v *validator) checkFields(path string, obj map[string]interface{}, fields map[string]string) {
+	keys := make([]string, 0, len(fields))
+	for field := range fields {
+		keys = append(keys, field)
+	}
+	sort.Strings(keys)
+	for _, field := range keys {
+		typ := fields[field]
+		value, ok := obj[field]
+		if !ok || value == nil {
+			continue
+		}
+		if !hasType(value, typ) {
+			v.add(join(path, field), "expected "+typ)
+		}
+	}
+}
+
+// require checks that the given fields are present
+func (v *validator) require(path string, obj map[string]interface{}, fields ...string) {
+	for _, field := range fields {
+		if value, ok := obj[field]; !ok || value == nil {
+			v.add(join(path, field), "required")
+		}
+	}
+}
+
+// objects returns the elements of an array of objects
+func (v *validator) objects(path string, value interface{}) []map[string]interface{} {
+	items, _ := value.([]interface{})
+	objs := make([]map[string]interface{}, 0, len(items))
+	for i, item := range items {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			v.add(fmt.Sprintf("%s[%d]", path, i), "expected object")
+			obj = map[string]interface{}{}
+		}
+		objs = append(objs, obj)
+	}
+	return objs
+}
+
+// checkResponse checks the fields, status and templates of a response
+func (v *validator) checkResponse(path string, response map[string]interface{}) {
+	v.checkFields(path, response, responseFields)
+	for _, problem := range checkResponse(response) {
+		v.add(path, problem)
+	}
+}
+
+// checkHumanTask checks the fields, form and due duration of a human task
+func (v *validator) checkHumanTask(path string, task map[string]interface{}) {
+	v.checkFields(path, task, humanTaskFields)
+	v.require(path, task, "event")
+	if assignee, ok := task["assignee"].(string); ok && isSelector(assignee) {
+		if _, _, err := parseSelector(assignee); err != nil {
+			v.add(path+".assignee", err.Error())
+		}
+	}
+	if form, ok := task["form"].(map[string]interface{}); ok {
+		data, _ := json.Marshal(form)
+		if err := json.Unmarshal(data, &PayloadSchema{}); err != nil {
+			v.add(path+".form", err.Error())
+		}
+	}
+	if due, ok := task["due"].(string); ok {
+		if d, err := time.ParseDuration(due); err != nil || d <= 0 {
+			v.add(path+".due", fmt.Sprintf("invalid duration '%s'", due))
+		}
+	}
+}
+
+// checkVariables checks the declared variables and their default value
+// Variables are used in expressions, so their names must be identifiers
+func (v *validator) checkVariables(doc map[string]interface{}) {
+	context, _ := doc["context"].(map[string]interface{})
+	seen := map[string]bool{}
+	for i, decl := range v.objects("variables", doc["variables"]) {
+		path := fmt.Sprintf("variables[%d]", i)
+		v.checkFields(path, decl, variableFields)
+		v.require(path, decl, "name", "type")
+		name, _ := decl["name"].(string)
+		typ, _ := decl["type"].(string)
+		if name != "" {
+			switch {
+			case !identifier.MatchString(name) || reservedNames[name]:
+				v.add(path+".name", fmt.Sprintf("'%s' is not a valid variable name", name))
+			case seen[name]:
+				v.add(path+".name", fmt.Sprintf("duplicate variable '%s'", name))
+			case context[name] != nil:
+				v.add(path+".name", fmt.Sprintf("variable '%s' is also set in context", name))
+			}
+			seen[name] = true
+		}
+		if typ == "" {
+			continue
+		}
+		variable := Variable{Name: name, Type: typ}
+		if _, err := variable.check(variable.initial()); err != nil {
+			v.add(path+".type", err.Error())
+		} else if def, ok := decl["default"]; ok {
+			if _, err := variable.check(def); err != nil {
+				v.add(path+".default", err.Error())
+			}
+		}
+	}
+}
+
+// checkStateRef checks that a value names a defined state
+func (v *validator) checkStateRef(path string, value interface{}, names map[string]bool) {
+	name, ok := value.(string)
+	if !ok || name == "" {
+		return
+	}
+	if !names[name] {
+		v.add(path, fmt.Sprintf("unknown state '%s'", name))
+	}
+}
+
+// hasType reports whether a decoded JSON value has the given type
+func hasType(value interface{}, typ string) bool {
+	switch typ {
+	case typeString:
+		_, ok := value.(string)
+		return ok
+	case typeBool:
+		_, ok := value.(bool)
+		return ok
+	case typeInteger:
+		n, ok := value.(float64)
+		return ok && n == float64(int(n))
+	case typeObject:
+		_, ok := value.(map[string]interface{})
+		return ok
+	case typeArray:
+		_, ok := value.([]interface{})
+		return ok
+	case typeAction:
+		switch value.(type) {
+		case string, map[string]interface{}:
+			return true
+		}
+		return false
+	case typeList:
+		items, ok := value.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, item := range items {
+			if _, ok := item.(string); !ok {
+				return false
+			}
+		}
+		return true
+	case typeStrings:
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for _, v := range obj {
+			if _, ok := v.(string); !ok {
+				return false
+			}
+		}
+		return true
+	}
+	return true
+}
+
+// checkAutoCycles reports loops of transitions that are taken without any event
+// Entering such a loop would never return, so the definition is rejected
+func (v *validator) checkAutoCycles(states, transitions []map[string]interface{}) {
+	// States that take their transition as soon as they are entered
+	auto := map[string]bool{}
+	for _, s := range states {
+		name, _ := s["name"].(string)
+		wait, _ := s["waitForEvent"].(bool)
+		after, _ := s["after"].(string)
+		invoke, _ := s["invoke"].(string)
+		if name != "" && !wait && after == "" && invoke == "" {
+			auto[name] = true
+		}
+	}
+	// Unconditional transitions between those states
+	next := map[string][]string{}
+	for _, t := range transitions {
+		from, _ := t["from"].(string)
+		to, _ := t["toSuccess"].(string)
+		event, _ := t["event"].(string)
+		guard, _ := t["guard"].(string)
+		_, choice := t["outcomes"]
+		if auto[from] && auto[to] && event == "" && guard == "" && !choice {
+			next[from] = append(next[from], to)
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	color := map[string]int{}
+	var path []string
+	var visit func(state string)
+	visit = func(state string) {
+		color[state] = visiting
+		path = append(path, state)
+		for _, to := range next[state] {
+			switch color[to] {
+			case visiting:
+				// Report the loop starting from the state that closes it
+				start := 0
+				for i, s := range path {
+					if s == to {
+						start = i
+					}
+				}
+				loop := append(append([]string{}, path[start:]...), to)
+				v.add("transitions", "states that don't wait for an event loop forever: "+strings.Join(loop, " -> "))
+			case unvisited:
+				visit(to)
+			}
+		}
+		path = path[:len(path)-1]
+		color[state] = done
+	}
+	for _, s := range states {
+		if name, _ := s["name"].(string); auto[name] && color[name] == unvisited {
+			visit(name)
+		}
+	}
+}
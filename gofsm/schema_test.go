@@ -0,0 +1,31 @@
+package gofsm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBadSelectorRejected(t *testing.T) {
+	tests := []struct {
+		name  string
+		def   string
+		field string
+	}{
+		{"v1 state", `{"initialState": "A", "states": [{"name": "A", "action": "Log", "action_arg": "$.bogus[["}], "transitions": []}`, "states[0].actionArg"},
+		{"v1 compensation", `{"initialState": "A", "states": [{"name": "A", "action": "Log", "compensation": "Log", "compensation_arg": "$.bogus[["}], "transitions": []}`, "states[0].compensationArg"},
+		{"v1 transition", `{"initialState": "A", "states": [{"name": "A", "action": "Log"}], "transitions": [{"from": "A", "toSuccess": "A", "event": "go", "action": "Log", "action_arg": "$.bogus[["}]}`, "transitions[0].actionArg"},
+		{"v2 state", `{"schemaVersion": 2, "initialState": "A", "states": [{"name": "A", "action": "Log", "actionArg": "$.bogus[["}], "transitions": []}`, "states[0].actionArg"},
+		{"v2 compensation", `{"schemaVersion": 2, "initialState": "A", "states": [{"name": "A", "action": "Log", "compensation": "Log", "compensationArg": "$.bogus[["}], "transitions": []}`, "states[0].compensationArg"},
+		{"v2 transition", `{"schemaVersion": 2, "initialState": "A", "states": [{"name": "A", "action": "Log"}], "transitions": [{"from": "A", "toSuccess": "A", "event": "go", "action": "Log", "actionArg": "$.bogus[["}]}`, "transitions[0].actionArg"},
+	}
+	for _, test := range tests {
+		_, err := LoadDefinition([]byte(test.def))
+		if err == nil {
+			t.Errorf("%s: the bad selector was loaded", test.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.field) {
+			t.Errorf("%s: got error %v, want one at %s", test.name, err, test.field)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package gofsm
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// SchemaVersion is the current version of the definition format, written by
+// MigrateDefinition. Definitions without 'schemaVersion' are version 1
+const SchemaVersion = 2
+
+// compatLayers convert a definition of a schema version to the next one, the
+// layer of version n being compatLayers[n-1]. Definitions are parsed in the
+// current version once their layers ran, so the parser only knows one format
+// A new version adds the layer converting its predecessor
+var compatLayers = []func(v *validator, doc map[string]interface{}){
+	upgradeV1,
+}
+
+// negotiateVersion returns the schema version of a definition
+// Unknown versions are reported, since nothing else can be checked
+func negotiateVersion(v *validator, doc map[string]interface{}) (int, bool) {
+	value, ok := doc["schemaVersion"]
+	if !ok || value == nil {
+		return 1, true
+	}
+	n, ok := value.(float64)
+	if !ok || n != float64(int(n)) || n < 1 || int(n) > SchemaVersion {
+		text, _ := json.Marshal(value)
+		v.add("schemaVersion", fmt.Sprintf("unsupported version %s, this version of jsonfsm reads versions 1 to %d", text, SchemaVersion))
+		return 0, false
+	}
+	return int(n), true
+}
+
+// upgradeDocument converts a definition to the current schema version in
+// place, with the layers of its version and the following ones
+// Returns false if the version is unknown
+func upgradeDocument(v *validator, doc map[string]interface{}) bool {
+	version, ok := negotiateVersion(v, doc)
+	if !ok {
+		return false
+	}
+	for n := version; n < SchemaVersion; n++ {
+		compatLayers[n-1](v, doc)
+	}
+	return true
+}
+
+// upgradeNames converts a definition to the current schema version in place,
+// leaving the problems to the validation
+func upgradeNames(doc map[string]interface{}) {
+	if upgradeDocument(&validator{}, doc) {
+		doc["schemaVersion"] = float64(SchemaVersion)
+	}
+}
+
+// upgradeDefinition returns a definition converted to the current schema
+// version, so it can be decoded into a Definition
+func upgradeDefinition(data []byte) []byte {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return data
+	}
+	version := doc["schemaVersion"]
+	if !upgradeDocument(&validator{}, doc) {
+		return data
+	}
+	// The definition keeps the version it was written in
+	doc["schemaVersion"] = version
+	upgraded, err := json.Marshal(doc)
+	if err != nil {
+		return data
+	}
+	return upgraded
+}
+
+/******* Version 1 ********/
+
+// renamedFields maps the snake_case fields of the states and transitions of
+// version 1 to their name in version 2, where every field is camelCase
+var renamedFields = map[string]string{
+	"action_arg":       "actionArg",
+	"compensation_arg": "compensationArg",
+}
+
+// upgradeV1 renames the snake_case fields of the states and transitions
+func upgradeV1(v *validator, doc map[string]interface{}) {
+	for _, list := range []string{"states", "transitions"} {
+		items, _ := doc[list].([]interface{})
+		for i, item := range items {
+			obj, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			path := fmt.Sprintf("%s[%d]", list, i)
+			for _, old := range sortedKeys(renamedFields) {
+				name := renamedFields[old]
+				value, ok := obj[old]
+				if !ok {
+					continue
+				}
+				if _, both := obj[name]; both {
+					v.add(join(path, old), fmt.Sprintf("cannot be used with '%s'", name))
+				} else {
+					obj[name] = value
+				}
+				delete(obj, old)
+			}
+		}
+	}
+}
+
+// checkRenamed reports the fields of a state or a transition given under
+// their version 1 name in a later version
+func (v *validator) checkRenamed(path string, obj map[string]interface{}) {
+	for _, old := range sortedKeys(renamedFields) {
+		if _, ok := obj[old]; ok {
+			v.add(join(path, old), fmt.Sprintf("renamed to '%s' in schema version 2", renamedFields[old]))
+		}
+	}
+}
+
+// sortedKeys returns the keys of a map of strings in order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,198 @@
+package gofsm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// Supported script languages
+const (
+	ScriptLua = "lua"
+)
+
+// scriptTimeout bounds the execution time of a script
+const scriptTimeout = 5 * time.Second
+
+// Script is an inline action defined in the state, e.g.
+//
+//	"action": {"lang": "lua", "script": "ctx.retries = (ctx.retries or 0) + 1; return param == '123'"}
+//
+// The script sees the globals 'param', 'event' (action, param, data), 'state' and
+// 'ctx', the FSM context. Changes to 'ctx' are stored back in the context.
+// Returning false makes the action fail, any other value counts as success
+type Script struct {
+	Lang   string `json:"lang"`
+	Source string `json:"script"`
+}
+
+// Check verifies that the script language is supported and that the script compiles
+func (s *Script) Check() error {
+	if s.Lang != ScriptLua {
+		return fmt.Errorf("unsupported script language '%s'", s.Lang)
+	}
+	if _, err := parse.Parse(strings.NewReader(s.Source), "script"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// stateAlias has the fields of State without its JSON methods
+type stateAlias State
+
+// UnmarshalJSON accepts an action given either as a name or as an inline script
+func (s *State) UnmarshalJSON(data []byte) error {
+	aux := struct {
+		*stateAlias
+		Action json.RawMessage `json:"action"`
+	}{stateAlias: (*stateAlias)(s)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	s.Action = ""
+	s.Script = nil
+	if len(aux.Action) == 0 || string(aux.Action) == "null" {
+		return nil
+	}
+	if aux.Action[0] == '{' {
+		s.Script = &Script{}
+		return json.Unmarshal(aux.Action, s.Script)
+	}
+	return json.Unmarshal(aux.Action, &s.Action)
+}
+
+// MarshalJSON writes inline scripts back as the 'action' object
+func (s State) MarshalJSON() ([]byte, error) {
+	var action interface{} = s.Action
+	if s.Script != nil {
+		action = s.Script
+	}
+	return json.Marshal(struct {
+		stateAlias
+		Action interface{} `json:"action"`
+	}{stateAlias: stateAlias(s), Action: action})
+}
+
+// runScript executes an inline script action
+func (fsm *Machine) runScript(script *Script, event Event) (bool, error) {
+	if script.Lang != ScriptLua {
+		return false, fmt.Errorf("Error: Unsupported script language '%s' in state '%s'", script.Lang, fsm.CurrentState.Name)
+	}
+
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	defer L.Close()
+	// Only open the libraries that have no access to the host
+	for _, lib := range []struct {
+		name string
+		fn   lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.fn))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), scriptTimeout)
+	defer cancel()
+	L.SetContext(ctx)
+
+	ctxTable := toLua(L, fsm.Context).(*lua.LTable)
+	L.SetGlobal("ctx", ctxTable)
+	L.SetGlobal("param", lua.LString(event.Param))
+	L.SetGlobal("state", lua.LString(fsm.CurrentState.Name))
+	eventTable := L.NewTable()
+	eventTable.RawSetString("action", lua.LString(event.Action))
+	eventTable.RawSetString("param", lua.LString(event.Param))
+	eventTable.RawSetString("data", toLua(L, event.Data))
+	L.SetGlobal("event", eventTable)
+	L.SetGlobal("emit", L.NewFunction(func(L *lua.LState) int {
+		fsm.Emit(L.CheckString(1), L.OptString(2, ""))
+		return 0
+	}))
+
+	fn, err := L.LoadString(script.Source)
+	if err != nil {
+		return false, fmt.Errorf("Error: Invalid script in state '%s': %v", fsm.CurrentState.Name, err)
+	}
+	L.Push(fn)
+	if err := L.PCall(0, 1, nil); err != nil {
+		return false, fmt.Errorf("Error: Script failed in state '%s': %v", fsm.CurrentState.Name, err)
+	}
+	result := L.Get(-1)
+
+	if values, ok := fromLua(L.GetGlobal("ctx")).(map[string]interface{}); ok {
+		fsm.Context = values
+	}
+	// A string result is the outcome of the action
+	if outcome, ok := result.(lua.LString); ok {
+		fsm.SetOutcome(string(outcome))
+	}
+	return result != lua.LFalse, nil
+}
+
+// toLua converts a Go value to a Lua value
+// Maps always become tables, even when nil
+func toLua(L *lua.LState, v interface{}) lua.LValue {
+	switch value := v.(type) {
+	case nil:
+		return lua.LNil
+	case string:
+		return lua.LString(value)
+	case bool:
+		return lua.LBool(value)
+	case float64:
+		return lua.LNumber(value)
+	case int:
+		return lua.LNumber(value)
+	case int64:
+		return lua.LNumber(value)
+	case map[string]interface{}:
+		t := L.NewTable()
+		for k, item := range value {
+			t.RawSetString(k, toLua(L, item))
+		}
+		return t
+	case []interface{}:
+		t := L.NewTable()
+		for _, item := range value {
+			t.Append(toLua(L, item))
+		}
+		return t
+	}
+	return lua.LString(fmt.Sprint(v))
+}
+
+// fromLua converts a Lua value to a Go value
+// Tables with only integer keys become slices, other tables become maps
+func fromLua(v lua.LValue) interface{} {
+	switch value := v.(type) {
+	case lua.LString:
+		return string(value)
+	case lua.LNumber:
+		return float64(value)
+	case lua.LBool:
+		return bool(value)
+	case *lua.LTable:
+		if n := value.MaxN(); n > 0 {
+			list := make([]interface{}, 0, n)
+			for i := 1; i <= n; i++ {
+				list = append(list, fromLua(value.RawGetInt(i)))
+			}
+			return list
+		}
+		m := map[string]interface{}{}
+		value.ForEach(func(k, item lua.LValue) {
+			m[k.String()] = fromLua(item)
+		})
+		return m
+	}
+	return nil
+}
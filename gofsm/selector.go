@@ -0,0 +1,140 @@
+package gofsm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// selectorPrefix marks action arguments that are taken from the event or the context
+const selectorPrefix = "$."
+
+// Roots of the action argument selectors
+const (
+	selectorEvent = "event"
+	selectorParam = "param"
+	selectorCtx   = "ctx"
+)
+
+// isSelector reports whether an action argument is a selector
+func isSelector(arg string) bool {
+	return strings.HasPrefix(arg, selectorPrefix)
+}
+
+// parseSelector splits a selector into its root and the steps of its path
+// Steps are field names or array indexes, e.g. "$.event.items[0].sku"
+// gives "event" and ["items", 0, "sku"]
+func parseSelector(sel string) (string, []interface{}, error) {
+	rest := strings.TrimPrefix(sel, selectorPrefix)
+	var steps []interface{}
+	for rest != "" {
+		end := strings.IndexAny(rest, ".[")
+		if end < 0 {
+			end = len(rest)
+		}
+		if end == 0 {
+			return "", nil, fmt.Errorf("invalid selector '%s'", sel)
+		}
+		steps = append(steps, rest[:end])
+		rest = rest[end:]
+		for strings.HasPrefix(rest, "[") {
+			closing := strings.Index(rest, "]")
+			if closing < 0 {
+				return "", nil, fmt.Errorf("invalid selector '%s': missing ']'", sel)
+			}
+			index, err := strconv.Atoi(rest[1:closing])
+			if err != nil || index < 0 {
+				return "", nil, fmt.Errorf("invalid selector '%s': bad index '%s'", sel, rest[1:closing])
+			}
+			steps = append(steps, index)
+			rest = rest[closing+1:]
+		}
+		if strings.HasPrefix(rest, ".") {
+			rest = rest[1:]
+			if rest == "" {
+				return "", nil, fmt.Errorf("invalid selector '%s'", sel)
+			}
+		} else if rest != "" {
+			return "", nil, fmt.Errorf("invalid selector '%s'", sel)
+		}
+	}
+	if len(steps) == 0 {
+		return "", nil, fmt.Errorf("invalid selector '%s'", sel)
+	}
+	root, _ := steps[0].(string)
+	switch root {
+	case selectorEvent, selectorCtx:
+	case selectorParam:
+		if len(steps) > 1 {
+			return "", nil, fmt.Errorf("invalid selector '%s': '%s' has no fields", sel, root)
+		}
+	default:
+		return "", nil, fmt.Errorf("invalid selector '%s': unknown root '%s'", sel, root)
+	}
+	return root, steps[1:], nil
+}
+
+// selectValue returns the value picked by a selector from the event payload,
+// the event parameter or the context, as decoded from JSON
+func (fsm *Machine) selectValue(sel string, event Event) (interface{}, error) {
+	root, steps, err := parseSelector(sel)
+	if err != nil {
+		return nil, fmt.Errorf("Error: %v", err)
+	}
+	var value interface{}
+	switch root {
+	case selectorParam:
+		return event.Param, nil
+	case selectorEvent:
+		value = event.Data
+	case selectorCtx:
+		value = fsm.Context
+	}
+	for _, step := range steps {
+		switch step := step.(type) {
+		case string:
+			obj, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("Error: Selector '%s': no field '%s'", sel, step)
+			}
+			if value, ok = obj[step]; !ok {
+				return nil, fmt.Errorf("Error: Selector '%s': no field '%s'", sel, step)
+			}
+		case int:
+			items, ok := value.([]interface{})
+			if !ok || step >= len(items) {
+				return nil, fmt.Errorf("Error: Selector '%s': no item %d", sel, step)
+			}
+			value = items[step]
+		}
+	}
+	return value, nil
+}
+
+// resolveSelector returns the value picked by a selector from the event
+// payload, the event parameter or the context
+// Values that are not strings are returned as JSON
+func (fsm *Machine) resolveSelector(sel string, event Event) (string, error) {
+	value, err := fsm.selectValue(sel, event)
+	if err != nil {
+		return "", err
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("Error: Selector '%s': %v", sel, err)
+	}
+	return string(data), nil
+}
+
+// actionArg returns the argument of the action of the current state
+// It is picked by the state's selector if it has one, otherwise it is the event parameter
+func (fsm *Machine) actionArg(event Event) (string, error) {
+	if !isSelector(fsm.CurrentState.ActionArg) {
+		return event.Param, nil
+	}
+	return fsm.resolveSelector(fsm.CurrentState.ActionArg, event)
+}
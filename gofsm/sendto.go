@@ -0,0 +1,107 @@
+package gofsm
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ErrNotManaged is returned for the events sent to other instances by a
+// machine that doesn't belong to a manager
+var ErrNotManaged = errors.New("Error: The machine doesn't belong to a manager")
+
+// SendTo describes an event sent to another instance of the manager, e.g. a
+// child notifying its parent: {"instance": "$.ctx.parentId", "event": "childDone"}
+type SendTo struct {
+	// Instance is the session of the instance, or a selector such as "$.ctx.parentId"
+	Instance string `json:"instance"`
+	Event    string `json:"event"`
+	// Param is the parameter of the event, or a selector, optional
+	Param string `json:"param,omitempty"`
+}
+
+// SendTo sends an event to another instance of the manager of the machine,
+// once the current step succeeded
+// The event is queued by the manager, so it is processed after the step
+// and the instances can send events to each other without waiting
+// It is meant to be called by actions, the abandoned ones get ErrActionAbandoned
+func (fsm *Machine) SendTo(instance string, event Event) error {
+	if fsm.onSend == nil {
+		return ErrNotManaged
+	}
+	if instance == "" {
+		return fmt.Errorf("Error: No instance to send the event '%s' to", event.Action)
+	}
+	event.Session, event.CorrelationKey = instance, ""
+	event.sentBy = fsm.ID
+	fsm.actionMu.Lock()
+	defer fsm.actionMu.Unlock()
+	if fsm.abandoned() {
+		return ErrActionAbandoned
+	}
+	fsm.outbox = append(fsm.outbox, event)
+	return nil
+}
+
+// sendTo sends the event of the 'sendTo' of a transition
+func (fsm *Machine) sendTo(s SendTo, event Event) error {
+	instance, err := fsm.selectArg(s.Instance, event)
+	if err != nil {
+		return err
+	}
+	param, err := fsm.selectArg(s.Param, event)
+	if err != nil {
+		return err
+	}
+	return fsm.SendTo(instance, Event{Action: s.Event, Param: param})
+}
+
+// selectArg resolves an argument that is either a selector or a literal
+func (fsm *Machine) selectArg(arg string, event Event) (string, error) {
+	if !isSelector(arg) {
+		return arg, nil
+	}
+	return fsm.resolveSelector(arg, event)
+}
+
+// deliverOutbox hands the events sent by a step to the manager, or drops
+// them if the step failed
+func (fsm *Machine) deliverOutbox(err error) {
+	fsm.actionMu.Lock()
+	outbox := fsm.outbox
+	fsm.outbox = nil
+	fsm.actionMu.Unlock()
+	if err != nil {
+		return
+	}
+	for _, event := range outbox {
+		fsm.onSend(event)
+	}
+}
+
+// deliver queues an event sent by a machine to another instance
+// Events for unknown instances are rejected rather than creating them
+func (m *Manager) deliver(event Event) {
+	if !m.exists(event.Session) {
+		log.Printf("Error: Instance '%s' not found for the event '%s' sent by '%s'\n", event.Session, event.Action, event.sentBy)
+		m.AuditRejected(event, ErrSessionNotFound)
+		return
+	}
+	if err := m.Enqueue(event); err != nil {
+		log.Println(err)
+	}
+}
+
+// exists tells if a session exists, loaded, evicted or in the shared store
+// The machines are not locked, since the sender of an event holds its own
+func (m *Manager) exists(id string) bool {
+	s := m.shard(id)
+	s.mu.Lock()
+	found := s.has(id)
+	s.mu.Unlock()
+	if !found && m.shared {
+		_, err := m.snapshots.LoadSnapshot(id)
+		found = err == nil
+	}
+	return found
+}
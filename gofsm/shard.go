@@ -0,0 +1,328 @@
+package gofsm
+
+import (
+	"container/list"
+	"hash/fnv"
+	"log"
+	"sync"
+)
+
+// shard holds a part of the sessions of a manager behind its own lock, so
+// events of different sessions rarely wait for each other
+type shard struct {
+	mu sync.Mutex
+	// sessions maps the IDs of the loaded sessions to their element in lru
+	sessions map[string]*list.Element
+	// lru orders the loaded sessions from the most to the least recently used
+	lru *list.List
+	// evicted maps the IDs of the evicted sessions to how to load them back
+	evicted map[string]evictedSession
+	// capacity is the number of loaded sessions, unlimited if zero
+	capacity  int
+	evictions uint64
+	loads     uint64
+}
+
+// evictedSession is a machine of a shard saved to the snapshot store
+type evictedSession struct {
+	create func() (*Machine, error)
+	// labels are the labels of the definition of the machine, so bulk
+	// operations select it without loading it
+	labels map[string]string
+}
+
+// session is a loaded machine of a shard
+type session struct {
+	id     string
+	fsm    *Machine
+	create func() (*Machine, error)
+	// busy counts the events being sent to the machine, it is not evicted meanwhile
+	busy int
+}
+
+// ShardStats describes the occupancy of a shard of a manager
+type ShardStats struct {
+	// Loaded is the number of machines in memory
+	Loaded int `json:"loaded"`
+	// Evicted is the number of machines saved to the snapshot store
+	Evicted int `json:"evicted"`
+	// Capacity is the number of machines the shard keeps in memory, unlimited if zero
+	Capacity int `json:"capacity"`
+	// Evictions and Loads count the machines saved to and restored from the store
+	Evictions uint64 `json:"evictions"`
+	Loads     uint64 `json:"loads"`
+}
+
+// WithShards spreads the sessions of the manager over n shards hashed by session ID
+// Each shard has its own lock, which lets servers with many sessions create
+// and look up machines concurrently
+func WithShards(n int) ManagerOption {
+	return func(m *Manager) {
+		if n < 1 {
+			n = 1
+		}
+		m.shards = newShards(n)
+	}
+}
+
+// WithEviction keeps at most maxLoaded machines in memory, spread evenly over the shards
+// The least recently used idle machines beyond it are saved to the store and
+// restored on their next event. Machines that wait for a timer, a timeout, a
+// schedule, a sub-machine or a correlation key are never evicted
+func WithEviction(maxLoaded int, store SnapshotStore) ManagerOption {
+	return func(m *Manager) {
+		m.maxLoaded = maxLoaded
+		m.snapshots = store
+	}
+}
+
+// newShards creates n empty shards
+func newShards(n int) []*shard {
+	shards := make([]*shard, n)
+	for i := range shards {
+		shards[i] = &shard{
+			sessions: map[string]*list.Element{},
+			lru:      list.New(),
+			evicted:  map[string]evictedSession{},
+		}
+	}
+	return shards
+}
+
+// shard returns the shard of a session
+func (m *Manager) shard(id string) *shard {
+	if len(m.shards) == 1 {
+		return m.shards[0]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return m.shards[h.Sum32()%uint32(len(m.shards))]
+}
+
+// Stats returns the occupancy of each shard
+func (m *Manager) Stats() []ShardStats {
+	stats := make([]ShardStats, len(m.shards))
+	for i, s := range m.shards {
+		s.mu.Lock()
+		stats[i] = ShardStats{
+			Loaded:    len(s.sessions),
+			Evicted:   len(s.evicted),
+			Capacity:  s.capacity,
+			Evictions: s.evictions,
+			Loads:     s.loads,
+		}
+		s.mu.Unlock()
+	}
+	return stats
+}
+
+// acquire returns the machine of a session, creating or loading it if needed,
+// and keeps it in memory until release is called
+func (m *Manager) acquire(id string) (*Machine, func(), error) {
+	s := m.shard(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, err := m.load(s, id, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	sess.busy++
+	release := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		sess.busy--
+	}
+	return sess.fsm, release, nil
+}
+
+// load returns a session of the locked shard, restoring it from the snapshot
+// store if it was evicted or, with a shared store, saved by another replica, and
+// creating it with the factory if create is set
+// Returns nil if the session doesn't exist and create isn't set
+func (m *Manager) load(s *shard, id string, create bool) (*session, error) {
+	if e, ok := s.sessions[id]; ok {
+		s.lru.MoveToFront(e)
+		return e.Value.(*session), nil
+	}
+	evicted, stored := s.evicted[id]
+	factory := evicted.create
+	// The sessions of the other replicas are found in the shared store
+	if !stored && m.shared && m.factory != nil {
+		if _, err := m.snapshots.LoadSnapshot(id); err == nil {
+			factory, stored = m.factory, true
+		}
+	}
+	if stored {
+		sess, err := m.restore(id, factory)
+		if err != nil {
+			return nil, err
+		}
+		delete(s.evicted, id)
+		s.loads++
+		s.insert(sess)
+		m.evict(s)
+		return sess, nil
+	}
+	if !create {
+		return nil, nil
+	}
+	if m.factory == nil {
+		return nil, ErrSessionNotFound
+	}
+	fsm, err := m.factory()
+	if err != nil {
+		return nil, err
+	}
+	m.add(id, fsm)
+	sess := &session{id: id, fsm: fsm, create: m.factory}
+	s.insert(sess)
+	m.evict(s)
+	return sess, nil
+}
+
+// restore recreates an evicted machine from its snapshot, no action is run
+func (m *Manager) restore(id string, factory func() (*Machine, error)) (*session, error) {
+	snap, err := m.snapshots.LoadSnapshot(id)
+	if err != nil {
+		return nil, err
+	}
+	fsm, err := factory()
+	if err != nil {
+		return nil, err
+	}
+	m.attach(id, fsm)
+	if err := fsm.Restore(snap); err != nil {
+		return nil, err
+	}
+	// The shared store of replicas keeps the snapshot as the reference
+	if !m.shared {
+		m.deleteSnapshot(id)
+	}
+	return &session{id: id, fsm: fsm, create: factory}, nil
+}
+
+// evict saves the least recently used idle machines of the locked shard to the
+// snapshot store until the shard is within its capacity
+// Busy machines are skipped, so the shard may stay above its capacity for a while
+// The most recently used machine is kept, it is the one being returned
+func (m *Manager) evict(s *shard) {
+	if s.capacity == 0 {
+		return
+	}
+	e := s.lru.Back()
+	for s.lru.Len() > s.capacity && e != s.lru.Front() {
+		sess := e.Value.(*session)
+		e = e.Prev()
+		if _, err := m.unload(s, sess); err != nil {
+			log.Printf("Error: Session '%s' could not be evicted: %v\n", sess.id, err)
+			return
+		}
+	}
+}
+
+// Flush saves all the idle machines to the snapshot store and unloads them,
+// e.g. to release memory, and returns how many were unloaded
+// Machines that are busy or wait for a timer are kept, like for the eviction
+func (m *Manager) Flush() (int, error) {
+	if m.snapshots == nil {
+		return 0, nil
+	}
+	n := 0
+	for _, s := range m.shards {
+		s.mu.Lock()
+		for e := s.lru.Front(); e != nil; {
+			sess := e.Value.(*session)
+			e = e.Next()
+			unloaded, err := m.unload(s, sess)
+			if err != nil {
+				s.mu.Unlock()
+				return n, err
+			}
+			if unloaded {
+				n++
+			}
+		}
+		s.mu.Unlock()
+	}
+	return n, nil
+}
+
+// unload saves an idle session of the locked shard to the snapshot store and
+// removes it from memory
+// Returns false if the session is busy or needs to stay in memory
+func (m *Manager) unload(s *shard, sess *session) (bool, error) {
+	if sess.busy > 0 {
+		return false, nil
+	}
+	snap, ok := sess.fsm.idleSnapshot()
+	if !ok {
+		return false, nil
+	}
+	// With a locker, the shared store already has the state of the last event
+	// and may have a newer one from another replica
+	if m.locker == nil {
+		if err := m.snapshots.SaveSnapshot(sess.id, snap); err != nil {
+			return false, err
+		}
+	}
+	s.remove(sess.id)
+	s.evicted[sess.id] = evictedSession{create: sess.create, labels: labelsOf(sess.fsm.Metadata)}
+	s.evictions++
+	sess.fsm.Stop()
+	return true, nil
+}
+
+// deleteSnapshot removes the snapshot of a session from the store
+func (m *Manager) deleteSnapshot(id string) {
+	if err := m.snapshots.DeleteSnapshot(id); err != nil && err != ErrNotFound {
+		log.Printf("Error: Snapshot of session '%s' could not be deleted: %v\n", id, err)
+	}
+}
+
+// has tells if the locked shard has a session, loaded or evicted
+func (s *shard) has(id string) bool {
+	_, loaded := s.sessions[id]
+	_, evicted := s.evicted[id]
+	return loaded || evicted
+}
+
+// labels returns the labels of the definition of a session of the locked
+// shard, without loading it if it was evicted
+func (s *shard) labels(id string) (map[string]string, bool) {
+	if e, ok := s.sessions[id]; ok {
+		return labelsOf(e.Value.(*session).fsm.Metadata), true
+	}
+	evicted, ok := s.evicted[id]
+	return evicted.labels, ok
+}
+
+// insert adds a loaded session to the locked shard as the most recently used
+func (s *shard) insert(sess *session) {
+	s.sessions[sess.id] = s.lru.PushFront(sess)
+}
+
+// remove removes a loaded session from the locked shard, returns nil if it isn't loaded
+func (s *shard) remove(id string) *session {
+	e, ok := s.sessions[id]
+	if !ok {
+		return nil
+	}
+	delete(s.sessions, id)
+	s.lru.Remove(e)
+	return e.Value.(*session)
+}
+
+// idleSnapshot captures a machine that only waits for events
+// Returns false if the machine is busy or waits for a timer, a timeout, a
+// schedule, a sub-machine or a correlation key, since those need it in memory
+func (fsm *Machine) idleSnapshot() (Snapshot, bool) {
+	if !fsm.mu.TryLock() {
+		return Snapshot{}, false
+	}
+	defer fsm.mu.Unlock()
+	if fsm.timer != nil || fsm.timeouts != nil || len(fsm.scheduleTimers) > 0 ||
+		fsm.child != nil || fsm.correlationKey != "" {
+		return Snapshot{}, false
+	}
+	return fsm.snapshot(), true
+}
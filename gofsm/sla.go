@@ -0,0 +1,104 @@
+package gofsm
+
+import (
+	"time"
+)
+
+// AuditStuck is the kind of the alerts of machines stuck in a state past its SLA
+const AuditStuck = "instance.stuck"
+
+// StuckInstance describes a machine that stayed in a state longer than its SLA
+type StuckInstance struct {
+	ID        string    `json:"id"`
+	State     string    `json:"state"`
+	SLA       string    `json:"sla"`
+	EnteredAt time.Time `json:"enteredAt"`
+}
+
+// WithStuckScan checks the machines of all sessions for the ones stuck in
+// a state past its SLA at every interval, and alerts the audit sinks once
+// per stuck state entry, see Manager.ScanStuck
+func WithStuckScan(interval time.Duration) ManagerOption {
+	return func(m *Manager) {
+		m.stuckInterval = interval
+	}
+}
+
+// Stuck tells if the machine is in a non-final state for longer than the SLA
+// of the state. Paused and terminated machines are never stuck
+func (fsm *Machine) Stuck() (StuckInstance, bool) {
+	fsm = fsm.root()
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	state := fsm.CurrentState
+	if state.SLA == "" || state.Final || fsm.terminated || fsm.paused {
+		return StuckInstance{}, false
+	}
+	sla, err := time.ParseDuration(state.SLA)
+	if err != nil || fsm.clock().Now().Sub(fsm.enteredAt) < sla {
+		return StuckInstance{}, false
+	}
+	return StuckInstance{ID: fsm.ID, State: state.Name, SLA: state.SLA, EnteredAt: fsm.enteredAt}, true
+}
+
+// alertStuck sends the alert of a stuck machine to its audit sinks
+func (fsm *Machine) alertStuck(stuck StuckInstance) {
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	since := stuck.EnteredAt
+	fsm.audit(AuditRecord{
+		Kind:  AuditStuck,
+		Param: stuck.SLA,
+		From:  stuck.State,
+		To:    stuck.State,
+		Since: &since,
+	})
+}
+
+// Stuck returns the machines of the sessions that are stuck, see Machine.Stuck
+// The evicted machines are loaded to be checked
+func (m *Manager) Stuck() []StuckInstance {
+	stuck := []StuckInstance{}
+	for _, id := range m.Sessions() {
+		fsm, ok := m.Get(id)
+		if !ok {
+			continue
+		}
+		if s, ok := fsm.Stuck(); ok {
+			s.ID = id
+			stuck = append(stuck, s)
+		}
+	}
+	return stuck
+}
+
+// ScanStuck returns the stuck machines and alerts the audit sinks of the
+// ones that weren't stuck at the previous scan, or that got stuck again in
+// another state entry since
+func (m *Manager) ScanStuck() []StuckInstance {
+	stuck := m.Stuck()
+	m.stuckMu.Lock()
+	alerted := make(map[string]time.Time, len(stuck))
+	var fresh []StuckInstance
+	for _, s := range stuck {
+		if at, ok := m.stuckAlerted[s.ID]; !ok || !at.Equal(s.EnteredAt) {
+			fresh = append(fresh, s)
+		}
+		alerted[s.ID] = s.EnteredAt
+	}
+	m.stuckAlerted = alerted
+	m.stuckMu.Unlock()
+	for _, s := range fresh {
+		if fsm, ok := m.Get(s.ID); ok {
+			fsm.alertStuck(s)
+		}
+	}
+	return stuck
+}
+
+// watchStuck scans the machines for stuck ones at every interval
+func (m *Manager) watchStuck(interval time.Duration) {
+	for range time.Tick(interval) {
+		m.ScanStuck()
+	}
+}
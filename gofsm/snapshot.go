@@ -0,0 +1,185 @@
+package gofsm
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Snapshot is the persisted runtime state of a machine
+type Snapshot struct {
+	Version      string                 `json:"version,omitempty"`
+	CurrentState string                 `json:"currentState"`
+	Context      map[string]interface{} `json:"context,omitempty"`
+	Child        *Snapshot              `json:"child,omitempty"`
+	// Terminated is set for aborted machines, with the reason of the abort
+	Terminated  bool   `json:"terminated,omitempty"`
+	AbortReason string `json:"abortReason,omitempty"`
+	// Paused is set for paused machines, their timers start on resume
+	Paused bool `json:"paused,omitempty"`
+	// EnteredAt is when the current state was entered
+	EnteredAt time.Time `json:"enteredAt,omitempty"`
+	// Sealed holds the encrypted context and child of the snapshots saved
+	// by an EncryptSnapshots store
+	Sealed []byte `json:"sealed,omitempty"`
+}
+
+// MigrationFunc upgrades a snapshot taken with an older definition
+type MigrationFunc func(Snapshot) Snapshot
+
+type migration struct {
+	to string
+	fn MigrationFunc
+}
+
+var (
+	migrationsMu sync.RWMutex
+	migrations   = map[string]migration{}
+)
+
+// RegisterMigration registers the upgrade of snapshots from one definition version to another
+// Only one migration can start from a given version
+func RegisterMigration(fromVer, toVer string, fn MigrationFunc) {
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+	migrations[fromVer] = migration{to: toVer, fn: fn}
+}
+
+// Migrate upgrades a snapshot to the given version by chaining the registered migrations
+// Returns an error if no chain of migrations leads to the version
+func Migrate(snap Snapshot, version string) (Snapshot, error) {
+	migrationsMu.RLock()
+	defer migrationsMu.RUnlock()
+	seen := map[string]bool{}
+	for snap.Version != version {
+		if seen[snap.Version] {
+			return snap, fmt.Errorf("Error: Migration loop at version '%s'", snap.Version)
+		}
+		seen[snap.Version] = true
+		m, ok := migrations[snap.Version]
+		if !ok {
+			return snap, fmt.Errorf("Error: No migration from version '%s' to version '%s'", snap.Version, version)
+		}
+		snap = m.fn(snap)
+		snap.Version = m.to
+	}
+	return snap, nil
+}
+
+// Snapshot captures the runtime state of the machine
+func (fsm *Machine) Snapshot() Snapshot {
+	fsm = fsm.root()
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	return fsm.snapshot()
+}
+
+// snapshot captures the runtime state of the locked machine
+func (fsm *Machine) snapshot() Snapshot {
+	snap := Snapshot{
+		Version:      fsm.Version,
+		CurrentState: fsm.CurrentState.Name,
+		Context:      copyContext(fsm.Context),
+		Terminated:   fsm.terminated,
+		AbortReason:  fsm.abortReason,
+		Paused:       fsm.paused,
+		EnteredAt:    fsm.enteredAt,
+	}
+	if fsm.child != nil {
+		child := fsm.child.Snapshot()
+		snap.Child = &child
+	}
+	return snap
+}
+
+// Restore puts the machine back in the state captured by a snapshot
+// Snapshots of older definition versions are migrated first
+// No action is run, but the timer of the restored state is started again
+func (fsm *Machine) Restore(snap Snapshot) error {
+	fsm = fsm.root()
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	if err := fsm.restore(snap); err != nil {
+		return err
+	}
+	fsm.recordRestore(snap)
+	return nil
+}
+
+// restore puts the locked machine back in the state captured by a snapshot
+func (fsm *Machine) restore(snap Snapshot) error {
+	snap, err := Migrate(snap, fsm.Version)
+	if err != nil {
+		return err
+	}
+	state, err := fsm.GetState(snap.CurrentState)
+	if err != nil {
+		return err
+	}
+
+	fsm.cancelTimer()
+	fsm.generation++
+	fsm.child = nil
+	previous := fsm.CurrentState.Name
+	fsm.CurrentState = state
+	// Restoring is not an entry, the time in the state counts from its
+	// entry, or from now for the snapshots that didn't record it
+	fsm.trackExit(previous)
+	if !snap.EnteredAt.IsZero() {
+		fsm.enteredAt = snap.EnteredAt
+	}
+	fsm.Context = copyContext(snap.Context)
+	fsm.terminated = snap.Terminated
+	fsm.abortReason = snap.AbortReason
+	fsm.abortedAt = fsm.clock().Now()
+	// A machine resumed by another replica starts its schedules again
+	resumed := fsm.paused && !snap.Paused
+	fsm.paused = snap.Paused
+	fsm.updateCorrelation(Event{})
+	if fsm.terminated {
+		// Aborted machines have no timers to start again
+		fsm.halt()
+		return nil
+	}
+	// Timeouts count again from the restore
+	fsm.scheduleTimeouts("")
+	if state.Invoke != "" && snap.Child != nil {
+		def, err := fsm.childDefinition(state.Invoke)
+		if err != nil {
+			return err
+		}
+		child := NewMachine(def, WithActions(fsm.actions), WithClock(fsm.Clock), WithWorkerPool(fsm.pool), WithCircuitBreakers(fsm.breakers))
+		child.Tenant = fsm.Tenant
+		if err := child.Restore(*snap.Child); err != nil {
+			return err
+		}
+		fsm.child = child
+	}
+	if fsm.paused {
+		// Paused machines start their timers when they are resumed
+		fsm.halt()
+		return nil
+	}
+	if resumed {
+		fsm.stopped = false
+		if err := fsm.startSchedules(); err != nil {
+			return err
+		}
+	}
+	if state.After != "" {
+		return fsm.scheduleAfter(Event{})
+	}
+	return nil
+}
+
+// copyContext returns a shallow copy of a context
+func copyContext(ctx map[string]interface{}) map[string]interface{} {
+	if ctx == nil {
+		return nil
+	}
+	c := make(map[string]interface{}, len(ctx))
+	for k, v := range ctx {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,118 @@
+package gofsm
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// SnapshotStore persists the snapshots of the machines evicted by a manager
+// Implementations must be safe for concurrent use
+type SnapshotStore interface {
+	// SaveSnapshot creates or replaces the snapshot of a session
+	SaveSnapshot(id string, snap Snapshot) error
+	// LoadSnapshot returns the snapshot of a session or ErrNotFound
+	LoadSnapshot(id string) (Snapshot, error)
+	// DeleteSnapshot removes the snapshot of a session or returns ErrNotFound
+	DeleteSnapshot(id string) error
+}
+
+// MemorySnapshotStore keeps snapshots in memory, they are lost on restart
+// A snapshot is much smaller than its machine, so this still saves memory
+type MemorySnapshotStore struct {
+	mu        sync.RWMutex
+	snapshots map[string]Snapshot
+}
+
+var _ SnapshotStore = (*MemorySnapshotStore)(nil)
+
+// NewMemorySnapshotStore creates an empty memory snapshot store
+func NewMemorySnapshotStore() *MemorySnapshotStore {
+	return &MemorySnapshotStore{snapshots: map[string]Snapshot{}}
+}
+
+// SaveSnapshot stores the snapshot
+func (s *MemorySnapshotStore) SaveSnapshot(id string, snap Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshots[id] = snap
+	return nil
+}
+
+// LoadSnapshot returns the stored snapshot
+func (s *MemorySnapshotStore) LoadSnapshot(id string) (Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snap, ok := s.snapshots[id]
+	if !ok {
+		return Snapshot{}, ErrNotFound
+	}
+	return snap, nil
+}
+
+// DeleteSnapshot removes the stored snapshot
+func (s *MemorySnapshotStore) DeleteSnapshot(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.snapshots[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.snapshots, id)
+	return nil
+}
+
+// FileSnapshotStore keeps each snapshot in a JSON file of a directory
+// Session IDs are hex encoded in the file names, so any ID can be stored
+type FileSnapshotStore struct {
+	dir string
+}
+
+var _ SnapshotStore = (*FileSnapshotStore)(nil)
+
+// NewFileSnapshotStore creates a snapshot store in the given directory, creating it if needed
+func NewFileSnapshotStore(dir string) (*FileSnapshotStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileSnapshotStore{dir: dir}, nil
+}
+
+// path returns the file of a snapshot
+func (s *FileSnapshotStore) path(id string) string {
+	return filepath.Join(s.dir, hex.EncodeToString([]byte(id))+".json")
+}
+
+// SaveSnapshot writes the snapshot file
+func (s *FileSnapshotStore) SaveSnapshot(id string, snap Snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	return writeFile(s.path(id), data)
+}
+
+// LoadSnapshot reads the snapshot file
+func (s *FileSnapshotStore) LoadSnapshot(id string) (Snapshot, error) {
+	var snap Snapshot
+	data, err := ioutil.ReadFile(s.path(id))
+	if os.IsNotExist(err) {
+		return snap, ErrNotFound
+	}
+	if err != nil {
+		return snap, err
+	}
+	err = json.Unmarshal(data, &snap)
+	return snap, err
+}
+
+// DeleteSnapshot removes the snapshot file
+func (s *FileSnapshotStore) DeleteSnapshot(id string) error {
+	err := os.Remove(s.path(id))
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return err
+}
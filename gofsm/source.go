@@ -0,0 +1,39 @@
+package gofsm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+)
+
+// EventSink receives the events delivered by an event source
+// Both Machine and Manager are event sinks
+type EventSink interface {
+	SendEvent(event Event) (TransitionResult, error)
+}
+
+// EventSource delivers events from an external system, e.g. a message broker
+type EventSource interface {
+	// Run delivers events to the sink until the context is cancelled
+	Run(ctx context.Context, sink EventSink) error
+	// Close releases the connection to the external system
+	Close() error
+}
+
+// DecodeEvent decodes a JSON event as sent to the HTTP endpoint
+func DecodeEvent(data []byte) (Event, error) {
+	var event Event
+	err := json.Unmarshal(data, &event)
+	return event, err
+}
+
+// Rejected tells if an event can never be processed as it is, e.g. without a
+// transition in the current state, so event sources dead-letter it instead
+// of delivering it again
+func Rejected(err error) bool {
+	var noTransition *ErrNoTransition
+	var payloadErr *PayloadError
+	var handlerMissing *ErrHandlerMissing
+	return errors.As(err, &noTransition) || errors.As(err, &payloadErr) || errors.As(err, &handlerMissing) ||
+		errors.Is(err, ErrNoCorrelation) || errors.Is(err, ErrSessionNotFound) || errors.Is(err, ErrForbidden)
+}
@@ -0,0 +1,393 @@
+// Package sqlite persists definitions, snapshots, instances and events in a
+// SQLite database, so a single server is durable without other infrastructure
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ditek/jsonfsm/gofsm"
+	// Registers the "sqlite" driver, written in Go so the binary needs no cgo
+	_ "modernc.org/sqlite"
+)
+
+// migrations create and upgrade the tables, each one runs once and in order
+// New migrations are appended, the applied ones must never change
+var migrations = []string{
+	`CREATE TABLE definitions (
+		tenant     TEXT NOT NULL,
+		name       TEXT NOT NULL,
+		data       BLOB NOT NULL,
+		updated_at TEXT NOT NULL,
+		PRIMARY KEY (tenant, name)
+	)`,
+	`CREATE TABLE snapshots (
+		tenant     TEXT NOT NULL,
+		id         TEXT NOT NULL,
+		data       BLOB NOT NULL,
+		updated_at TEXT NOT NULL,
+		PRIMARY KEY (tenant, id)
+	)`,
+	`CREATE TABLE instances (
+		tenant     TEXT NOT NULL,
+		id         TEXT NOT NULL,
+		state      TEXT NOT NULL,
+		started_at TEXT NOT NULL,
+		updated_at TEXT NOT NULL,
+		PRIMARY KEY (tenant, id)
+	)`,
+	`CREATE TABLE events (
+		seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+		tenant     TEXT NOT NULL,
+		machine    TEXT NOT NULL,
+		kind       TEXT NOT NULL,
+		event_id   TEXT NOT NULL,
+		event      TEXT NOT NULL,
+		param      TEXT NOT NULL,
+		from_state TEXT NOT NULL,
+		to_state   TEXT NOT NULL,
+		error      TEXT NOT NULL,
+		time       TEXT NOT NULL
+	)`,
+	`CREATE INDEX events_machine ON events (tenant, machine, seq)`,
+}
+
+// Store keeps the definitions, the snapshots of the sessions, the current
+// state of the instances and the audit trail of the events of a tenant
+// It is a definition store, a snapshot store, a projection maintaining the
+// instances table and an audit sink appending to the events table
+type Store struct {
+	db     *sql.DB
+	tenant string
+}
+
+var (
+	_ gofsm.Store         = (*Store)(nil)
+	_ gofsm.SnapshotStore = (*Store)(nil)
+	_ gofsm.Projection    = (*Store)(nil)
+	_ gofsm.AuditSink     = (*Store)(nil)
+	_ gofsm.HistoryStore  = (*Store)(nil)
+)
+
+// Instance is a row of the instances table
+type Instance struct {
+	ID        string    `json:"id"`
+	State     string    `json:"state"`
+	StartedAt time.Time `json:"startedAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Open opens the database in the given file, creating it if needed, and
+// applies the migrations it misses
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite has a single writer, one connection avoids waiting for locks
+	db.SetMaxOpenConns(1)
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Error: Cannot migrate database '%s': %v", path, err)
+	}
+	return &Store{db: db}, nil
+}
+
+// migrate applies the migrations that are missing from the database
+func migrate(db *sql.DB) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TEXT NOT NULL
+	)`); err != nil {
+		return err
+	}
+	var version int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("the database has version %d, newer than %d", version, len(migrations))
+	}
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %v", i+1, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, i+1, now()); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Tenant returns the store of a tenant, sharing the database
+func (s *Store) Tenant(tenant string) *Store {
+	return &Store{db: s.db, tenant: tenant}
+}
+
+// Close closes the database, for the stores of all tenants
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// SaveDefinition creates or replaces the JSON definition stored under a name
+func (s *Store) SaveDefinition(name string, data []byte) error {
+	if err := gofsm.CheckName(name); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`INSERT INTO definitions (tenant, name, data, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (tenant, name) DO UPDATE SET data = excluded.data, updated_at = excluded.updated_at`,
+		s.tenant, name, data, now())
+	return err
+}
+
+// Definition returns the JSON definition stored under a name or ErrNotFound
+func (s *Store) Definition(name string) ([]byte, error) {
+	var data []byte
+	err := s.db.QueryRow(`SELECT data FROM definitions WHERE tenant = ? AND name = ?`, s.tenant, name).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, gofsm.ErrNotFound
+	}
+	return data, err
+}
+
+// DeleteDefinition removes a definition or returns ErrNotFound
+func (s *Store) DeleteDefinition(name string) error {
+	return deleted(s.db.Exec(`DELETE FROM definitions WHERE tenant = ? AND name = ?`, s.tenant, name))
+}
+
+// Definitions returns the sorted names of the stored definitions
+func (s *Store) Definitions() ([]string, error) {
+	rows, err := s.db.Query(`SELECT name FROM definitions WHERE tenant = ? ORDER BY name`, s.tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// SaveSnapshot creates or replaces the snapshot of a session
+func (s *Store) SaveSnapshot(id string, snap gofsm.Snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO snapshots (tenant, id, data, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (tenant, id) DO UPDATE SET data = excluded.data, updated_at = excluded.updated_at`,
+		s.tenant, id, data, now())
+	return err
+}
+
+// LoadSnapshot returns the snapshot of a session or ErrNotFound
+func (s *Store) LoadSnapshot(id string) (gofsm.Snapshot, error) {
+	var data []byte
+	err := s.db.QueryRow(`SELECT data FROM snapshots WHERE tenant = ? AND id = ?`, s.tenant, id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return gofsm.Snapshot{}, gofsm.ErrNotFound
+	}
+	if err != nil {
+		return gofsm.Snapshot{}, err
+	}
+	var snap gofsm.Snapshot
+	err = json.Unmarshal(data, &snap)
+	return snap, err
+}
+
+// DeleteSnapshot removes the snapshot of a session or returns ErrNotFound
+func (s *Store) DeleteSnapshot(id string) error {
+	return deleted(s.db.Exec(`DELETE FROM snapshots WHERE tenant = ? AND id = ?`, s.tenant, id))
+}
+
+// Project keeps the current state of the instance of a transition
+func (s *Store) Project(record gofsm.ProjectionRecord) error {
+	at := record.Timestamp.UTC().Format(time.RFC3339Nano)
+	_, err := s.db.Exec(`INSERT INTO instances (tenant, id, state, started_at, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (tenant, id) DO UPDATE SET state = excluded.state, updated_at = excluded.updated_at`,
+		s.tenant, record.Machine, record.To, at, at)
+	return err
+}
+
+// Instances returns the instances of the tenant, in the order they started
+func (s *Store) Instances() ([]Instance, error) {
+	rows, err := s.db.Query(`SELECT id, state, started_at, updated_at FROM instances
+		WHERE tenant = ? ORDER BY started_at, id`, s.tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	instances := []Instance{}
+	for rows.Next() {
+		var i Instance
+		var started, updated string
+		if err := rows.Scan(&i.ID, &i.State, &started, &updated); err != nil {
+			return nil, err
+		}
+		i.StartedAt, _ = time.Parse(time.RFC3339Nano, started)
+		i.UpdatedAt, _ = time.Parse(time.RFC3339Nano, updated)
+		instances = append(instances, i)
+	}
+	return instances, rows.Err()
+}
+
+// DeleteInstance removes the instance and the events of a machine, e.g.
+// once they are archived
+func (s *Store) DeleteInstance(id string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM instances WHERE tenant = ? AND id = ?`, s.tenant, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM events WHERE tenant = ? AND machine = ?`, s.tenant, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Audit appends a record to the events table
+// Sinks can't fail the event, so errors are logged
+func (s *Store) Audit(record gofsm.AuditRecord) {
+	_, err := s.db.Exec(`INSERT INTO events
+		(tenant, machine, kind, event_id, event, param, from_state, to_state, error, time)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		s.tenant, record.Machine, record.Kind, record.EventID, record.Event, record.Param,
+		record.From, record.To, record.Error, record.Time.UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		log.Printf("Error: Cannot record event of machine '%s': %v\n", record.Machine, err)
+	}
+}
+
+// Events returns the audit records of a machine, oldest first
+func (s *Store) Events(machine string) ([]gofsm.AuditRecord, error) {
+	rows, err := s.db.Query(`SELECT kind, event_id, event, param, from_state, to_state, error, time
+		FROM events WHERE tenant = ? AND machine = ? ORDER BY seq`, s.tenant, machine)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	records := []gofsm.AuditRecord{}
+	for rows.Next() {
+		r := gofsm.AuditRecord{Machine: machine, Tenant: s.tenant}
+		var at string
+		if err := rows.Scan(&r.Kind, &r.EventID, &r.Event, &r.Param, &r.From, &r.To, &r.Error, &at); err != nil {
+			return nil, err
+		}
+		r.Time, _ = time.Parse(time.RFC3339Nano, at)
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// Exceeding returns the machines with more events than the retention keeps,
+// or with events older than it
+func (s *Store) Exceeding(r gofsm.Retention, now time.Time) ([]string, error) {
+	var having []string
+	args := []interface{}{s.tenant}
+	if r.MaxEvents > 0 {
+		having = append(having, "COUNT(*) > ?")
+		args = append(args, r.MaxEvents)
+	}
+	if r.MaxAge > 0 {
+		// The times are text, julianday compares them whatever their precision
+		having = append(having, "MIN(julianday(time)) < julianday(?)")
+		args = append(args, now.Add(-r.MaxAge).UTC().Format(time.RFC3339Nano))
+	}
+	if len(having) == 0 {
+		return nil, nil
+	}
+	rows, err := s.db.Query(`SELECT machine FROM events WHERE tenant = ?
+		GROUP BY machine HAVING `+strings.Join(having, " OR ")+` ORDER BY machine`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var machines []string
+	for rows.Next() {
+		var machine string
+		if err := rows.Scan(&machine); err != nil {
+			return nil, err
+		}
+		machines = append(machines, machine)
+	}
+	return machines, rows.Err()
+}
+
+// Truncate removes the events of a machine older than the retention, then
+// the oldest ones beyond its maximum number of events
+func (s *Store) Truncate(machine string, r gofsm.Retention, now time.Time) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	total := 0
+	if r.MaxAge > 0 {
+		n, err := removed(tx.Exec(`DELETE FROM events WHERE tenant = ? AND machine = ? AND julianday(time) < julianday(?)`,
+			s.tenant, machine, now.Add(-r.MaxAge).UTC().Format(time.RFC3339Nano)))
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	if r.MaxEvents > 0 {
+		n, err := removed(tx.Exec(`DELETE FROM events WHERE tenant = ? AND machine = ? AND seq <= (
+			SELECT seq FROM events WHERE tenant = ? AND machine = ? ORDER BY seq DESC LIMIT 1 OFFSET ?)`,
+			s.tenant, machine, s.tenant, machine, r.MaxEvents))
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, tx.Commit()
+}
+
+// removed returns the number of rows removed by a delete statement
+func removed(result sql.Result, err error) (int, error) {
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// deleted returns ErrNotFound if a delete statement removed no row
+func deleted(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return gofsm.ErrNotFound
+	}
+	return nil
+}
+
+// now returns the current time as stored in the tables
+func now() string {
+	return time.Now().UTC().Format(time.RFC3339Nano)
+}
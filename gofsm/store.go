@@ -0,0 +1,188 @@
+package gofsm
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrNotFound is returned by stores for unknown names
+var ErrNotFound = errors.New("Error: Not found")
+
+// Store persists named definitions
+// Implementations must be safe for concurrent use
+type Store interface {
+	// SaveDefinition creates or replaces the JSON definition stored under a name
+	SaveDefinition(name string, data []byte) error
+	// Definition returns the JSON definition stored under a name or ErrNotFound
+	Definition(name string) ([]byte, error)
+	// DeleteDefinition removes a definition or returns ErrNotFound
+	DeleteDefinition(name string) error
+	// Definitions returns the sorted names of the stored definitions
+	Definitions() ([]string, error)
+}
+
+// validName matches the names accepted by the stores
+var validName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// CheckName returns an error if a name cannot be used in a store
+func CheckName(name string) error {
+	if !validName.MatchString(name) || strings.Trim(name, ".") == "" {
+		return fmt.Errorf("Error: Invalid name '%s', only letters, digits, '_', '-' and '.' are allowed", name)
+	}
+	return nil
+}
+
+// MemoryStore keeps definitions in memory, they are lost on restart
+type MemoryStore struct {
+	mu          sync.RWMutex
+	definitions map[string][]byte
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore creates an empty memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{definitions: map[string][]byte{}}
+}
+
+// SaveDefinition stores a copy of the definition
+func (s *MemoryStore) SaveDefinition(name string, data []byte) error {
+	if err := CheckName(name); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.definitions[name] = append([]byte(nil), data...)
+	return nil
+}
+
+// Definition returns the stored definition
+func (s *MemoryStore) Definition(name string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data, ok := s.definitions[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), data...), nil
+}
+
+// DeleteDefinition removes the definition
+func (s *MemoryStore) DeleteDefinition(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.definitions[name]; !ok {
+		return ErrNotFound
+	}
+	delete(s.definitions, name)
+	return nil
+}
+
+// Definitions returns the names of the stored definitions
+func (s *MemoryStore) Definitions() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.definitions))
+	for name := range s.definitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// FileStore keeps each definition in a JSON file of a directory
+type FileStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+var _ Store = (*FileStore)(nil)
+
+// NewFileStore creates a store in the given directory, creating it if needed
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// path returns the file of a definition
+func (s *FileStore) path(name string) string {
+	return filepath.Join(s.dir, name+".json")
+}
+
+// SaveDefinition writes the definition to a temporary file and renames it,
+// so readers never see a partial file
+func (s *FileStore) SaveDefinition(name string, data []byte) error {
+	if err := CheckName(name); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeFile(s.path(name), data)
+}
+
+// writeFile writes data to a temporary file next to the given path and renames it
+func writeFile(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Definition reads the definition file
+func (s *FileStore) Definition(name string) ([]byte, error) {
+	if CheckName(name) != nil {
+		return nil, ErrNotFound
+	}
+	data, err := ioutil.ReadFile(s.path(name))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+// DeleteDefinition removes the definition file
+func (s *FileStore) DeleteDefinition(name string) error {
+	if CheckName(name) != nil {
+		return ErrNotFound
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := os.Remove(s.path(name))
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Definitions lists the definition files
+func (s *FileStore) Definitions() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, strings.TrimSuffix(filepath.Base(file), ".json"))
+	}
+	sort.Strings(names)
+	return names, nil
+}
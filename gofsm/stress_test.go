@@ -0,0 +1,165 @@
+package gofsm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"testing"
+)
+
+// The stress tests are meant to run with the race detector
+//
+//	go test -race -run Stress ./gofsm
+
+func TestMain(m *testing.M) {
+	// The machines log every transition
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+const stressDefinition = `{
+	"initialState": "IDLE",
+	"context": {"count": 0},
+	"states": [
+		{"name": "IDLE", "action": "Count", "waitForEvent": true},
+		{"name": "BUSY", "action": "Count", "waitForEvent": true, "timeouts": [{"after": "1ms", "event": "expire"}]},
+		{"name": "COOLING", "action": "Count", "after": "1ms"}
+	],
+	"transitions": [
+		{"from": "IDLE", "toSuccess": "BUSY", "event": "work"},
+		{"from": "BUSY", "toSuccess": "IDLE", "event": "done"},
+		{"from": "BUSY", "toSuccess": "IDLE", "event": "expire"},
+		{"from": "IDLE", "toSuccess": "COOLING", "event": "cool"},
+		{"from": "COOLING", "toSuccess": "IDLE"}
+	]
+}`
+
+// stressActions counts the states entered in the context of the machine
+func stressActions() *ActionRegistry {
+	r := NewDefaultActionRegistry()
+	r.Register("Count", func(fsm *Machine, arg string) bool {
+		fsm.Context["count"] = fsm.Context["count"].(float64) + 1
+		return true
+	})
+	return r
+}
+
+// newStressMachine creates an initialized machine of the stress definition
+func newStressMachine(t testing.TB) *Machine {
+	def, err := LoadDefinition([]byte(stressDefinition))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsm := NewMachine(def, WithActions(stressActions()))
+	fsm.Init()
+	t.Cleanup(fsm.Stop)
+	return fsm
+}
+
+// TestStressMachine sends events to a machine from many goroutines while its
+// timers fire and other goroutines read it
+func TestStressMachine(t *testing.T) {
+	fsm := newStressMachine(t)
+	events := []string{"work", "done", "cool"}
+	const senders, sends = 8, 200
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	entered := 1 // The initial state
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < sends; j++ {
+				result, err := fsm.SendEvent(Event{Action: events[(i+j)%len(events)]})
+				if err != nil {
+					continue
+				}
+				mu.Lock()
+				entered += len(result.Path)
+				mu.Unlock()
+			}
+		}(i)
+	}
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				snapshot := fsm.Snapshot()
+				if _, err := fsm.GetState(snapshot.CurrentState); err != nil {
+					t.Error(err)
+					return
+				}
+				if state := fsm.Introspect().CurrentState; state == "" {
+					t.Error("Introspect returned no state")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	readers.Wait()
+	fsm.Stop()
+
+	// The timers fire on their own, so only the events sent are compared
+	snapshot := fsm.Snapshot()
+	if count := int(snapshot.Context["count"].(float64)); count < entered {
+		t.Errorf("The actions ran %d times for %d states entered by the events", count, entered)
+	}
+}
+
+// TestStressManager sends events to the sessions of a manager from many
+// goroutines while other goroutines take their snapshots
+func TestStressManager(t *testing.T) {
+	def, err := LoadDefinition([]byte(stressDefinition))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(func() (*Machine, error) {
+		return NewMachine(def, WithActions(stressActions())), nil
+	})
+	const sessions = 4
+	for i := 0; i < sessions; i++ {
+		if _, err := m.Start(fmt.Sprintf("s%d", i), def, WithActions(stressActions())); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		for _, id := range m.Sessions() {
+			if fsm, ok := m.Get(id); ok {
+				fsm.Stop()
+			}
+		}
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				id := fmt.Sprintf("s%d", (i+j)%sessions)
+				if j%3 == 0 {
+					m.Snapshot(id)
+					continue
+				}
+				m.SendEvent(Event{Session: id, Action: []string{"work", "done", "cool"}[j%3]})
+			}
+		}(i)
+	}
+	wg.Wait()
+	if got := len(m.Sessions()); got != sessions {
+		t.Errorf("Got %d sessions, want %d", got, sessions)
+	}
+}
@@ -0,0 +1,268 @@
+package gofsm
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+)
+
+// ContextTask is the context key of the pending human task of the machine
+// Keeping it in the context lets snapshots keep the task and its claim
+const ContextTask = "task"
+
+// Errors of the human tasks
+var (
+	// ErrTaskNotFound is returned for tasks that are not pending
+	ErrTaskNotFound = errors.New("Error: Task not found")
+	// ErrTaskClaimed is returned when the task was claimed by another user
+	ErrTaskClaimed = errors.New("Error: Task claimed by another user")
+	// ErrTaskNotAssigned is returned when the task is assigned to another user
+	ErrTaskNotAssigned = errors.New("Error: Task assigned to another user")
+)
+
+// HumanTask makes a state wait for a person to complete a task
+// The task is pending while the machine is in the state
+type HumanTask struct {
+	Title string `json:"title,omitempty"`
+	// Assignee is the user the task is assigned to, or a selector such as
+	// "$.ctx.approver", anybody can claim the task if empty
+	Assignee string `json:"assignee,omitempty"`
+	// Form is the schema of the data completing the task
+	Form *PayloadSchema `json:"form,omitempty"`
+	// Due is the time given to complete the task, e.g. "48h"
+	Due string `json:"due,omitempty"`
+	// Event is sent to the machine when the task is completed, with the form data
+	Event string `json:"event"`
+}
+
+// Task is a pending human task
+type Task struct {
+	ID        string         `json:"id"`
+	Session   string         `json:"session"`
+	State     string         `json:"state"`
+	Title     string         `json:"title,omitempty"`
+	Event     string         `json:"event"`
+	Assignee  string         `json:"assignee,omitempty"`
+	ClaimedBy string         `json:"claimedBy,omitempty"`
+	Created   time.Time      `json:"created"`
+	Due       *time.Time     `json:"due,omitempty"`
+	Form      *PayloadSchema `json:"form,omitempty"`
+}
+
+// Overdue tells if the task is past its due date
+func (t Task) Overdue(now time.Time) bool {
+	return t.Due != nil && now.After(*t.Due)
+}
+
+// openTask records the human task of the state just entered and closes the
+// task of the previous state
+func (fsm *Machine) openTask(event Event) {
+	delete(fsm.Context, ContextTask)
+	human := fsm.CurrentState.HumanTask
+	if human == nil {
+		return
+	}
+	assignee := human.Assignee
+	if isSelector(assignee) {
+		var err error
+		if assignee, err = fsm.resolveSelector(human.Assignee, event); err != nil {
+			log.Printf("Error: No assignee for the task of state '%s': %v\n", fsm.CurrentState.Name, err)
+		}
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	now := fsm.clock().Now()
+	task := map[string]interface{}{
+		"id":       hex.EncodeToString(b),
+		"state":    fsm.CurrentState.Name,
+		"title":    human.Title,
+		"event":    human.Event,
+		"assignee": assignee,
+		"created":  now.Format(time.RFC3339Nano),
+	}
+	if human.Due != "" {
+		// The due date was checked by validation
+		if d, err := time.ParseDuration(human.Due); err == nil {
+			task["due"] = now.Add(d).Format(time.RFC3339Nano)
+		}
+	}
+	if fsm.Context == nil {
+		fsm.Context = map[string]interface{}{}
+	}
+	fsm.Context[ContextTask] = task
+}
+
+// taskOf returns the pending task recorded in the snapshot of a session
+// The form is left out, since the snapshot doesn't have the definition
+func taskOf(id string, snap Snapshot) (Task, bool) {
+	record, ok := snap.Context[ContextTask].(map[string]interface{})
+	if !ok {
+		return Task{}, false
+	}
+	field := func(name string) string {
+		s, _ := record[name].(string)
+		return s
+	}
+	task := Task{
+		ID:        field("id"),
+		Session:   id,
+		State:     field("state"),
+		Title:     field("title"),
+		Event:     field("event"),
+		Assignee:  field("assignee"),
+		ClaimedBy: field("claimedBy"),
+	}
+	if task.ID == "" || task.State != snap.CurrentState {
+		return Task{}, false
+	}
+	task.Created, _ = time.Parse(time.RFC3339Nano, field("created"))
+	if due, err := time.Parse(time.RFC3339Nano, field("due")); err == nil {
+		task.Due = &due
+	}
+	return task, true
+}
+
+// PendingTask returns the human task the machine is waiting for
+func (fsm *Machine) PendingTask() (Task, bool) {
+	fsm = fsm.root()
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	return fsm.pendingTask()
+}
+
+// pendingTask returns the human task the locked machine is waiting for
+func (fsm *Machine) pendingTask() (Task, bool) {
+	human := fsm.CurrentState.HumanTask
+	if human == nil {
+		return Task{}, false
+	}
+	task, ok := taskOf(fsm.ID, Snapshot{CurrentState: fsm.CurrentState.Name, Context: fsm.Context})
+	task.Title = human.Title
+	task.Event = human.Event
+	task.Form = human.Form
+	return task, ok
+}
+
+// ClaimTask reserves the pending task for a user
+// Returns ErrTaskClaimed if another user claimed it and ErrTaskNotAssigned
+// if it is assigned to another user
+func (fsm *Machine) ClaimTask(id, user string) error {
+	fsm = fsm.root()
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	task, err := fsm.checkTask(id, user)
+	if err != nil {
+		return err
+	}
+	if task.ClaimedBy == "" {
+		// Snapshots share the record, so it is copied
+		record := copyContext(fsm.Context[ContextTask].(map[string]interface{}))
+		record["claimedBy"] = user
+		fsm.Context[ContextTask] = record
+	}
+	return nil
+}
+
+// CompleteTask completes the pending task for a user by sending the event
+// of the task, with the data as payload
+// The data must match the form of the task, else a *PayloadError is returned
+func (fsm *Machine) CompleteTask(id, user string, data map[string]interface{}) (TransitionResult, error) {
+	fsm = fsm.root()
+	fsm.mu.Lock()
+	task, err := fsm.checkTask(id, user)
+	human := fsm.CurrentState.HumanTask
+	fsm.mu.Unlock()
+	if err != nil {
+		return TransitionResult{}, err
+	}
+	event := Event{ID: "task-" + task.ID, Session: fsm.ID, Action: human.Event, Data: data, task: true}
+	if human.Form != nil {
+		if data == nil {
+			data = map[string]interface{}{}
+		}
+		if err := human.Form.Validate(data); err != nil {
+			return TransitionResult{}, &PayloadError{Event: event.Action, Errors: err.(ValidationErrors)}
+		}
+	}
+	// The ID of the event makes sure the task is only completed once
+	return fsm.SendEvent(event)
+}
+
+// checkTask returns the pending task if the user can work on it
+func (fsm *Machine) checkTask(id, user string) (Task, error) {
+	task, ok := fsm.pendingTask()
+	if !ok || task.ID != id {
+		return Task{}, ErrTaskNotFound
+	}
+	if task.Assignee != "" && task.Assignee != user {
+		return Task{}, ErrTaskNotAssigned
+	}
+	if task.ClaimedBy != "" && task.ClaimedBy != user {
+		return Task{}, ErrTaskClaimed
+	}
+	return task, nil
+}
+
+// Tasks returns the pending tasks of all sessions, evicted ones included
+func (m *Manager) Tasks() []Task {
+	tasks := []Task{}
+	for _, id := range m.Sessions() {
+		snap, ok := m.Snapshot(id)
+		if !ok {
+			continue
+		}
+		if task, ok := taskOf(id, snap); ok {
+			tasks = append(tasks, task)
+		}
+	}
+	return tasks
+}
+
+// Task returns a pending task with its form
+func (m *Manager) Task(id string) (Task, bool) {
+	session, ok := m.taskSession(id)
+	if !ok {
+		return Task{}, false
+	}
+	fsm, ok := m.Get(session)
+	if !ok {
+		return Task{}, false
+	}
+	task, ok := fsm.PendingTask()
+	return task, ok && task.ID == id
+}
+
+// ClaimTask reserves a pending task for a user, see Machine.ClaimTask
+func (m *Manager) ClaimTask(id, user string) error {
+	session, ok := m.taskSession(id)
+	if !ok {
+		return ErrTaskNotFound
+	}
+	_, err := m.run(session, func(error) {}, func(fsm *Machine) (TransitionResult, error) {
+		return TransitionResult{}, fsm.ClaimTask(id, user)
+	})
+	return err
+}
+
+// CompleteTask completes a pending task for a user, see Machine.CompleteTask
+func (m *Manager) CompleteTask(id, user string, data map[string]interface{}) (TransitionResult, error) {
+	session, ok := m.taskSession(id)
+	if !ok {
+		return TransitionResult{}, ErrTaskNotFound
+	}
+	return m.run(session, func(error) {}, func(fsm *Machine) (TransitionResult, error) {
+		return fsm.CompleteTask(id, user, data)
+	})
+}
+
+// taskSession returns the session of a pending task
+func (m *Manager) taskSession(id string) (string, bool) {
+	for _, task := range m.Tasks() {
+		if task.ID == id {
+			return task.Session, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,16 @@
+package gofsm
+
+// WithTenant scopes the manager to a tenant
+// Its machines carry the tenant, which their actions read from Machine.Tenant
+// and which is attached to their transition and audit records. Each tenant
+// has its own manager, so sessions and correlation keys never cross tenants
+func WithTenant(tenant string) ManagerOption {
+	return func(m *Manager) {
+		m.tenant = tenant
+	}
+}
+
+// Tenant returns the tenant of the manager, empty if it isn't scoped to one
+func (m *Manager) Tenant() string {
+	return m.tenant
+}
@@ -0,0 +1,85 @@
+package gofsm
+
+import (
+	"log"
+	"time"
+)
+
+// Timeout injects an event if the machine is still in a state after a delay
+// The delays of the timeouts of a state all count from the state entry, so
+// they form an escalation chain such as a reminder followed by an escalation
+type Timeout struct {
+	After string `json:"after"`
+	Event string `json:"event"`
+	Param string `json:"param,omitempty"`
+}
+
+// stateTimeouts are the pending timeouts of the current state
+type stateTimeouts struct {
+	state string
+	// entry identifies the state entry that scheduled the timeouts
+	entry  uint64
+	timers []Timer
+}
+
+// scheduleTimeouts starts the timeouts of the current state
+// Transitions from a state to itself keep the pending timeouts, so a
+// reminder can loop back to the state without restarting the chain
+func (fsm *Machine) scheduleTimeouts(previous string) {
+	if fsm.timeouts != nil && fsm.timeouts.state == fsm.CurrentState.Name && previous == fsm.CurrentState.Name {
+		return
+	}
+	fsm.cancelTimeouts()
+	if len(fsm.CurrentState.Timeouts) == 0 {
+		return
+	}
+	fsm.timeoutEntries++
+	st := &stateTimeouts{state: fsm.CurrentState.Name, entry: fsm.timeoutEntries}
+	for _, timeout := range fsm.CurrentState.Timeouts {
+		delay, err := time.ParseDuration(timeout.After)
+		if err != nil {
+			log.Printf("Error: Invalid timeout '%s' in state '%s': %v\n", timeout.After, fsm.CurrentState.Name, err)
+			continue
+		}
+		event := Event{Action: timeout.Event, Param: timeout.Param, internal: true}
+		entry := st.entry
+		st.timers = append(st.timers, fsm.clock().AfterFunc(delay, func() {
+			fsm.mu.Lock()
+			defer fsm.mu.Unlock()
+			if err := fsm.runToCompletion(StepTimer, event, func() error {
+				return fsm.fireTimeout(entry, event)
+			}); err != nil {
+				log.Println(err)
+			}
+		}))
+	}
+	fsm.timeouts = st
+}
+
+// fireTimeout sends the event of a timeout if the state hasn't been left in the meantime
+// A running sub-machine is abandoned, since the event is meant for the parent
+func (fsm *Machine) fireTimeout(entry uint64, event Event) error {
+	if fsm.stopped || fsm.timeouts == nil || fsm.timeouts.entry != entry {
+		return nil
+	}
+	i, err := fsm.matchTransition(event.Action, event)
+	if err != nil {
+		return err
+	}
+	if i < 0 {
+		return fsm.noTransition(event.Action)
+	}
+	fsm.child = nil
+	return fsm.beginTransition(i, event)
+}
+
+// cancelTimeouts stops the pending timeouts, if any
+func (fsm *Machine) cancelTimeouts() {
+	if fsm.timeouts == nil {
+		return
+	}
+	for _, timer := range fsm.timeouts.timers {
+		timer.Stop()
+	}
+	fsm.timeouts = nil
+}
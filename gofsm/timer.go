@@ -0,0 +1,66 @@
+package gofsm
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// stateTimer is a pending delayed transition of the current state
+type stateTimer struct {
+	timer Timer
+	// generation identifies the state entry that scheduled the timer
+	generation uint64
+}
+
+// scheduleAfter starts the timer of the current state if it has an 'after' delay
+// Once the delay expires the transition without an event is taken
+func (fsm *Machine) scheduleAfter(event Event) error {
+	delay, err := time.ParseDuration(fsm.CurrentState.After)
+	if err != nil {
+		return fmt.Errorf("Error: Invalid delay '%s' in state '%s': %v",
+			fsm.CurrentState.After, fsm.CurrentState.Name, err)
+	}
+	generation := fsm.generation
+	if !isSelector(fsm.CurrentState.ActionArg) {
+		event.Param = fsm.CurrentState.ActionArg
+	}
+	event.internal = true
+	fsm.timer = &stateTimer{
+		generation: generation,
+		timer: fsm.clock().AfterFunc(delay, func() {
+			fsm.mu.Lock()
+			defer fsm.mu.Unlock()
+			if err := fsm.runToCompletion(StepTimer, event, func() error {
+				return fsm.fireTimer(generation, event)
+			}); err != nil {
+				log.Println(err)
+			}
+		}),
+	}
+	return nil
+}
+
+// fireTimer performs the delayed transition if the state hasn't changed in the meantime
+func (fsm *Machine) fireTimer(generation uint64, event Event) error {
+	if fsm.timer == nil || fsm.timer.generation != generation {
+		return nil
+	}
+	fsm.timer = nil
+	i, err := fsm.matchTransition("", event)
+	if err != nil {
+		return err
+	}
+	if i >= 0 {
+		return fsm.beginTransition(i, event)
+	}
+	return fsm.noTransition("")
+}
+
+// cancelTimer stops the pending timer of the previous state, if any
+func (fsm *Machine) cancelTimer() {
+	if fsm.timer != nil {
+		fsm.timer.timer.Stop()
+		fsm.timer = nil
+	}
+}
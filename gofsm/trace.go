@@ -0,0 +1,160 @@
+package gofsm
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// tracer logs the steps of a traced machine
+type tracer struct {
+	logger *log.Logger
+	// sw turns the trace on and off if set, see WithTraceSwitch
+	sw *TraceSwitch
+	// active is set while the current step is traced
+	active bool
+	// path holds the states of the current step, from the one it started in
+	path []string
+	// moved is set once the current step entered a state
+	moved bool
+}
+
+// WithTrace logs how the machine processes each step, to explain e.g. the
+// events rejected without a transition: the transitions considered, and why
+// they were skipped, from another state, for another event or their guard
+// being false, the transition taken and the states entered
+// The trace goes to the given logger, the standard one if nil
+// It is verbose and meant for debugging
+func WithTrace(logger *log.Logger) Option {
+	return func(fsm *Machine) {
+		if logger == nil {
+			logger = log.New(log.Writer(), log.Prefix(), log.Flags())
+		}
+		fsm.tracer = &tracer{logger: logger}
+	}
+}
+
+// TraceSwitch turns the trace of the machines on and off while they run,
+// e.g. from an admin end point, see WithTraceSwitch
+type TraceSwitch struct {
+	on atomic.Bool
+}
+
+// Set turns the trace on or off, from the next step of the machines
+func (sw *TraceSwitch) Set(on bool) {
+	sw.on.Store(on)
+}
+
+// On tells if the trace is on
+func (sw *TraceSwitch) On() bool {
+	return sw.on.Load()
+}
+
+// WithTraceSwitch logs the steps of the machine like WithTrace while the
+// switch is on
+func WithTraceSwitch(sw *TraceSwitch, logger *log.Logger) Option {
+	return func(fsm *Machine) {
+		WithTrace(logger)(fsm)
+		fsm.tracer.sw = sw
+	}
+}
+
+// tracing tells if the current step of the machine is traced
+func (fsm *Machine) tracing() bool {
+	return fsm.tracer != nil && fsm.tracer.active
+}
+
+// tracef logs a line of the trace, naming the machine if it has an ID
+func (fsm *Machine) tracef(format string, args ...interface{}) {
+	if fsm.ID != "" {
+		format = "Trace [" + fsm.ID + "]: " + format
+	} else {
+		format = "Trace: " + format
+	}
+	fsm.tracer.logger.Print(fsm.redact(fmt.Sprintf(format, args...)))
+}
+
+// beginTrace starts the trace of a step of the machine
+func (fsm *Machine) beginTrace(kind string, event Event) {
+	if fsm.tracer == nil {
+		return
+	}
+	// The switch is read once per step, so a step is traced whole
+	fsm.tracer.active = fsm.tracer.sw == nil || fsm.tracer.sw.On()
+	if !fsm.tracer.active {
+		return
+	}
+	fsm.tracer.path = nil
+	fsm.tracer.moved = false
+	if fsm.CurrentState.Name != "" {
+		fsm.tracer.path = []string{fsm.CurrentState.Name}
+	}
+	switch {
+	case kind == StepInit:
+		fsm.tracef("initializing in state '%s'", fsm.InitialState)
+	case event.Action != "":
+		fsm.tracef("%s '%s' in state '%s'", kind, event.Action, fsm.CurrentState.Name)
+	default:
+		fsm.tracef("%s in state '%s'", kind, fsm.CurrentState.Name)
+	}
+}
+
+// traceState adds the state entered to the path of the step
+func (fsm *Machine) traceState() {
+	if fsm.tracing() {
+		fsm.tracer.path = append(fsm.tracer.path, fsm.CurrentState.Name)
+		fsm.tracer.moved = true
+	}
+}
+
+// endTrace logs the path of the step and its error
+func (fsm *Machine) endTrace(err error) {
+	if !fsm.tracing() {
+		return
+	}
+	switch {
+	case fsm.tracer.moved && len(fsm.tracer.path) == 1:
+		fsm.tracef("entered state '%s'", fsm.tracer.path[0])
+	case fsm.tracer.moved:
+		fsm.tracef("path %s", strings.Join(fsm.tracer.path, " -> "))
+	default:
+		fsm.tracef("stayed in state '%s'", fsm.CurrentState.Name)
+	}
+	if err != nil {
+		fsm.tracef("failed: %v", err)
+	}
+	fsm.tracer.path = nil
+}
+
+// traceMatching logs the search of the transition of an event, or of the
+// eventless transition if the event name is empty
+func (fsm *Machine) traceMatching(eventName string) {
+	if eventName == "" {
+		fsm.tracef("  looking for an eventless transition from state '%s'", fsm.CurrentState.Name)
+	} else {
+		fsm.tracef("  looking for a transition from state '%s' for event '%s'", fsm.CurrentState.Name, eventName)
+	}
+}
+
+// traceDestination logs the destination of the transition taken, and why if
+// it isn't the success one
+func (fsm *Machine) traceDestination(t Transition, to string, success bool) {
+	switch {
+	case t.Branch && !success:
+		fsm.tracef("  the action of state '%s' failed, going to '%s'", fsm.CurrentState.Name, to)
+	case len(t.Outcomes) > 0:
+		fsm.tracef("  the outcome selects '%s'", to)
+	default:
+		fsm.tracef("  going to '%s'", to)
+	}
+}
+
+// traceTransition logs the outcome of the ith transition of the definition
+func (fsm *Machine) traceTransition(i int, t Transition, outcome string) {
+	event := t.Event
+	if event == "" {
+		event = "(eventless)"
+	}
+	fsm.tracef("    transitions[%d] %s --%s--> %s: %s", i, t.From, event, describeDestinations(t), outcome)
+}
@@ -0,0 +1,42 @@
+package gofsm
+
+import (
+	"log"
+)
+
+// Policies for the events without a transition from the current state
+const (
+	// UnknownEventsError rejects the event with ErrNoTransition, the default
+	UnknownEventsError = "error"
+	// UnknownEventsIgnore drops the event silently
+	UnknownEventsIgnore = "ignore"
+	// UnknownEventsLog drops the event and logs it
+	UnknownEventsLog = "log"
+)
+
+// ValidUnknownEvents tells if a policy for the unknown events is known
+func ValidUnknownEvents(policy string) bool {
+	switch policy {
+	case "", UnknownEventsError, UnknownEventsIgnore, UnknownEventsLog:
+		return true
+	}
+	return false
+}
+
+// unknownEvent applies the 'unknownEvents' policy of the definition to an
+// event without a transition from the current state
+// The ignored events leave the machine as it is and count as processed
+func (fsm *Machine) unknownEvent(event Event) error {
+	switch fsm.UnknownEvents {
+	case UnknownEventsIgnore, UnknownEventsLog:
+		if fsm.UnknownEvents == UnknownEventsLog {
+			log.Printf("Ignored event '%s' without a transition from state '%s'", event.Action, fsm.CurrentState.Name)
+		}
+		if fsm.result != nil && fsm.microsteps == 0 {
+			// The external event was ignored, not an internal one
+			fsm.result.Ignored = true
+		}
+		return nil
+	}
+	return fsm.noTransition(event.Action)
+}
@@ -0,0 +1,172 @@
+package gofsm
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/ditek/jsonfsm/gofsm/expr"
+)
+
+// Types of the declared variables
+const (
+	VarNumber  = "number"
+	VarInteger = "integer"
+	VarString  = "string"
+	VarBoolean = "boolean"
+)
+
+// Variable declares a typed variable of the machine context
+type Variable struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Default is the initial value, the zero value of the type if nil
+	Default interface{} `json:"default,omitempty"`
+}
+
+// check returns the value as stored in the context, or an error if it doesn't have the type of the variable
+// Numbers are stored as float64, like the numbers decoded from JSON
+func (v Variable) check(value interface{}) (interface{}, error) {
+	switch v.Type {
+	case VarNumber, VarInteger:
+		n, ok := numberOf(value)
+		if !ok {
+			return nil, fmt.Errorf("expected %s, got %s", v.Type, typeName(value))
+		}
+		if v.Type == VarInteger && n != math.Trunc(n) {
+			return nil, fmt.Errorf("expected integer, got %v", n)
+		}
+		return n, nil
+	case VarString:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+	case VarBoolean:
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown variable type '%s'", v.Type)
+	}
+	return nil, fmt.Errorf("expected %s, got %s", v.Type, typeName(value))
+}
+
+// parse converts the text of a value to the type of the variable
+func (v Variable) parse(s string) (interface{}, error) {
+	switch v.Type {
+	case VarNumber, VarInteger:
+		n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return nil, fmt.Errorf("expected %s, got '%s'", v.Type, s)
+		}
+		return v.check(n)
+	case VarBoolean:
+		b, err := strconv.ParseBool(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("expected boolean, got '%s'", s)
+		}
+		return b, nil
+	}
+	return v.check(s)
+}
+
+// initial returns the default value of the variable
+func (v Variable) initial() interface{} {
+	if v.Default != nil {
+		return v.Default
+	}
+	switch v.Type {
+	case VarNumber, VarInteger:
+		return float64(0)
+	case VarBoolean:
+		return false
+	}
+	return ""
+}
+
+// numberOf returns a Go number as float64
+func numberOf(value interface{}) (float64, bool) {
+	switch value.(type) {
+	case string:
+		return 0, false
+	}
+	return expr.ToNumber(value)
+}
+
+// typeName names the type of a value in the terms of the definition
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return VarString
+	case bool:
+		return VarBoolean
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+	if _, ok := numberOf(value); ok {
+		return VarNumber
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// variable returns the declaration of a variable, or nil if it isn't declared
+func (def *Definition) variable(name string) *Variable {
+	for i := range def.Variables {
+		if def.Variables[i].Name == name {
+			return &def.Variables[i]
+		}
+	}
+	return nil
+}
+
+// initVariables sets the declared variables to their default value
+func (fsm *Machine) initVariables() {
+	if len(fsm.Variables) > 0 && fsm.Context == nil {
+		fsm.Context = make(map[string]interface{}, len(fsm.Variables))
+	}
+	for _, v := range fsm.Variables {
+		fsm.Context[v.Name] = v.initial()
+	}
+}
+
+// SetVar assigns the value of an expression to a declared variable
+// The variable and the expression are the "name" and "expr" state args, or
+// the argument has the form "name = expr", e.g. "retries = retries + 1"
+// Fails if the variable isn't declared or the value doesn't have its type
+func (fsm *Machine) SetVar(arg string) bool {
+	name, source := fsm.CurrentState.Args["name"], fsm.CurrentState.Args["expr"]
+	if name == "" {
+		var ok bool
+		if name, source, ok = splitVariable(arg); !ok {
+			fsm.logf("Error: Invalid variable assignment '%s'", arg)
+			return false
+		}
+	}
+	decl := fsm.variable(name)
+	if decl == nil {
+		log.Printf("Error: Variable '%s' is not declared\n", name)
+		return false
+	}
+	e, err := parseGuard(source)
+	if err != nil {
+		fsm.logf("Error: Invalid expression '%s': %v", source, err)
+		return false
+	}
+	value, err := e.Eval(fsm.exprVars(Event{Param: arg}))
+	if err != nil {
+		fsm.logf("Error: Expression '%s' failed: %v", source, err)
+		return false
+	}
+	if value, err = decl.check(value); err != nil {
+		fsm.logf("Error: Variable '%s' can't be set: %v", name, err)
+		return false
+	}
+	fsm.Context[name] = value
+	return true
+}
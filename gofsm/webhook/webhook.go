@@ -0,0 +1,194 @@
+// Package webhook posts the transitions of state machines to HTTP endpoints
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ditek/jsonfsm/gofsm"
+	"github.com/ditek/jsonfsm/gofsm/cloudevents"
+)
+
+// Defaults used when the config leaves them out
+const (
+	defaultRetries   = 3
+	defaultBackoff   = time.Second
+	defaultTimeout   = 10 * time.Second
+	defaultQueueSize = 1000
+	defaultSource    = "jsonfsm"
+)
+
+// Formats of the notifications
+const (
+	// FormatJSON posts the transition records as they are
+	FormatJSON = "json"
+	// FormatCloudEvents posts the transition records as structured CloudEvents
+	FormatCloudEvents = "cloudevents"
+)
+
+// Config describes a webhook endpoint
+type Config struct {
+	URL string `json:"url"`
+	// States limits the notifications to transitions into these states, all transitions if empty
+	States []string `json:"states,omitempty"`
+	// Retries is the number of additional attempts after a failed delivery
+	Retries int `json:"retries,omitempty"`
+	// Backoff is the delay before the first retry, doubled for every further retry
+	Backoff string `json:"backoff,omitempty"`
+	// Format is FormatJSON (default) or FormatCloudEvents
+	Format string `json:"format,omitempty"`
+	// Source is the source attribute of the CloudEvents, "jsonfsm" if empty
+	Source string `json:"source,omitempty"`
+	// Alerts posts the audit records of the stuck instances too
+	Alerts bool `json:"alerts,omitempty"`
+}
+
+// Sink delivers transition records and alerts to a webhook endpoint in the background
+// Records are dropped when the queue is full so that machines never block
+type Sink struct {
+	url     string
+	states  map[string]bool
+	retries int
+	backoff time.Duration
+	format  string
+	source  string
+	alerts  bool
+	client  *http.Client
+	// queue holds the transition records and the alerts to deliver
+	queue chan interface{}
+}
+
+// NewSink creates a sink and starts its delivery goroutine
+func NewSink(cfg Config) (*Sink, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("Error: A webhook needs a URL")
+	}
+	s := &Sink{
+		url:     cfg.URL,
+		retries: cfg.Retries,
+		backoff: defaultBackoff,
+		format:  cfg.Format,
+		source:  cfg.Source,
+		alerts:  cfg.Alerts,
+		client:  &http.Client{Timeout: defaultTimeout},
+		queue:   make(chan interface{}, defaultQueueSize),
+	}
+	if s.retries == 0 {
+		s.retries = defaultRetries
+	}
+	switch s.format {
+	case "":
+		s.format = FormatJSON
+	case FormatJSON, FormatCloudEvents:
+	default:
+		return nil, fmt.Errorf("Error: Unknown webhook format '%s'", cfg.Format)
+	}
+	if s.source == "" {
+		s.source = defaultSource
+	}
+	if cfg.Backoff != "" {
+		d, err := time.ParseDuration(cfg.Backoff)
+		if err != nil {
+			return nil, fmt.Errorf("Error: Invalid webhook backoff '%s': %v", cfg.Backoff, err)
+		}
+		s.backoff = d
+	}
+	if len(cfg.States) > 0 {
+		s.states = map[string]bool{}
+		for _, state := range cfg.States {
+			s.states[state] = true
+		}
+	}
+	go s.run()
+	return s, nil
+}
+
+// Notify queues a transition record for delivery
+// It can be registered as a gofsm.TransitionListener
+func (s *Sink) Notify(record gofsm.TransitionRecord) {
+	if s.states != nil && !s.states[record.To] {
+		return
+	}
+	select {
+	case s.queue <- record:
+	default:
+		log.Println("Error: Webhook queue is full, dropping transition to", record.To)
+	}
+}
+
+// Audit queues the alerts of stuck instances for delivery if the sink posts
+// them, the other audit records are ignored
+// It can be registered as a gofsm.AuditSink
+func (s *Sink) Audit(record gofsm.AuditRecord) {
+	if !s.alerts || record.Kind != gofsm.AuditStuck {
+		return
+	}
+	select {
+	case s.queue <- record:
+	default:
+		log.Println("Error: Webhook queue is full, dropping alert of", record.Machine)
+	}
+}
+
+// run delivers the queued records in order
+func (s *Sink) run() {
+	for record := range s.queue {
+		s.deliver(record)
+	}
+}
+
+// deliver posts a transition or an audit record, retrying with exponential backoff
+func (s *Sink) deliver(record interface{}) {
+	contentType := "application/json"
+	var payload []byte
+	var err error
+	switch r := record.(type) {
+	case gofsm.TransitionRecord:
+		if s.format == FormatCloudEvents {
+			contentType = cloudevents.ContentType
+			payload, err = cloudevents.FromTransition(r, s.source)
+		} else {
+			payload, err = json.Marshal(r)
+		}
+	case gofsm.AuditRecord:
+		if s.format == FormatCloudEvents {
+			contentType = cloudevents.ContentType
+			payload, err = cloudevents.FromAudit(r, s.source)
+		} else {
+			payload, err = json.Marshal(r)
+		}
+	}
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	backoff := s.backoff
+	for attempt := 0; ; attempt++ {
+		err = s.post(contentType, payload)
+		if err == nil {
+			return
+		}
+		if attempt >= s.retries {
+			log.Printf("Error: Webhook delivery to %s failed: %v\n", s.url, err)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (s *Sink) post(contentType string, payload []byte) error {
+	resp, err := s.client.Post(s.url, contentType, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ditek/jsonfsm/gofsm"
+	"github.com/ditek/jsonfsm/gofsm/cloudevents"
+)
+
+// request is a delivery received by the test endpoint
+type request struct {
+	contentType string
+	body        []byte
+}
+
+// endpoint starts an HTTP server answering the first failures requests with
+// an error and returns its URL with the channel of the deliveries
+func endpoint(t *testing.T, failures int) (string, chan request) {
+	t.Helper()
+	requests := make(chan request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		requests <- request{r.Header.Get("Content-Type"), body}
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, requests
+}
+
+// receive waits for the next delivery
+func receive(t *testing.T, requests chan request) request {
+	t.Helper()
+	select {
+	case r := <-requests:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("No delivery")
+		return request{}
+	}
+}
+
+// none checks that nothing else is delivered
+func none(t *testing.T, requests chan request) {
+	t.Helper()
+	select {
+	case r := <-requests:
+		t.Errorf("Unexpected delivery %s", r.body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestNotify(t *testing.T) {
+	url, requests := endpoint(t, 0)
+	sink, err := NewSink(Config{URL: url, States: []string{"ARMED"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.Notify(gofsm.TransitionRecord{Machine: "s1", From: "ARMED", To: "IDLE"})
+	sink.Notify(gofsm.TransitionRecord{Machine: "s1", From: "IDLE", To: "ARMED", Event: "ARM"})
+	r := receive(t, requests)
+	if r.contentType != "application/json" {
+		t.Errorf("Got content type %s", r.contentType)
+	}
+	var record gofsm.TransitionRecord
+	if err := json.Unmarshal(r.body, &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.To != "ARMED" || record.Event != "ARM" {
+		t.Errorf("Got %+v, want the transition to ARMED", record)
+	}
+	none(t, requests)
+}
+
+func TestCloudEvents(t *testing.T) {
+	url, requests := endpoint(t, 0)
+	sink, err := NewSink(Config{URL: url, Format: FormatCloudEvents, Source: "/shop", Alerts: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.Notify(gofsm.TransitionRecord{Machine: "s1", From: "IDLE", To: "ARMED", Event: "ARM"})
+	sink.Audit(gofsm.AuditRecord{Kind: "transition", Machine: "s1"})
+	sink.Audit(gofsm.AuditRecord{Kind: gofsm.AuditStuck, Machine: "s1"})
+	for _, kind := range []string{"transition", "alert"} {
+		r := receive(t, requests)
+		if r.contentType != cloudevents.ContentType {
+			t.Errorf("Got content type %s for the %s", r.contentType, kind)
+		}
+		var event map[string]interface{}
+		if err := json.Unmarshal(r.body, &event); err != nil {
+			t.Fatal(err)
+		}
+		if event["source"] != "/shop" || event["subject"] != "s1" {
+			t.Errorf("Got %s for the %s", r.body, kind)
+		}
+	}
+	none(t, requests)
+}
+
+func TestRetries(t *testing.T) {
+	url, requests := endpoint(t, 2)
+	sink, err := NewSink(Config{URL: url, Retries: 2, Backoff: "1ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.Notify(gofsm.TransitionRecord{Machine: "s1", To: "ARMED"})
+	if r := receive(t, requests); !strings.Contains(string(r.body), `"ARMED"`) {
+		t.Errorf("Got %s", r.body)
+	}
+}
+
+func TestNewSinkErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		err  string
+	}{
+		{"no URL", Config{}, "needs a URL"},
+		{"bad format", Config{URL: "http://localhost", Format: "xml"}, "Unknown webhook format 'xml'"},
+		{"bad backoff", Config{URL: "http://localhost", Backoff: "soon"}, "Invalid webhook backoff 'soon'"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewSink(test.cfg)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("Got error %v, want %q", err, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package gofsm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// WorkerPool runs the actions of machines on a bounded number of goroutines,
+// so heavy actions such as HTTP calls or database writes can't take up more
+// than their share of the server
+// Each action can have its own concurrency limit below the size of the pool
+type WorkerPool struct {
+	jobs chan func()
+	// limits holds a semaphore for each limited action
+	limits map[string]chan struct{}
+
+	mu      sync.Mutex
+	size    int
+	busy    int
+	waiting int
+	running map[string]int
+}
+
+// WorkerPoolStats describes the load of a worker pool
+type WorkerPoolStats struct {
+	Workers int `json:"workers"`
+	Busy    int `json:"busy"`
+	// Waiting is the number of actions waiting for a worker or for their limit
+	Waiting int `json:"waiting"`
+	// Running is the number of running invocations of each action
+	Running map[string]int `json:"running"`
+}
+
+// NewWorkerPool starts a pool of size workers
+// limits maps action names to the number of their invocations that may run
+// at once, the actions that aren't listed are only bounded by the size
+func NewWorkerPool(size int, limits map[string]int) (*WorkerPool, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("Error: A worker pool needs at least one worker")
+	}
+	p := &WorkerPool{
+		jobs:    make(chan func()),
+		limits:  make(map[string]chan struct{}, len(limits)),
+		size:    size,
+		running: map[string]int{},
+	}
+	for name, limit := range limits {
+		if limit <= 0 {
+			return nil, fmt.Errorf("Error: Invalid concurrency limit %d of action '%s'", limit, name)
+		}
+		p.limits[name] = make(chan struct{}, limit)
+	}
+	for i := 0; i < size; i++ {
+		go p.work()
+	}
+	return p, nil
+}
+
+// WithWorkerPool runs the actions of the machine on a worker pool instead of
+// the goroutine processing the event, which waits for their result
+// Sub-machines and clones use the pool of the machine they come from
+func WithWorkerPool(pool *WorkerPool) Option {
+	return func(fsm *Machine) {
+		fsm.pool = pool
+	}
+}
+
+// work runs the submitted jobs
+func (p *WorkerPool) work() {
+	for job := range p.jobs {
+		job()
+	}
+}
+
+// submit runs an action on a worker once its limit allows it
+// Waiting for the limit doesn't hold a worker, so the actions at their limit
+// don't keep the others waiting. Returns the error of the context if it is
+// done before the action starts
+func (p *WorkerPool) submit(ctx context.Context, name string, fn func()) error {
+	p.mu.Lock()
+	p.waiting++
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.waiting--
+		p.mu.Unlock()
+	}()
+
+	limit := p.limits[name]
+	if limit != nil {
+		select {
+		case limit <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	job := func() {
+		p.track(name, 1)
+		defer p.track(name, -1)
+		if limit != nil {
+			defer func() { <-limit }()
+		}
+		fn()
+	}
+	select {
+	case p.jobs <- job:
+		return nil
+	case <-ctx.Done():
+		if limit != nil {
+			<-limit
+		}
+		return ctx.Err()
+	}
+}
+
+// track counts the running invocations of an action
+func (p *WorkerPool) track(name string, delta int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.busy += delta
+	p.running[name] += delta
+	if p.running[name] == 0 {
+		delete(p.running, name)
+	}
+}
+
+// Stats returns the current load of the pool
+func (p *WorkerPool) Stats() WorkerPoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := WorkerPoolStats{Workers: p.size, Busy: p.busy, Waiting: p.waiting, Running: make(map[string]int, len(p.running))}
+	for name, n := range p.running {
+		stats.Running[name] = n
+	}
+	return stats
+}
@@ -0,0 +1,400 @@
+// Package xstate converts XState machine configs to gofsm definitions
+// so machines defined for a frontend can be mirrored server-side.
+//
+// Flat machines are supported: events, guarded and eventless ('always')
+// transitions, delayed transitions, final states and the context.
+// XState runs entry actions when a state is entered while gofsm runs the
+// action of a state when it is left, so exit actions become state actions
+// and entry actions become the actions of the transitions into the state.
+// Features that have no equivalent are reported as warnings.
+package xstate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ditek/jsonfsm/gofsm"
+)
+
+// machineConfig is the root of an XState config
+type machineConfig struct {
+	ID      string                 `json:"id"`
+	Initial string                 `json:"initial"`
+	Context map[string]interface{} `json:"context"`
+	States  json.RawMessage        `json:"states"`
+}
+
+// stateConfig is a state node of an XState config
+type stateConfig struct {
+	Type   string          `json:"type"`
+	States json.RawMessage `json:"states"`
+	On     json.RawMessage `json:"on"`
+	Always json.RawMessage `json:"always"`
+	After  json.RawMessage `json:"after"`
+	Entry  json.RawMessage `json:"entry"`
+	Exit   json.RawMessage `json:"exit"`
+	Invoke json.RawMessage `json:"invoke"`
+}
+
+// transitionConfig is a normalized XState transition
+type transitionConfig struct {
+	target  string
+	guard   string
+	actions []string
+}
+
+// converter accumulates the definition and the warnings
+type converter struct {
+	id       string
+	def      gofsm.Definition
+	warnings []string
+	// entry holds the entry actions of every state
+	entry map[string][]string
+	// pending holds the transitions of every state, resolved once all entry actions are known
+	pending []pendingTransition
+}
+
+type pendingTransition struct {
+	from  string
+	event string
+	transitionConfig
+}
+
+// Convert converts an XState machine config to a validated gofsm definition
+// The warnings list the parts of the config that could not be converted
+func Convert(data []byte) (*gofsm.Definition, []string, error) {
+	var config machineConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, nil, fmt.Errorf("Error: Invalid XState config: %v", err)
+	}
+	c := &converter{
+		id:    config.ID,
+		entry: map[string][]string{},
+		def: gofsm.Definition{
+			SchemaVersion:  gofsm.SchemaVersion,
+			InitialState:   config.Initial,
+			InitialContext: config.Context,
+			States:         []gofsm.State{},
+			Transitions:    []gofsm.Transition{},
+		},
+	}
+
+	names, err := orderedKeys(config.States)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error: Invalid XState states: %v", err)
+	}
+	var states map[string]stateConfig
+	if err := json.Unmarshal(config.States, &states); err != nil {
+		return nil, nil, fmt.Errorf("Error: Invalid XState states: %v", err)
+	}
+	for _, name := range names {
+		if err := c.addState(name, states[name]); err != nil {
+			return nil, nil, err
+		}
+	}
+	c.addTransitions()
+	if len(c.entry[config.Initial]) > 0 {
+		c.warn("state '%s': entry actions of the initial state are not run", config.Initial)
+	}
+
+	// Round-trip through JSON so the definition is validated and indexed
+	out, err := json.Marshal(&c.def)
+	if err != nil {
+		return nil, nil, err
+	}
+	def, err := gofsm.LoadDefinition(out)
+	if err != nil {
+		return nil, c.warnings, err
+	}
+	return def, c.warnings, nil
+}
+
+func (c *converter) warn(format string, args ...interface{}) {
+	c.warnings = append(c.warnings, fmt.Sprintf(format, args...))
+}
+
+// addState converts a state node and collects its transitions
+func (c *converter) addState(name string, s stateConfig) error {
+	if isSet(s.States) {
+		return fmt.Errorf("Error: State '%s' has nested states, which are not supported", name)
+	}
+	if s.Type == "parallel" || s.Type == "history" {
+		return fmt.Errorf("Error: State '%s' is a %s state, which is not supported", name, s.Type)
+	}
+	state := gofsm.State{Name: name, WaitForEvent: true}
+	if s.Type == "final" {
+		state.Final = true
+	}
+	if isSet(s.Invoke) {
+		c.warn("state '%s': invoke is not converted", name)
+	}
+
+	entry, err := parseActions(s.Entry)
+	if err != nil {
+		return fmt.Errorf("Error: State '%s' entry: %v", name, err)
+	}
+	c.entry[name] = entry
+	exit, err := parseActions(s.Exit)
+	if err != nil {
+		return fmt.Errorf("Error: State '%s' exit: %v", name, err)
+	}
+	state.Action = c.single(exit, "state '%s' exit", name)
+
+	if isSet(s.On) {
+		events, err := orderedKeys(s.On)
+		if err != nil {
+			return fmt.Errorf("Error: State '%s' on: %v", name, err)
+		}
+		var on map[string]json.RawMessage
+		if err := json.Unmarshal(s.On, &on); err != nil {
+			return fmt.Errorf("Error: State '%s' on: %v", name, err)
+		}
+		for _, event := range events {
+			if event == "*" || strings.HasPrefix(event, "done.") || strings.HasPrefix(event, "error.") {
+				c.warn("state '%s': event '%s' is not converted", name, event)
+				continue
+			}
+			if err := c.addPending(name, event, on[event]); err != nil {
+				return err
+			}
+		}
+	}
+
+	if isSet(s.Always) {
+		// The state is left as soon as it is entered
+		state.WaitForEvent = false
+		if err := c.addPending(name, "", s.Always); err != nil {
+			return err
+		}
+	}
+
+	if isSet(s.After) {
+		delays, err := orderedKeys(s.After)
+		if err != nil {
+			return fmt.Errorf("Error: State '%s' after: %v", name, err)
+		}
+		var after map[string]json.RawMessage
+		if err := json.Unmarshal(s.After, &after); err != nil {
+			return fmt.Errorf("Error: State '%s' after: %v", name, err)
+		}
+		for i, delay := range delays {
+			ms, err := strconv.Atoi(delay)
+			if err != nil || i > 0 || state.After != "" || !state.WaitForEvent {
+				c.warn("state '%s': delayed transition after '%s' is not converted", name, delay)
+				continue
+			}
+			state.After = fmt.Sprintf("%dms", ms)
+			if err := c.addPending(name, "", after[delay]); err != nil {
+				return err
+			}
+		}
+	}
+
+	c.def.States = append(c.def.States, state)
+	return nil
+}
+
+// addPending parses the transitions of an event and keeps them for addTransitions
+func (c *converter) addPending(from, event string, raw json.RawMessage) error {
+	transitions, err := c.parseTransitions(raw)
+	if err != nil {
+		return fmt.Errorf("Error: State '%s' event '%s': %v", from, event, err)
+	}
+	for _, t := range transitions {
+		c.pending = append(c.pending, pendingTransition{from: from, event: event, transitionConfig: t})
+	}
+	return nil
+}
+
+// addTransitions converts the collected transitions
+// The entry actions of the target are run after the actions of the transition
+func (c *converter) addTransitions() {
+	events := map[string]bool{}
+	for _, p := range c.pending {
+		target := p.target
+		if target == "" {
+			// Targetless transitions only run actions, they re-enter the state in gofsm
+			target = p.from
+		}
+		actions := append(append([]string(nil), p.actions...), c.entry[target]...)
+		t := gofsm.Transition{
+			From:      p.from,
+			ToSuccess: target,
+			Event:     p.event,
+			Guard:     p.guard,
+			Action:    c.single(actions, "transition from '%s' on '%s'", p.from, p.event),
+		}
+		c.def.Transitions = append(c.def.Transitions, t)
+		if p.event != "" {
+			events[p.event] = true
+		}
+	}
+	for event := range events {
+		c.def.Events = append(c.def.Events, event)
+	}
+	sort.Strings(c.def.Events)
+}
+
+// single returns the only action of a list, gofsm runs one action per state or transition
+func (c *converter) single(actions []string, format string, args ...interface{}) string {
+	if len(actions) == 0 {
+		return ""
+	}
+	if len(actions) > 1 {
+		c.warn(format+": only the first of the actions %v is kept", append(args, actions)...)
+	}
+	return actions[0]
+}
+
+// parseTransitions normalizes the forms of an XState transition:
+// a target, a transition object or an array of them
+func (c *converter) parseTransitions(raw json.RawMessage) ([]transitionConfig, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > 0 && raw[0] == '[' {
+		var items []json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, err
+		}
+		var transitions []transitionConfig
+		for _, item := range items {
+			t, err := c.parseTransition(item)
+			if err != nil {
+				return nil, err
+			}
+			transitions = append(transitions, t)
+		}
+		return transitions, nil
+	}
+	t, err := c.parseTransition(raw)
+	if err != nil {
+		return nil, err
+	}
+	return []transitionConfig{t}, nil
+}
+
+func (c *converter) parseTransition(raw json.RawMessage) (transitionConfig, error) {
+	var target string
+	if err := json.Unmarshal(raw, &target); err == nil {
+		return transitionConfig{target: c.resolveTarget(target)}, nil
+	}
+	var obj struct {
+		Target  json.RawMessage `json:"target"`
+		Cond    json.RawMessage `json:"cond"`
+		Guard   json.RawMessage `json:"guard"`
+		Actions json.RawMessage `json:"actions"`
+	}
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return transitionConfig{}, fmt.Errorf("invalid transition: %v", err)
+	}
+	var t transitionConfig
+	if isSet(obj.Target) {
+		var targets []string
+		if err := json.Unmarshal(obj.Target, &target); err == nil {
+			targets = []string{target}
+		} else if err := json.Unmarshal(obj.Target, &targets); err != nil {
+			return t, fmt.Errorf("invalid target: %s", obj.Target)
+		}
+		if len(targets) > 1 {
+			c.warn("only the first of the targets %v is kept", targets)
+		}
+		if len(targets) > 0 {
+			t.target = c.resolveTarget(targets[0])
+		}
+	}
+	guard := obj.Guard
+	if !isSet(guard) {
+		guard = obj.Cond
+	}
+	if isSet(guard) {
+		// Named guards become expressions, e.g. a context variable of the same name
+		names, err := parseActions(guard)
+		if err != nil || len(names) != 1 {
+			return t, fmt.Errorf("invalid guard: %s", guard)
+		}
+		t.guard = names[0]
+	}
+	actions, err := parseActions(obj.Actions)
+	if err != nil {
+		return t, err
+	}
+	t.actions = actions
+	return t, nil
+}
+
+// resolveTarget returns the state name of a target such as "#machine.state"
+func (c *converter) resolveTarget(target string) string {
+	if strings.HasPrefix(target, "#") {
+		target = strings.TrimPrefix(target[1:], c.id+".")
+	}
+	if strings.HasPrefix(target, ".") || strings.Contains(target, ".") {
+		c.warn("target '%s' of a nested state is kept as is", target)
+	}
+	return target
+}
+
+// parseActions returns the names of actions given as a name, an object
+// with a 'type' or an array of them
+func parseActions(raw json.RawMessage) ([]string, error) {
+	if !isSet(raw) {
+		return nil, nil
+	}
+	raw = bytes.TrimSpace(raw)
+	var items []json.RawMessage
+	if raw[0] == '[' {
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, err
+		}
+	} else {
+		items = []json.RawMessage{raw}
+	}
+	var names []string
+	for _, item := range items {
+		var name string
+		if err := json.Unmarshal(item, &name); err == nil {
+			names = append(names, name)
+			continue
+		}
+		var obj struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(item, &obj); err != nil || obj.Type == "" {
+			return nil, fmt.Errorf("invalid action: %s", item)
+		}
+		names = append(names, obj.Type)
+	}
+	return names, nil
+}
+
+// orderedKeys returns the keys of a JSON object in document order
+func orderedKeys(raw json.RawMessage) ([]string, error) {
+	if !isSet(raw) {
+		return nil, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, fmt.Errorf("expected object")
+	}
+	var keys []string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, tok.(string))
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// isSet reports whether an optional JSON value is present
+func isSet(raw json.RawMessage) bool {
+	raw = bytes.TrimSpace(raw)
+	return len(raw) > 0 && !bytes.Equal(raw, []byte("null"))
+}
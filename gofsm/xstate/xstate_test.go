@@ -0,0 +1,96 @@
+package xstate
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ditek/jsonfsm/gofsm"
+)
+
+const toggleConfig = `{
+	"id": "toggle",
+	"initial": "off",
+	"context": {"allowed": true},
+	"states": {
+		"off": {"on": {"TOGGLE": {"target": "on", "guard": "allowed"}}},
+		"on": {
+			"exit": "Log",
+			"on": {"TOGGLE": "#toggle.off", "BREAK": "broken"},
+			"invoke": {"src": "poll"}
+		},
+		"broken": {"always": "done"},
+		"done": {"type": "final"}
+	}
+}`
+
+func TestConvertRoundTrip(t *testing.T) {
+	def, warnings, err := Convert([]byte(toggleConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "invoke") {
+		t.Errorf("Got warnings %v, want the one of invoke", warnings)
+	}
+	// The converted definition loads back as it is
+	data, err := json.Marshal(def)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := gofsm.LoadDefinition(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.States, def.States) || !reflect.DeepEqual(loaded.Transitions, def.Transitions) {
+		t.Errorf("Got a different definition after the round trip:\n%s", data)
+	}
+	if want := []string{"BREAK", "TOGGLE"}; !reflect.DeepEqual(def.Events, want) {
+		t.Errorf("Got events %v, want %v", def.Events, want)
+	}
+
+	// The machine behaves like the XState one
+	fsm := gofsm.NewMachine(loaded)
+	fsm.Init()
+	steps := []struct {
+		event string
+		state string
+	}{
+		{"TOGGLE", "on"},
+		{"TOGGLE", "off"},
+		{"TOGGLE", "on"},
+		{"BREAK", "done"},
+	}
+	for _, step := range steps {
+		if _, err := fsm.SendEvent(gofsm.Event{Action: step.event}); err != nil {
+			t.Fatalf("%s: %v", step.event, err)
+		}
+		if fsm.CurrentState.Name != step.state {
+			t.Fatalf("%s: got state %s, want %s", step.event, fsm.CurrentState.Name, step.state)
+		}
+	}
+}
+
+func TestConvertErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{"invalid JSON", `{`, "Invalid XState config"},
+		{"nested states", `{"initial": "a", "states": {"a": {"states": {"b": {}}}}}`, "nested states"},
+		{"parallel state", `{"initial": "a", "states": {"a": {"type": "parallel"}}}`, "parallel state"},
+		{"invalid transition", `{"initial": "a", "states": {"a": {"on": {"GO": 42}}}}`, "invalid transition"},
+		{"unknown target", `{"initial": "a", "states": {"a": {"on": {"GO": "b"}}}}`, "b"},
+	}
+	for _, test := range tests {
+		_, _, err := Convert([]byte(test.config))
+		if err == nil {
+			t.Errorf("%s: converted without error", test.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got error %q, want %q", test.name, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ditek/jsonfsm/gofsm"
+	"github.com/gorilla/mux"
+)
+
+// instanceHistory is the number of transitions kept for each instance
+const instanceHistory = 1000
+
+// instanceRegistry starts machine instances from the uploaded definitions
+// and keeps their metadata and recent transitions
+type instanceRegistry struct {
+	manager     *gofsm.Manager
+	definitions *definitionRegistry
+	// auth authorizes the events sent by the bulk operations
+	auth *authenticator
+	// options are given to the machines of the instances
+	options []gofsm.Option
+
+	mu        sync.Mutex
+	instances map[string]*instance
+}
+
+// instance is the metadata of a running machine
+type instance struct {
+	definition string
+	started    time.Time
+	history    []gofsm.TransitionRecord
+}
+
+// instanceInfo describes an instance in the API
+type instanceInfo struct {
+	ID           string                   `json:"id"`
+	Definition   string                   `json:"definition,omitempty"`
+	CurrentState string                   `json:"currentState"`
+	Terminated   bool                     `json:"terminated,omitempty"`
+	AbortReason  string                   `json:"abortReason,omitempty"`
+	Paused       bool                     `json:"paused,omitempty"`
+	Stuck        *gofsm.StuckInstance     `json:"stuck,omitempty"`
+	StartedAt    *time.Time               `json:"startedAt,omitempty"`
+	Context      map[string]interface{}   `json:"context,omitempty"`
+	History      []gofsm.TransitionRecord `json:"history,omitempty"`
+}
+
+// newInstanceRegistry creates the registry of the machines of a manager
+// It must be created before the sessions so it sees all their transitions
+func newInstanceRegistry(manager *gofsm.Manager, definitions *definitionRegistry, auth *authenticator, options ...gofsm.Option) *instanceRegistry {
+	reg := &instanceRegistry{
+		manager:     manager,
+		definitions: definitions,
+		auth:        auth,
+		options:     options,
+		instances:   map[string]*instance{},
+	}
+	manager.OnTransition(reg.record)
+	return reg
+}
+
+// record keeps a transition in the history of its instance
+func (reg *instanceRegistry) record(record gofsm.TransitionRecord) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	inst, ok := reg.instances[record.Machine]
+	if !ok {
+		// Sessions created by their first event use the default definition
+		inst = &instance{started: record.Timestamp}
+		reg.instances[record.Machine] = inst
+	}
+	inst.history = append(inst.history, record)
+	if len(inst.history) > instanceHistory {
+		inst.history = inst.history[len(inst.history)-instanceHistory:]
+	}
+}
+
+// routes registers the instance end points, wrapped with the given middleware
+func (reg *instanceRegistry) routes(r *mux.Router, wrap func(http.Handler) http.Handler) {
+	r.Handle("/instances", wrap(http.HandlerFunc(reg.listHandler))).Methods("GET")
+	r.Handle("/instances", wrap(http.HandlerFunc(reg.startHandler))).Methods("POST")
+	r.Handle("/instances/bulk", wrap(http.HandlerFunc(reg.bulkHandler))).Methods("POST")
+	r.Handle("/instances/{id}", wrap(http.HandlerFunc(reg.getHandler))).Methods("GET")
+	r.Handle("/instances/{id}", wrap(http.HandlerFunc(reg.deleteHandler))).Methods("DELETE")
+	r.Handle("/instances/{id}/pause", wrap(http.HandlerFunc(reg.pauseHandler))).Methods("POST")
+	r.Handle("/instances/{id}/resume", wrap(http.HandlerFunc(reg.resumeHandler))).Methods("POST")
+	r.Handle("/instances/{id}/reset", wrap(http.HandlerFunc(reg.resetHandler))).Methods("POST")
+}
+
+// info describes an instance, with its context and history if detailed
+func (reg *instanceRegistry) info(id string, snap gofsm.Snapshot, detailed bool) instanceInfo {
+	i := instanceInfo{ID: id, CurrentState: snap.CurrentState, Terminated: snap.Terminated, AbortReason: snap.AbortReason, Paused: snap.Paused}
+	reg.mu.Lock()
+	if inst, ok := reg.instances[id]; ok {
+		i.Definition = inst.definition
+		started := inst.started
+		i.StartedAt = &started
+		if detailed {
+			i.History = append([]gofsm.TransitionRecord{}, inst.history...)
+		}
+	}
+	reg.mu.Unlock()
+	if detailed {
+		i.Context = snap.Context
+	}
+	return i
+}
+
+// listHandler lists the instances, only the ones stuck past the SLA of
+// their state with ?stuck=true
+func (reg *instanceRegistry) listHandler(w http.ResponseWriter, r *http.Request) {
+	infos := []instanceInfo{}
+	if r.URL.Query().Get("stuck") == "true" {
+		for _, stuck := range reg.manager.Stuck() {
+			if snap, ok := reg.manager.Snapshot(stuck.ID); ok {
+				info := reg.info(stuck.ID, snap, false)
+				info.Stuck = &stuck
+				infos = append(infos, info)
+			}
+		}
+		gofsm.RespondWithJSON(w, http.StatusOK, infos)
+		return
+	}
+	// Evicted instances are described from their snapshot rather than loaded
+	for _, id := range reg.manager.Sessions() {
+		if snap, ok := reg.manager.Snapshot(id); ok {
+			infos = append(infos, reg.info(id, snap, false))
+		}
+	}
+	gofsm.RespondWithJSON(w, http.StatusOK, infos)
+}
+
+// startHandler starts an instance of an uploaded definition
+// The instance ID is generated unless the request gives one, and the
+// optional input is stored in the context of the instance
+func (reg *instanceRegistry) startHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var req struct {
+		ID         string                 `json:"id"`
+		Definition string                 `json:"definition"`
+		Input      map[string]interface{} `json:"input"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		gofsm.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	def, ok := reg.definitions.get(req.Definition)
+	if !ok {
+		gofsm.RespondWithError(w, http.StatusNotFound, "definition not found")
+		return
+	}
+	if req.ID == "" {
+		req.ID = newInstanceID()
+	}
+
+	// The metadata must exist before the machine makes its first transitions
+	reg.mu.Lock()
+	if _, ok := reg.instances[req.ID]; ok {
+		reg.mu.Unlock()
+		gofsm.RespondWithError(w, http.StatusConflict, gofsm.ErrSessionExists.Error())
+		return
+	}
+	reg.instances[req.ID] = &instance{definition: req.Definition, started: time.Now()}
+	reg.mu.Unlock()
+
+	// The input is in the context before the initial state is entered
+	opts := append([]gofsm.Option{gofsm.WithContext(req.Input)}, reg.options...)
+	fsm, err := reg.manager.Start(req.ID, def, opts...)
+	if err != nil {
+		reg.mu.Lock()
+		delete(reg.instances, req.ID)
+		reg.mu.Unlock()
+		code := http.StatusInternalServerError
+		if err == gofsm.ErrSessionExists {
+			code = http.StatusConflict
+		}
+		gofsm.RespondWithError(w, code, err.Error())
+		return
+	}
+	gofsm.RespondWithJSON(w, http.StatusCreated, reg.info(req.ID, fsm.Snapshot(), false))
+}
+
+func (reg *instanceRegistry) getHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	snap, ok := reg.manager.Snapshot(id)
+	if !ok {
+		gofsm.RespondWithError(w, http.StatusNotFound, "instance not found")
+		return
+	}
+	gofsm.RespondWithJSON(w, http.StatusOK, reg.info(id, snap, true))
+}
+
+// deleteHandler aborts an instance, with the 'reason' query parameter
+// The terminated instance is kept, it rejects further events
+func (reg *instanceRegistry) deleteHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	err := reg.manager.Abort(id, r.URL.Query().Get("reason"))
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case gofsm.ErrSessionNotFound:
+		gofsm.RespondWithError(w, http.StatusNotFound, "instance not found")
+	case gofsm.ErrInstanceTerminated:
+		gofsm.RespondWithError(w, http.StatusConflict, err.Error())
+	default:
+		gofsm.RespondWithError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// pauseHandler pauses an instance, its timers stop until it is resumed
+func (reg *instanceRegistry) pauseHandler(w http.ResponseWriter, r *http.Request) {
+	respondWithPauseError(w, reg.manager.Pause(mux.Vars(r)["id"]))
+}
+
+// resumeHandler resumes a paused instance
+func (reg *instanceRegistry) resumeHandler(w http.ResponseWriter, r *http.Request) {
+	respondWithPauseError(w, reg.manager.Resume(mux.Vars(r)["id"]))
+}
+
+// resetHandler returns an instance to its initial state and context
+// Its transitions are dropped unless the 'keepHistory' query parameter is
+// true, in which case the reset shows among them as a transition for '_reset'
+func (reg *instanceRegistry) resetHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	keepHistory := false
+	if value := r.URL.Query().Get("keepHistory"); value != "" {
+		var err error
+		if keepHistory, err = strconv.ParseBool(value); err != nil {
+			gofsm.RespondWithError(w, http.StatusBadRequest, "invalid keepHistory")
+			return
+		}
+	}
+	if _, ok := reg.manager.Snapshot(id); !ok {
+		gofsm.RespondWithError(w, http.StatusNotFound, "instance not found")
+		return
+	}
+	if !keepHistory {
+		reg.mu.Lock()
+		if inst, ok := reg.instances[id]; ok {
+			inst.history = nil
+		}
+		reg.mu.Unlock()
+	}
+	switch err := reg.manager.Reset(id, keepHistory); err {
+	case nil:
+		snap, _ := reg.manager.Snapshot(id)
+		gofsm.RespondWithJSON(w, http.StatusOK, reg.info(id, snap, true))
+	case gofsm.ErrSessionNotFound:
+		gofsm.RespondWithError(w, http.StatusNotFound, "instance not found")
+	default:
+		gofsm.RespondWithError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// respondWithPauseError answers a pause or a resume request
+func respondWithPauseError(w http.ResponseWriter, err error) {
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case gofsm.ErrSessionNotFound:
+		gofsm.RespondWithError(w, http.StatusNotFound, "instance not found")
+	case gofsm.ErrInstanceTerminated:
+		gofsm.RespondWithError(w, http.StatusConflict, err.Error())
+	default:
+		gofsm.RespondWithError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// bulkHandler pauses, resumes, aborts or sends an event to all the
+// instances matching a state and labels, or only lists them on a dry run
+// The caller must be allowed to send the event of the send operation
+func (reg *instanceRegistry) bulkHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var req gofsm.BulkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		gofsm.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Operation == gofsm.BulkSend {
+		req.Event.Roles = rolesFromRequest(r)
+		if err := reg.auth.authorize(principalFromRequest(r), req.Event); err != nil {
+			reg.manager.AuditRejected(req.Event, err)
+			gofsm.RespondWithError(w, http.StatusForbidden, err.Error())
+			return
+		}
+	}
+	result, err := reg.manager.Bulk(req)
+	if err != nil {
+		gofsm.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	gofsm.RespondWithJSON(w, http.StatusOK, result)
+}
+
+// newInstanceID returns a random instance ID
+func newInstanceID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
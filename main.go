@@ -2,68 +2,589 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/ditek/jsonfsm/gofsm"
+	"github.com/ditek/jsonfsm/gofsm/archive"
+	"github.com/ditek/jsonfsm/gofsm/audit"
+	"github.com/ditek/jsonfsm/gofsm/cloudevents"
+	"github.com/ditek/jsonfsm/gofsm/lock"
+	"github.com/ditek/jsonfsm/gofsm/webhook"
 	"github.com/gorilla/mux"
 )
 
 // FSM is a local alias to allow type extension
 type FSM gofsm.FSM
 
+// server holds the state shared by the REST end points of a tenant
+type server struct {
+	manager *gofsm.Manager
+	auth    *authenticator
+	// def is the definition given on the command line
+	def         *gofsm.Definition
+	definitions *definitionRegistry
+	// workers runs the actions of the machines, if configured
+	workers *gofsm.WorkerPool
+	// breakers short-circuit the failing actions, if configured
+	breakers *gofsm.CircuitBreakers
+	// coverage counts the states and transitions of the machines, if enabled
+	coverage *gofsm.Coverage
+	// router serves the end points of the tenant
+	router *mux.Router
+}
+
 /**** REST End Points and Functions ****/
 
-func eventHandler(w http.ResponseWriter, r *http.Request, fsm *gofsm.FSM) {
+func (s *server) eventHandler(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
-	var event gofsm.Event
-	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		gofsm.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	event, err := decodeEvent(r.Header, body)
+	if err != nil {
 		gofsm.RespondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	event.Roles = rolesFromRequest(r)
+	if err := s.auth.authorize(principalFromRequest(r), event); err != nil {
+		log.Println(err)
+		s.manager.AuditRejected(event, err)
+		gofsm.RespondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	// Asynchronous events are queued, the caller doesn't wait for the transition
+	if r.URL.Query().Get("async") == "true" {
+		if err := s.manager.Enqueue(event); err != nil {
+			gofsm.RespondWithError(w, http.StatusTooManyRequests, err.Error())
+			return
+		}
+		gofsm.RespondWithJSON(w, http.StatusAccepted, map[string]string{"status": "queued"})
+		return
+	}
 
-	event.Writer = w
-	err := fsm.SendEvent(event)
+	result, err := s.manager.SendEvent(event)
+	if err == gofsm.ErrNoCorrelation || err == gofsm.ErrSessionNotFound {
+		gofsm.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err == gofsm.ErrInstanceTerminated {
+		reason, _ := gofsm.Rejection(err)
+		gofsm.RespondWithJSON(w, http.StatusGone, map[string]interface{}{
+			"error":  err.Error(),
+			"reason": reason,
+		})
+		return
+	}
+	if errors.Is(err, gofsm.ErrTransitionVetoed) || errors.Is(err, gofsm.ErrForbidden) {
+		gofsm.RespondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if err == gofsm.ErrInstancePaused || err == gofsm.ErrActionPending {
+		gofsm.RespondWithError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err == gofsm.ErrDuplicateEvent {
+		// Redelivered events were already handled, so the sender should not retry
+		gofsm.RespondWithJSON(w, http.StatusOK, map[string]string{"status": "duplicate"})
+		return
+	}
+	// Events the current state can't handle conflict with the state, not the request
+	var noTransition *gofsm.ErrNoTransition
+	if errors.As(err, &noTransition) {
+		accepted := []string{}
+		fsm, ok := s.manager.Get(event.Session)
+		if event.Session == "" && event.CorrelationKey != "" {
+			fsm, ok = s.manager.Correlated(event.CorrelationKey)
+		}
+		if ok {
+			accepted = fsm.AcceptedEvents()
+		}
+		gofsm.RespondWithJSON(w, http.StatusConflict, map[string]interface{}{
+			"error":          err.Error(),
+			"state":          noTransition.State,
+			"acceptedEvents": accepted,
+			"reason":         noTransition.Reason,
+		})
+		return
+	}
+	var payloadErr *gofsm.PayloadError
+	if errors.As(err, &payloadErr) {
+		gofsm.RespondWithJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"error":   err.Error(),
+			"details": payloadErr.Errors,
+		})
+		return
+	}
 	if err != nil {
 		log.Println(err)
 		gofsm.RespondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	// Reply with the response of the actions, otherwise describe the transition
+	if result.Response != nil {
+		gofsm.RespondWithJSON(w, result.Response.Code, result.Response.Body)
+		return
+	}
+	gofsm.RespondWithJSON(w, http.StatusOK, result)
+}
+
+// broadcastHandler sends an event to all the instances matching a state and labels
+// The caller must be allowed to send the event
+func (s *server) broadcastHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var req struct {
+		Selector gofsm.Selector `json:"selector"`
+		Event    gofsm.Event    `json:"event"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		gofsm.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	req.Event.Roles = rolesFromRequest(r)
+	if err := s.auth.authorize(principalFromRequest(r), req.Event); err != nil {
+		s.manager.AuditRejected(req.Event, err)
+		gofsm.RespondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	result, err := s.manager.Broadcast(req.Selector, req.Event)
+	if err != nil {
+		gofsm.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	gofsm.RespondWithJSON(w, http.StatusOK, result)
+}
+
+// decodeEvent decodes the event of a request, which is a CloudEvent or follows the event format
+func decodeEvent(header http.Header, body []byte) (gofsm.Event, error) {
+	if cloudevents.IsCloudEvent(header) {
+		return cloudevents.Decode(header, body)
+	}
+	var event gofsm.Event
+	err := json.Unmarshal(body, &event)
+	return event, err
+}
+
+// stateHandler describes the machine of a session, with the metrics of its states
+// The default machine is described unless the 'session' query parameter is set
+func (s *server) stateHandler(w http.ResponseWriter, r *http.Request) {
+	fsm, ok := s.manager.Get(r.URL.Query().Get("session"))
+	if !ok {
+		gofsm.RespondWithError(w, http.StatusNotFound, "session not found")
+		return
+	}
+	gofsm.RespondWithJSON(w, http.StatusOK, fsm.Introspect())
+}
+
+// graphHandler draws the machine of a session as an SVG image with its
+// current state highlighted, the default machine unless 'session' is set
+func (s *server) graphHandler(w http.ResponseWriter, r *http.Request) {
+	fsm, ok := s.manager.Get(r.URL.Query().Get("session"))
+	if !ok {
+		gofsm.RespondWithError(w, http.StatusNotFound, "session not found")
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(fsm.SVG())
+}
+
+// statsHandler reports the occupancy of the shards of the sessions
+func (s *server) statsHandler(w http.ResponseWriter, r *http.Request) {
+	shards := s.manager.Stats()
+	var loaded, evicted int
+	for _, shard := range shards {
+		loaded += shard.Loaded
+		evicted += shard.Evicted
+	}
+	stats := map[string]interface{}{
+		"loaded":  loaded,
+		"evicted": evicted,
+		"shards":  shards,
+		"queue":   s.manager.QueueStats(),
+	}
+	if s.workers != nil {
+		stats["workers"] = s.workers.Stats()
+	}
+	if s.breakers != nil {
+		stats["breakers"] = s.breakers.Stats()
+	}
+	gofsm.RespondWithJSON(w, http.StatusOK, stats)
+}
+
+// managerOptions returns the sharding, eviction, locking and partitioning options
+// of the session manager of a tenant
+// The snapshots and the locks of the tenants are kept apart
+// With a database, the machines are saved to it after each event, and a
+// Postgres database locks them for the replicas sharing it
+func managerOptions(tenant string, cfg SessionsConfig, lockCfg lock.Config, clusterCfg ClusterConfig, db *database, enc gofsm.Encrypter) ([]gofsm.ManagerOption, error) {
+	var opts []gofsm.ManagerOption
+	if tenant != "" {
+		opts = append(opts, gofsm.WithTenant(tenant))
+		cfg.SnapshotDir = tenantDir(cfg.SnapshotDir, tenant)
+		if lockCfg.Prefix == "" {
+			lockCfg.Prefix = lock.DefaultPrefix
+		}
+		lockCfg.Prefix += tenant + "/"
+	}
+	if cfg.Shards > 1 {
+		opts = append(opts, gofsm.WithShards(cfg.Shards))
+	}
+	if cfg.Queue.Size > 0 {
+		policy := gofsm.OverflowPolicy(cfg.Queue.Overflow)
+		if policy == "" {
+			policy = gofsm.OverflowReject
+		}
+		if !gofsm.ValidOverflowPolicy(policy) {
+			return nil, fmt.Errorf("Error: Unknown queue overflow policy '%s'", policy)
+		}
+		timeout := time.Second
+		if cfg.Queue.Timeout != "" {
+			var err error
+			if timeout, err = time.ParseDuration(cfg.Queue.Timeout); err != nil {
+				return nil, err
+			}
+		}
+		opts = append(opts, gofsm.WithEventQueue(cfg.Queue.Size, policy, timeout))
+	}
+	if cfg.StuckInterval != "" {
+		interval, err := time.ParseDuration(cfg.StuckInterval)
+		if err != nil {
+			return nil, fmt.Errorf("Error: Invalid stuck scan interval '%s': %v", cfg.StuckInterval, err)
+		}
+		opts = append(opts, gofsm.WithStuckScan(interval))
+	}
+	if lockCfg.Type != "" && clusterCfg.Self != "" {
+		return nil, fmt.Errorf("Error: The lock and the cluster are alternatives, only one can be configured")
+	}
+	if (lockCfg.Type != "" || clusterCfg.Self != "") && cfg.SnapshotDir == "" {
+		return nil, fmt.Errorf("Error: Replicas need a snapshot directory shared between them")
+	}
+	if db != nil && (lockCfg.Type != "" || clusterCfg.Self != "") {
+		return nil, fmt.Errorf("Error: The database replaces the lock and the cluster, only one can be configured")
+	}
+	var store gofsm.SnapshotStore = gofsm.NewMemorySnapshotStore()
+	switch {
+	case db != nil:
+		store = db.tenant(tenant)
+	case cfg.SnapshotDir != "":
+		var err error
+		if store, err = gofsm.NewFileSnapshotStore(cfg.SnapshotDir); err != nil {
+			return nil, err
+		}
+	}
+	// The snapshots are only encrypted once they leave the memory
+	if enc != nil && (db != nil || cfg.SnapshotDir != "") {
+		store = gofsm.EncryptSnapshots(store, enc)
+	}
+	switch {
+	case db != nil && db.locker != nil:
+		opts = append(opts, gofsm.WithLocker(db.locker(tenant), store))
+	case db != nil:
+		opts = append(opts, gofsm.WithSharedStore(store))
+	}
+	if db != nil && db.compactInterval > 0 {
+		opts = append(opts, gofsm.WithCompaction(db.tenant(tenant), db.retention, db.compactInterval))
+	}
+	if cfg.MaxLoaded > 0 {
+		opts = append(opts, gofsm.WithEviction(cfg.MaxLoaded, store))
+	}
+	if lockCfg.Type != "" {
+		locker, err := lock.New(lockCfg)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, gofsm.WithLocker(locker, store))
+	}
+	if clusterCfg.Self != "" {
+		opts = append(opts, gofsm.WithSharedStore(store))
+	}
+	return opts, nil
+}
+
+func usage() {
+	fmt.Println(fmt.Errorf("Usage: ./jsonfsm [-config <config_file>] [-db <database_file_or_url>] [-trace] <file_name>"))
+	os.Exit(1)
 }
 
 func main() {
 	if len(os.Args) < 2 {
-		fmt.Println(fmt.Errorf("Usage: ./jsonfsm <file_name>"))
-		os.Exit(1)
+		usage()
+	}
+	switch os.Args[1] {
+	case "validate":
+		os.Exit(validateCommand(os.Args[2:]))
+	case "bench":
+		os.Exit(benchCommand(os.Args[2:]))
+	case "import":
+		os.Exit(importCommand(os.Args[2:]))
+	case "generate":
+		os.Exit(generateCommand(os.Args[2:]))
+	case "openapi":
+		os.Exit(openAPICommand(os.Args[2:]))
+	case "lint":
+		os.Exit(lintCommand(os.Args[2:]))
+	case "migrate":
+		os.Exit(migrateCommand(os.Args[2:]))
+	case "coverage":
+		os.Exit(coverageCommand(os.Args[2:]))
+	case "matrix":
+		os.Exit(matrixCommand(os.Args[2:]))
+	case "new":
+		os.Exit(newCommand(os.Args[2:]))
+	}
+
+	configFile := flag.String("config", "", "server configuration file")
+	trace := flag.Bool("trace", false, "log the transitions considered for each event and why they were skipped")
+	dbName := flag.String("db", "", "SQLite file, bolt:<file> or postgres:// URL of the database keeping the definitions, sessions and events")
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+	}
+	fileName := flag.Arg(0)
+
+	cfg := defaultConfig()
+	if *configFile != "" {
+		var err error
+		if cfg, err = loadConfig(*configFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var db *database
+	if *dbName != "" {
+		var err error
+		if db, err = openDatabase(*dbName); err != nil {
+			log.Fatal(err)
+		}
+		defer db.close()
+	}
+	if err := db.setRetention(cfg.Database); err != nil {
+		log.Fatal(err)
+	}
+	enc, err := cfg.Encryption.encrypter()
+	if err != nil {
+		log.Fatal(err)
+	}
+	auth, err := newAuthenticator(cfg.Auth, cfg.Tenants)
+	if err != nil {
+		log.Fatal(err)
 	}
-	fileName := os.Args[1]
-	file, err := os.Open(fileName)
+	if cfg.Tenants.enabled() && cfg.Cluster.Self != "" {
+		log.Fatal(fmt.Errorf("Error: Tenants are not supported in cluster mode"))
+	}
+
+	// Parse the definition from the json file once, each session gets its own machine
+	def, err := gofsm.LoadDefinitionFile(fileName)
 	if err != nil {
 		log.Fatal(err)
 	}
-	data, err := ioutil.ReadAll(file)
+	// Machines keep their history so the debugger can step them back
+	var opts []gofsm.Option
+	if cfg.Debug {
+		opts = append(opts, gofsm.WithHistory(debugHistory))
+	}
+	// The log level and the trace can be changed from the admin end points
+	var logs *logFilter
+	if cfg.Admin.Addr != "" {
+		logs = filterLog()
+	}
+	traces := &gofsm.TraceSwitch{}
+	traces.Set(cfg.Trace || *trace)
+	if traces.On() || cfg.Admin.Addr != "" {
+		opts = append(opts, gofsm.WithTraceSwitch(traces, nil))
+	}
+	// The workers are shared by the tenants
+	var workers *gofsm.WorkerPool
+	if cfg.Workers.Size > 0 {
+		if workers, err = gofsm.NewWorkerPool(cfg.Workers.Size, cfg.Workers.Limits); err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, gofsm.WithWorkerPool(workers))
+	} else if len(cfg.Workers.Limits) > 0 {
+		log.Fatal(fmt.Errorf("Error: The action limits need a worker pool size"))
+	}
+	// The breakers are shared by the tenants, which call the same services
+	var breakers *gofsm.CircuitBreakers
+	if len(cfg.Breakers) > 0 {
+		policies := make(map[string]gofsm.BreakerPolicy, len(cfg.Breakers))
+		for name, b := range cfg.Breakers {
+			cooldown, err := time.ParseDuration(b.Cooldown)
+			if err != nil {
+				log.Fatal(fmt.Errorf("Error: Invalid cooldown '%s' of the circuit breaker of action '%s': %v", b.Cooldown, name, err))
+			}
+			policies[name] = gofsm.BreakerPolicy{Failures: b.Failures, Cooldown: cooldown}
+		}
+		if breakers, err = gofsm.NewCircuitBreakers(policies); err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, gofsm.WithCircuitBreakers(breakers))
+	}
+	// The recordings of the tenants are kept in their own subdirectory
+	if cfg.Record != "" {
+		recorder, err := gofsm.NewRecorder(cfg.Record)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, gofsm.WithRecorder(recorder))
+	}
+	// The sinks are shared by the tenants, the records name their tenant
+	var notify []gofsm.TransitionListener
+	var sinks []gofsm.AuditSink
+	for _, hook := range cfg.Webhooks {
+		sink, err := webhook.NewSink(hook)
+		if err != nil {
+			log.Fatal(err)
+		}
+		notify = append(notify, sink.Notify)
+		if hook.Alerts {
+			sinks = append(sinks, sink)
+		}
+	}
+	for _, sinkCfg := range cfg.Audit {
+		sink, err := audit.New(sinkCfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sinks = append(sinks, sink)
+	}
+	archiving, err := newArchiving(cfg.Archive, enc)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// Create the FSM from the json file
-	fsm := gofsm.FSM{}
-	err = json.Unmarshal(data, &fsm)
+	var debug *debugger
+	if cfg.Debug {
+		debug = newDebugger(def, auth)
+	}
+	tenants := newTenants(func(tenant string) (*server, error) {
+		managerOpts, err := managerOptions(tenant, cfg.Sessions, cfg.Lock, cfg.Cluster, db, enc)
+		if err != nil {
+			return nil, err
+		}
+		// The coverage of a tenant only counts its own machines
+		machineOpts := opts
+		var coverage *gofsm.Coverage
+		if cfg.Coverage {
+			coverage = gofsm.NewCoverage()
+			machineOpts = append(append([]gofsm.Option{}, opts...), gofsm.WithCoverage(coverage))
+		}
+		manager := gofsm.NewManager(func() (*gofsm.Machine, error) {
+			return gofsm.NewMachine(def, machineOpts...), nil
+		}, managerOpts...)
+		for _, listener := range notify {
+			manager.OnTransition(listener)
+		}
+		if debug != nil {
+			manager.OnTransition(debug.record)
+		}
+		for _, sink := range sinks {
+			manager.OnAudit(sink)
+		}
+		var store gofsm.Store
+		var history archive.History
+		if db != nil {
+			// The database records the instances and the events of the tenant
+			tenantDB := db.tenant(tenant)
+			store, history = tenantDB, tenantDB
+			manager.OnCommit(tenantDB)
+			if enc != nil {
+				manager.OnAudit(gofsm.EncryptAudit(tenantDB, enc))
+			} else {
+				manager.OnAudit(tenantDB)
+			}
+		} else if store, err = newStore(tenant, cfg.Store); err != nil {
+			return nil, err
+		}
+		archiving.start(manager, history)
+		definitions, err := newDefinitionRegistry(store)
+		if err != nil {
+			return nil, err
+		}
+		s := &server{manager: manager, auth: auth, def: def, definitions: definitions, workers: workers, breakers: breakers, coverage: coverage}
+		s.routes(newInstanceRegistry(manager, definitions, auth, machineOpts...))
+		return s, nil
+	})
+	root, err := tenants.get("")
 	if err != nil {
 		log.Fatal(err)
 	}
+	manager := root.manager
+
+	if debug != nil {
+		debug.tenants = tenants
+	}
+
+	// Initialize the state machine of the default session
+	if _, err := manager.Session(""); err != nil {
+		log.Fatal(err)
+	}
 
-	// Initialize the state machine
-	fsm.Init()
+	sources, err := eventSources(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for name, source := range sources {
+		go runSource(name, source, manager)
+	}
 
 	r := mux.NewRouter()
-	r.HandleFunc("/send_event", func(w http.ResponseWriter, r *http.Request) {
-		eventHandler(w, r, &fsm)
-	}).Methods("POST")
-	if err := http.ListenAndServe(":3000", r); err != nil {
+	var handler http.Handler = tenants
+	var partition *partitioner
+	if cfg.Cluster.Self != "" {
+		if partition, err = newPartitioner(cfg.Cluster, cfg.TLS, manager); err != nil {
+			log.Fatal(err)
+		}
+		handler = partition.middleware(handler)
+	}
+	var limiter *rateLimiter
+	if cfg.RateLimit.EventsPerSecond > 0 {
+		limiter = newRateLimiter(cfg.RateLimit.EventsPerSecond, cfg.RateLimit.Burst)
+		handler = limiter.middleware(handler)
+	}
+	handler = auth.middleware(handler)
+	if cfg.MaxBodyBytes > 0 {
+		handler = limitBody(cfg.MaxBodyBytes, handler)
+	}
+	r.Handle("/send_event", handler).Methods("POST")
+	protect := func(h http.Handler) http.Handler {
+		h = auth.middleware(h)
+		if cfg.MaxBodyBytes > 0 {
+			h = limitBody(cfg.MaxBodyBytes, h)
+		}
+		return h
+	}
+	if limiter != nil {
+		// Broadcasts send events too, so they share the rate of the caller
+		r.Handle("/instances/broadcast", protect(limiter.middleware(tenants))).Methods("POST")
+	}
+	r.HandleFunc("/openapi.json", root.openAPIHandler).Methods("GET")
+	if partition != nil {
+		partition.routes(r, protect)
+	}
+	if debug != nil {
+		debug.routes(r, protect)
+	}
+	// The other end points are served by the tenant of the caller
+	r.PathPrefix("/").Handler(protect(tenants))
+	if cfg.Admin.Addr != "" {
+		admin, err := newAdmin(cfg.Admin, tenants, traces, logs)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func() {
+			log.Fatal(listen(cfg.Admin.Addr, cfg.TLS, admin.routes()))
+		}()
+	}
+	if err := listen(cfg.Addr, cfg.TLS, r); err != nil {
 		log.Fatal(err)
 	}
 }
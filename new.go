@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/ditek/jsonfsm/gofsm"
+	"github.com/ditek/jsonfsm/gofsm/codegen"
+)
+
+// newState is a state of the definition written by the wizard
+// Only the fields the wizard asks for are written
+type newState struct {
+	Name         string `json:"name"`
+	Action       string `json:"action,omitempty"`
+	WaitForEvent bool   `json:"waitForEvent,omitempty"`
+	Final        bool   `json:"final,omitempty"`
+}
+
+// newTransition is a transition of the definition written by the wizard
+type newTransition struct {
+	From      string `json:"from"`
+	Event     string `json:"event,omitempty"`
+	ToSuccess string `json:"toSuccess"`
+}
+
+// newDefinition is the definition written by the wizard
+type newDefinition struct {
+	SchemaVersion int              `json:"schemaVersion"`
+	Metadata      *gofsm.Metadata  `json:"metadata,omitempty"`
+	InitialState  string           `json:"initialState"`
+	Events        []string         `json:"events,omitempty"`
+	States        []*newState      `json:"states"`
+	Transitions   []*newTransition `json:"transitions"`
+}
+
+// newCommand asks for the states, events and transitions of a new machine
+// and writes its definition and a Go file with a stub for each of its actions
+// Returns the process exit code
+func newCommand(args []string) int {
+	flags := flag.NewFlagSet("new", flag.ExitOnError)
+	out := flags.String("o", "fsm.json", "definition file to write")
+	handlers := flags.String("handlers", "handlers.go", "Go file with the action stubs to write, none if empty")
+	pkg := flags.String("pkg", "main", "package of the action stubs")
+	force := flags.Bool("f", false, "overwrite the existing files")
+	flags.Parse(args)
+	if flags.NArg() != 0 || !token.IsIdentifier(*pkg) {
+		fmt.Println(fmt.Errorf("Usage: ./jsonfsm new [-o <file_name>] [-handlers <go_file>] [-pkg <package>] [-f]"))
+		return 1
+	}
+	if !*force {
+		for _, file := range []string{*out, *handlers} {
+			if _, err := os.Stat(file); file != "" && err == nil {
+				fmt.Fprintf(os.Stderr, "Error: '%s' exists, use -f to overwrite it\n", file)
+				return 1
+			}
+		}
+	}
+	def, err := runWizard(os.Stdin, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	data, err := json.MarshalIndent(def, "", "    ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	data = append(data, '\n')
+	// The answers are checked as they come, the definition should be valid
+	if _, err := gofsm.LoadDefinition(data); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: The definition is invalid:\n%v\n", err)
+		return 1
+	}
+	if err := ioutil.WriteFile(*out, data, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("Wrote %s\n", *out)
+	if *handlers == "" {
+		return 0
+	}
+	src, err := actionStubs(def, *pkg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if src == nil {
+		fmt.Println("No action to implement, the states only use built-in actions")
+		return 0
+	}
+	if err := ioutil.WriteFile(*handlers, src, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("Wrote %s\n", *handlers)
+	return 0
+}
+
+// wizard asks questions on out and reads the answers from in
+type wizard struct {
+	in  *bufio.Scanner
+	out io.Writer
+}
+
+// ask asks a question and returns the trimmed answer, or the default value
+// if the answer is empty
+func (w *wizard) ask(question, value string) (string, error) {
+	if value != "" {
+		fmt.Fprintf(w.out, "%s [%s]: ", question, value)
+	} else {
+		fmt.Fprintf(w.out, "%s: ", question)
+	}
+	if !w.in.Scan() {
+		if err := w.in.Err(); err != nil {
+			return "", err
+		}
+		return "", fmt.Errorf("Error: The wizard was interrupted")
+	}
+	if answer := strings.TrimSpace(w.in.Text()); answer != "" {
+		return answer, nil
+	}
+	return value, nil
+}
+
+// askList asks for a comma separated list until valid returns no error
+func (w *wizard) askList(question string, valid func([]string) error) ([]string, error) {
+	for {
+		answer, err := w.ask(question, "")
+		if err != nil {
+			return nil, err
+		}
+		var list []string
+		for _, item := range strings.Split(answer, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		if err := valid(list); err != nil {
+			fmt.Fprintln(w.out, err)
+			continue
+		}
+		return list, nil
+	}
+}
+
+// runWizard asks for a new definition
+func runWizard(in io.Reader, out io.Writer) (*newDefinition, error) {
+	w := &wizard{in: bufio.NewScanner(in), out: out}
+	def := &newDefinition{SchemaVersion: gofsm.SchemaVersion, Transitions: []*newTransition{}}
+	fmt.Fprintln(out, "Describe the new state machine, press Enter to keep the value in brackets")
+
+	name, err := w.ask("Name of the machine, e.g. orders", "")
+	if err != nil {
+		return nil, err
+	}
+	if name != "" {
+		def.Metadata = &gofsm.Metadata{Name: name}
+	}
+
+	states := map[string]*newState{}
+	names, err := w.askList("States, separated by commas, e.g. CREATED, PAID, SHIPPED", func(list []string) error {
+		if len(list) == 0 {
+			return fmt.Errorf("Error: A machine needs at least one state")
+		}
+		return unique("state", list)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		states[name] = &newState{Name: name}
+		def.States = append(def.States, states[name])
+	}
+	for {
+		if def.InitialState, err = w.ask("Initial state", names[0]); err != nil {
+			return nil, err
+		}
+		if states[def.InitialState] != nil {
+			break
+		}
+		fmt.Fprintf(out, "Error: Unknown state '%s'\n", def.InitialState)
+	}
+	finals, err := w.askList("Final states, separated by commas, none if empty", func(list []string) error {
+		return known(states, list)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range finals {
+		states[name].Final = true
+	}
+
+	events, err := w.askList("Events, separated by commas, e.g. PAY, SHIP", func(list []string) error {
+		return unique("event", list)
+	})
+	if err != nil {
+		return nil, err
+	}
+	def.Events = events
+	declared := map[string]bool{}
+	for _, event := range events {
+		declared[event] = true
+	}
+
+	fmt.Fprintln(out, "Transitions, one per line as '<from> <event> <to>', '-' for the transitions")
+	fmt.Fprintln(out, "taken without event, and an empty line once done")
+	for {
+		answer, err := w.ask("Transition", "")
+		if err != nil {
+			return nil, err
+		}
+		if answer == "" {
+			break
+		}
+		fields := strings.Fields(answer)
+		if len(fields) != 3 {
+			fmt.Fprintln(out, "Error: Expected '<from> <event> <to>', e.g. CREATED PAY PAID")
+			continue
+		}
+		if err := known(states, []string{fields[0], fields[2]}); err != nil {
+			fmt.Fprintln(out, err)
+			continue
+		}
+		if states[fields[0]].Final {
+			fmt.Fprintf(out, "Error: State '%s' is final, no transition can leave it\n", fields[0])
+			continue
+		}
+		t := &newTransition{From: fields[0], Event: fields[1], ToSuccess: fields[2]}
+		if t.Event == "-" {
+			t.Event = ""
+		} else if !declared[t.Event] {
+			declared[t.Event] = true
+			def.Events = append(def.Events, t.Event)
+			fmt.Fprintf(out, "Added the event '%s'\n", t.Event)
+		}
+		def.Transitions = append(def.Transitions, t)
+	}
+
+	// The states leaving with an event wait for it, the others move on by themselves
+	for _, t := range def.Transitions {
+		if t.Event != "" {
+			states[t.From].WaitForEvent = true
+		}
+	}
+	for _, s := range def.States {
+		if s.Final {
+			continue
+		}
+		action, err := w.ask(fmt.Sprintf("Action of state %s, none if empty", s.Name), "")
+		if err != nil {
+			return nil, err
+		}
+		s.Action = action
+	}
+	return def, nil
+}
+
+// unique fails if a name is listed twice
+func unique(kind string, list []string) error {
+	seen := map[string]bool{}
+	for _, name := range list {
+		if strings.ContainsAny(name, " \t") {
+			return fmt.Errorf("Error: The %s '%s' has spaces", kind, name)
+		}
+		if seen[name] {
+			return fmt.Errorf("Error: The %s '%s' is listed twice", kind, name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// known fails if a name is not a known state
+func known(states map[string]*newState, list []string) error {
+	for _, name := range list {
+		if states[name] == nil {
+			return fmt.Errorf("Error: Unknown state '%s'", name)
+		}
+	}
+	return nil
+}
+
+// stub is an action to implement in the stub file
+type stub struct {
+	Name   string
+	Func   string
+	States []string
+}
+
+var stubsTemplate = template.Must(template.New("stubs").Parse(`package {{.Package}}
+
+import "github.com/ditek/jsonfsm/gofsm"
+
+// The actions of the definition, registered in gofsm.Actions
+func init() {
+{{- range .Stubs}}
+	gofsm.Actions.Register({{printf "%q" .Name}}, {{.Func}})
+{{- end}}
+}
+{{range .Stubs}}
+// {{.Func}} is the action of state {{range $i, $s := .States}}{{if $i}}, {{end}}{{$s}}{{end}}
+// The arg is the 'actionArg' of the state, false is returned if the action failed
+func {{.Func}}(fsm *gofsm.Machine, arg string) bool {
+	// TODO: implement the action
+	return true
+}
+{{end}}`))
+
+// actionStubs returns the source of a file registering a stub for each action
+// of the definition that is not built in, nil if there is none
+func actionStubs(def *newDefinition, pkg string) ([]byte, error) {
+	var stubs []*stub
+	byName := map[string]*stub{}
+	funcs := map[string]string{}
+	for _, s := range def.States {
+		if s.Action == "" || gofsm.Actions.Get(s.Action) != nil {
+			continue
+		}
+		if st, ok := byName[s.Action]; ok {
+			st.States = append(st.States, s.Name)
+			continue
+		}
+		fn := codegen.Ident(s.Action)
+		if fn == "" || !token.IsIdentifier(fn) {
+			fn = "Action" + fn
+		}
+		if other, ok := funcs[fn]; ok {
+			return nil, fmt.Errorf("Error: The actions '%s' and '%s' both generate the function '%s'", other, s.Action, fn)
+		}
+		funcs[fn] = s.Action
+		byName[s.Action] = &stub{Name: s.Action, Func: fn, States: []string{s.Name}}
+		stubs = append(stubs, byName[s.Action])
+	}
+	if len(stubs) == 0 {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	if err := stubsTemplate.Execute(&buf, map[string]interface{}{"Package": pkg, "Stubs": stubs}); err != nil {
+		return nil, err
+	}
+	return format.Source(buf.Bytes())
+}
@@ -0,0 +1,763 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/ditek/jsonfsm/gofsm"
+)
+
+// object is a node of the OpenAPI document
+type object = map[string]interface{}
+
+// defaultMachine names the definition given on the command line in the spec
+const defaultMachine = "default"
+
+// openAPIHandler serves the OpenAPI spec of the server, built from the
+// definitions loaded when it is requested
+func (s *server) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	defs := s.definitions.all()
+	defs[defaultMachine] = s.def
+	gofsm.RespondWithJSON(w, http.StatusOK, openAPISpec(defs))
+}
+
+// openAPISpec describes the end points of the server
+// The events accepted by each definition are listed as enums, so that
+// generated clients only offer valid events
+func openAPISpec(defs map[string]*gofsm.Definition) object {
+	names := make([]string, 0, len(defs))
+	for name := range defs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	schemas := object{
+		"Event":            eventSchema(),
+		"CloudEvent":       cloudEventSchema(),
+		"TransitionResult": transitionResultSchema(),
+		"Error": object{
+			"type":       "object",
+			"properties": object{"error": object{"type": "string"}},
+		},
+		"Conflict": object{
+			"type": "object",
+			"properties": object{
+				"error":          object{"type": "string"},
+				"state":          object{"type": "string"},
+				"acceptedEvents": object{"type": "array", "items": object{"type": "string"}},
+				"reason":         ref("RejectionReason"),
+			},
+		},
+		"Rejected": object{
+			"type": "object",
+			"properties": object{
+				"error":  object{"type": "string"},
+				"reason": ref("RejectionReason"),
+			},
+		},
+		"RejectionReason": object{
+			"type": "object",
+			"properties": object{
+				"code": object{
+					"type": "string",
+					"enum": []string{gofsm.RejectedFinalized, gofsm.RejectedNoTransitions, gofsm.RejectedUnknownEvent, gofsm.RejectedWrongState, gofsm.RejectedGuardFailed},
+				},
+				"state":   object{"type": "string"},
+				"event":   object{"type": "string"},
+				"guards":  object{"type": "array", "items": object{"type": "string"}},
+				"states":  object{"type": "array", "items": object{"type": "string"}},
+				"message": object{"type": "string"},
+			},
+		},
+		"State":    stateSchema(),
+		"Stats":    statsSchema(),
+		"Coverage": coverageSchema(),
+		"Instance": instanceSchema(),
+		"Task":     taskSchema(),
+		"PendingAction": object{
+			"type": "object",
+			"properties": object{
+				"token":   object{"type": "string"},
+				"session": object{"type": "string"},
+				"state":   object{"type": "string"},
+				"action":  object{"type": "string"},
+				"started": object{"type": "string", "format": "date-time"},
+			},
+		},
+	}
+	events := []interface{}{}
+	for _, name := range names {
+		schema := "Event_" + name
+		action := object{"type": "string"}
+		// Enums can't be empty, definitions may only have eventless transitions
+		if events := definitionEvents(defs[name]); len(events) > 0 {
+			action["enum"] = events
+		}
+		schemas[schema] = object{
+			"description": "Event of the '" + name + "' definition",
+			"allOf": []interface{}{
+				ref("Event"),
+				object{"properties": object{"action": action}},
+			},
+		}
+		events = append(events, ref(schema))
+	}
+	uploaded := []string{}
+	for _, name := range names {
+		if name != defaultMachine {
+			uploaded = append(uploaded, name)
+		}
+	}
+	taskID := object{"name": "id", "in": "path", "required": true, "schema": object{"type": "string"}}
+	actionToken := object{"name": "token", "in": "path", "required": true, "schema": object{"type": "string"}}
+	definitionName := object{"type": "string"}
+	if len(uploaded) > 0 {
+		definitionName["enum"] = uploaded
+	}
+
+	return object{
+		"openapi": "3.0.3",
+		"info": object{
+			"title":   "jsonfsm",
+			"version": "1.0.0",
+		},
+		"paths": object{
+			"/send_event": object{
+				"post": object{
+					"summary":     "Send an event to a machine",
+					"operationId": "sendEvent",
+					"parameters": []interface{}{
+						object{"name": "async", "in": "query", "description": "Queue the event instead of waiting for its transition",
+							"schema": object{"type": "boolean"}},
+					},
+					"requestBody": object{
+						"required": true,
+						"content": object{
+							"application/json":             object{"schema": object{"oneOf": events}},
+							"application/cloudevents+json": object{"schema": ref("CloudEvent")},
+						},
+					},
+					"responses": object{
+						"200": response("The transition, or the response of the actions", ref("TransitionResult")),
+						"202": response("The event was queued", object{"type": "object", "properties": object{"status": object{"type": "string"}}}),
+						"400": response("The event was rejected", ref("Error")),
+						"403": response("The caller is not allowed to send the event, has none of the roles of the transition, or a hook vetoed the transition", ref("Error")),
+						"404": response("No machine is waiting for the correlation key", ref("Error")),
+						"409": response("The current state has no transition for the event, or the instance is paused or waiting for an action", ref("Conflict")),
+						"410": response("The instance is terminated", ref("Rejected")),
+						"422": response("The event data doesn't match the payload schema", ref("Error")),
+						"429": response("The event queue of the session is full", ref("Error")),
+					},
+				},
+			},
+			"/state": object{
+				"get": object{
+					"summary":     "Describe a machine and the metrics of its states",
+					"operationId": "getState",
+					"parameters": []interface{}{
+						object{"name": "session", "in": "query", "schema": object{"type": "string"}},
+					},
+					"responses": object{
+						"200": response("The machine", ref("State")),
+						"404": response("Unknown session", ref("Error")),
+					},
+				},
+			},
+			"/graph.svg": object{
+				"get": object{
+					"summary":     "Draw a machine with its current state highlighted",
+					"operationId": "getGraph",
+					"parameters": []interface{}{
+						object{"name": "session", "in": "query", "schema": object{"type": "string"}},
+					},
+					"responses": object{
+						"200": object{
+							"description": "The SVG image of the machine",
+							"content":     object{"image/svg+xml": object{"schema": object{"type": "string"}}},
+						},
+						"404": response("Unknown session", ref("Error")),
+					},
+				},
+			},
+			"/stats": object{
+				"get": object{
+					"summary":     "Report the machines held in memory and evicted",
+					"operationId": "getStats",
+					"responses": object{
+						"200": response("The occupancy of the shards", ref("Stats")),
+					},
+				},
+			},
+			"/coverage": object{
+				"get": object{
+					"summary":     "Report the states and transitions exercised by the machines",
+					"operationId": "getCoverage",
+					"parameters": []interface{}{
+						object{"name": "window", "in": "query", "description": "Only count the hits within the window before now, e.g. 24h", "schema": object{"type": "string"}},
+						object{"name": "definition", "in": "query", "description": "Only report the uploaded definition of the given name", "schema": object{"type": "string"}},
+					},
+					"responses": object{
+						"200": response("The coverage of each definition", object{"type": "array", "items": ref("Coverage")}),
+						"400": response("Invalid window", ref("Error")),
+						"404": response("Coverage disabled or unknown definition", ref("Error")),
+					},
+				},
+			},
+			"/instances": object{
+				"get": object{
+					"summary":     "List the instances",
+					"operationId": "listInstances",
+					"parameters": []interface{}{
+						object{"name": "stuck", "in": "query", "description": "Only list the instances stuck past the SLA of their state", "schema": object{"type": "boolean"}},
+					},
+					"responses": object{
+						"200": response("The instances", object{"type": "array", "items": ref("Instance")}),
+					},
+				},
+				"post": object{
+					"summary":     "Start an instance of an uploaded definition",
+					"operationId": "startInstance",
+					"requestBody": object{
+						"required": true,
+						"content": jsonContent(object{
+							"type":     "object",
+							"required": []string{"definition"},
+							"properties": object{
+								"definition": definitionName,
+								"id":         object{"type": "string"},
+								"input":      object{"type": "object", "additionalProperties": true},
+							},
+						}),
+					},
+					"responses": object{
+						"201": response("The instance", ref("Instance")),
+						"404": response("Unknown definition", ref("Error")),
+						"409": response("The instance ID is in use", ref("Error")),
+					},
+				},
+			},
+			"/instances/{id}": object{
+				"parameters": []interface{}{
+					object{"name": "id", "in": "path", "required": true, "schema": object{"type": "string"}},
+				},
+				"get": object{
+					"summary":     "Get an instance with its context and recent transitions",
+					"operationId": "getInstance",
+					"responses": object{
+						"200": response("The instance", ref("Instance")),
+						"404": response("Unknown instance", ref("Error")),
+					},
+				},
+				"delete": object{
+					"summary":     "Abort an instance, running its compensations",
+					"operationId": "deleteInstance",
+					"parameters": []interface{}{
+						object{"name": "reason", "in": "query", "schema": object{"type": "string"}},
+					},
+					"responses": object{
+						"204": object{"description": "The instance was terminated"},
+						"404": response("Unknown instance", ref("Error")),
+						"409": response("The instance is already terminated", ref("Error")),
+					},
+				},
+			},
+			"/instances/{id}/pause": object{
+				"parameters": []interface{}{
+					object{"name": "id", "in": "path", "required": true, "schema": object{"type": "string"}},
+				},
+				"post": object{
+					"summary":     "Pause an instance, stopping its timers and rejecting its events",
+					"operationId": "pauseInstance",
+					"responses": object{
+						"204": object{"description": "The instance is paused"},
+						"404": response("Unknown instance", ref("Error")),
+						"409": response("The instance is terminated", ref("Error")),
+					},
+				},
+			},
+			"/instances/{id}/resume": object{
+				"parameters": []interface{}{
+					object{"name": "id", "in": "path", "required": true, "schema": object{"type": "string"}},
+				},
+				"post": object{
+					"summary":     "Resume a paused instance",
+					"operationId": "resumeInstance",
+					"responses": object{
+						"204": object{"description": "The instance is resumed"},
+						"404": response("Unknown instance", ref("Error")),
+						"409": response("The instance is terminated", ref("Error")),
+					},
+				},
+			},
+			"/instances/{id}/reset": object{
+				"parameters": []interface{}{
+					object{"name": "id", "in": "path", "required": true, "schema": object{"type": "string"}},
+				},
+				"post": object{
+					"summary":     "Return an instance to its initial state and context",
+					"operationId": "resetInstance",
+					"parameters": []interface{}{
+						object{"name": "keepHistory", "in": "query", "schema": object{"type": "boolean"}},
+					},
+					"responses": object{
+						"200": response("The reset instance", ref("Instance")),
+						"400": response("Invalid keepHistory", ref("Error")),
+						"404": response("Unknown instance", ref("Error")),
+					},
+				},
+			},
+			"/instances/bulk": object{
+				"post": object{
+					"summary":     "Pause, resume, abort or send an event to all the instances matching a state and labels",
+					"operationId": "bulkInstances",
+					"requestBody": object{
+						"required": true,
+						"content": jsonContent(object{
+							"type":     "object",
+							"required": []string{"operation", "selector"},
+							"properties": object{
+								"operation": object{"type": "string", "enum": []string{"pause", "resume", "abort", "send"}},
+								"selector": object{
+									"type": "object",
+									"properties": object{
+										"state":  object{"type": "string"},
+										"labels": object{"type": "object", "additionalProperties": object{"type": "string"}},
+									},
+								},
+								"event":  ref("Event"),
+								"reason": object{"type": "string"},
+								"dryRun": object{"type": "boolean"},
+							},
+						}),
+					},
+					"responses": object{
+						"200": response("The matched instances and the outcome for each", object{
+							"type": "object",
+							"properties": object{
+								"operation": object{"type": "string"},
+								"dryRun":    object{"type": "boolean"},
+								"matched":   object{"type": "array", "items": object{"type": "string"}},
+								"succeeded": object{"type": "array", "items": object{"type": "string"}},
+								"failed":    object{"type": "object", "additionalProperties": object{"type": "string"}},
+							},
+						}),
+						"400": response("Unknown operation, missing event or empty selector", ref("Error")),
+						"403": response("The caller is not allowed to send the event", ref("Error")),
+					},
+				},
+			},
+			"/instances/broadcast": object{
+				"post": object{
+					"summary":     "Send an event to all the instances matching a state and labels",
+					"operationId": "broadcastEvent",
+					"requestBody": object{
+						"required": true,
+						"content": jsonContent(object{
+							"type":     "object",
+							"required": []string{"selector", "event"},
+							"properties": object{
+								"selector": object{
+									"type": "object",
+									"properties": object{
+										"state":  object{"type": "string"},
+										"labels": object{"type": "object", "additionalProperties": object{"type": "string"}},
+									},
+								},
+								"event": ref("Event"),
+							},
+						}),
+					},
+					"responses": object{
+						"200": response("The outcome for each matched instance", object{
+							"type": "object",
+							"properties": object{
+								"event":   object{"type": "string"},
+								"matched": object{"type": "integer"},
+								"results": object{"type": "object", "additionalProperties": ref("TransitionResult")},
+								"states":  object{"type": "object", "additionalProperties": object{"type": "integer"}},
+								"failed":  object{"type": "object", "additionalProperties": object{"type": "string"}},
+							},
+						}),
+						"400": response("Missing event or empty selector", ref("Error")),
+						"403": response("The caller is not allowed to send the event", ref("Error")),
+					},
+				},
+			},
+			"/tasks": object{
+				"get": object{
+					"summary":     "List the pending human tasks",
+					"operationId": "listTasks",
+					"parameters": []interface{}{
+						object{"name": "assignee", "in": "query", "schema": object{"type": "string"}},
+						object{"name": "claimedBy", "in": "query", "schema": object{"type": "string"}},
+						object{"name": "overdue", "in": "query", "schema": object{"type": "boolean"}},
+					},
+					"responses": object{
+						"200": response("The tasks", object{"type": "array", "items": ref("Task")}),
+					},
+				},
+			},
+			"/tasks/{id}": object{
+				"parameters": []interface{}{taskID},
+				"get": object{
+					"summary":     "Get a task with its form",
+					"operationId": "getTask",
+					"responses": object{
+						"200": response("The task", ref("Task")),
+						"404": response("Unknown task", ref("Error")),
+					},
+				},
+			},
+			"/tasks/{id}/claim": object{
+				"parameters": []interface{}{taskID},
+				"post": object{
+					"summary":     "Claim a task",
+					"operationId": "claimTask",
+					"requestBody": object{"content": jsonContent(taskRequestSchema())},
+					"responses": object{
+						"200": response("The task", ref("Task")),
+						"403": response("The task is assigned to another user", ref("Error")),
+						"404": response("Unknown task", ref("Error")),
+						"409": response("The task is claimed by another user", ref("Error")),
+					},
+				},
+			},
+			"/tasks/{id}/complete": object{
+				"parameters": []interface{}{taskID},
+				"post": object{
+					"summary":     "Complete a task, sending its event with the form data",
+					"operationId": "completeTask",
+					"requestBody": object{"content": jsonContent(taskRequestSchema())},
+					"responses": object{
+						"200": response("The transition", ref("TransitionResult")),
+						"403": response("The task is assigned to another user", ref("Error")),
+						"404": response("Unknown task", ref("Error")),
+						"409": response("The task is claimed by another user", ref("Error")),
+						"422": response("The data doesn't match the form", ref("Error")),
+					},
+				},
+			},
+			"/actions": object{
+				"get": object{
+					"summary":     "List the asynchronous actions the instances wait for",
+					"operationId": "listPendingActions",
+					"parameters": []interface{}{
+						object{"name": "session", "in": "query", "schema": object{"type": "string"}},
+					},
+					"responses": object{
+						"200": response("The pending actions", object{"type": "array", "items": ref("PendingAction")}),
+					},
+				},
+			},
+			"/actions/{token}/complete": object{
+				"parameters": []interface{}{actionToken},
+				"post": object{
+					"summary":     "Complete an asynchronous action with its result",
+					"operationId": "completeAction",
+					"requestBody": object{"required": true, "content": jsonContent(object{
+						"type": "object",
+						"properties": object{
+							"success": object{"type": "boolean"},
+							"outcome": object{"type": "string"},
+							"data":    object{"type": "object", "additionalProperties": true},
+						},
+					})},
+					"responses": object{
+						"200": response("The transition", ref("TransitionResult")),
+						"400": response("Invalid result", ref("Error")),
+						"404": response("No action is pending with the token", ref("Error")),
+						"409": response("The instance is paused", ref("Error")),
+						"410": response("The instance is terminated", ref("Error")),
+					},
+				},
+			},
+		},
+		"components": object{"schemas": schemas},
+	}
+}
+
+// definitionEvents returns the events of a definition, the declared ones first
+func definitionEvents(def *gofsm.Definition) []string {
+	seen := map[string]bool{}
+	events := []string{}
+	add := func(event string) {
+		if event != "" && !seen[event] {
+			seen[event] = true
+			events = append(events, event)
+		}
+	}
+	for _, event := range def.Events {
+		add(event)
+	}
+	for _, t := range def.Transitions {
+		add(t.Event)
+	}
+	return events
+}
+
+func ref(schema string) object {
+	return object{"$ref": "#/components/schemas/" + schema}
+}
+
+func jsonContent(schema object) object {
+	return object{"application/json": object{"schema": schema}}
+}
+
+func response(description string, schema object) object {
+	return object{"description": description, "content": jsonContent(schema)}
+}
+
+func eventSchema() object {
+	return object{
+		"type":     "object",
+		"required": []string{"action"},
+		"properties": object{
+			"eventId":        object{"type": "string"},
+			"session":        object{"type": "string"},
+			"correlationKey": object{"type": "string"},
+			"action":         object{"type": "string"},
+			"param":          object{"type": "string"},
+			"data":           object{"type": "object", "additionalProperties": true},
+			"priority":       object{"type": "integer", "description": "Moves a queued event ahead of the queued events of lower priority"},
+		},
+	}
+}
+
+func cloudEventSchema() object {
+	return object{
+		"type":     "object",
+		"required": []string{"specversion", "id", "source", "type"},
+		"properties": object{
+			"specversion":    object{"type": "string", "enum": []string{"1.0"}},
+			"id":             object{"type": "string"},
+			"source":         object{"type": "string"},
+			"type":           object{"type": "string", "description": "The action of the event"},
+			"subject":        object{"type": "string", "description": "The session, unless the session extension is set"},
+			"session":        object{"type": "string"},
+			"correlationkey": object{"type": "string"},
+			"param":          object{"type": "string"},
+			"priority":       object{"type": "string", "description": "The priority of the event, an integer"},
+			"data":           object{"description": "The data of the event if an object, or its param if a string"},
+		},
+	}
+}
+
+func transitionResultSchema() object {
+	return object{
+		"type": "object",
+		"properties": object{
+			"fromState":     object{"type": "string"},
+			"toState":       object{"type": "string"},
+			"actionOutcome": object{"type": "string", "enum": []string{"none", "success", "failure", "error", "pending"}},
+			"path":          object{"type": "array", "items": object{"type": "string"}},
+			"microsteps":    object{"type": "integer"},
+			"ignored":       object{"type": "boolean", "description": "The event had no transition and the definition ignores unknown events"},
+		},
+	}
+}
+
+func stateSchema() object {
+	return object{
+		"type": "object",
+		"properties": object{
+			"id":           object{"type": "string"},
+			"tenant":       object{"type": "string"},
+			"metadata":     metadataSchema(),
+			"currentState": object{"type": "string"},
+			"enteredAt":    object{"type": "string", "format": "date-time"},
+			"context":      object{"type": "object", "additionalProperties": true},
+			"states": object{
+				"type": "object",
+				"additionalProperties": object{
+					"type": "object",
+					"properties": object{
+						"entries":   object{"type": "integer"},
+						"timeSpent": object{"type": "integer", "description": "Nanoseconds"},
+					},
+				},
+			},
+		},
+	}
+}
+
+func metadataSchema() object {
+	return object{
+		"type": "object",
+		"properties": object{
+			"name":        object{"type": "string"},
+			"description": object{"type": "string"},
+			"owner":       object{"type": "string"},
+			"labels":      object{"type": "object", "additionalProperties": object{"type": "string"}},
+		},
+	}
+}
+
+func statsSchema() object {
+	return object{
+		"type": "object",
+		"properties": object{
+			"loaded":  object{"type": "integer"},
+			"evicted": object{"type": "integer"},
+			"shards": object{
+				"type": "array",
+				"items": object{
+					"type": "object",
+					"properties": object{
+						"loaded":    object{"type": "integer"},
+						"evicted":   object{"type": "integer"},
+						"capacity":  object{"type": "integer"},
+						"evictions": object{"type": "integer"},
+						"loads":     object{"type": "integer"},
+					},
+				},
+			},
+			"queue": object{
+				"type": "object",
+				"properties": object{
+					"pending":   object{"type": "integer"},
+					"enqueued":  object{"type": "integer"},
+					"processed": object{"type": "integer"},
+					"blocked":   object{"type": "integer"},
+					"timedOut":  object{"type": "integer"},
+					"rejected":  object{"type": "integer"},
+					"dropped":   object{"type": "integer"},
+				},
+			},
+			"workers": object{
+				"type": "object",
+				"properties": object{
+					"workers": object{"type": "integer"},
+					"busy":    object{"type": "integer"},
+					"waiting": object{"type": "integer"},
+					"running": object{"type": "object", "additionalProperties": object{"type": "integer"}},
+				},
+			},
+			"breakers": object{
+				"type": "object",
+				"additionalProperties": object{
+					"type": "object",
+					"properties": object{
+						"state":    object{"type": "string", "enum": []string{gofsm.BreakerClosed, gofsm.BreakerOpen, gofsm.BreakerHalfOpen}},
+						"failures": object{"type": "integer"},
+						"openedAt": object{"type": "string", "format": "date-time"},
+						"opened":   object{"type": "integer"},
+						"rejected": object{"type": "integer"},
+					},
+				},
+			},
+		},
+	}
+}
+
+func taskSchema() object {
+	return object{
+		"type": "object",
+		"properties": object{
+			"id":        object{"type": "string"},
+			"session":   object{"type": "string"},
+			"state":     object{"type": "string"},
+			"title":     object{"type": "string"},
+			"event":     object{"type": "string"},
+			"assignee":  object{"type": "string"},
+			"claimedBy": object{"type": "string"},
+			"created":   object{"type": "string", "format": "date-time"},
+			"due":       object{"type": "string", "format": "date-time"},
+			"overdue":   object{"type": "boolean"},
+			"form":      object{"type": "object", "description": "JSON Schema of the data completing the task"},
+		},
+	}
+}
+
+func taskRequestSchema() object {
+	return object{
+		"type": "object",
+		"properties": object{
+			"user": object{"type": "string", "description": "The user, when the server doesn't authenticate callers"},
+			"data": object{"type": "object", "additionalProperties": true},
+		},
+	}
+}
+
+func instanceSchema() object {
+	return object{
+		"type": "object",
+		"properties": object{
+			"id":           object{"type": "string"},
+			"definition":   object{"type": "string"},
+			"currentState": object{"type": "string"},
+			"terminated":   object{"type": "boolean"},
+			"abortReason":  object{"type": "string"},
+			"paused":       object{"type": "boolean"},
+			"stuck": object{
+				"type": "object",
+				"properties": object{
+					"id":        object{"type": "string"},
+					"state":     object{"type": "string"},
+					"sla":       object{"type": "string"},
+					"enteredAt": object{"type": "string", "format": "date-time"},
+				},
+			},
+			"startedAt": object{"type": "string", "format": "date-time"},
+			"context":   object{"type": "object", "additionalProperties": true},
+			"history": object{
+				"type": "array",
+				"items": object{
+					"type": "object",
+					"properties": object{
+						"machine":   object{"type": "string"},
+						"from":      object{"type": "string"},
+						"to":        object{"type": "string"},
+						"event":     object{"type": "string"},
+						"timestamp": object{"type": "string", "format": "date-time"},
+					},
+				},
+			},
+		},
+	}
+}
+
+// coverageSchema describes the coverage report of a definition
+func coverageSchema() object {
+	summary := object{
+		"type": "object",
+		"properties": object{
+			"covered": object{"type": "integer"},
+			"total":   object{"type": "integer"},
+			"percent": object{"type": "number"},
+		},
+	}
+	edge := object{
+		"from":  object{"type": "string"},
+		"event": object{"type": "string"},
+		"to":    object{"type": "string"},
+	}
+	hits := object{}
+	for name, schema := range edge {
+		hits[name] = schema
+	}
+	hits["hits"] = object{"type": "integer"}
+	hits["lastHit"] = object{"type": "string", "format": "date-time"}
+	return object{
+		"type": "object",
+		"properties": object{
+			"definition":      object{"type": "string"},
+			"since":           object{"type": "string", "format": "date-time"},
+			"states":          summary,
+			"transitions":     summary,
+			"unvisitedStates": object{"type": "array", "items": object{"type": "string"}},
+			"neverFired":      object{"type": "array", "items": object{"type": "object", "properties": edge}},
+			"stateHits": object{
+				"type": "array",
+				"items": object{
+					"type": "object",
+					"properties": object{
+						"state":   object{"type": "string"},
+						"hits":    object{"type": "integer"},
+						"lastHit": object{"type": "string", "format": "date-time"},
+					},
+				},
+			},
+			"transitionHits": object{"type": "array", "items": object{"type": "object", "properties": hits}},
+		},
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/ditek/jsonfsm/gofsm"
+	"github.com/ditek/jsonfsm/gofsm/cluster"
+	"github.com/gorilla/mux"
+)
+
+// forwardedHeader marks the events forwarded by another node
+// They are processed where they arrive, so differing views of the cluster can't loop
+const forwardedHeader = "X-Jsonfsm-Forwarded"
+
+// forwardedSignatureHeader holds the hex HMAC-SHA256 of the body of a
+// forwarded event with the secret of the cluster
+const forwardedSignatureHeader = "X-Jsonfsm-Forwarded-Signature"
+
+// partitioner forwards the events of the sessions owned by other nodes
+type partitioner struct {
+	membership *cluster.Membership
+	client     *http.Client
+	// secret signs the forwarded events, nodes is the set of the hosts of
+	// the nodes, for their certificates over mutual TLS
+	secret string
+	nodes  map[string]bool
+}
+
+// newPartitioner creates the partitioner of the node and hands the sessions
+// it no longer owns off to their new owner when the cluster changes
+// The nodes reach each other over TLS if it is configured
+// The events forwarded by the nodes are trusted only if they are signed with
+// the secret of the cluster or come over mutual TLS from a node certificate,
+// so callers can't bypass the owner of a session
+func newPartitioner(cfg ClusterConfig, tlsCfg TLSConfig, manager *gofsm.Manager) (*partitioner, error) {
+	if cfg.Secret == "" && tlsCfg.ClientCA == "" {
+		return nil, fmt.Errorf("Error: The cluster needs a secret or mutual TLS to trust the events forwarded by its nodes")
+	}
+	transport, err := tlsCfg.clientTransport()
+	if err != nil {
+		return nil, err
+	}
+	nodes := map[string]bool{}
+	for _, node := range append([]string{cfg.Self}, cfg.Nodes...) {
+		u, err := url.Parse(node)
+		if err != nil {
+			return nil, fmt.Errorf("Error: Invalid node URL '%s': %v", node, err)
+		}
+		nodes[u.Hostname()] = true
+	}
+	interval := time.Duration(0)
+	if cfg.ProbeInterval != "" {
+		var err error
+		if interval, err = time.ParseDuration(cfg.ProbeInterval); err != nil {
+			return nil, err
+		}
+	}
+	p := &partitioner{
+		membership: cluster.NewMembership(cfg.Self, cfg.Nodes, interval),
+		client:     &http.Client{Timeout: 30 * time.Second},
+		secret:     cfg.Secret,
+		nodes:      nodes,
+	}
+	if transport != nil {
+		p.client.Transport = transport
+		p.membership.SetTransport(transport)
+	}
+	p.membership.OnChange(func(live []string) {
+		if n := manager.Handoff(p.membership.Owns); n > 0 {
+			log.Printf("Handed %d sessions off to other nodes\n", n)
+		}
+	})
+	go p.membership.Run(make(chan struct{}))
+	return p, nil
+}
+
+// middleware forwards the events of the sessions owned by another node
+// Events routed by correlation key are processed locally, since only the
+// node of the waiting machine knows the key
+func (p *partitioner) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			gofsm.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if r.Header.Get(forwardedHeader) != "" && !p.trusted(r, body) {
+			// Only the nodes may have an event processed out of its owner
+			r.Header.Del(forwardedHeader)
+		}
+		event, err := decodeEvent(r.Header, body)
+		if r.Header.Get(forwardedHeader) != "" || err != nil ||
+			(event.Session == "" && event.CorrelationKey != "") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		owner := p.membership.Owner(event.Session)
+		if owner == p.membership.Self() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p.forward(w, r, owner, body)
+	})
+}
+
+// forward sends the request to its owner and copies the response back
+// The headers are kept, so the owner authenticates the caller again
+func (p *partitioner) forward(w http.ResponseWriter, r *http.Request, owner string, body []byte) {
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, owner+r.URL.RequestURI(), bytes.NewReader(body))
+	if err != nil {
+		gofsm.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	req.Header = r.Header.Clone()
+	req.Header.Set(forwardedHeader, p.membership.Self())
+	if p.secret != "" {
+		req.Header.Set(forwardedSignatureHeader, p.sign(body))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		gofsm.RespondWithError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	defer resp.Body.Close()
+	for name, values := range resp.Header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// sign returns the signature of the body of a forwarded event
+func (p *partitioner) sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(p.secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// trusted tells if a request was forwarded by a node: signed with the secret
+// of the cluster, or over mutual TLS with a certificate naming a node
+func (p *partitioner) trusted(r *http.Request, body []byte) bool {
+	if p.secret != "" {
+		sent, err := hex.DecodeString(r.Header.Get(forwardedSignatureHeader))
+		expected, _ := hex.DecodeString(p.sign(body))
+		if err == nil && hmac.Equal(sent, expected) {
+			return true
+		}
+	}
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return false
+	}
+	leaf := r.TLS.VerifiedChains[0][0]
+	for _, name := range leaf.DNSNames {
+		if p.nodes[name] {
+			return true
+		}
+	}
+	for _, ip := range leaf.IPAddresses {
+		if p.nodes[ip.String()] {
+			return true
+		}
+	}
+	return false
+}
+
+// routes registers the cluster end points
+// The health end point is probed by the other nodes and is not authenticated
+func (p *partitioner) routes(r *mux.Router, wrap func(http.Handler) http.Handler) {
+	r.HandleFunc(cluster.HealthPath, p.healthHandler).Methods("GET")
+	r.Handle("/cluster", wrap(http.HandlerFunc(p.statusHandler))).Methods("GET")
+}
+
+func (p *partitioner) healthHandler(w http.ResponseWriter, r *http.Request) {
+	gofsm.RespondWithJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// statusHandler describes the cluster as seen by this node
+func (p *partitioner) statusHandler(w http.ResponseWriter, r *http.Request) {
+	gofsm.RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"self":   p.membership.Self(),
+		"leader": p.membership.Leader(),
+		"live":   p.membership.Live(),
+	})
+}
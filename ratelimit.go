@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ditek/jsonfsm/gofsm"
+)
+
+// bucketIdleTime is how long an unused client bucket is kept
+const bucketIdleTime = time.Minute
+
+// bucket is the token bucket of a single client
+type bucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// rateLimiter limits the number of events per client using token buckets
+type rateLimiter struct {
+	rate      float64
+	burst     float64
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+// newRateLimiter creates a limiter allowing 'rate' events per second
+// with bursts of up to 'burst' events
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:      rate,
+		burst:     float64(burst),
+		buckets:   map[string]*bucket{},
+		lastPrune: time.Now(),
+	}
+}
+
+// allow takes a token from the client's bucket
+// Returns false if the bucket is empty
+func (l *rateLimiter) allow(client string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.prune(now)
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: l.burst, lastSeen: now}
+		l.buckets[client] = b
+	}
+	b.tokens += now.Sub(b.lastSeen).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.lastSeen = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// prune forgets the clients that have been idle for a while
+func (l *rateLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < bucketIdleTime {
+		return
+	}
+	l.lastPrune = now
+	for client, b := range l.buckets {
+		if now.Sub(b.lastSeen) > bucketIdleTime {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// middleware rejects the requests of clients that exceed their rate
+// Clients are identified by their authenticated name or their IP address
+func (l *rateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := principalFromRequest(r)
+		if client == "" {
+			client = clientIP(r)
+		}
+		if !l.allow(client) {
+			w.Header().Set("Retry-After", "1")
+			gofsm.RespondWithError(w, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP returns the IP address of the caller
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// limitBody rejects requests with a body larger than maxBytes
+func limitBody(maxBytes int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > maxBytes {
+			respondTooLarge(w, maxBytes)
+			return
+		}
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBytes+1))
+		r.Body.Close()
+		if err != nil {
+			gofsm.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if int64(len(body)) > maxBytes {
+			respondTooLarge(w, maxBytes)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
+
+func respondTooLarge(w http.ResponseWriter, maxBytes int64) {
+	gofsm.RespondWithError(w, http.StatusRequestEntityTooLarge,
+		fmt.Sprintf("request body is larger than %d bytes", maxBytes))
+}
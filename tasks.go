@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/ditek/jsonfsm/gofsm"
+	"github.com/gorilla/mux"
+)
+
+// taskAPI lists the pending human tasks and lets users claim and complete them
+type taskAPI struct {
+	manager *gofsm.Manager
+	auth    *authenticator
+}
+
+// taskInfo describes a task in the API
+type taskInfo struct {
+	gofsm.Task
+	Overdue bool `json:"overdue"`
+}
+
+// userRequest is the body of the claim and complete requests
+// The user is the authenticated caller, the body only names it without authentication
+type userRequest struct {
+	User string                 `json:"user"`
+	Data map[string]interface{} `json:"data"`
+}
+
+// routes registers the task end points, wrapped with the given middleware
+func (api *taskAPI) routes(r *mux.Router, wrap func(http.Handler) http.Handler) {
+	r.Handle("/tasks", wrap(http.HandlerFunc(api.listHandler))).Methods("GET")
+	r.Handle("/tasks/{id}", wrap(http.HandlerFunc(api.getHandler))).Methods("GET")
+	r.Handle("/tasks/{id}/claim", wrap(http.HandlerFunc(api.claimHandler))).Methods("POST")
+	r.Handle("/tasks/{id}/complete", wrap(http.HandlerFunc(api.completeHandler))).Methods("POST")
+}
+
+// listHandler lists the pending tasks, optionally only the ones of an
+// assignee, claimed by a user or overdue
+func (api *taskAPI) listHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	now := time.Now()
+	infos := []taskInfo{}
+	for _, task := range api.manager.Tasks() {
+		info := taskInfo{Task: task, Overdue: task.Overdue(now)}
+		if assignee := query.Get("assignee"); assignee != "" && task.Assignee != assignee {
+			continue
+		}
+		if user := query.Get("claimedBy"); user != "" && task.ClaimedBy != user {
+			continue
+		}
+		if query.Get("overdue") == "true" && !info.Overdue {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	gofsm.RespondWithJSON(w, http.StatusOK, infos)
+}
+
+func (api *taskAPI) getHandler(w http.ResponseWriter, r *http.Request) {
+	task, ok := api.manager.Task(mux.Vars(r)["id"])
+	if !ok {
+		gofsm.RespondWithError(w, http.StatusNotFound, gofsm.ErrTaskNotFound.Error())
+		return
+	}
+	gofsm.RespondWithJSON(w, http.StatusOK, taskInfo{Task: task, Overdue: task.Overdue(time.Now())})
+}
+
+// claimHandler reserves a task for the caller
+func (api *taskAPI) claimHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	req, err := readUserRequest(r)
+	if err != nil {
+		gofsm.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := api.manager.ClaimTask(id, req.User); err != nil {
+		respondWithTaskError(w, err)
+		return
+	}
+	task, _ := api.manager.Task(id)
+	gofsm.RespondWithJSON(w, http.StatusOK, taskInfo{Task: task, Overdue: task.Overdue(time.Now())})
+}
+
+// completeHandler completes a task with the form data of the request
+// The caller must be allowed to send the event of the task
+func (api *taskAPI) completeHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	req, err := readUserRequest(r)
+	if err != nil {
+		gofsm.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	task, ok := api.manager.Task(id)
+	if !ok {
+		gofsm.RespondWithError(w, http.StatusNotFound, gofsm.ErrTaskNotFound.Error())
+		return
+	}
+	event := gofsm.Event{Session: task.Session, Action: task.Event, Data: req.Data}
+	if err := api.auth.authorize(principalFromRequest(r), event); err != nil {
+		api.manager.AuditRejected(event, err)
+		gofsm.RespondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	result, err := api.manager.CompleteTask(id, req.User, req.Data)
+	if err != nil {
+		respondWithTaskError(w, err)
+		return
+	}
+	gofsm.RespondWithJSON(w, http.StatusOK, result)
+}
+
+// readUserRequest decodes the optional body of a claim or complete request
+func readUserRequest(r *http.Request) (userRequest, error) {
+	defer r.Body.Close()
+	var req userRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		return req, err
+	}
+	if principal := principalFromRequest(r); principal != "" {
+		req.User = principal
+	}
+	return req, nil
+}
+
+// respondWithTaskError maps the errors of the tasks to status codes
+func respondWithTaskError(w http.ResponseWriter, err error) {
+	var payloadErr *gofsm.PayloadError
+	switch {
+	case err == gofsm.ErrTaskNotFound:
+		gofsm.RespondWithError(w, http.StatusNotFound, err.Error())
+	case err == gofsm.ErrTaskNotAssigned:
+		gofsm.RespondWithError(w, http.StatusForbidden, err.Error())
+	case err == gofsm.ErrTaskClaimed || err == gofsm.ErrDuplicateEvent:
+		gofsm.RespondWithError(w, http.StatusConflict, err.Error())
+	case errors.As(err, &payloadErr):
+		gofsm.RespondWithJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"error":   err.Error(),
+			"details": payloadErr.Errors,
+		})
+	default:
+		gofsm.RespondWithError(w, http.StatusBadRequest, err.Error())
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/ditek/jsonfsm/gofsm"
+	"github.com/gorilla/mux"
+)
+
+// tenants serves each tenant with its own sessions, definitions and instances
+// The server of a tenant is created on its first request
+type tenants struct {
+	create  func(tenant string) (*server, error)
+	mu      sync.Mutex
+	servers map[string]*server
+}
+
+// newTenants creates the tenants served by the servers of the factory
+func newTenants(create func(tenant string) (*server, error)) *tenants {
+	return &tenants{create: create, servers: map[string]*server{}}
+}
+
+// get returns the server of a tenant, creating it if needed
+// The empty tenant is the default one, which serves every request when
+// tenancy is disabled and the event sources otherwise
+func (ts *tenants) get(tenant string) (*server, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if s, ok := ts.servers[tenant]; ok {
+		return s, nil
+	}
+	s, err := ts.create(tenant)
+	if err != nil {
+		return nil, err
+	}
+	ts.servers[tenant] = s
+	return s, nil
+}
+
+// each calls fn with the server of every tenant created so far, by name
+func (ts *tenants) each(fn func(tenant string, s *server)) {
+	ts.mu.Lock()
+	servers := make(map[string]*server, len(ts.servers))
+	names := make([]string, 0, len(ts.servers))
+	for tenant, s := range ts.servers {
+		servers[tenant] = s
+		names = append(names, tenant)
+	}
+	ts.mu.Unlock()
+	sort.Strings(names)
+	for _, tenant := range names {
+		fn(tenant, servers[tenant])
+	}
+}
+
+// ServeHTTP passes a request to the server of the tenant of the caller
+func (ts *tenants) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s, err := ts.get(tenantFromRequest(r))
+	if err != nil {
+		log.Println(err)
+		gofsm.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.router.ServeHTTP(w, r)
+}
+
+// routes registers the end points of a tenant
+// They are authenticated before the request reaches the tenant
+func (s *server) routes(instances *instanceRegistry) {
+	r := mux.NewRouter()
+	none := func(h http.Handler) http.Handler { return h }
+	r.HandleFunc("/send_event", s.eventHandler).Methods("POST")
+	r.HandleFunc("/state", s.stateHandler).Methods("GET")
+	r.HandleFunc("/graph.svg", s.graphHandler).Methods("GET")
+	r.HandleFunc("/stats", s.statsHandler).Methods("GET")
+	r.HandleFunc("/coverage", s.coverageHandler).Methods("GET")
+	r.HandleFunc("/instances/broadcast", s.broadcastHandler).Methods("POST")
+	s.definitions.routes(r, none)
+	instances.routes(r, none)
+	tasks := &taskAPI{manager: s.manager, auth: s.auth}
+	tasks.routes(r, none)
+	actions := &actionAPI{manager: s.manager}
+	actions.routes(r, none)
+	s.router = r
+}
+
+// tenantDir returns the directory of a tenant under a directory shared by all tenants
+func tenantDir(dir, tenant string) string {
+	if dir == "" || tenant == "" {
+		return dir
+	}
+	return filepath.Join(dir, tenant)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// TLSConfig serves the end points over TLS, with mutual TLS when ClientCA is set
+type TLSConfig struct {
+	// CertFile and KeyFile are the PEM certificate and key of the server, TLS
+	// is disabled if empty
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+	// ClientCA is the PEM bundle of the CAs issuing the client certificates,
+	// every client must then present a certificate they issued
+	ClientCA string `json:"clientCA,omitempty"`
+	// AllowedSANs restricts the clients to the certificates with one of these
+	// subject alternative names: DNS names, URIs such as SPIFFE IDs, emails or IPs
+	AllowedSANs []string `json:"allowedSANs,omitempty"`
+}
+
+// enabled tells if the end points are served over TLS
+func (cfg TLSConfig) enabled() bool {
+	return cfg.CertFile != ""
+}
+
+// serverConfig returns the TLS config of the server, nil without TLS
+func (cfg TLSConfig) serverConfig() (*tls.Config, error) {
+	if !cfg.enabled() {
+		if cfg.ClientCA != "" || len(cfg.AllowedSANs) > 0 {
+			return nil, fmt.Errorf("Error: Mutual TLS needs the certificate of the server")
+		}
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error: Cannot load the certificate of the server: %v", err)
+	}
+	tlsCfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if cfg.ClientCA == "" {
+		if len(cfg.AllowedSANs) > 0 {
+			return nil, fmt.Errorf("Error: The allowed SANs need the client CA")
+		}
+		return tlsCfg, nil
+	}
+	if tlsCfg.ClientCAs, err = loadCAs(cfg.ClientCA); err != nil {
+		return nil, err
+	}
+	tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	if len(cfg.AllowedSANs) > 0 {
+		tlsCfg.VerifyPeerCertificate = cfg.checkSAN
+	}
+	return tlsCfg, nil
+}
+
+// clientTransport returns the transport presenting the certificate of the
+// server to the other nodes, which trust the client CA, nil without TLS
+func (cfg TLSConfig) clientTransport() (http.RoundTripper, error) {
+	if !cfg.enabled() {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error: Cannot load the certificate of the server: %v", err)
+	}
+	tlsCfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if cfg.ClientCA != "" {
+		// The nodes are assumed to share their CA
+		if tlsCfg.RootCAs, err = loadCAs(cfg.ClientCA); err != nil {
+			return nil, err
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsCfg
+	return transport, nil
+}
+
+// loadCAs reads a PEM bundle of CA certificates
+func loadCAs(fileName string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("Error: Cannot read the client CA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("Error: No certificate in the client CA '%s'", fileName)
+	}
+	return pool, nil
+}
+
+// checkSAN rejects the client certificates, verified against the client CA,
+// without any of the allowed SANs
+func (cfg TLSConfig) checkSAN(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	if len(verifiedChains) == 0 || len(verifiedChains[0]) == 0 {
+		return errors.New("no verified client certificate")
+	}
+	leaf := verifiedChains[0][0]
+	sans := append([]string{}, leaf.DNSNames...)
+	sans = append(sans, leaf.EmailAddresses...)
+	for _, uri := range leaf.URIs {
+		sans = append(sans, uri.String())
+	}
+	for _, ip := range leaf.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, allowed := range cfg.AllowedSANs {
+		for _, san := range sans {
+			if san == allowed {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("client certificate '%s' has none of the allowed SANs", leaf.Subject.CommonName)
+}
+
+// listen serves the end points on an address, over TLS if configured
+func listen(addr string, cfg TLSConfig, handler http.Handler) error {
+	tlsCfg, err := cfg.serverConfig()
+	if err != nil {
+		return err
+	}
+	if tlsCfg == nil {
+		return http.ListenAndServe(addr, handler)
+	}
+	server := &http.Server{Addr: addr, Handler: handler, TLSConfig: tlsCfg}
+	return server.ListenAndServeTLS("", "")
+}